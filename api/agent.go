@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/constant"
+	"insane/server"
+	"insane/utils"
+)
+
+type AgentMessage struct {
+	Message
+}
+
+type AgentListMessage struct {
+	Message
+}
+
+// 被测机器上的agent连接，注册后定时上报负载，断开时移除
+func (agentMessage *AgentMessage) Do() {
+	var wsConn = agentMessage.Message.WsConn
+	if wsConn == nil {
+		return
+	}
+	defer wsConn.Close()
+
+	var agent *server.Agent
+	defer func() {
+		if agent != nil {
+			server.InsaneAgents.Unregister(agent)
+			logger.Debug("agent closed: ", agent.Name)
+		}
+	}()
+	for {
+		var msg server.AgentMsg
+		_, message, err := wsConn.ReadMessage()
+		if err != nil {
+			logger.Debug(err)
+			return
+		}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			logger.Debug(err)
+			continue
+		}
+
+		switch msg.ProtoId {
+		case constant.A_REGISTER:
+			if agent, err = server.InsaneAgents.Register(msg.Register, wsConn.RemoteAddr().String()); err != nil {
+				logger.Debug(err)
+				return
+			}
+			protoByte, err := json.Marshal(server.ProtoReplyMsg{ProtoId: constant.S_AGENT})
+			if err != nil {
+				logger.Debug(err)
+				return
+			}
+			WsConnWrite(wsConn, constant.MSG_TYPE, protoByte)
+		case constant.A_SAMPLE:
+			if agent != nil && msg.Sample != nil {
+				server.InsaneAgents.AddSample(agent, msg.Sample)
+			}
+		}
+	}
+}
+
+// 已连接的agent及最近一次上报的负载
+func (agentListMessage *AgentListMessage) Do() {
+	utils.Response(agentListMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(nil),
+		Data: server.InsaneAgents.List(),
+	})
+}
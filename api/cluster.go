@@ -13,15 +13,20 @@ type ClusterMessage struct {
 
 func (clusterMessage *ClusterMessage) Do() {
 	var wsConn = clusterMessage.Message.WsConn
+	if wsConn == nil {
+		return
+	}
+	defer wsConn.Close()
+
+	// 当前连接对应的子节点，断开时从集群中移除
+	var clusterId uint64
 	defer func() {
-		if wsConn != nil {
-			wsConn.Close()
+		if clusterId != 0 {
+			server.InsaneMaster.RemoveCluster(clusterId)
 		}
 	}()
-
 	for {
 		var msg server.ProtoSentMsg
-		var sentData server.SentData
 		_, message, err := wsConn.ReadMessage()
 		if err != nil {
 			logger.Debug(err)
@@ -32,27 +37,25 @@ func (clusterMessage *ClusterMessage) Do() {
 			continue
 		}
 
-		if err := json.Unmarshal(message, &sentData); err != nil {
-			logger.Debug(err)
-			continue
-		}
-
 		switch msg.ProtoId {
 		case constant.C_REGISTER:
-			clusterMessage.s_register(&sentData)
+			clusterId = clusterMessage.s_register(&msg.SentData)
 		case constant.C_REPORT:
-			clusterMessage.s_report(&sentData)
+			clusterMessage.s_report(clusterId, &msg.SentData)
 		}
 	}
 }
 
-func (clusterMessage *ClusterMessage) s_register(sentData *server.SentData) {
+func (clusterMessage *ClusterMessage) s_register(sentData *server.SentData) (clusterId uint64) {
 	// 添加子节点到集群列表
-	var cluster server.Cluster
-	clusterId := server.InsaneMaster.GenerateClusterId()
-	cluster.ClusterId = clusterId
-	cluster.ClusterInfo.ServerInfo = sentData.ServerInfo
-	server.InsaneMaster.AddCluster(&cluster)
+	clusterId = server.InsaneMaster.GenerateClusterId()
+	cluster := &server.Cluster{
+		ClusterId: clusterId,
+		ClusterInfo: &server.ClusterInfo{
+			ServerInfo: sentData.ServerInfo,
+		},
+	}
+	server.InsaneMaster.AddCluster(cluster)
 
 	protoMsg := server.ProtoReplyMsg{
 		ProtoId: constant.S_REGISTER,
@@ -66,8 +69,10 @@ func (clusterMessage *ClusterMessage) s_register(sentData *server.SentData) {
 		return
 	}
 	WsConnWrite(clusterMessage.Message.WsConn, constant.MSG_TYPE, protoByte)
+	return
 }
 
-func (clusterMessage *ClusterMessage) s_report(sentData *server.SentData) {
-
+// 子节点上报的报告保留错误分类等信息，由master合并
+func (clusterMessage *ClusterMessage) s_report(clusterId uint64, sentData *server.SentData) {
+	server.InsaneMaster.SetReport(clusterId, sentData.Report)
 }
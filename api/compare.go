@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type CompareMessage struct {
+	Message
+}
+
+type BaselineMessage struct {
+	Message
+}
+
+// 对比报告：/compare?ids=a,b,c 以第一个为基准；/compare?id=x 与同名任务的基准对比
+// 容差：latency（%）、throughput（%）、errorRate（百分点），为空时使用基准或配置中的值
+func (compareMessage *CompareMessage) Do() {
+	query := compareMessage.Message.Request.URL.Query()
+	var (
+		comparison *server.Comparison
+		err        error
+	)
+	if ids := query.Get("ids"); ids != "" {
+		comparison, err = server.CompareReports(strings.Split(ids, ","), queryTolerance(query))
+	} else {
+		comparison, err = server.CompareBaseline(query.Get("id"), queryTolerance(query))
+	}
+	if err != nil {
+		logger.Debug(err)
+	}
+	utils.Response(compareMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: comparison,
+	})
+}
+
+// 基准：/baseline 列出全部；/baseline?name=x 查询；/baseline?id=x&name=x 设置，name为空时使用报告中的任务名称；/baseline?name=x&delete=1 删除
+func (baselineMessage *BaselineMessage) Do() {
+	query := baselineMessage.Message.Request.URL.Query()
+	var (
+		data interface{}
+		err  error
+	)
+	switch {
+	case query.Get("delete") != "":
+		err = server.InsaneBaselines.Delete(query.Get("name"))
+	case query.Get("id") != "":
+		data, err = server.InsaneBaselines.Set(query.Get("name"), query.Get("id"), queryTolerance(query))
+	case query.Get("name") != "":
+		data = server.InsaneBaselines.Get(query.Get("name"))
+	default:
+		data = server.InsaneBaselines.List()
+	}
+	if err != nil {
+		logger.Debug(err)
+	}
+	utils.Response(baselineMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: data,
+	})
+}
+
+func queryTolerance(query url.Values) *server.Tolerance {
+	if query.Get("latency") == "" && query.Get("throughput") == "" && query.Get("errorRate") == "" {
+		return nil
+	}
+	tolerance := new(server.Tolerance)
+	tolerance.Latency, _ = strconv.ParseFloat(query.Get("latency"), 64)
+	tolerance.Throughput, _ = strconv.ParseFloat(query.Get("throughput"), 64)
+	tolerance.ErrorRate, _ = strconv.ParseFloat(query.Get("errorRate"), 64)
+	return tolerance
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"strconv"
+)
+
+type ControlMessage struct {
+	Message
+}
+
+// 调整执行中的任务：/control?id=xxx&action=pause|resume|vus|rate|duration&value=n
+// vus、rate为调整后的值，duration为延长（正数）或缩短（负数）的秒数，action为空时只返回当前状态
+func (controlMessage *ControlMessage) Do() {
+	query := controlMessage.Message.Request.URL.Query()
+	value, _ := strconv.ParseInt(query.Get("value"), 10, 64)
+	state, err := server.ControlTask(query.Get("id"), query.Get("action"), value)
+	if err != nil {
+		logger.Debug(err)
+	}
+	utils.Response(controlMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: state,
+	})
+}
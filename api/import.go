@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"io/ioutil"
+)
+
+type ImportMessage struct {
+	Message
+}
+
+type importRequest struct {
+	Type    string `json:"type"`    // curl | openapi
+	Content string `json:"content"` // curl命令或OpenAPI文档
+	BaseUrl string `json:"baseUrl"` // OpenAPI服务地址，为空时使用文档中的servers
+}
+
+const (
+	IMPORT_TYPE_CURL    = "curl"
+	IMPORT_TYPE_OPENAPI = "openapi"
+)
+
+func (importMessage *ImportMessage) Do() {
+	var (
+		importReq importRequest
+		data      interface{}
+		err       error
+	)
+	defer func() {
+		if err != nil {
+			logger.Debug(err)
+		}
+		utils.Response(importMessage.Message.ResponseWriter, utils.RspData{
+			Msg:  utils.GetMsg(err),
+			Data: data,
+		})
+	}()
+
+	body, err := ioutil.ReadAll(importMessage.Message.Request.Body)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(body, &importReq); err != nil {
+		return
+	}
+
+	switch importReq.Type {
+	case IMPORT_TYPE_CURL:
+		data, err = server.ParseCurl(importReq.Content)
+	case IMPORT_TYPE_OPENAPI:
+		data, err = server.ParseOpenApi(importReq.Content, importReq.BaseUrl)
+	default:
+		err = errors.New("type必须是curl | openapi")
+	}
+}
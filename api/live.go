@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type LiveMessage struct {
+	Message
+}
+
+// 不能使用websocket时的sse推送：/live?ids=a,b,cluster，每秒一个live事件，全部任务结束后发送end事件
+// 断线重连时浏览器带上Last-Event-ID，从上次的位置继续推送
+func (liveMessage *LiveMessage) Do() {
+	writer := liveMessage.Message.ResponseWriter
+	request := liveMessage.Message.Request
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		utils.Response(writer, utils.RspData{Msg: "不支持sse"})
+		return
+	}
+	live := server.NewLiveSubscription()
+	if ids := request.URL.Query().Get("ids"); ids != "" {
+		live.Subscribe(strings.Split(ids, ",")...)
+	}
+	if eventId := request.Header.Get("Last-Event-ID"); eventId != "" {
+		live.Resume(eventId)
+	}
+	if live.Len() == 0 {
+		utils.Response(writer, utils.RspData{Msg: "ids不能为空"})
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, "retry: 3000\n\n")
+	flusher.Flush()
+
+	t := time.NewTicker(1 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-t.C:
+		}
+		frames := live.Frames()
+		data, err := json.Marshal(frames)
+		if err != nil {
+			logger.Debug(err)
+			return
+		}
+		if _, err := fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", live.EventId(), WS_TYPE_LIVE, data); err != nil {
+			logger.Debug(err)
+			return
+		}
+		if live.Len() == 0 {
+			fmt.Fprint(writer, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+)
+
+type MetricsMessage struct {
+	Message
+}
+
+// Prometheus抓取的实时指标
+func (metricsMessage *MetricsMessage) Do() {
+	metricsMessage.Message.ResponseWriter.Header().Set("Content-Type", server.METRICS_CONTENT_TYPE)
+	if _, err := metricsMessage.Message.ResponseWriter.Write(server.InsaneMetrics.Export()); err != nil {
+		logger.Debug(err)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"io/ioutil"
+)
+
+type RecordMessage struct {
+	Message
+}
+
+type recordRequest struct {
+	Type   string `json:"type"`   // start | stop | get | ca
+	Id     string `json:"id"`     // 录制任务id
+	Host   string `json:"host"`   // 只录制域名包含该值的请求
+	Static bool   `json:"static"` // 是否录制静态资源
+}
+
+const (
+	RECORD_TYPE_START = "start"
+	RECORD_TYPE_STOP  = "stop"
+	RECORD_TYPE_GET   = "get"
+	RECORD_TYPE_CA    = "ca"
+)
+
+func (recordMessage *RecordMessage) Do() {
+	var (
+		recordReq recordRequest
+		data      interface{}
+		err       error
+	)
+
+	// 根证书直接下载
+	if recordMessage.Message.Request.URL.Query().Get("type") == RECORD_TYPE_CA {
+		ca, err := server.InsaneRecorder.CaPem()
+		if err != nil {
+			logger.Debug(err)
+			recordMessage.Message.ResponseWriter.WriteHeader(500)
+			return
+		}
+		recordMessage.Message.ResponseWriter.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		recordMessage.Message.ResponseWriter.Header().Set("Content-Disposition", "attachment; filename=insane-ca.pem")
+		recordMessage.Message.ResponseWriter.Write(ca)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			logger.Debug(err)
+		}
+		utils.Response(recordMessage.Message.ResponseWriter, utils.RspData{
+			Msg:  utils.GetMsg(err),
+			Data: data,
+		})
+	}()
+
+	body, err := ioutil.ReadAll(recordMessage.Message.Request.Body)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(body, &recordReq); err != nil {
+		return
+	}
+
+	switch recordReq.Type {
+	case RECORD_TYPE_START:
+		data, err = server.InsaneRecorder.Start(recordReq.Host, recordReq.Static)
+	case RECORD_TYPE_STOP:
+		data, err = server.InsaneRecorder.Stop(recordReq.Id)
+	case RECORD_TYPE_GET:
+		data, err = server.InsaneRecorder.Get(recordReq.Id)
+	default:
+		err = errors.New("type必须是start | stop | get | ca")
+	}
+}
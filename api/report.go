@@ -0,0 +1,33 @@
+package api
+
+import (
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"net/http"
+)
+
+type ReportMessage struct {
+	Message
+}
+
+// 导出任务报告：/report?id=xxx&format=html|json|csv|junit|samples，download=1时作为附件下载
+func (reportMessage *ReportMessage) Do() {
+	query := reportMessage.Message.Request.URL.Query()
+	file, err := server.ExportReport(query.Get("id"), query.Get("format"))
+	if err != nil {
+		logger.Debug(err)
+		utils.Response(reportMessage.Message.ResponseWriter, utils.RspData{
+			Msg: utils.GetMsg(err),
+		})
+		return
+	}
+	writer := reportMessage.Message.ResponseWriter
+	writer.Header().Set("Content-Type", file.ContentType)
+	if query.Get("download") != "" {
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, file.Name))
+	}
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(file.Content)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"io/ioutil"
+)
+
+// 执行data/test_task中保存的任务：/run?task=x，body为覆盖任务字段的json对象，可以为空
+// 引用的脚本、数据文件由服务端读取并检查，dry=1时只返回解析后的请求参数，不加入任务列表
+type RunMessage struct {
+	Message
+}
+
+func (runMessage *RunMessage) Do() {
+	query := runMessage.Message.Request.URL.Query()
+	var data interface{}
+	overrides, err := ioutil.ReadAll(runMessage.Message.Request.Body)
+	if err == nil {
+		if query.Get("dry") != "" {
+			var body []byte
+			if body, err = server.SavedTaskRequest(query.Get("task"), overrides); err == nil {
+				data = json.RawMessage(body)
+			}
+		} else {
+			var insaneRequest *server.InsaneRequest
+			if insaneRequest, err = server.NewSavedTaskRequest(query.Get("task"), overrides); err == nil {
+				err = server.TK.TaskListAdd(insaneRequest)
+				data = insaneRequest.Id
+			}
+		}
+	}
+	if err != nil {
+		logger.Debug(err)
+	}
+	utils.Response(runMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: data,
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
+	"io/ioutil"
+	"strconv"
+)
+
+type ScheduleMessage struct {
+	Message
+}
+
+// 定时执行保存的任务：
+// POST /schedule 添加或修改计划，body为{id, task, cron | at, window, overlap, enabled}，id为空时新建
+// /schedule 列出全部；/schedule?id=x 查询；/schedule?id=x&delete=1 删除
+// /schedule?upcoming=n 接下来的执行时间；/schedule?history=n 执行记录，加上id时只返回这个计划的
+func (scheduleMessage *ScheduleMessage) Do() {
+	request := scheduleMessage.Message.Request
+	query := request.URL.Query()
+	id := query.Get("id")
+	var (
+		data interface{}
+		err  error
+	)
+	switch {
+	case request.Method == "POST":
+		var body []byte
+		if body, err = ioutil.ReadAll(request.Body); err != nil {
+			break
+		}
+		schedule := new(server.TaskSchedule)
+		if err = json.Unmarshal(body, schedule); err != nil {
+			break
+		}
+		data, err = server.InsaneScheduler.Save(schedule)
+	case query.Get("delete") != "":
+		err = server.InsaneScheduler.Delete(id)
+	case query.Get("upcoming") != "":
+		n, _ := strconv.Atoi(query.Get("upcoming"))
+		data = server.InsaneScheduler.Upcoming(id, n)
+	case query.Get("history") != "":
+		n, _ := strconv.Atoi(query.Get("history"))
+		data = server.InsaneScheduler.History(id, n)
+	case id != "":
+		data = server.InsaneScheduler.Get(id)
+	default:
+		data = server.InsaneScheduler.List()
+	}
+	if err != nil {
+		logger.Debug(err)
+	}
+	utils.Response(scheduleMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: data,
+	})
+}
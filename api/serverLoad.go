@@ -2,13 +2,34 @@ package api
 
 import (
 	"insane/server"
+	"insane/utils"
+	"strconv"
+	"time"
 )
 
 type ServerLoadMessage struct {
 	Message
 }
 
+// 不带参数时返回旧格式；带start、end（毫秒时间戳）或last（最近多少秒）时返回时间范围内的采样
 func (serverLoadMessage *ServerLoadMessage) Do() {
-	data, _ := server.InsaneLoad.Get()
-	serverLoadMessage.Message.ResponseWriter.Write([]byte(data))
+	query := serverLoadMessage.Message.Request.URL.Query()
+	if query.Get("start") == "" && query.Get("end") == "" && query.Get("last") == "" {
+		data, _ := server.InsaneLoad.Get()
+		serverLoadMessage.Message.ResponseWriter.Write([]byte(data))
+		return
+	}
+
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+	if last, err := strconv.ParseInt(query.Get("last"), 10, 64); err == nil && last > 0 {
+		start = utils.Now() - last*int64(time.Second/time.Millisecond)
+	}
+	utils.Response(serverLoadMessage.Message.ResponseWriter, utils.RspData{
+		Msg: utils.GetMsg(nil),
+		Data: map[string]interface{}{
+			"serverInfo": server.InsaneLoad.ServerInfo,
+			"samples":    server.InsaneLoad.Query(start, end),
+		},
+	})
 }
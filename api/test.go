@@ -1,19 +1,24 @@
 package api
 
 import (
-	"fmt"
 	"github.com/donnie4w/go-logger/logger"
+	"insane/server"
+	"insane/utils"
 )
 
+// 容量模式：逐步加压找出满足SLO的最大并发数/速率，返回任务id，结果通过任务报告查看
 type TestMessage struct {
 	Message
 }
 
 func (testMessage *TestMessage) Do() {
-	load, err := testMessage.Message.InsaneRequest.Capacity()
+	testMessage.Message.InsaneRequest.Type = server.TYPE_CAPACITY
+	err := server.TK.TaskListAdd(testMessage.Message.InsaneRequest)
 	if err != nil {
 		logger.Debug(err)
-		return
 	}
-	testMessage.ResponseWriter.Write([]byte(fmt.Sprintf("%.2f", load)))
+	utils.Response(testMessage.Message.ResponseWriter, utils.RspData{
+		Msg:  utils.GetMsg(err),
+		Data: testMessage.Message.InsaneRequest.Id,
+	})
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
+	"github.com/gorilla/websocket"
 	"github.com/tidwall/gjson"
 	"insane/constant"
 	"insane/server"
@@ -13,10 +14,9 @@ import (
 	"time"
 )
 
+// 所有连接共用同一个WsMessage，每个连接的状态保存在wsSession中
 type WsMessage struct {
 	Message
-	MessageData gjson.Result
-	M           sync.Mutex
 }
 
 type WsResponse struct {
@@ -25,47 +25,64 @@ type WsResponse struct {
 	Data  interface{} `json:"data"`
 }
 
-// 任务Id，ws断开连接时，任务需要中断
-var taskId = ""
+// 一个websocket连接
+type wsSession struct {
+	conn  *websocket.Conn
+	live  *server.LiveSubscription
+	owned map[string]bool // 通过report订阅的任务，连接断开时中断
+	m     sync.Mutex
+}
 
 const (
-	WS_TYPE_PING   = "ping"
-	WS_TYPE_REPORT = "report"
-	WS_TYPE_SCRIPT = "test_script"
+	WS_TYPE_PING        = "ping"
+	WS_TYPE_REPORT      = "report"      // 每秒推送任务的完整报告，连接断开时中断任务
+	WS_TYPE_SUBSCRIBE   = "subscribe"   // 订阅一个或多个任务的增量数据，cluster为集群合并后的报告
+	WS_TYPE_UNSUBSCRIBE = "unsubscribe" // 取消订阅
+	WS_TYPE_LIVE        = "live"        // 每秒推送订阅任务的增量数据
+	WS_TYPE_CONTROL     = "control"     // 暂停、恢复或调整执行中的任务，data为{id, action, value}
+	WS_TYPE_SCRIPT      = "test_script"
 )
 
 func (wsMessage *WsMessage) Do() {
-
-	var wsConn = wsMessage.Message.WsConn
+	session := &wsSession{
+		conn:  wsMessage.Message.WsConn,
+		live:  server.NewLiveSubscription(),
+		owned: make(map[string]bool),
+	}
+	if session.conn == nil {
+		return
+	}
+	closed := make(chan struct{})
 	defer func() {
-		if wsConn != nil {
-			wsConn.Close()
-		}
+		close(closed)
+		session.conn.Close()
 	}()
 
 	// 心跳
 	go func() {
 		t := time.NewTicker(time.Duration(constant.MSG_HEARTBEAT) * time.Second)
+		defer t.Stop()
 		for {
-			<-t.C
-			if wsConn == nil {
-				logger.Debug("ws close")
+			select {
+			case <-closed:
 				return
+			case <-t.C:
 			}
-			if err := wsMessage.send(WS_TYPE_PING, nil, ""); err != nil {
-				wsConn.Close()
+			if err := session.send(WS_TYPE_PING, nil, ""); err != nil {
+				session.conn.Close()
 				logger.Debug("client close")
 				return
 			}
 		}
 	}()
+	go session.pushLive(closed)
 
 	for {
-		_, message, err := wsConn.ReadMessage()
+		_, message, err := session.conn.ReadMessage()
 
 		if err != nil {
 			logger.Debug(err)
-			wsMessage.taskClose()
+			session.taskClose()
 			break
 		}
 
@@ -74,23 +91,44 @@ func (wsMessage *WsMessage) Do() {
 			logger.Debug(err)
 			continue
 		}
-		wsType := data.Get("type").String()
-		wsMessage.MessageData = data
 
-		switch wsType {
+		switch data.Get("type").String() {
 		case WS_TYPE_REPORT:
-			go wsMessage.reqReport()
+			id := data.Get("data").String()
+			session.m.Lock()
+			session.owned[id] = true
+			session.m.Unlock()
+			go session.reqReport(id, closed)
+		case WS_TYPE_SUBSCRIBE:
+			session.live.Subscribe(wsIds(data.Get("data"))...)
+		case WS_TYPE_UNSUBSCRIBE:
+			session.live.Unsubscribe(wsIds(data.Get("data"))...)
+		case WS_TYPE_CONTROL:
+			state, err := server.ControlTask(data.Get("data.id").String(), data.Get("data.action").String(), data.Get("data.value").Int())
+			session.send(WS_TYPE_CONTROL, err, state)
 		case WS_TYPE_SCRIPT:
-			go wsMessage.testScript()
+			go session.testScript(data)
 		}
 
 	}
 }
 
-func (wsMessage *WsMessage) send(wsType string, wsErr error, data interface{}) (err error) {
+// data为任务id或任务id数组
+func wsIds(data gjson.Result) []string {
+	if !data.IsArray() {
+		return []string{data.String()}
+	}
+	var ids []string
+	for _, id := range data.Array() {
+		ids = append(ids, id.String())
+	}
+	return ids
+}
+
+func (session *wsSession) send(wsType string, wsErr error, data interface{}) (err error) {
 	// websocket并发有问题，这里使用互斥锁
-	wsMessage.M.Lock()
-	defer wsMessage.M.Unlock()
+	session.m.Lock()
+	defer session.m.Unlock()
 
 	var err1 string
 	if wsErr != nil {
@@ -106,48 +144,73 @@ func (wsMessage *WsMessage) send(wsType string, wsErr error, data interface{}) (
 		logger.Debug(err)
 		return err
 	}
-	if err := wsMessage.Message.WsConn.WriteMessage(constant.MSG_TYPE, dataByte); err != nil {
+	if err := session.conn.WriteMessage(constant.MSG_TYPE, dataByte); err != nil {
 		logger.Debug(err)
 		return err
 	}
-	logger.Info(fmt.Sprintf("remote addr: %s, type: %s, data: %s", wsMessage.Message.WsConn.RemoteAddr().String(), wsType, string(dataByte)))
+	logger.Info(fmt.Sprintf("remote addr: %s, type: %s, len: %d", session.conn.RemoteAddr().String(), wsType, len(dataByte)))
 	return nil
 }
 
-func (wsMessage *WsMessage) taskClose() {
-	if taskId != "" {
-		err := server.TK.TaskListRemove(taskId)
-		if err != nil {
+// 连接断开时中断通过report订阅、还没有结束的任务
+func (session *wsSession) taskClose() {
+	session.m.Lock()
+	defer session.m.Unlock()
+	for id := range session.owned {
+		if server.TK.TaskListStatus(id) == server.COMPLETED_TASK {
+			continue
+		}
+		if err := server.TK.TaskListRemove(id); err != nil {
 			logger.Debug(err)
 		}
 	}
 }
 
-func (wsMessage *WsMessage) reqReport() {
-	taskId = wsMessage.MessageData.Get("data").String()
+// 每秒推送一次完整的报告，任务结束后最后推送一次
+func (session *wsSession) reqReport(id string, closed <-chan struct{}) {
 	t := time.NewTicker(1 * time.Second)
+	defer t.Stop()
 	for {
-		<-t.C
-		if server.TK.TaskListStatus(taskId) == server.COMPLETED_TASK {
-			// 如果任务状态已完成，最后发送一次report然后结束
-			report := server.TK.TaskListInfo(taskId)
-			wsMessage.send(WS_TYPE_REPORT, nil, report)
+		select {
+		case <-closed:
+			return
+		case <-t.C:
+		}
+		completed := server.TK.TaskListStatus(id) == server.COMPLETED_TASK
+		if err := session.send(WS_TYPE_REPORT, nil, server.TK.TaskListInfo(id)); err != nil || completed {
+			return
+		}
+	}
+}
+
+// 每秒推送一次订阅任务的增量，没有订阅时不推送
+func (session *wsSession) pushLive(closed <-chan struct{}) {
+	t := time.NewTicker(1 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-t.C:
+		}
+		if session.live.Len() == 0 {
+			continue
+		}
+		if err := session.send(WS_TYPE_LIVE, nil, session.live.Frames()); err != nil {
 			return
 		}
-		report := server.TK.TaskListInfo(taskId)
-		wsMessage.send(WS_TYPE_REPORT, nil, report)
 	}
 }
 
-func (wsMessage *WsMessage) testScript() {
+func (session *wsSession) testScript(message gjson.Result) {
 	var (
 		err  error
 		vc   = make([]byte, 0)
-		data = wsMessage.MessageData.Get("data.data").Array()
+		data = message.Get("data.data").Array()
 	)
 
 	defer func() {
-		wsMessage.send(WS_TYPE_SCRIPT, err, string(vc))
+		session.send(WS_TYPE_SCRIPT, err, string(vc))
 	}()
 
 	if data == nil {
@@ -6,4 +6,11 @@ const (
 	ERROR_REQUEST_CONNECTION = 5001 // 连接失败
 	ERROR_REQUEST_RECEIVE    = 5002 // websocket接收数据失败
 	ERROR_REQUEST_TIMEOUT    = 5003 // 请求超时
+	ERROR_REQUEST_DNS        = 5004 // 域名解析失败
+	ERROR_REQUEST_REFUSED    = 5005 // 连接被拒绝
+	ERROR_REQUEST_RESET      = 5006 // 连接被重置
+	ERROR_REQUEST_TLS        = 5007 // tls错误
+	ERROR_REQUEST_ASSERTION  = 5008 // 响应内容不符合预期
+	ERROR_REQUEST_EXTRACTION = 5009 // 提取响应字段失败
+	ERROR_REQUEST_SCRIPT     = 5010 // 脚本执行错误
 )
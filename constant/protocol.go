@@ -11,4 +11,9 @@ const (
 
 	C_REPORT = 1002
 	S_REPORT = 2002
+
+	// 被测机器上的agent
+	A_REGISTER = 1003
+	S_AGENT    = 2003
+	A_SAMPLE   = 1004
 )
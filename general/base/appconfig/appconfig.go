@@ -10,6 +10,12 @@ type InsaneConfigs struct {
 	Log     Log        `toml:"log"`
 	Cluster Cluster    `toml:"cluster"`
 	File    File       `toml:"file"`
+	Record  Record     `toml:"record"`
+	Monitor Monitor    `toml:"monitor"`
+	Load    Load       `toml:"load"`
+	Push    Push       `toml:"push"`
+	Compare Compare    `toml:"compare"`
+	Agent   Agent      `toml:"agent"`
 }
 
 type HttpConfig struct {
@@ -36,6 +42,66 @@ type File struct {
 	UploadPath string `toml:"uploadPath"`
 }
 
+type Record struct {
+	Bind   string `toml:"bind"`
+	CaCert string `toml:"caCert"`
+	CaKey  string `toml:"caKey"`
+}
+
+// 施压机饱和的上限，超过时在报告中提示，为0时使用默认值
+type Monitor struct {
+	Cpu        float64 `toml:"cpu"`        // 整机cpu使用率（%）
+	Mem        float64 `toml:"mem"`        // 整机内存使用率（%）
+	Goroutines uint64  `toml:"goroutines"` // 协程数
+	GcPause    uint64  `toml:"gcPause"`    // 每秒GC暂停时间（毫秒）
+	Fd         float64 `toml:"fd"`         // 打开的文件描述符占上限的比例（%）
+	Backlog    float64 `toml:"backlog"`    // 结果通道积压占容量的比例（%）
+}
+
+// 本机负载采集
+type Load struct {
+	Interval  uint64 `toml:"interval"`  // 采集间隔（秒）
+	Retention uint64 `toml:"retention"` // 保留时长（秒）
+}
+
+// 任务执行期间按间隔推送聚合后的指标
+type Push struct {
+	Interval      uint64            `toml:"interval"`      // 聚合间隔（秒）
+	Batch         int               `toml:"batch"`         // 每次发送的最大行数
+	Retry         int               `toml:"retry"`         // 发送失败的重试次数
+	RetryInterval uint64            `toml:"retryInterval"` // 重试间隔（毫秒）
+	Buffer        int               `toml:"buffer"`        // 发送失败时缓存的最大行数，超出丢弃最早的
+	Tags          map[string]string `toml:"tags"`          // 附加到所有指标的标签
+	Influx        Influx            `toml:"influx"`
+	Statsd        Statsd            `toml:"statsd"`
+}
+
+type Influx struct {
+	Url         string `toml:"url"`         // http(s)://host:8086/write?db=insane 或 udp://host:8089，为空不推送
+	Token       string `toml:"token"`       // Authorization头，如 "Token xxx"
+	Measurement string `toml:"measurement"` // measurement前缀
+	PacketSize  int    `toml:"packetSize"`  // udp每个数据报的最大字节数
+}
+
+type Statsd struct {
+	Addr       string `toml:"addr"`       // host:8125，为空不推送
+	Prefix     string `toml:"prefix"`     // 指标名前缀
+	Dogstatsd  bool   `toml:"dogstatsd"`  // 使用DogStatsD的标签格式，否则标签拼接到指标名中
+	PacketSize int    `toml:"packetSize"` // 每个数据报的最大字节数
+}
+
+// 与基准对比时的默认容差，超出时判定为性能退化，为0时使用默认值
+type Compare struct {
+	Latency    float64 `toml:"latency"`    // 耗时增加的比例（%）
+	Throughput float64 `toml:"throughput"` // 每秒成功请求数下降的比例（%）
+	ErrorRate  float64 `toml:"errorRate"`  // 错误率增加的百分点
+}
+
+// 被测机器上的agent
+type Agent struct {
+	Token string `toml:"token"` // agent注册时需要携带的令牌，为空时不校验
+}
+
 var cnf InsaneConfigs
 
 func InitConfig(path string) error {
@@ -33,13 +33,26 @@ func HttpConfigInit() {
 
 func RegisterRoutesHandle() {
 	http.HandleFunc("/request", api.HandleMessage(new(api.PushMessage), true))
+	http.HandleFunc("/run", api.HandleMessage(new(api.RunMessage), false))
 	http.HandleFunc("/info", api.HandleMessage(new(api.InfoMessage), true))
 	http.HandleFunc("/del", api.HandleMessage(new(api.DeleteMessage), true))
 	http.HandleFunc("/ws", api.HandleMessage(new(api.WsMessage), true))
+	http.HandleFunc("/live", api.HandleMessage(new(api.LiveMessage), false))
+	http.HandleFunc("/control", api.HandleMessage(new(api.ControlMessage), false))
 	http.HandleFunc("/serverLoad", api.HandleMessage(new(api.ServerLoadMessage), true))
 	http.HandleFunc("/upload", api.HandleMessage(new(api.UploadMessage), false))
 	http.HandleFunc("/data", api.HandleMessage(new(api.DataMessage), false))
 	http.HandleFunc("/test", api.HandleMessage(new(api.TestMessage), true))
+	http.HandleFunc("/import", api.HandleMessage(new(api.ImportMessage), false))
+	http.HandleFunc("/record", api.HandleMessage(new(api.RecordMessage), false))
+	http.HandleFunc("/agent", api.HandleMessage(new(api.AgentMessage), false))
+	http.HandleFunc("/cluster", api.HandleMessage(new(api.ClusterMessage), false))
+	http.HandleFunc("/agents", api.HandleMessage(new(api.AgentListMessage), false))
+	http.HandleFunc("/metrics", api.HandleMessage(new(api.MetricsMessage), false))
+	http.HandleFunc("/report", api.HandleMessage(new(api.ReportMessage), false))
+	http.HandleFunc("/compare", api.HandleMessage(new(api.CompareMessage), false))
+	http.HandleFunc("/baseline", api.HandleMessage(new(api.BaselineMessage), false))
+	http.HandleFunc("/schedule", api.HandleMessage(new(api.ScheduleMessage), false))
 
 	http.HandleFunc("/getCsvInfo/", api.HandleMessage(new(api.CsvInfoMessage), true))
 
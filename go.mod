@@ -7,9 +7,14 @@ require (
 	github.com/StackExchange/wmi v0.0.0-20190523213315-cbe66965904d // indirect
 	github.com/donnie4w/go-logger v0.0.0-20170827050443-4740c51383f4
 	github.com/go-ole/go-ole v1.2.4 // indirect
+	github.com/golang/protobuf v1.3.2
 	github.com/gorilla/websocket v1.4.1
+	github.com/jhump/protoreflect v1.5.0
 	github.com/shirou/gopsutil v2.19.11+incompatible
 	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/tidwall/gjson v1.3.5
+	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
 	golang.org/x/sys v0.0.0-20191219235734-af0d71d358ab // indirect
+	google.golang.org/grpc v1.26.0
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
 	"insane/general/base/appconfig"
@@ -9,10 +10,72 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
 func main() {
+	// agent模式：运行在被测机器上，把负载上报给insane服务，不启动http服务
+	agentUrl := flag.String("agent", "", "insane服务的agent地址，如 ws://127.0.0.1:9500/agent")
+	agentName := flag.String("name", "", "被测机器名称，默认为主机名")
+	agentProcess := flag.String("process", "", "监控的进程名或pid，多个用逗号分隔")
+	agentInterval := flag.Uint64("interval", 1, "上报间隔（秒）")
+	agentToken := flag.String("token", "", "注册令牌，与insane服务配置的agent.token一致")
+	// 模拟推送的接收端，打印收到的数据
+	pushListen := flag.String("listen", "", "模拟的推送接收端，如 udp://:8125 或 http://:8086")
+	// 导出报告后退出
+	reportSource := flag.String("report", "", "导出报告，任务id（读取日志目录）或报告json文件路径")
+	reportFormat := flag.String("format", "html", "报告格式：html | json | csv | junit | samples")
+	reportOut := flag.String("out", "", "报告的保存路径，默认为 insane-<id>.<格式>")
+	// 对比报告后退出，有超出容差的指标时退出码为1
+	compareIds := flag.String("compare", "", "对比报告，逗号分隔的任务id，以第一个为基准")
+	baselineId := flag.String("baseline", "", "与同名任务的基准对比的任务id")
+	flag.Parse()
+	if *compareIds != "" || *baselineId != "" {
+		if err := appconfig.InitConfig("./config/app.toml"); err != nil {
+			logger.Debug(err)
+		}
+		var comparison *server.Comparison
+		var err error
+		if *compareIds != "" {
+			comparison, err = server.CompareReports(strings.Split(*compareIds, ","), nil)
+		} else {
+			comparison, err = server.CompareBaseline(*baselineId, nil)
+		}
+		if err != nil {
+			logger.Error(err)
+			os.Exit(2)
+		}
+		fmt.Print(comparison.Text())
+		if comparison.Regressions > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *reportSource != "" {
+		if err := appconfig.InitConfig("./config/app.toml"); err != nil {
+			logger.Debug(err)
+		}
+		out, err := server.ExportReportFile(*reportSource, *reportFormat, *reportOut)
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+		fmt.Println(out)
+		return
+	}
+	if *pushListen != "" {
+		if err := server.PushListen(*pushListen); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+	if *agentUrl != "" {
+		logger.Info("insane agent ready")
+		server.NewAgentClient(*agentUrl, *agentName, *agentToken, *agentProcess, *agentInterval).Run()
+		return
+	}
+
 	logger.Info("insane server ready")
 	if err := appconfig.InitConfig("./config/app.toml"); err != nil {
 		logger.Debug("insane server error ", err)
@@ -23,7 +86,10 @@ func main() {
 
 	go server.TK.TaskListRun()
 	go insane.OnStart()
-	go server.InsaneLoad.Start(3)
+	go server.InsaneLoad.Start()
+	go server.InsanePush.Start()
+	go server.InsaneScheduler.Start()
+	go server.InsaneCluster.Run()
 	logger.Debug("insane server starting ")
 
 	for {
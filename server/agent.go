@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"sort"
+	"sync"
+)
+
+// 被测机器上的agent上报的负载，与本机负载的采样相同，另外有磁盘与进程
+type AgentSample struct {
+	LoadSample
+	Disk      *DiskInfo        `json:"disk"`      // 每秒磁盘读写字节数
+	Processes []*ProcessSample `json:"processes"` // 指定进程的负载
+}
+
+type DiskInfo struct {
+	Read  uint64 `json:"read"`
+	Write uint64 `json:"write"`
+}
+
+type ProcessSample struct {
+	Pid     int32   `json:"pid"`
+	Name    string  `json:"name"`
+	Cpu     float64 `json:"cpu"`     // 进程cpu使用率，占单个核心的比例（%）
+	Mem     uint64  `json:"mem"`     // 占用内存（MB）
+	Threads int32   `json:"threads"` // 线程数
+	Fds     int32   `json:"fds"`     // 打开的文件描述符数
+	Conn    uint32  `json:"conn"`    // 连接数
+}
+
+type AgentRegister struct {
+	Token      string     `json:"token"` // 与配置的agent.token一致才能注册
+	Name       string     `json:"name"`
+	ServerInfo ServerInfo `json:"serverInfo"`
+	Processes  []string   `json:"processes"` // 监控的进程名或pid
+}
+
+type AgentMsg struct {
+	ProtoId  uint64         `json:"protoId"`
+	Register *AgentRegister `json:"register"`
+	Sample   *AgentSample   `json:"sample"`
+}
+
+type Agent struct {
+	Name       string       `json:"name"`
+	Addr       string       `json:"addr"`
+	ServerInfo ServerInfo   `json:"serverInfo"`
+	Processes  []string     `json:"processes"`
+	Last       *AgentSample `json:"last"`     // 最近一次上报的负载
+	LastTime   int64        `json:"lastTime"` // 最近一次上报的时间（毫秒）
+}
+
+// 任务报告中被测机器每秒的负载
+type TargetReport struct {
+	ServerInfo ServerInfo              `json:"serverInfo"`
+	Samples    map[uint64]*AgentSample `json:"samples"`
+}
+
+type TargetReports map[string]*TargetReport
+
+// 正在执行的任务，agent上报的负载按任务开始后的秒数写入报告
+type agentTask struct {
+	startTime int64
+	m         sync.Locker // 报告的锁
+	targets   TargetReports
+}
+
+type AgentList struct {
+	agents map[string]*Agent
+	tasks  map[string]*agentTask
+	m      sync.Mutex
+}
+
+var InsaneAgents = &AgentList{
+	agents: make(map[string]*Agent),
+	tasks:  make(map[string]*agentTask),
+}
+
+func (agentList *AgentList) Register(register *AgentRegister, addr string) (*Agent, error) {
+	if register == nil || register.Name == "" {
+		return nil, errors.New("agent名称不能为空")
+	}
+	if token := appconfig.GetConfig().Agent.Token; token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(register.Token)) != 1 {
+		return nil, errors.New("agent令牌错误")
+	}
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	// 同名agent重连时替换旧的连接
+	agent := &Agent{
+		Name:       register.Name,
+		Addr:       addr,
+		ServerInfo: register.ServerInfo,
+		Processes:  register.Processes,
+	}
+	agentList.agents[agent.Name] = agent
+	return agent, nil
+}
+
+func (agentList *AgentList) Unregister(agent *Agent) {
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	if cur, ok := agentList.agents[agent.Name]; ok && cur == agent {
+		delete(agentList.agents, agent.Name)
+	}
+}
+
+func (agentList *AgentList) AddSample(agent *Agent, sample *AgentSample) {
+	now := utils.Now()
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	agent.Last = sample
+	agent.LastTime = now
+	for _, task := range agentList.tasks {
+		curSecond := utils.CurSecond(uint64(task.startTime))
+		task.m.Lock()
+		target, ok := task.targets[agent.Name]
+		if !ok {
+			target = &TargetReport{ServerInfo: agent.ServerInfo, Samples: make(map[uint64]*AgentSample)}
+			task.targets[agent.Name] = target
+		}
+		target.Samples[curSecond] = sample
+		task.m.Unlock()
+	}
+}
+
+func (agentList *AgentList) List() []*Agent {
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	list := make([]*Agent, 0, len(agentList.agents))
+	for _, agent := range agentList.agents {
+		cur := *agent
+		list = append(list, &cur)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// 任务开始时加入，结束时移除
+func (agentList *AgentList) attach(id string, m sync.Locker, targets TargetReports) {
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	agentList.tasks[id] = &agentTask{startTime: utils.Now(), m: m, targets: targets}
+}
+
+func (agentList *AgentList) detach(id string) {
+	agentList.m.Lock()
+	defer agentList.m.Unlock()
+	delete(agentList.tasks, id)
+}
+
+// 合并集群中其他节点的报告，同一台被测机器的负载以先到的为准
+func (targets TargetReports) merge(other TargetReports) {
+	for name, target := range other {
+		cur, ok := targets[name]
+		if !ok {
+			cur = &TargetReport{ServerInfo: target.ServerInfo, Samples: make(map[uint64]*AgentSample)}
+			targets[name] = cur
+		}
+		for second, sample := range target.Samples {
+			if _, ok := cur.Samples[second]; !ok {
+				cur.Samples[second] = sample
+			}
+		}
+	}
+}
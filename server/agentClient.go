@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/gorilla/websocket"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/process"
+	"insane/constant"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AGENT_RECONNECT = 3  // 断开后重连间隔（秒）
+	AGENT_RESCAN    = 10 // 每上报多少次重新按名称查找进程
+)
+
+// agent模式：运行在被测机器上，定时把负载上报给insane服务
+type AgentClient struct {
+	Url       string   // insane服务的agent地址，如 ws://127.0.0.1:9500/agent
+	Name      string   // 被测机器名称，默认为主机名
+	Token     string   // 注册令牌
+	Processes []string // 监控的进程名或pid
+	Interval  uint64   // 上报间隔（秒）
+
+	procs    map[int32]*process.Process
+	samples  int
+	load     ServerLoad // cpu、内存、网络等与本机负载的采集相同
+	lastDisk *DiskInfo
+	lastTime time.Time
+}
+
+func NewAgentClient(url string, name string, token string, processes string, interval uint64) *AgentClient {
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	if interval == 0 {
+		interval = 1
+	}
+	agentClient := &AgentClient{
+		Url:      url,
+		Name:     name,
+		Token:    token,
+		Interval: interval,
+		procs:    make(map[int32]*process.Process),
+	}
+	for _, p := range strings.Split(processes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			agentClient.Processes = append(agentClient.Processes, p)
+		}
+	}
+	return agentClient
+}
+
+// 一直运行，连接断开后重连
+func (agentClient *AgentClient) Run() {
+	InsaneLoad.GetServerInfo()
+	for {
+		if err := agentClient.connect(); err != nil {
+			logger.Debug(err)
+		}
+		time.Sleep(AGENT_RECONNECT * time.Second)
+	}
+}
+
+func (agentClient *AgentClient) connect() error {
+	wsConn, _, err := websocket.DefaultDialer.Dial(agentClient.Url, nil)
+	if err != nil {
+		return err
+	}
+	defer wsConn.Close()
+
+	if err = agentClient.send(wsConn, &AgentMsg{
+		ProtoId: constant.A_REGISTER,
+		Register: &AgentRegister{
+			Token:      agentClient.Token,
+			Name:       agentClient.Name,
+			ServerInfo: InsaneLoad.ServerInfo,
+			Processes:  agentClient.Processes,
+		},
+	}); err != nil {
+		return err
+	}
+
+	// 服务端拒绝注册或断开时结束
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			var msg ProtoReplyMsg
+			_, message, err := wsConn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			if err := json.Unmarshal(message, &msg); err != nil {
+				logger.Debug(err)
+			}
+		}
+	}()
+
+	logger.Info("agent connected: ", agentClient.Url)
+	agentClient.sample() // 第一次采集作为计算速率的起点
+	t := time.NewTicker(time.Duration(agentClient.Interval) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-closed:
+			return err
+		case <-t.C:
+		}
+		if err := agentClient.send(wsConn, &AgentMsg{ProtoId: constant.A_SAMPLE, Sample: agentClient.sample()}); err != nil {
+			return err
+		}
+	}
+}
+
+func (agentClient *AgentClient) send(wsConn *websocket.Conn, msg *AgentMsg) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return wsConn.WriteMessage(constant.MSG_TYPE, data)
+}
+
+func (agentClient *AgentClient) sample() *AgentSample {
+	now := time.Now()
+	seconds := now.Sub(agentClient.lastTime).Seconds()
+	agentClient.lastTime = now
+
+	sample := &AgentSample{LoadSample: *agentClient.load.sample()}
+
+	// 所有磁盘的读写字节数
+	if counters, err := disk.IOCounters(); err == nil {
+		cur := new(DiskInfo)
+		for _, counter := range counters {
+			cur.Read += counter.ReadBytes
+			cur.Write += counter.WriteBytes
+		}
+		if last := agentClient.lastDisk; last != nil && seconds > 0 {
+			sample.Disk = &DiskInfo{
+				Read:  counterRate(last.Read, cur.Read, seconds),
+				Write: counterRate(last.Write, cur.Write, seconds),
+			}
+		}
+		agentClient.lastDisk = cur
+	} else {
+		logger.Debug(err)
+	}
+
+	sample.Processes = agentClient.processSamples()
+	return sample
+}
+
+func (agentClient *AgentClient) processSamples() []*ProcessSample {
+	if len(agentClient.Processes) == 0 {
+		return nil
+	}
+	if agentClient.samples%AGENT_RESCAN == 0 {
+		agentClient.findProcesses()
+	}
+	agentClient.samples++
+
+	list := make([]*ProcessSample, 0, len(agentClient.procs))
+	for pid, proc := range agentClient.procs {
+		if running, _ := proc.IsRunning(); !running {
+			delete(agentClient.procs, pid)
+			continue
+		}
+		processSample := &ProcessSample{Pid: pid}
+		processSample.Name, _ = proc.Name()
+		processSample.Cpu, _ = proc.Percent(0)
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			processSample.Mem = memInfo.RSS / 1024 / 1024
+		}
+		processSample.Threads, _ = proc.NumThreads()
+		processSample.Fds, _ = proc.NumFDs()
+		if conns, err := proc.Connections(); err == nil {
+			processSample.Conn = uint32(len(conns))
+		}
+		list = append(list, processSample)
+	}
+	return list
+}
+
+// 按pid或进程名查找，保留已有的进程对象用于计算cpu使用率
+func (agentClient *AgentClient) findProcesses() {
+	pids, err := process.Pids()
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	for _, pid := range pids {
+		if _, ok := agentClient.procs[pid]; ok {
+			continue
+		}
+		proc, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		name, _ := proc.Name()
+		for _, p := range agentClient.Processes {
+			if p == name || p == strconv.Itoa(int(pid)) {
+				agentClient.procs[pid] = proc
+				proc.Percent(0)
+				break
+			}
+		}
+	}
+}
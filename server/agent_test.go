@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"insane/general/base/appconfig"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestAgentList() *AgentList {
+	return &AgentList{agents: make(map[string]*Agent), tasks: make(map[string]*agentTask)}
+}
+
+func TestAgentListRegister(t *testing.T) {
+	agentList := newTestAgentList()
+	for _, register := range []*AgentRegister{nil, {}} {
+		if _, err := agentList.Register(register, "127.0.0.1:1"); err == nil {
+			t.Errorf("%+v: want error", register)
+		}
+	}
+
+	old, _ := agentList.Register(&AgentRegister{Name: "db"}, "10.0.0.1:1")
+	web, _ := agentList.Register(&AgentRegister{Name: "web", Processes: []string{"nginx"}}, "10.0.0.2:1")
+	// 同名agent重连后，旧连接断开不影响新连接
+	db, _ := agentList.Register(&AgentRegister{Name: "db"}, "10.0.0.1:2")
+	agentList.Unregister(old)
+
+	list := agentList.List()
+	if len(list) != 2 || list[0].Name != "db" || list[0].Addr != "10.0.0.1:2" || list[1].Name != "web" {
+		t.Fatalf("list = %+v", list)
+	}
+	list[0].Addr = ""
+	if db.Addr == "" {
+		t.Error("List should return copies")
+	}
+	agentList.Unregister(web)
+	if list := agentList.List(); len(list) != 1 {
+		t.Errorf("list = %+v", list)
+	}
+}
+
+func TestAgentListRegisterToken(t *testing.T) {
+	config := appconfig.GetConfig().Agent
+	defer func() { appconfig.GetConfig().Agent = config }()
+	appconfig.GetConfig().Agent.Token = "secret"
+
+	agentList := newTestAgentList()
+	cases := []struct {
+		token string
+		err   bool
+	}{
+		{token: "", err: true},
+		{token: "secre", err: true},
+		{token: "secret"},
+	}
+	for _, c := range cases {
+		if _, err := agentList.Register(&AgentRegister{Name: "db", Token: c.token}, "10.0.0.1:1"); (err != nil) != c.err {
+			t.Errorf("token %q: err = %v", c.token, err)
+		}
+	}
+}
+
+func TestAgentSampleJson(t *testing.T) {
+	// 与本机负载的采样字段相同
+	data, err := json.Marshal(&AgentSample{LoadSample: LoadSample{Cpu: 12.5, Net: &NetRate{BytesSent: 3}}, Disk: &DiskInfo{Read: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sample AgentSample
+	if err := json.Unmarshal(data, &sample); err != nil || sample.Cpu != 12.5 || sample.Net.BytesSent != 3 || sample.Disk.Read != 1 {
+		t.Errorf("sample = %s", data)
+	}
+	if !strings.Contains(string(data), `"cpu":12.5`) {
+		t.Errorf("sample = %s", data)
+	}
+}
+
+func TestAgentListAddSample(t *testing.T) {
+	agentList := newTestAgentList()
+	agent, _ := agentList.Register(&AgentRegister{Name: "db", ServerInfo: ServerInfo{Cpu: 8}}, "10.0.0.1:1")
+
+	sample := &AgentSample{LoadSample: LoadSample{Cpu: 50}}
+	agentList.AddSample(agent, sample) // 没有任务时只记录最近一次
+	if agent.Last != sample || agent.LastTime == 0 {
+		t.Errorf("agent = %+v", agent)
+	}
+
+	var m sync.Mutex
+	targets := make(TargetReports)
+	agentList.attach("t1", &m, targets) // 任务开始后的第一秒记为1
+	agentList.AddSample(agent, &AgentSample{LoadSample: LoadSample{Cpu: 60}})
+	agentList.detach("t1")
+	agentList.AddSample(agent, &AgentSample{LoadSample: LoadSample{Cpu: 70}})
+
+	target, ok := targets["db"]
+	if !ok || target.ServerInfo.Cpu != 8 || len(target.Samples) != 1 || target.Samples[1].Cpu != 60 {
+		t.Errorf("targets = %+v", targets)
+	}
+}
+
+func TestTargetReportsMerge(t *testing.T) {
+	targets := TargetReports{"db": {Samples: map[uint64]*AgentSample{1: {LoadSample: LoadSample{Cpu: 10}}}}}
+	targets.merge(TargetReports{
+		"db":  {Samples: map[uint64]*AgentSample{1: {LoadSample: LoadSample{Cpu: 90}}, 2: {LoadSample: LoadSample{Cpu: 20}}}},
+		"web": {ServerInfo: ServerInfo{Cpu: 4}, Samples: map[uint64]*AgentSample{1: {LoadSample: LoadSample{Cpu: 30}}}},
+	})
+	if targets["db"].Samples[1].Cpu != 10 || targets["db"].Samples[2].Cpu != 20 {
+		t.Errorf("db = %+v", targets["db"].Samples)
+	}
+	if web := targets["web"]; web.ServerInfo.Cpu != 4 || web.Samples[1].Cpu != 30 {
+		t.Errorf("web = %+v", web)
+	}
+}
+
+func TestNewAgentClient(t *testing.T) {
+	agentClient := NewAgentClient("ws://h/agent", "db", "t", " mysqld, 123 ,,", 0)
+	if agentClient.Name != "db" || agentClient.Token != "t" || agentClient.Interval != 1 || !reflect.DeepEqual(agentClient.Processes, []string{"mysqld", "123"}) {
+		t.Errorf("agentClient = %+v", agentClient)
+	}
+	if agentClient := NewAgentClient("ws://h/agent", "", "", "", 5); agentClient.Name == "" || agentClient.Interval != 5 || len(agentClient.Processes) != 0 {
+		t.Errorf("agentClient = %+v", agentClient)
+	}
+}
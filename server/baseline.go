@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/tidwall/gjson"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 任务的基准运行，同名任务结束时与它对比
+type Baseline struct {
+	Name      string     `json:"name"`      // 任务名称
+	Id        string     `json:"id"`        // 作为基准的报告
+	Tolerance *Tolerance `json:"tolerance"` // 为空时使用配置中的容差
+	Created   string     `json:"created"`
+}
+
+type Baselines struct {
+	list   map[string]*Baseline
+	loaded bool
+	m      sync.Mutex
+}
+
+var InsaneBaselines = &Baselines{}
+
+func baselinesFile() string {
+	return fmt.Sprintf("%s/baselines.json", appconfig.GetConfig().Log.Location)
+}
+
+// 设置任务的基准，name为空时使用报告中的任务名称
+func (baselines *Baselines) Set(name string, id string, tolerance *Tolerance) (*Baseline, error) {
+	content, err := ReportContent(id)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = gjson.GetBytes(content, "name").String()
+	}
+	if name == "" {
+		return nil, errors.New("任务没有名称，需要指定name")
+	}
+	baseline := &Baseline{Name: name, Id: id, Tolerance: tolerance, Created: time.Now().Format("2006-01-02 15:04:05")}
+	baselines.m.Lock()
+	defer baselines.m.Unlock()
+	baselines.load()
+	baselines.list[name] = baseline
+	return baseline, baselines.save()
+}
+
+func (baselines *Baselines) Get(name string) *Baseline {
+	baselines.m.Lock()
+	defer baselines.m.Unlock()
+	baselines.load()
+	return baselines.list[name]
+}
+
+func (baselines *Baselines) List() []*Baseline {
+	baselines.m.Lock()
+	defer baselines.m.Unlock()
+	baselines.load()
+	list := make([]*Baseline, 0, len(baselines.list))
+	for _, baseline := range baselines.list {
+		list = append(list, baseline)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (baselines *Baselines) Delete(name string) error {
+	baselines.m.Lock()
+	defer baselines.m.Unlock()
+	baselines.load()
+	if _, ok := baselines.list[name]; !ok {
+		return errors.New("基准不存在")
+	}
+	delete(baselines.list, name)
+	return baselines.save()
+}
+
+// 报告与同名任务的基准对比
+func CompareBaseline(id string, tolerance *Tolerance) (*Comparison, error) {
+	content, err := ReportContent(id)
+	if err != nil {
+		return nil, err
+	}
+	name := gjson.GetBytes(content, "name").String()
+	if name == "" {
+		return nil, errors.New("任务没有名称，无法对比基准")
+	}
+	baseline := InsaneBaselines.Get(name)
+	if baseline == nil {
+		return nil, fmt.Errorf("任务%s没有设置基准", name)
+	}
+	if tolerance == nil {
+		tolerance = baseline.Tolerance
+	}
+	return CompareReports([]string{baseline.Id, id}, tolerance)
+}
+
+// 任务结束时与基准对比，没有名称、没有基准或自身就是基准时返回nil
+func (baselines *Baselines) check(run *CompareRun) *Comparison {
+	if run.Name == "" {
+		return nil
+	}
+	baseline := baselines.Get(run.Name)
+	if baseline == nil || baseline.Id == run.Id {
+		return nil
+	}
+	content, err := ReportContent(baseline.Id)
+	if err != nil {
+		logger.Debug(err)
+		return nil
+	}
+	base, err := loadCompareRun(baseline.Id, content)
+	if err != nil {
+		logger.Debug(err)
+		return nil
+	}
+	return compareRuns([]*CompareRun{base, run}, baseline.Tolerance)
+}
+
+func (baselines *Baselines) load() {
+	if baselines.loaded {
+		return
+	}
+	baselines.loaded = true
+	baselines.list = make(map[string]*Baseline)
+	content, err := utils.FileGet(baselinesFile())
+	if err != nil || content == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(content), &baselines.list); err != nil {
+		logger.Debug(err)
+	}
+}
+
+func (baselines *Baselines) save() error {
+	content, err := json.MarshalIndent(baselines.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.FileWrite(baselinesFile(), string(content))
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/utils"
+	"sort"
+	"sync"
+)
+
+const (
+	TYPE_COMMON   = "common"
+	TYPE_CAPACITY = "capacity" // 逐步加压，找出满足SLO的最大并发数/速率
+
+	CAPACITY_MODE_STEP   = "step"   // 从start开始每次增加step，直到不满足SLO或达到max
+	CAPACITY_MODE_BINARY = "binary" // 在start与max之间二分查找
+
+	CAPACITY_TARGET_CONCURRENCY = "concurrency" // 调整并发数
+	CAPACITY_TARGET_RATE        = "rate"        // 调整每秒请求数，并发数为执行请求的协程数
+
+	CAPACITY_DEFAULT_STEP_DURATION = 30 // 每一步默认持续时间（秒）
+	CAPACITY_DEFAULT_ERROR_RATE    = 1  // 默认允许的错误率（%）
+)
+
+type CapacityRequest struct {
+	Mode         string  `json:"mode"`         // step | binary
+	Target       string  `json:"target"`       // concurrency | rate
+	Start        uint64  `json:"start"`        // 起始并发数/速率
+	Step         uint64  `json:"step"`         // step：每步增加的并发数/速率，默认为start
+	Max          uint64  `json:"max"`          // 最大并发数/速率
+	Precision    uint64  `json:"precision"`    // binary：查找范围小于该值时结束，默认为start
+	StepDuration uint64  `json:"stepDuration"` // 每一步持续时间（秒）
+	P95          uint64  `json:"p95"`          // SLO：95%请求的耗时上限（毫秒），为0不检查
+	P99          uint64  `json:"p99"`          // SLO：99%请求的耗时上限（毫秒），为0不检查
+	ErrorRate    float64 `json:"errorRate"`    // SLO：错误率上限（%）
+
+	m       sync.Mutex
+	current *InsaneRequest // 正在执行的一步
+	stopped bool
+}
+
+type CapacityReport struct {
+	Mode     string          `json:"mode"`
+	Target   string          `json:"target"`
+	Slo      *CapacitySlo    `json:"slo"`
+	Steps    []*CapacityStep `json:"steps"`    // 按执行顺序
+	Curve    []*CapacityStep `json:"curve"`    // 按并发数/速率排序的负载曲线
+	Best     *CapacityStep   `json:"best"`     // 满足SLO的最大并发数/速率
+	Knee     *CapacityStep   `json:"knee"`     // 拐点：吞吐量/平均耗时最大的一步，再加压收益开始下降
+	Running  uint64          `json:"running"`  // 正在执行的并发数/速率
+	Finished bool            `json:"finished"` // 查找结束
+}
+
+type CapacitySlo struct {
+	P95       uint64  `json:"p95"`
+	P99       uint64  `json:"p99"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+type CapacityStep struct {
+	Level      uint64  `json:"level"` // 并发数或速率
+	SuccessNum uint64  `json:"successNum"`
+	FailureNum uint64  `json:"failureNum"`
+	Rps        float64 `json:"rps"`       // 每秒成功请求数
+	ErrorRate  float64 `json:"errorRate"` // 错误率（%）
+	Avg        uint64  `json:"avg"`       // 耗时（毫秒）
+	P50        uint64  `json:"p50"`
+	P95        uint64  `json:"p95"`
+	P99        uint64  `json:"p99"`
+	Passed     bool    `json:"passed"`    // 是否满足SLO
+	Reason     string  `json:"reason"`    // 不满足SLO的原因
+	Saturated  bool    `json:"saturated"` // 施压机饱和或跟不上计划，结果偏乐观
+}
+
+func (capacity *CapacityRequest) Load(insaneRequest *InsaneRequest) error {
+	switch insaneRequest.Form {
+	case TYPE_HTTP, TYPE_WEBSOCKET, TYPE_GRPC, TYPE_TCP, TYPE_UDP:
+	default:
+		return fmt.Errorf("容量模式只支持%s | %s | %s | %s | %s", TYPE_HTTP, TYPE_WEBSOCKET, TYPE_GRPC, TYPE_TCP, TYPE_UDP)
+	}
+	switch capacity.Mode {
+	case "":
+		capacity.Mode = CAPACITY_MODE_STEP
+	case CAPACITY_MODE_STEP, CAPACITY_MODE_BINARY:
+	default:
+		return fmt.Errorf("mode必须是%s | %s", CAPACITY_MODE_STEP, CAPACITY_MODE_BINARY)
+	}
+	switch capacity.Target {
+	case "":
+		capacity.Target = CAPACITY_TARGET_CONCURRENCY
+	case CAPACITY_TARGET_CONCURRENCY:
+	case CAPACITY_TARGET_RATE:
+		// 每一步的速率由查找决定，起始速率用于校验
+		insaneRequest.Executor = EXECUTOR_RATE
+		insaneRequest.Rate = capacity.Start
+		if insaneRequest.ConCurrency == 0 {
+			return errors.New("rate需要设置conCurrent作为执行请求的协程数")
+		}
+	default:
+		return fmt.Errorf("target必须是%s | %s", CAPACITY_TARGET_CONCURRENCY, CAPACITY_TARGET_RATE)
+	}
+	if capacity.Start == 0 || capacity.Max < capacity.Start {
+		return errors.New("start必须大于0且不大于max")
+	}
+	if capacity.Target == CAPACITY_TARGET_RATE && capacity.Max > SCHEDULE_MAX_RATE {
+		return fmt.Errorf("max不能超过%d", SCHEDULE_MAX_RATE)
+	}
+	if capacity.Step == 0 {
+		capacity.Step = capacity.Start
+	}
+	if capacity.Precision == 0 {
+		capacity.Precision = capacity.Start
+	}
+	if capacity.StepDuration == 0 {
+		capacity.StepDuration = CAPACITY_DEFAULT_STEP_DURATION
+	}
+	if capacity.ErrorRate <= 0 {
+		capacity.ErrorRate = CAPACITY_DEFAULT_ERROR_RATE
+	}
+	return nil
+}
+
+// 依次执行每一步，结果写入任务报告
+func (capacity *CapacityRequest) run(insaneRequest *InsaneRequest) {
+	report := &CapacityReport{
+		Mode:   capacity.Mode,
+		Target: capacity.Target,
+		Slo:    &CapacitySlo{P95: capacity.P95, P99: capacity.P99, ErrorRate: capacity.ErrorRate},
+	}
+	insaneRequest.Report.m.Lock()
+	insaneRequest.Report.Capacity = report
+	insaneRequest.Report.Targets = make(TargetReports)
+	insaneRequest.Report.m.Unlock()
+	// 被测机器的负载覆盖整个查找过程，与每一步的结果对照
+	InsaneAgents.attach(insaneRequest.Id, &insaneRequest.Report.m, insaneRequest.Report.Targets)
+	startTime := utils.Now()
+
+	if capacity.Mode == CAPACITY_MODE_BINARY {
+		capacity.binarySearch(insaneRequest, report)
+	} else {
+		for level := capacity.Start; level <= capacity.Max; level += capacity.Step {
+			step := capacity.runStep(insaneRequest, report, level)
+			if step == nil || !step.Passed {
+				break
+			}
+		}
+	}
+
+	InsaneAgents.detach(insaneRequest.Id)
+	insaneRequest.Report.m.Lock()
+	insaneRequest.Report.RequestTime = uint64((utils.Now() - startTime) / 1000)
+	report.Running = 0
+	report.Finished = true
+	insaneRequest.Report.Status = true
+	insaneRequest.Report.m.Unlock()
+	insaneRequest.Report.save(insaneRequest.Id)
+}
+
+// start不满足SLO时结束，max满足时直接返回max，否则在两者之间查找
+func (capacity *CapacityRequest) binarySearch(insaneRequest *InsaneRequest, report *CapacityReport) {
+	lo, hi := capacity.Start, capacity.Max
+	if step := capacity.runStep(insaneRequest, report, lo); step == nil || !step.Passed {
+		return
+	}
+	if hi == lo {
+		return
+	}
+	if step := capacity.runStep(insaneRequest, report, hi); step == nil || step.Passed {
+		return
+	}
+	for hi-lo > capacity.Precision {
+		mid := lo + (hi-lo)/2
+		step := capacity.runStep(insaneRequest, report, mid)
+		if step == nil {
+			return
+		}
+		if step.Passed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+}
+
+// 执行一步，任务被停止时返回nil
+func (capacity *CapacityRequest) runStep(insaneRequest *InsaneRequest, report *CapacityReport, level uint64) *CapacityStep {
+	step := insaneRequest.capacityStep(len(report.Steps), level, capacity.StepDuration)
+	capacity.m.Lock()
+	if capacity.stopped {
+		capacity.m.Unlock()
+		return nil
+	}
+	capacity.current = step
+	capacity.m.Unlock()
+
+	insaneRequest.Report.m.Lock()
+	report.Running = level
+	insaneRequest.Report.m.Unlock()
+	logger.Debug(fmt.Sprintf("容量模式：%s=%d", capacity.Target, level))
+
+	step.Dispose()
+
+	capacity.m.Lock()
+	capacity.current = nil
+	stopped := capacity.stopped
+	capacity.m.Unlock()
+	if stopped {
+		return nil
+	}
+
+	result := capacity.evaluate(step.Report, level)
+	insaneRequest.Report.m.Lock()
+	report.add(result)
+	insaneRequest.Report.m.Unlock()
+	return result
+}
+
+// 每一步使用新的统计，请求配置与连接共用
+func (insaneRequest *InsaneRequest) capacityStep(index int, level uint64, duration uint64) *InsaneRequest {
+	step := &InsaneRequest{
+		HttpRequest:    insaneRequest.HttpRequest,
+		GrpcRequest:    insaneRequest.GrpcRequest,
+		SocketRequest:  insaneRequest.SocketRequest,
+		ConCurrency:    insaneRequest.ConCurrency,
+		Duration:       duration,
+		Interval:       insaneRequest.Interval,
+		Executor:       insaneRequest.Executor,
+		Rate:           insaneRequest.Rate,
+		CorrectLatency: insaneRequest.CorrectLatency,
+		Samples:        insaneRequest.Samples,
+		Form:           insaneRequest.Form,
+		Type:           TYPE_COMMON,
+		Id:             fmt.Sprintf("%s_%d", insaneRequest.Id, index+1),
+		Report:         new(Report),
+		ScriptReportList: &ScriptReportList{
+			ScriptReport: make(map[uint64][]*ScriptReport),
+		},
+		keepOpen: true,
+	}
+	if insaneRequest.CapacityRequest.Target == CAPACITY_TARGET_RATE {
+		step.Rate = level
+	} else {
+		step.ConCurrency = level
+	}
+	step.initControl()
+	return step
+}
+
+func (capacity *CapacityRequest) evaluate(report *Report, level uint64) *CapacityStep {
+	report.m.Lock()
+	defer report.m.Unlock()
+	step := &CapacityStep{
+		Level:      level,
+		SuccessNum: report.SuccessNum,
+		FailureNum: report.FailureNum,
+		Rps:        float64(report.SuccessNum) / float64(capacity.StepDuration),
+	}
+	if total := report.SuccessNum + report.FailureNum; total > 0 {
+		step.ErrorRate = float64(report.FailureNum) * 100 / float64(total)
+	}
+	latency := report.Latency
+	if report.Schedule != nil {
+		// 按计划时间发送时使用修正后的耗时
+		latency = report.Schedule.Corrected
+		step.Saturated = report.Schedule.BehindSchedule
+	}
+	if latency != nil {
+		summary := latency.Summary()
+		step.Avg, step.P50, step.P95, step.P99 = summary.Avg, summary.P50, summary.P95, summary.P99
+	}
+	if report.Generator != nil && report.Generator.Saturated {
+		step.Saturated = true
+	}
+
+	step.Passed = true
+	switch {
+	case step.SuccessNum == 0:
+		step.Reason = "没有成功的请求"
+	case step.ErrorRate > capacity.ErrorRate:
+		step.Reason = fmt.Sprintf("错误率%.2f%%超过%.2f%%", step.ErrorRate, capacity.ErrorRate)
+	case capacity.P95 > 0 && step.P95 > capacity.P95:
+		step.Reason = fmt.Sprintf("p95耗时%dms超过%dms", step.P95, capacity.P95)
+	case capacity.P99 > 0 && step.P99 > capacity.P99:
+		step.Reason = fmt.Sprintf("p99耗时%dms超过%dms", step.P99, capacity.P99)
+	default:
+		return step
+	}
+	step.Passed = false
+	return step
+}
+
+func (report *CapacityReport) add(step *CapacityStep) {
+	report.Steps = append(report.Steps, step)
+	report.Curve = append(report.Curve, step)
+	sort.Slice(report.Curve, func(i, j int) bool {
+		return report.Curve[i].Level < report.Curve[j].Level
+	})
+
+	report.Best, report.Knee = nil, nil
+	var bestPower float64
+	for _, step := range report.Curve {
+		if !step.Passed {
+			continue
+		}
+		if report.Best == nil || step.Level > report.Best.Level {
+			report.Best = step
+		}
+		// 吞吐量与耗时的比值（power）最大处为拐点
+		avg := step.Avg
+		if avg == 0 {
+			avg = 1
+		}
+		if power := step.Rps / float64(avg); power > bestPower {
+			bestPower = power
+			report.Knee = step
+		}
+	}
+}
+
+// 停止容量查找，结束正在执行的一步
+func (capacity *CapacityRequest) stop() {
+	capacity.m.Lock()
+	defer capacity.m.Unlock()
+	capacity.stopped = true
+	if step := capacity.current; step != nil && !step.Status {
+		step.Status = true
+		if err := step.Close(); err != nil {
+			logger.Debug(err)
+		}
+	}
+}
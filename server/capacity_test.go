@@ -0,0 +1,125 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestCapacityLoad(t *testing.T) {
+	cases := []struct {
+		name     string
+		form     string
+		con      uint64
+		capacity *CapacityRequest
+		err      bool
+	}{
+		{name: "默认值", form: TYPE_HTTP, capacity: &CapacityRequest{Start: 10, Max: 100}},
+		{name: "rate", form: TYPE_GRPC, con: 50, capacity: &CapacityRequest{Target: CAPACITY_TARGET_RATE, Start: 100, Max: 1000}},
+		{name: "脚本不支持", form: TYPE_SCRIPT, capacity: &CapacityRequest{Start: 10, Max: 100}, err: true},
+		{name: "mode错误", form: TYPE_HTTP, capacity: &CapacityRequest{Mode: "random", Start: 10, Max: 100}, err: true},
+		{name: "target错误", form: TYPE_HTTP, capacity: &CapacityRequest{Target: "vus", Start: 10, Max: 100}, err: true},
+		{name: "rate缺少协程数", form: TYPE_HTTP, capacity: &CapacityRequest{Target: CAPACITY_TARGET_RATE, Start: 10, Max: 100}, err: true},
+		{name: "start为0", form: TYPE_HTTP, capacity: &CapacityRequest{Max: 100}, err: true},
+		{name: "max小于start", form: TYPE_HTTP, capacity: &CapacityRequest{Start: 10, Max: 5}, err: true},
+		{name: "rate超过上限", form: TYPE_HTTP, con: 1, capacity: &CapacityRequest{Target: CAPACITY_TARGET_RATE, Start: 10, Max: SCHEDULE_MAX_RATE + 1}, err: true},
+	}
+	for _, c := range cases {
+		insaneRequest := &InsaneRequest{Form: c.form, ConCurrency: c.con}
+		capacity := c.capacity
+		err := capacity.Load(insaneRequest)
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if capacity.Mode != CAPACITY_MODE_STEP || capacity.Step != capacity.Start || capacity.Precision != capacity.Start ||
+			capacity.StepDuration != CAPACITY_DEFAULT_STEP_DURATION || capacity.ErrorRate != CAPACITY_DEFAULT_ERROR_RATE {
+			t.Errorf("%s: defaults %+v", c.name, capacity)
+		}
+		if capacity.Target == CAPACITY_TARGET_RATE && (insaneRequest.Executor != EXECUTOR_RATE || insaneRequest.Rate != capacity.Start) {
+			t.Errorf("%s: request %s %d", c.name, insaneRequest.Executor, insaneRequest.Rate)
+		}
+	}
+}
+
+func TestCapacityEvaluate(t *testing.T) {
+	capacity := &CapacityRequest{StepDuration: 10, ErrorRate: 1, P95: 100, P99: 200}
+	latency := func(values ...uint64) *Histogram {
+		histogram := NewHistogram()
+		for _, v := range values {
+			histogram.Add(v)
+		}
+		return histogram
+	}
+	repeat := func(value uint64, n int) []uint64 {
+		values := make([]uint64, n)
+		for i := range values {
+			values[i] = value
+		}
+		return values
+	}
+	fast := repeat(50, 100)
+
+	cases := []struct {
+		name      string
+		report    *Report
+		passed    bool
+		saturated bool
+	}{
+		{name: "满足SLO", report: &Report{SuccessNum: 100, Latency: latency(fast...)}, passed: true},
+		{name: "没有成功请求", report: &Report{FailureNum: 10}},
+		{name: "错误率", report: &Report{SuccessNum: 98, FailureNum: 2, Latency: latency(fast...)}},
+		{name: "p99", report: &Report{SuccessNum: 100, Latency: latency(append(repeat(50, 98), 500, 500)...)}},
+		{name: "p95", report: &Report{SuccessNum: 100, Latency: latency(append(repeat(50, 90), repeat(150, 10)...)...)}},
+		{name: "p95以内的长尾", report: &Report{SuccessNum: 100, Latency: latency(append(repeat(50, 98), 150, 150)...)}, passed: true},
+		{
+			name:      "使用修正后的耗时",
+			report:    &Report{SuccessNum: 100, Latency: latency(fast...), Schedule: &ScheduleReport{Corrected: latency(300), BehindSchedule: true}},
+			saturated: true,
+		},
+		{
+			name:      "施压机饱和",
+			report:    &Report{SuccessNum: 100, Latency: latency(fast...), Generator: &GeneratorReport{Saturated: true}},
+			passed:    true,
+			saturated: true,
+		},
+	}
+	for _, c := range cases {
+		step := capacity.evaluate(c.report, 10)
+		if step.Passed != c.passed || step.Saturated != c.saturated || (step.Reason == "") != c.passed {
+			t.Errorf("%s: %+v", c.name, step)
+		}
+		if step.Level != 10 || step.Rps != float64(c.report.SuccessNum)/10 {
+			t.Errorf("%s: level %d rps %v", c.name, step.Level, step.Rps)
+		}
+	}
+}
+
+func TestCapacityReportAdd(t *testing.T) {
+	report := new(CapacityReport)
+	for _, step := range []*CapacityStep{
+		{Level: 10, Rps: 100, Avg: 10, Passed: true},
+		{Level: 40, Rps: 150, Avg: 60, Passed: false},
+		{Level: 20, Rps: 190, Avg: 11, Passed: true},
+		{Level: 30, Rps: 200, Avg: 30, Passed: true},
+	} {
+		report.add(step)
+	}
+	var levels []uint64
+	for _, step := range report.Curve {
+		levels = append(levels, step.Level)
+	}
+	if len(report.Steps) != 4 || report.Steps[1].Level != 40 || len(levels) != 4 || levels[0] != 10 || levels[3] != 40 {
+		t.Errorf("steps %d, curve %v", len(report.Steps), levels)
+	}
+	if report.Best.Level != 30 || report.Knee.Level != 20 {
+		t.Errorf("best %d, knee %d", report.Best.Level, report.Knee.Level)
+	}
+
+	report = new(CapacityReport)
+	report.add(&CapacityStep{Level: 10})
+	if report.Best != nil || report.Knee != nil {
+		t.Error("no passed step: best and knee should be nil")
+	}
+}
@@ -6,6 +6,12 @@ import (
 	"github.com/gorilla/websocket"
 	"insane/constant"
 	"insane/general/base/appconfig"
+	"time"
+)
+
+const (
+	CLUSTER_REPORT_INTERVAL = time.Second     // 子节点上报报告的间隔
+	CLUSTER_RECONNECT       = 5 * time.Second // 与master断开后重连的间隔
 )
 
 type Cluster struct {
@@ -14,12 +20,12 @@ type Cluster struct {
 }
 
 type ClusterInfo struct {
-	Report     Report     `json:"report"`
+	Report     *Report    `json:"report"`
 	ServerInfo ServerInfo `json:"serverInfo"`
 }
 
 type SentData struct {
-	Report     Report     `json:"report"`
+	Report     *Report    `json:"report"`
 	ServerInfo ServerInfo `json:"serverInfo"`
 }
 
@@ -31,10 +37,27 @@ type ProtoSentMsg struct {
 var InsaneCluster Cluster
 
 func (cluster *Cluster) Init() {
+	// InsaneLoad同时在采集，这里单独读取一次
+	var serverLoad ServerLoad
+	serverLoad.GetServerInfo()
 	cluster.ClusterInfo = new(ClusterInfo)
-	cluster.ClusterInfo.ServerInfo = InsaneLoad.ServerInfo
+	cluster.ClusterInfo.ServerInfo = serverLoad.ServerInfo
+}
+
+// 配置了masterUrl时作为子节点运行，连接断开后重连
+func (cluster *Cluster) Run() {
+	if appconfig.GetConfig().Cluster.MasterUrl == "" {
+		return
+	}
+	for {
+		if err := cluster.Register(); err != nil {
+			logger.Debug(err)
+		}
+		time.Sleep(CLUSTER_RECONNECT)
+	}
 }
 
+// 连接master并注册，之后每秒上报正在执行的任务的报告，任务结束后再上报一次最终的报告
 func (cluster *Cluster) Register() error {
 	cluster.Init()
 	masterUrl := appconfig.GetConfig().Cluster.MasterUrl
@@ -44,47 +67,102 @@ func (cluster *Cluster) Register() error {
 			logger.Debug(err)
 			return err
 		}
+		defer wsConn.Close()
 		protoMsg := ProtoSentMsg{
 			ProtoId: constant.C_REGISTER,
 			SentData: SentData{
 				ServerInfo: cluster.ClusterInfo.ServerInfo,
 			},
 		}
-		protoByte, err := json.Marshal(protoMsg)
-		if err != nil {
+		if err := cluster.send(wsConn, &protoMsg); err != nil {
 			logger.Debug(err)
 			return err
 		}
-		wsConn.WriteMessage(constant.MSG_TYPE, protoByte)
 
+		closed := make(chan error, 1)
 		go func() {
 			for {
 				var msg ProtoReplyMsg
-				var replyData ReplyData
 				_, message, err := wsConn.ReadMessage()
 				if err != nil {
-					logger.Debug(err)
+					closed <- err
 					return
 				}
 				if err := json.Unmarshal(message, &msg); err != nil {
 					logger.Debug(err)
 					continue
 				}
-				if err := json.Unmarshal(message, &replyData); err != nil {
-					logger.Debug(err)
-					continue
-				}
 				switch msg.ProtoId {
 				case constant.S_REGISTER:
-					cluster.c_register(&replyData)
+					cluster.c_register(&msg.ReplyData)
 				case constant.S_REPORT:
 				}
 			}
 		}()
+
+		t := time.NewTicker(CLUSTER_REPORT_INTERVAL)
+		defer t.Stop()
+		var lastId string
+		for {
+			select {
+			case err := <-closed:
+				logger.Debug(err)
+				return err
+			case <-t.C:
+			}
+			var report *Report
+			if report, lastId = cluster.report(lastId); report == nil {
+				continue
+			}
+			protoMsg := ProtoSentMsg{
+				ProtoId: constant.C_REPORT,
+				SentData: SentData{
+					Report:     report,
+					ServerInfo: cluster.ClusterInfo.ServerInfo,
+				},
+			}
+			if err := cluster.send(wsConn, &protoMsg); err != nil {
+				logger.Debug(err)
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// 需要上报的报告快照：正在执行的任务，没有时为上次上报的任务的最终报告（只上报一次）
+// 脚本任务按步骤统计，没有合并到master的报告中
+func (cluster *Cluster) report(lastId string) (*Report, string) {
+	var task *Task
+	TK.RunTasks.Range(func(key, value interface{}) bool {
+		task = value.(*Task)
+		return false
+	})
+	if task == nil && lastId != "" {
+		task, _ = TK.findTask(lastId)
+		lastId = ""
+	} else if task != nil {
+		lastId = task.InsaneRequest.Id
+	}
+	if task == nil || task.InsaneRequest.Form == TYPE_SCRIPT || task.InsaneRequest.Report == nil {
+		return nil, lastId
+	}
+	report, err := task.InsaneRequest.Report.copy()
+	if err != nil {
+		logger.Debug(err)
+		return nil, lastId
+	}
+	return report, lastId
+}
+
+func (cluster *Cluster) send(wsConn *websocket.Conn, protoMsg *ProtoSentMsg) error {
+	protoByte, err := json.Marshal(protoMsg)
+	if err != nil {
+		return err
+	}
+	return wsConn.WriteMessage(constant.MSG_TYPE, protoByte)
+}
+
 func (cluster *Cluster) c_register(replyData *ReplyData) {
 	InsaneCluster.ClusterId = replyData.ClusterId
 }
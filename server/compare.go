@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"insane/general/base/appconfig"
+	"math"
+	"text/tabwriter"
+)
+
+const (
+	COMPARE_DEFAULT_LATENCY    = 10 // 耗时增加的比例（%）
+	COMPARE_DEFAULT_THROUGHPUT = 10 // 每秒成功请求数下降的比例（%）
+	COMPARE_DEFAULT_ERROR_RATE = 1  // 错误率增加的百分点
+)
+
+// 对比的指标，与阈值指标同名
+var compareMetrics = []string{THRESHOLD_RPS, THRESHOLD_ERROR_RATE, THRESHOLD_AVG, THRESHOLD_P50, THRESHOLD_P90, THRESHOLD_P95, THRESHOLD_P99, THRESHOLD_MAX}
+
+// 判定为性能退化的容差，为0时使用配置中的值
+type Tolerance struct {
+	Latency    float64 `json:"latency"`    // 耗时增加的比例（%）
+	Throughput float64 `json:"throughput"` // 每秒成功请求数下降的比例（%）
+	ErrorRate  float64 `json:"errorRate"`  // 错误率增加的百分点
+}
+
+// 参与对比的一次运行
+type CompareRun struct {
+	Id          string                        `json:"id"`
+	Name        string                        `json:"name"`
+	RequestTime uint64                        `json:"requestTime"`
+	Metrics     map[string]float64            `json:"metrics"` // 整个任务
+	Steps       map[string]map[string]float64 `json:"steps"`   // 分组或脚本步骤
+}
+
+// 单个指标相对第一个报告的变化
+type CompareDiff struct {
+	Id         string  `json:"id"`
+	Step       string  `json:"step"` // 为空时是整个任务
+	Metric     string  `json:"metric"`
+	Base       float64 `json:"base"`
+	Value      float64 `json:"value"`
+	Change     float64 `json:"change"` // 变化百分比，错误率为百分点
+	Regression bool    `json:"regression"`
+}
+
+type Comparison struct {
+	Base        string         `json:"base"` // 第一个报告，其余报告与它对比
+	Tolerance   *Tolerance     `json:"tolerance"`
+	Runs        []*CompareRun  `json:"runs"`
+	Diffs       []*CompareDiff `json:"diffs"`
+	Regressions int            `json:"regressions"` // 超出容差的指标数
+}
+
+// 以第一个报告为基准，对比其余报告
+func CompareReports(ids []string, tolerance *Tolerance) (*Comparison, error) {
+	if len(ids) < 2 {
+		return nil, errors.New("至少需要两个报告")
+	}
+	runs := make([]*CompareRun, 0, len(ids))
+	for _, id := range ids {
+		content, err := ReportContent(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s：%s", id, err)
+		}
+		run, err := loadCompareRun(id, content)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return compareRuns(runs, tolerance), nil
+}
+
+func loadCompareRun(id string, content []byte) (*CompareRun, error) {
+	if gjson.GetBytes(content, "scriptReport").Exists() {
+		var report ScriptReportList
+		if err := json.Unmarshal(content, &report); err != nil {
+			return nil, err
+		}
+		return report.compareRun(id), nil
+	}
+	var report Report
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+	return report.compareRun(id), nil
+}
+
+func newCompareRun(id string, name string, requestTime uint64, steps []string, source func(step string) *thresholdSource) *CompareRun {
+	run := &CompareRun{Id: id, Name: name, RequestTime: requestTime, Metrics: compareValues(source("")), Steps: make(map[string]map[string]float64)}
+	for _, step := range steps {
+		run.Steps[step] = compareValues(source(step))
+	}
+	return run
+}
+
+// 没有数据的指标不参与对比
+func compareValues(source *thresholdSource) map[string]float64 {
+	values := make(map[string]float64)
+	for _, metric := range compareMetrics {
+		if value, err := source.value(metric); err == nil {
+			values[metric] = value
+		}
+	}
+	return values
+}
+
+func compareRuns(runs []*CompareRun, tolerance *Tolerance) *Comparison {
+	tolerance = tolerance.withDefault()
+	comparison := &Comparison{Base: runs[0].Id, Tolerance: tolerance, Runs: runs}
+	base := runs[0]
+	for _, run := range runs[1:] {
+		comparison.diff(run.Id, "", base.Metrics, run.Metrics)
+		for _, step := range sortedKeys(base.Steps) {
+			if values, ok := run.Steps[step]; ok {
+				comparison.diff(run.Id, step, base.Steps[step], values)
+			}
+		}
+	}
+	return comparison
+}
+
+func (comparison *Comparison) diff(id string, step string, base map[string]float64, values map[string]float64) {
+	for _, metric := range compareMetrics {
+		baseValue, ok := base[metric]
+		if !ok {
+			continue
+		}
+		value, ok := values[metric]
+		if !ok {
+			continue
+		}
+		diff := &CompareDiff{Id: id, Step: step, Metric: metric, Base: baseValue, Value: value}
+		diff.Change, diff.Regression = comparison.Tolerance.check(metric, baseValue, value)
+		if diff.Regression {
+			comparison.Regressions++
+		}
+		comparison.Diffs = append(comparison.Diffs, diff)
+	}
+}
+
+func (tolerance *Tolerance) withDefault() *Tolerance {
+	config := appconfig.GetConfig().Compare
+	result := &Tolerance{Latency: config.Latency, Throughput: config.Throughput, ErrorRate: config.ErrorRate}
+	if tolerance != nil {
+		if tolerance.Latency > 0 {
+			result.Latency = tolerance.Latency
+		}
+		if tolerance.Throughput > 0 {
+			result.Throughput = tolerance.Throughput
+		}
+		if tolerance.ErrorRate > 0 {
+			result.ErrorRate = tolerance.ErrorRate
+		}
+	}
+	if result.Latency <= 0 {
+		result.Latency = COMPARE_DEFAULT_LATENCY
+	}
+	if result.Throughput <= 0 {
+		result.Throughput = COMPARE_DEFAULT_THROUGHPUT
+	}
+	if result.ErrorRate <= 0 {
+		result.ErrorRate = COMPARE_DEFAULT_ERROR_RATE
+	}
+	return result
+}
+
+// 返回变化量，以及是否超出容差：耗时变大、吞吐量变小、错误率变大为退化
+func (tolerance *Tolerance) check(metric string, base float64, value float64) (float64, bool) {
+	if metric == THRESHOLD_ERROR_RATE {
+		change := value - base
+		return change, change > tolerance.ErrorRate
+	}
+	if base == 0 {
+		return 0, false
+	}
+	change := (value - base) * 100 / base
+	if metric == THRESHOLD_RPS {
+		return change, -change > tolerance.Throughput
+	}
+	return change, change > tolerance.Latency
+}
+
+// 命令行输出的对比表格
+func (comparison *Comparison) Text() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "基准\t%s\n", comparison.Base)
+	fmt.Fprintf(w, "容差\t耗时+%s%%  吞吐量-%s%%  错误率+%s\n\n", thresholdNumber(comparison.Tolerance.Latency), thresholdNumber(comparison.Tolerance.Throughput), thresholdNumber(comparison.Tolerance.ErrorRate))
+	fmt.Fprintln(w, "报告\t步骤\t指标\t基准\t当前\t变化\t")
+	for _, diff := range comparison.Diffs {
+		step := diff.Step
+		if step == "" {
+			step = "-"
+		}
+		flag := ""
+		if diff.Regression {
+			flag = "退化"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", diff.Id, step, diff.Metric, thresholdNumber(diff.Base), thresholdNumber(diff.Value), diff.changeText(), flag)
+	}
+	fmt.Fprintf(w, "\n超出容差的指标数：%d\n", comparison.Regressions)
+	w.Flush()
+	return buf.String()
+}
+
+func (diff *CompareDiff) changeText() string {
+	if diff.Metric == THRESHOLD_ERROR_RATE {
+		return fmt.Sprintf("%+.2f", diff.Change)
+	}
+	if math.Abs(diff.Change) < 0.005 {
+		return "0%"
+	}
+	return fmt.Sprintf("%+.1f%%", diff.Change)
+}
+
+func (report *Report) compareRun(id string) *CompareRun {
+	return newCompareRun(id, report.Name, report.RequestTime, sortedKeys(report.Groups), report.thresholdSource)
+}
+
+func (scriptReportList *ScriptReportList) compareRun(id string) *CompareRun {
+	return newCompareRun(id, scriptReportList.Name, scriptReportList.RequestTime, sortedKeys(scriptReportList.Steps), scriptReportList.thresholdSource)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToleranceCheck(t *testing.T) {
+	config := appconfig.GetConfig().Compare
+	defer func() { appconfig.GetConfig().Compare = config }()
+	appconfig.GetConfig().Compare = appconfig.Compare{Latency: 20}
+
+	tolerance := (&Tolerance{ErrorRate: 2}).withDefault()
+	if tolerance.Latency != 20 || tolerance.Throughput != COMPARE_DEFAULT_THROUGHPUT || tolerance.ErrorRate != 2 {
+		t.Fatalf("tolerance = %+v", tolerance)
+	}
+	if nilTolerance := (*Tolerance)(nil).withDefault(); nilTolerance.Latency != 20 || nilTolerance.ErrorRate != COMPARE_DEFAULT_ERROR_RATE {
+		t.Errorf("nil tolerance = %+v", nilTolerance)
+	}
+
+	cases := []struct {
+		metric     string
+		base       float64
+		value      float64
+		change     float64
+		regression bool
+	}{
+		{metric: THRESHOLD_P95, base: 100, value: 120, change: 20},
+		{metric: THRESHOLD_P95, base: 100, value: 121, change: 21, regression: true},
+		{metric: THRESHOLD_AVG, base: 100, value: 50, change: -50},
+		{metric: THRESHOLD_AVG, base: 0, value: 50},
+		{metric: THRESHOLD_RPS, base: 100, value: 89, change: -11, regression: true},
+		{metric: THRESHOLD_RPS, base: 100, value: 200, change: 100},
+		{metric: THRESHOLD_ERROR_RATE, base: 1, value: 3, change: 2},
+		{metric: THRESHOLD_ERROR_RATE, base: 0, value: 2.5, change: 2.5, regression: true},
+	}
+	for _, c := range cases {
+		change, regression := tolerance.check(c.metric, c.base, c.value)
+		if change != c.change || regression != c.regression {
+			t.Errorf("%s %v -> %v: %v %t", c.metric, c.base, c.value, change, regression)
+		}
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	base := &CompareRun{
+		Id:      "a",
+		Metrics: map[string]float64{THRESHOLD_RPS: 100, THRESHOLD_P95: 50},
+		Steps:   map[string]map[string]float64{"login": {THRESHOLD_AVG: 10}, "logout": {THRESHOLD_AVG: 5}},
+	}
+	run := &CompareRun{
+		Id:      "b",
+		Metrics: map[string]float64{THRESHOLD_RPS: 80, THRESHOLD_P95: 50, THRESHOLD_MAX: 900},
+		Steps:   map[string]map[string]float64{"login": {THRESHOLD_AVG: 20}},
+	}
+	comparison := compareRuns([]*CompareRun{base, run}, nil)
+	var diffs []string
+	for _, diff := range comparison.Diffs {
+		diffs = append(diffs, diff.Step+":"+diff.Metric+":"+diff.changeText())
+	}
+	// 只对比两边都有的指标与步骤
+	if want := ":rps:-20.0%|:p95:0%|login:avg:+100.0%"; strings.Join(diffs, "|") != want {
+		t.Errorf("diffs = %q, want %s", diffs, want)
+	}
+	if comparison.Base != "a" || comparison.Regressions != 2 {
+		t.Errorf("comparison = %+v", comparison)
+	}
+	text := comparison.Text()
+	if !strings.Contains(text, "超出容差的指标数：2") || strings.Count(text, "退化") != 2 {
+		t.Errorf("text = %s", text)
+	}
+	if got := (&CompareDiff{Metric: THRESHOLD_ERROR_RATE, Change: 1.5}).changeText(); got != "+1.50" {
+		t.Errorf("error rate change = %s", got)
+	}
+}
+
+func TestLoadCompareRun(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		metrics map[string]float64
+		steps   []string
+		err     bool
+	}{
+		{
+			name:    "请求报告",
+			content: `{"name":"order","requestTime":10,"successNum":90,"failureNum":10,"groups":{"/a":{"successNum":9,"avgTime":12}}}`,
+			metrics: map[string]float64{THRESHOLD_RPS: 9, THRESHOLD_ERROR_RATE: 10},
+			steps:   []string{"/a"},
+		},
+		{
+			name:    "脚本报告",
+			content: `{"scriptReport":{},"name":"order","requestTime":2,"totalSuccess":4,"steps":{"login":{"successNum":4}}}`,
+			metrics: map[string]float64{THRESHOLD_RPS: 2, THRESHOLD_ERROR_RATE: 0},
+			steps:   []string{"login"},
+		},
+		{name: "格式错误", content: `{"successNum":"x"}`, err: true},
+	}
+	for _, c := range cases {
+		run, err := loadCompareRun("t1", []byte(c.content))
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v", c.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if run.Id != "t1" || run.Name != "order" || len(run.Steps) != len(c.steps) {
+			t.Errorf("%s: run = %+v", c.name, run)
+		}
+		for metric, want := range c.metrics {
+			if run.Metrics[metric] != want {
+				t.Errorf("%s: %s = %v, want %v", c.name, metric, run.Metrics[metric], want)
+			}
+		}
+		for _, step := range c.steps {
+			if _, ok := run.Steps[step]; !ok {
+				t.Errorf("%s: missing step %s", c.name, step)
+			}
+		}
+	}
+}
+
+func TestBaselines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baseline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	location := appconfig.GetConfig().Log.Location
+	defer func() { appconfig.GetConfig().Log.Location = location }()
+	appconfig.GetConfig().Log.Location = dir
+
+	reports := map[string]string{
+		"base1": `{"name":"order","requestTime":10,"successNum":100}`,
+		"run1":  `{"name":"order","requestTime":10,"successNum":50}`,
+		"anon1": `{"requestTime":10,"successNum":50}`,
+	}
+	for id, content := range reports {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	baselines := &Baselines{}
+	if _, err := baselines.Set("", "anon1", nil); err == nil {
+		t.Error("report without name: want error")
+	}
+	if _, err := baselines.Set("", "none", nil); err == nil {
+		t.Error("missing report: want error")
+	}
+	if baseline, err := baselines.Set("", "base1", &Tolerance{Throughput: 60}); err != nil || baseline.Name != "order" {
+		t.Fatalf("set = %+v, %v", baseline, err)
+	}
+
+	// 重新加载保存的文件
+	baselines = &Baselines{}
+	if list := baselines.List(); len(list) != 1 || list[0].Id != "base1" || list[0].Tolerance.Throughput != 60 {
+		t.Fatalf("list = %+v", list)
+	}
+	run, _ := loadCompareRun("run1", []byte(reports["run1"]))
+	comparison := baselines.check(run)
+	if comparison == nil || comparison.Base != "base1" || comparison.Regressions != 0 {
+		t.Errorf("comparison = %+v", comparison)
+	}
+	for _, id := range []string{"base1", "anon1"} {
+		run, _ := loadCompareRun(id, []byte(reports[id]))
+		if baselines.check(run) != nil {
+			t.Errorf("%s: base itself or unnamed task should not compare", id)
+		}
+	}
+
+	if err := baselines.Delete("order"); err != nil || len(baselines.List()) != 0 {
+		t.Errorf("delete = %v", err)
+	}
+	if err := baselines.Delete("order"); err == nil {
+		t.Error("delete missing: want error")
+	}
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"insane/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	CONTROL_PAUSE    = "pause"
+	CONTROL_RESUME   = "resume"
+	CONTROL_VUS      = "vus"      // 调整协程数
+	CONTROL_RATE     = "rate"     // 调整rate模式的每秒请求数
+	CONTROL_DURATION = "duration" // 延长（正数）或缩短（负数）剩余时间（秒）
+	CONTROL_MAX_VUS  = 100000
+)
+
+// 运行中的调整，记录在报告的时间线上
+type Annotation struct {
+	Time    uint64 `json:"time"` // 第几秒，与每秒统计对应
+	At      int64  `json:"at"`   // 时间戳（毫秒）
+	Action  string `json:"action"`
+	Value   int64  `json:"value"`
+	Message string `json:"message"`
+}
+
+type ControlState struct {
+	Id        string `json:"id"`
+	Paused    bool   `json:"paused"`
+	Vus       uint64 `json:"vus"`
+	Rate      uint64 `json:"rate"`      // rate模式才有
+	Remaining uint64 `json:"remaining"` // 剩余时间（秒），暂停时不计时
+}
+
+// 执行中任务的控制：暂停时所有协程收到结束信号退出，恢复时重新启动
+type taskControl struct {
+	insaneRequest *InsaneRequest
+	wg            *sync.WaitGroup
+	pacer         *pacer
+	metrics       *TaskMetrics
+	spawn         func(serial uint64, stop <-chan int, wg *sync.WaitGroup)
+	workers       map[uint64]chan int // 还在执行的协程及其结束信号，协程自行退出后删除
+	ready         bool                // Dispose启动协程后才能调整
+	stopped       bool
+	paused        bool
+	vus           uint64
+	serial        uint64 // 下一个协程的序号
+	rate          uint64
+	deadline      time.Time
+	remaining     time.Duration // 暂停时的剩余时间
+	timer         *time.Timer
+	m             sync.Mutex
+}
+
+// 调整执行中的任务，value为空时只返回当前状态
+func ControlTask(id string, action string, value int64) (*ControlState, error) {
+	task, status := TK.findTask(id)
+	if task == nil {
+		return nil, errors.New("任务不存在")
+	}
+	if status != RUN_TASK {
+		return nil, errors.New("任务没有在执行")
+	}
+	control := task.InsaneRequest.control
+	if task.InsaneRequest.Type == TYPE_CAPACITY {
+		return nil, errors.New("容量模式不支持调整")
+	}
+	if control == nil {
+		return nil, errors.New("任务还没有开始执行")
+	}
+	var err error
+	switch action {
+	case "":
+	case CONTROL_PAUSE:
+		err = control.pause()
+	case CONTROL_RESUME:
+		err = control.resume()
+	case CONTROL_VUS:
+		err = control.setVus(value)
+	case CONTROL_RATE:
+		err = control.setRate(value)
+	case CONTROL_DURATION:
+		err = control.addDuration(value)
+	default:
+		err = fmt.Errorf("action必须是%s | %s | %s | %s | %s", CONTROL_PAUSE, CONTROL_RESUME, CONTROL_VUS, CONTROL_RATE, CONTROL_DURATION)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return control.state(), nil
+}
+
+// 启动协程并开始计时
+func (control *taskControl) start(wg *sync.WaitGroup, pacer *pacer, metrics *TaskMetrics, spawn func(serial uint64, stop <-chan int, wg *sync.WaitGroup)) {
+	control.m.Lock()
+	defer control.m.Unlock()
+	control.wg, control.pacer, control.metrics, control.spawn = wg, pacer, metrics, spawn
+	control.workers = make(map[uint64]chan int)
+	control.vus = control.insaneRequest.ConCurrency
+	control.rate = control.insaneRequest.Rate
+	control.ready = true
+	if control.stopped {
+		// 启动前已经被终止
+		return
+	}
+	control.grow(control.vus)
+	metrics.setVus(control.vus)
+	duration := time.Duration(control.insaneRequest.Duration) * time.Second
+	control.deadline = time.Now().Add(duration)
+	control.timer = time.AfterFunc(duration, control.expire)
+}
+
+// 所有协程已经结束
+func (control *taskControl) finish() {
+	control.m.Lock()
+	defer control.m.Unlock()
+	control.stopped = true
+	if control.timer != nil {
+		control.timer.Stop()
+	}
+}
+
+func (control *taskControl) expire() {
+	if !control.insaneRequest.Status { // 如果请求正在执行，终止它
+		control.insaneRequest.closeRequest()
+	}
+}
+
+// 结束所有还在执行的协程，还没有启动时不再启动
+func (control *taskControl) stop() {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if control.stopped {
+		return
+	}
+	control.stopped = true
+	if !control.ready {
+		return
+	}
+	control.timer.Stop()
+	if control.paused {
+		// 暂停时没有协程，释放暂停时占用的计数
+		control.paused = false
+		control.wg.Done()
+		return
+	}
+	control.signal(uint64(len(control.workers)))
+}
+
+func (control *taskControl) pause() error {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if err := control.check(); err != nil {
+		return err
+	}
+	if control.paused {
+		return errors.New("任务已经暂停")
+	}
+	// 占用一个计数，避免协程全部退出后任务结束
+	control.wg.Add(1)
+	control.signal(uint64(len(control.workers)))
+	control.paused = true
+	control.remaining = time.Until(control.deadline)
+	control.timer.Stop()
+	control.pacer.setRate(0)
+	control.metrics.setVus(0)
+	control.annotate(CONTROL_PAUSE, 0, fmt.Sprintf("暂停，剩余%s", control.remaining.Round(time.Second)))
+	return nil
+}
+
+func (control *taskControl) resume() error {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if err := control.check(); err != nil {
+		return err
+	}
+	if !control.paused {
+		return errors.New("任务没有暂停")
+	}
+	control.pacer.setRate(control.rate)
+	control.grow(control.vus)
+	control.paused = false
+	control.wg.Done()
+	control.deadline = time.Now().Add(control.remaining)
+	control.timer.Reset(control.remaining)
+	control.metrics.setVus(control.vus)
+	control.annotate(CONTROL_RESUME, 0, fmt.Sprintf("恢复，%d个协程", control.vus))
+	return nil
+}
+
+func (control *taskControl) setVus(value int64) error {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if err := control.check(); err != nil {
+		return err
+	}
+	if value < 1 || value > CONTROL_MAX_VUS {
+		return fmt.Errorf("协程数范围1-%d", CONTROL_MAX_VUS)
+	}
+	vus := uint64(value)
+	control.pacer.setWorkers(vus)
+	if !control.paused {
+		// 按还在执行的协程数调整，自行退出的协程不再计入
+		if live := uint64(len(control.workers)); vus > live {
+			control.grow(vus - live)
+		} else {
+			control.signal(live - vus)
+		}
+		control.metrics.setVus(vus)
+	}
+	control.annotate(CONTROL_VUS, value, fmt.Sprintf("协程数%d -> %d", control.vus, vus))
+	control.vus = vus
+	return nil
+}
+
+func (control *taskControl) setRate(value int64) error {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if err := control.check(); err != nil {
+		return err
+	}
+	if control.insaneRequest.Executor != EXECUTOR_RATE {
+		return errors.New("只有rate模式可以调整每秒请求数")
+	}
+	if value < 1 || value > SCHEDULE_MAX_RATE {
+		return fmt.Errorf("rate范围1-%d", SCHEDULE_MAX_RATE)
+	}
+	if !control.paused {
+		control.pacer.setRate(uint64(value))
+	}
+	control.annotate(CONTROL_RATE, value, fmt.Sprintf("每秒请求数%d -> %d", control.rate, value))
+	control.rate = uint64(value)
+	return nil
+}
+
+// 剩余时间不大于0时立即结束任务
+func (control *taskControl) addDuration(value int64) error {
+	control.m.Lock()
+	defer control.m.Unlock()
+	if err := control.check(); err != nil {
+		return err
+	}
+	delta := time.Duration(value) * time.Second
+	var remaining time.Duration
+	if control.paused {
+		control.remaining += delta
+		remaining = control.remaining
+	} else {
+		control.deadline = control.deadline.Add(delta)
+		remaining = time.Until(control.deadline)
+		if remaining > 0 {
+			control.timer.Reset(remaining)
+		}
+	}
+	if remaining <= 0 {
+		control.annotate(CONTROL_DURATION, value, fmt.Sprintf("剩余时间调整%+ds，立即结束", value))
+		go control.insaneRequest.closeRequest()
+		return nil
+	}
+	control.annotate(CONTROL_DURATION, value, fmt.Sprintf("剩余时间调整%+ds，剩余%s", value, remaining.Round(time.Second)))
+	return nil
+}
+
+func (control *taskControl) check() error {
+	if !control.ready {
+		return errors.New("任务还没有开始执行")
+	}
+	if control.stopped {
+		return errors.New("任务已经结束")
+	}
+	return nil
+}
+
+// 每个协程有自己的结束信号，退出后从workers中删除
+func (control *taskControl) grow(n uint64) {
+	for end := control.serial + n; control.serial < end; control.serial++ {
+		serial, stop, done := control.serial, make(chan int), new(sync.WaitGroup)
+		control.workers[serial] = stop
+		control.wg.Add(1)
+		control.spawn(serial, stop, done)
+		go func() {
+			done.Wait()
+			control.exit(serial)
+			control.wg.Done()
+		}()
+	}
+}
+
+func (control *taskControl) exit(serial uint64) {
+	control.m.Lock()
+	defer control.m.Unlock()
+	delete(control.workers, serial)
+}
+
+// 关闭序号最大的n个协程的结束信号
+func (control *taskControl) signal(n uint64) {
+	serials := make([]uint64, 0, len(control.workers))
+	for serial := range control.workers {
+		serials = append(serials, serial)
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] > serials[j] })
+	for i := 0; i < len(serials) && uint64(i) < n; i++ {
+		close(control.workers[serials[i]])
+		delete(control.workers, serials[i])
+	}
+}
+
+func (control *taskControl) annotate(action string, value int64, message string) {
+	annotation := &Annotation{At: utils.Now(), Action: action, Value: value, Message: message}
+	if control.insaneRequest.Form == TYPE_SCRIPT {
+		control.insaneRequest.ScriptReportList.annotate(annotation)
+	} else {
+		control.insaneRequest.Report.annotate(annotation)
+	}
+}
+
+func (control *taskControl) state() *ControlState {
+	control.m.Lock()
+	defer control.m.Unlock()
+	state := &ControlState{Id: control.insaneRequest.Id, Paused: control.paused, Vus: control.vus}
+	if control.insaneRequest.Executor == EXECUTOR_RATE {
+		state.Rate = control.rate
+	}
+	remaining := control.remaining
+	if !control.paused {
+		remaining = time.Until(control.deadline)
+	}
+	if remaining > 0 {
+		state.Remaining = uint64(remaining / time.Second)
+	}
+	return state
+}
+
+func (report *Report) annotate(annotation *Annotation) {
+	report.m.Lock()
+	defer report.m.Unlock()
+	if report.startTime > 0 {
+		annotation.Time = utils.CurSecond(uint64(report.startTime))
+	}
+	report.Annotations = append(report.Annotations, annotation)
+}
+
+func (scriptReportList *ScriptReportList) annotate(annotation *Annotation) {
+	scriptReportList.m.Lock()
+	defer scriptReportList.m.Unlock()
+	if scriptReportList.startTime > 0 {
+		annotation.Time = utils.CurSecond(uint64(scriptReportList.startTime))
+	}
+	scriptReportList.Annotations = append(scriptReportList.Annotations, annotation)
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 模拟的协程收到结束信号或自行退出
+type testWorkers struct {
+	insaneRequest *InsaneRequest
+	control       *taskControl
+	wg            sync.WaitGroup
+	running       int64
+	spawned       []uint64
+	quit          []chan int // 模拟协程自行退出，如连接失败
+}
+
+func newTestWorkers(vus uint64, executor string) *testWorkers {
+	insaneRequest := &InsaneRequest{Id: "t1", Form: TYPE_HTTP, ConCurrency: vus, Duration: 60, Executor: executor, Rate: 10, Report: new(Report)}
+	insaneRequest.initControl()
+	workers := &testWorkers{insaneRequest: insaneRequest, control: insaneRequest.control}
+	return workers
+}
+
+func (workers *testWorkers) start() {
+	workers.control.start(&workers.wg, nil, nil, func(serial uint64, stop <-chan int, wg *sync.WaitGroup) {
+		wg.Add(1)
+		workers.spawned = append(workers.spawned, serial)
+		atomic.AddInt64(&workers.running, 1)
+		quit := make(chan int)
+		workers.quit = append(workers.quit, quit)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-stop:
+			case <-quit:
+			}
+			atomic.AddInt64(&workers.running, -1)
+		}()
+	})
+}
+
+func (workers *testWorkers) waitRunning(t *testing.T, want int64) {
+	for i := 0; i < 100 && atomic.LoadInt64(&workers.running) != want; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if running := atomic.LoadInt64(&workers.running); running != want {
+		t.Fatalf("running = %d, want %d", running, want)
+	}
+}
+
+func TestControlTaskNotFound(t *testing.T) {
+	if _, err := ControlTask("none", CONTROL_PAUSE, 0); err == nil {
+		t.Error("missing task: want error")
+	}
+}
+
+func TestTaskControlCheck(t *testing.T) {
+	workers := newTestWorkers(2, EXECUTOR_CONCURRENCY)
+	if err := workers.control.pause(); err == nil {
+		t.Error("not started: want error")
+	}
+	workers.start()
+	defer workers.insaneRequest.closeRequest()
+
+	cases := []struct {
+		name string
+		do   func() error
+	}{
+		{name: "协程数为0", do: func() error { return workers.control.setVus(0) }},
+		{name: "协程数超过上限", do: func() error { return workers.control.setVus(CONTROL_MAX_VUS + 1) }},
+		{name: "非rate模式调整rate", do: func() error { return workers.control.setRate(10) }},
+		{name: "没有暂停时恢复", do: workers.control.resume},
+	}
+	for _, c := range cases {
+		if err := c.do(); err == nil {
+			t.Errorf("%s: want error", c.name)
+		}
+	}
+
+	rate := newTestWorkers(1, EXECUTOR_RATE)
+	rate.start()
+	defer rate.insaneRequest.closeRequest()
+	if err := rate.control.setRate(SCHEDULE_MAX_RATE + 1); err == nil {
+		t.Error("rate over limit: want error")
+	}
+	if err := rate.control.setRate(20); err != nil || rate.control.state().Rate != 20 {
+		t.Errorf("set rate = %v, state %+v", err, rate.control.state())
+	}
+}
+
+func TestTaskControlVus(t *testing.T) {
+	workers := newTestWorkers(2, EXECUTOR_CONCURRENCY)
+	workers.start()
+	workers.waitRunning(t, 2)
+
+	if err := workers.control.setVus(4); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 4)
+	if err := workers.control.setVus(1); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 1)
+
+	// 暂停时所有协程退出，恢复时按当前协程数重新启动
+	if err := workers.control.pause(); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 0)
+	if err := workers.control.pause(); err == nil {
+		t.Error("pause twice: want error")
+	}
+	if err := workers.control.setVus(3); err != nil {
+		t.Fatal(err)
+	}
+	if state := workers.control.state(); !state.Paused || state.Vus != 3 || state.Remaining == 0 {
+		t.Errorf("paused state = %+v", state)
+	}
+	if err := workers.control.resume(); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 3)
+	if len(workers.spawned) != 7 || workers.spawned[6] != 6 {
+		t.Errorf("spawned = %v", workers.spawned)
+	}
+
+	workers.insaneRequest.closeRequest()
+	workers.wg.Wait()
+	if err := workers.control.setVus(2); err == nil {
+		t.Error("stopped: want error")
+	}
+
+	var actions []string
+	for _, annotation := range workers.insaneRequest.Report.Annotations {
+		actions = append(actions, annotation.Action)
+	}
+	want := []string{CONTROL_VUS, CONTROL_VUS, CONTROL_PAUSE, CONTROL_VUS, CONTROL_RESUME}
+	if len(actions) != len(want) {
+		t.Fatalf("annotations = %v", actions)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("annotations = %v, want %v", actions, want)
+			break
+		}
+	}
+}
+
+func TestTaskControlDuration(t *testing.T) {
+	workers := newTestWorkers(1, EXECUTOR_CONCURRENCY)
+	workers.start()
+	if err := workers.control.addDuration(30); err != nil {
+		t.Fatal(err)
+	}
+	if state := workers.control.state(); state.Remaining < 85 || state.Remaining > 90 {
+		t.Errorf("remaining = %d", state.Remaining)
+	}
+	if err := workers.control.pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := workers.control.addDuration(-30); err != nil {
+		t.Fatal(err)
+	}
+	if state := workers.control.state(); state.Remaining < 55 || state.Remaining > 60 {
+		t.Errorf("paused remaining = %d", state.Remaining)
+	}
+
+	// 剩余时间不大于0时结束任务，暂停占用的计数被释放
+	if err := workers.control.addDuration(-100); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		workers.wg.Wait()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+}
+
+func TestTaskControlWorkerExit(t *testing.T) {
+	workers := newTestWorkers(3, EXECUTOR_CONCURRENCY)
+	workers.start()
+	workers.waitRunning(t, 3)
+
+	// 自行退出的协程不计数，调整时按还在执行的协程补足
+	close(workers.quit[0])
+	workers.waitRunning(t, 2)
+	waitWorkers(t, workers.control, 2)
+	if err := workers.control.setVus(3); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 3)
+
+	// 暂停后恢复的协程不会被之前剩余的信号结束
+	if err := workers.control.pause(); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 0)
+	if err := workers.control.resume(); err != nil {
+		t.Fatal(err)
+	}
+	workers.waitRunning(t, 3)
+	time.Sleep(10 * time.Millisecond)
+	workers.waitRunning(t, 3)
+
+	// 所有协程自行退出后任务结束，再终止不会出错
+	for _, quit := range workers.quit[len(workers.quit)-3:] {
+		close(quit)
+	}
+	workers.wg.Wait()
+	workers.insaneRequest.closeRequest()
+}
+
+func TestTaskControlStopBeforeStart(t *testing.T) {
+	workers := newTestWorkers(2, EXECUTOR_CONCURRENCY)
+	workers.insaneRequest.closeRequest()
+	workers.start()
+	workers.wg.Wait()
+	if len(workers.spawned) != 0 {
+		t.Errorf("spawned = %v", workers.spawned)
+	}
+	if err := workers.control.pause(); err == nil {
+		t.Error("stopped: want error")
+	}
+}
+
+func waitWorkers(t *testing.T, control *taskControl, want int) {
+	for i := 0; i < 100; i++ {
+		control.m.Lock()
+		live := len(control.workers)
+		control.m.Unlock()
+		if live == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("live workers != %d", want)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 5段cron表达式：分 时 日 月 周，支持 * , - / 以及 @hourly @daily @weekly @monthly
+type cronSpec struct {
+	minute  uint64 // 按位表示允许的值
+	hour    uint64
+	day     uint64
+	month   uint64
+	week    uint64
+	anyDay  bool // 日以*开头（包括*/n），日和周同时指定时满足任意一个即可
+	anyWeek bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// 查找下次执行时间的最大次数，避免 2月30日 这类永远不会执行的表达式死循环
+const CRON_SEARCH_LIMIT = 100000
+
+func parseCron(expression string) (*cronSpec, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[expression]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron表达式%s需要5段：分 时 日 月 周", expression)
+	}
+	spec := new(cronSpec)
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if spec.day, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if spec.week, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 周日可以写成0或7
+	if spec.week&(1<<7) > 0 {
+		spec.week |= 1
+	}
+	spec.anyDay, spec.anyWeek = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return spec, nil
+}
+
+// 单个字段：* | 5 | 1-5 | */15 | 1-30/2 | 1,15,30
+func parseCronField(field string, min uint64, max uint64) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		var (
+			start, end = min, max
+			step       = uint64(1)
+			err        error
+		)
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.ParseUint(part[i+1:], 10, 64); err != nil || step == 0 {
+				return 0, fmt.Errorf("cron字段%s的步长错误", field)
+			}
+			part = part[:i]
+		}
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			start, err = strconv.ParseUint(bounds[0], 10, 64)
+			if err == nil {
+				end, err = strconv.ParseUint(bounds[1], 10, 64)
+			}
+		default:
+			start, err = strconv.ParseUint(part, 10, 64)
+			end = start
+			if step > 1 { // 5/15 表示从5开始每15
+				end = max
+			}
+		}
+		if err != nil || start < min || end > max || start > end {
+			return 0, fmt.Errorf("cron字段%s超出范围%d-%d", field, min, max)
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// after之后（不含）的第一个执行时间，精确到分钟
+func (spec *cronSpec) next(after time.Time) (time.Time, bool) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < CRON_SEARCH_LIMIT; i++ {
+		if spec.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !spec.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if spec.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if spec.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func (spec *cronSpec) matchDay(t time.Time) bool {
+	day := spec.day&(1<<uint(t.Day())) > 0
+	week := spec.week&(1<<uint(t.Weekday())) > 0
+	if !spec.anyDay && !spec.anyWeek {
+		return day || week
+	}
+	return day && week
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func cronTime(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		expression string
+		minute     []uint
+		err        bool
+	}{
+		{expression: "* * * * *"},
+		{expression: "@daily", minute: []uint{0}},
+		{expression: " 0 0 * * 0 ", minute: []uint{0}},
+		{expression: "5/15 * * * *", minute: []uint{5, 20, 35, 50}},
+		{expression: "*/20 * * * *", minute: []uint{0, 20, 40}},
+		{expression: "10-20/5 * * * *", minute: []uint{10, 15, 20}},
+		{expression: "1,30,59 * * * *", minute: []uint{1, 30, 59}},
+		{expression: "* * * *", err: true},
+		{expression: "* * * * * *", err: true},
+		{expression: "60 * * * *", err: true},
+		{expression: "*/0 * * * *", err: true},
+		{expression: "20-10 * * * *", err: true},
+		{expression: "a * * * *", err: true},
+		{expression: "* 24 * * *", err: true},
+		{expression: "* * 0 * *", err: true},
+		{expression: "* * * 13 *", err: true},
+		{expression: "* * * * 8", err: true},
+		{expression: "@never", err: true},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expression)
+		if (err != nil) != c.err {
+			t.Errorf("%q: err = %v, want err %v", c.expression, err, c.err)
+			continue
+		}
+		if err != nil || c.minute == nil {
+			continue
+		}
+		var bits uint64
+		for _, minute := range c.minute {
+			bits |= 1 << minute
+		}
+		if spec.minute != bits {
+			t.Errorf("%q: minute = %b, want %b", c.expression, spec.minute, bits)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		after      string
+		want       string // 为空时没有执行时间
+	}{
+		{"每15分钟", "*/15 * * * *", "2026-10-19 10:07", "2026-10-19 10:15"},
+		{"从5开始每15分钟", "5/15 * * * *", "2026-10-19 10:21", "2026-10-19 10:35"},
+		{"不包含after", "30 10 * * *", "2026-10-19 10:30", "2026-10-20 10:30"},
+		{"跨年", "0 0 1 1 *", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"宏", "@hourly", "2026-10-19 10:00", "2026-10-19 11:00"},
+		{"周日为0", "0 0 * * 0", "2026-10-21 00:00", "2026-10-25 00:00"},
+		{"周日为7", "0 0 * * 7", "2026-10-21 00:00", "2026-10-25 00:00"},
+		{"周范围到7", "0 0 * * 5-7", "2026-10-21 00:00", "2026-10-23 00:00"},
+		{"日和周都指定时满足周", "0 12 13 * 1", "2026-10-19 13:00", "2026-10-26 12:00"},
+		{"日和周都指定时满足日", "0 12 13 * 1", "2026-11-10 13:00", "2026-11-13 12:00"},
+		{"周为*时只按日", "0 12 13 * *", "2026-10-19 13:00", "2026-11-13 12:00"},
+		{"日为*时只按周", "0 12 * * 5", "2026-10-19 13:00", "2026-10-23 12:00"},
+		{"日为*/n时同时满足日和周", "0 12 */2 * 1", "2026-10-19 13:00", "2026-11-09 12:00"},
+		{"周为*/n时同时满足日和周", "0 12 13 * */2", "2026-10-01 00:00", "2026-10-13 12:00"},
+		{"闰年2月29日", "0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"2月30日", "0 0 30 2 *", "2026-10-19 00:00", ""},
+		{"4月31日", "0 0 31 4 *", "2026-10-19 00:00", ""},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expression)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		next, ok := spec.next(cronTime(c.after))
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s: next = %v, want none", c.name, next)
+			}
+			continue
+		}
+		if !ok || !next.Equal(cronTime(c.want)) {
+			t.Errorf("%s: next = %v %v, want %s", c.name, next, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// curl中不带参数、转换时直接忽略的选项
+var curlIgnoreFlags = map[string]bool{
+	"-k": true, "--insecure": true, "-s": true, "--silent": true, "-S": true, "--show-error": true,
+	"-L": true, "--location": true, "-v": true, "--verbose": true, "-i": true, "--include": true,
+	"--compressed": true, "-f": true, "--fail": true, "-#": true, "--progress-bar": true,
+	"-N": true, "--no-buffer": true, "--http1.1": true, "--http2": true, "-g": true, "--globoff": true,
+}
+
+// curl中带一个参数、转换时直接忽略的选项
+var curlIgnoreArgFlags = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"--retry": true, "-x": true, "--proxy": true, "-w": true, "--write-out": true,
+	"--cacert": true, "--cert": true, "--key": true, "-c": true, "--cookie-jar": true,
+	"--resolve": true, "-T": true, "--upload-file": true,
+}
+
+// 将curl命令转换为HttpRequest
+func ParseCurl(command string) (httpRequest *HttpRequest, err error) {
+	args, err := splitCurlArgs(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return nil, errors.New("不是有效的curl命令")
+	}
+
+	httpRequest = GenerateHttpRequest(false)
+	httpRequest.Header = make(map[string]string)
+
+	var (
+		method  string
+		rawUrl  string
+		data    []string
+		form    []string
+		isGet   bool
+		cookies []string
+	)
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := arg, "", false
+
+		// 支持 -XPOST、--request=POST 这类参数和值连写的形式
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			n := strings.Index(arg, "=")
+			name, value, hasValue = arg[:n], arg[n+1:], true
+		} else if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && curlTakesValue(arg[:2]) {
+			name, value, hasValue = arg[:2], arg[2:], true
+		}
+
+		if !strings.HasPrefix(name, "-") {
+			rawUrl = arg
+			continue
+		}
+
+		if curlTakesValue(name) && !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s缺少参数", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "-X", "--request":
+			method = strings.ToUpper(value)
+		case "--url":
+			rawUrl = value
+		case "-H", "--header":
+			n := strings.Index(value, ":")
+			if n <= 0 {
+				continue
+			}
+			k := strings.TrimSpace(value[:n])
+			v := strings.TrimSpace(value[n+1:])
+			if strings.EqualFold(k, "cookie") {
+				cookies = append(cookies, v)
+				continue
+			}
+			if strings.EqualFold(k, "content-type") {
+				k = "Content-Type"
+			}
+			httpRequest.Header[k] = v
+		case "-b", "--cookie":
+			// 不含=时是cookie文件，无法转换
+			if strings.Contains(value, "=") {
+				cookies = append(cookies, value)
+			}
+		case "-d", "--data", "--data-raw", "--data-ascii", "--data-binary":
+			if strings.HasPrefix(value, "@") && name != "--data-raw" {
+				// 文件内容作为二进制请求体，文件需要先上传
+				httpRequest.BodyType = BODY_TYPE_BINARY
+				httpRequest.BodyFile = filepath.Base(value[1:])
+				continue
+			}
+			data = append(data, value)
+		case "--data-urlencode":
+			data = append(data, curlUrlencode(value))
+		case "-F", "--form", "--form-string":
+			form = append(form, value)
+		case "-u", "--user":
+			httpRequest.Header["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(value))
+		case "-A", "--user-agent":
+			httpRequest.Header["User-Agent"] = value
+		case "-e", "--referer":
+			httpRequest.Header["Referer"] = value
+		case "-G", "--get":
+			isGet = true
+		case "-I", "--head":
+			method = http.MethodHead
+		default:
+			if curlIgnoreFlags[name] || curlIgnoreArgFlags[name] {
+				continue
+			}
+			// -sSL 这类短选项组合
+			if isCurlFlagGroup(name) {
+				continue
+			}
+			return nil, fmt.Errorf("不支持的curl选项：%s", name)
+		}
+	}
+
+	if rawUrl == "" {
+		return nil, errors.New("curl命令缺少url")
+	}
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "http://" + rawUrl
+	}
+
+	body := strings.Join(data, "&")
+	if isGet && body != "" {
+		sep := "?"
+		if strings.Contains(rawUrl, "?") {
+			sep = "&"
+		}
+		rawUrl += sep + body
+		body = ""
+	}
+
+	if method == "" {
+		method = http.MethodGet
+		if body != "" || len(form) > 0 || httpRequest.BodyType == BODY_TYPE_BINARY {
+			method = http.MethodPost
+		}
+	}
+
+	httpRequest.Url = rawUrl
+	httpRequest.Method = method
+	httpRequest.Cookie = strings.Join(cookies, "; ")
+	httpRequest.Name = fmt.Sprintf("%s %s", method, curlPath(rawUrl))
+
+	if len(form) > 0 {
+		err = httpRequest.parseCurlForm(form)
+	} else if body != "" && httpRequest.BodyType == "" {
+		err = httpRequest.parseRawBody(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return httpRequest, nil
+}
+
+// 将原始请求数据转换为body字段，json按顶层字段拆分，表单按字段拆分，其余作为raw请求体
+func (httpRequest *HttpRequest) parseRawBody(body string) error {
+	contentType := httpRequest.contentType()
+	if gjson.Valid(body) && gjson.Parse(body).IsObject() {
+		gjson.Parse(body).ForEach(func(key, value gjson.Result) bool {
+			httpRequest.HttpBody.Body = append(httpRequest.HttpBody.Body, &BodyField{
+				Name:    key.String(),
+				Type:    curlFieldType(value),
+				Default: value.Value(),
+			})
+			return true
+		})
+		if contentType == "" {
+			httpRequest.Header["Content-Type"] = "application/json"
+		}
+		return nil
+	}
+
+	values, err := url.ParseQuery(body)
+	if err != nil || (contentType != "" && !strings.Contains(contentType, "x-www-form-urlencoded")) || !strings.Contains(body, "=") {
+		httpRequest.BodyType = BODY_TYPE_RAW
+		httpRequest.RawBody = body
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		httpRequest.HttpBody.Body = append(httpRequest.HttpBody.Body, &BodyField{
+			Name:    k,
+			Type:    "string",
+			Default: values.Get(k),
+		})
+	}
+	if contentType == "" {
+		httpRequest.Header["Content-Type"] = "application/x-www-form-urlencoded"
+	}
+	return nil
+}
+
+// -F的数据按multipart发送，@文件作为上传文件字段，文件需要先上传
+func (httpRequest *HttpRequest) parseCurlForm(form []string) error {
+	for _, v := range form {
+		n := strings.Index(v, "=")
+		if n <= 0 {
+			return fmt.Errorf("无效的表单字段：%s", v)
+		}
+		value := v[n+1:]
+		if strings.HasPrefix(value, "<") {
+			return fmt.Errorf("暂不支持从文件读取表单字段：%s", v)
+		}
+		field := &BodyField{
+			Name:    v[:n],
+			Type:    "string",
+			Default: value,
+		}
+		if strings.HasPrefix(value, "@") {
+			// 去掉 ;type=xxx 这类附加参数
+			file := strings.Split(value[1:], ";")[0]
+			field.Type = "upload"
+			field.Default = nil
+			field.Dynamic = filepath.Base(file)
+		}
+		httpRequest.HttpBody.Body = append(httpRequest.HttpBody.Body, field)
+	}
+	httpRequest.BodyType = BODY_TYPE_MULTIPART
+	for k := range httpRequest.Header {
+		if strings.EqualFold(k, "content-type") {
+			delete(httpRequest.Header, k)
+		}
+	}
+	return nil
+}
+
+func curlTakesValue(name string) bool {
+	switch name {
+	case "-X", "--request", "--url", "-H", "--header", "-b", "--cookie", "-d", "--data", "--data-raw",
+		"--data-ascii", "--data-binary", "--data-urlencode", "-F", "--form", "--form-string",
+		"-u", "--user", "-A", "--user-agent", "-e", "--referer":
+		return true
+	}
+	return curlIgnoreArgFlags[name]
+}
+
+func isCurlFlagGroup(name string) bool {
+	if len(name) < 3 || name[0] != '-' || name[1] == '-' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if !curlIgnoreFlags["-"+string(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// --data-urlencode 的值：name=content 只编码content，没有=时编码全部
+func curlUrlencode(value string) string {
+	n := strings.Index(value, "=")
+	if n < 0 {
+		return url.QueryEscape(value)
+	}
+	if n == 0 {
+		return url.QueryEscape(value[1:])
+	}
+	return value[:n] + "=" + url.QueryEscape(value[n+1:])
+}
+
+func curlFieldType(value gjson.Result) string {
+	if value.Type == gjson.Number {
+		return "int"
+	}
+	return "string"
+}
+
+func curlPath(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// 按shell规则拆分命令行：支持单双引号、反斜杠转义和换行续行
+func splitCurlArgs(command string) (args []string, err error) {
+	var (
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range command {
+		if escaped {
+			escaped = false
+			// 反斜杠加换行表示续行
+			if c == '\n' || c == '\r' {
+				continue
+			}
+			// 双引号内只有 $ ` " \ 需要转义，其余反斜杠原样保留
+			if quote == '"' && !strings.ContainsRune("$`\"\\", c) {
+				cur.WriteRune('\\')
+			}
+			cur.WriteRune(c)
+			inArg = true
+			continue
+		}
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' {
+				escaped = true
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("curl命令引号不匹配")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCurlArgs(t *testing.T) {
+	cases := []struct {
+		command string
+		want    []string
+		err     bool
+	}{
+		{command: `curl http://h/a`, want: []string{"curl", "http://h/a"}},
+		{command: "curl  \t http://h/a  ", want: []string{"curl", "http://h/a"}},
+		{command: `curl 'http://h/a' -H "X-Id: 1"`, want: []string{"curl", "http://h/a", "-H", "X-Id: 1"}},
+		{command: "curl \\\n  -X POST \\\r\n  http://h/a", want: []string{"curl", "-X", "POST", "http://h/a"}},
+		{command: `curl -d "a\"b\n\$c\\"`, want: []string{"curl", "-d", `a"b\n$c\`}},
+		{command: `curl -d 'a\b"c'`, want: []string{"curl", "-d", `a\b"c`}},
+		{command: `curl a\ b`, want: []string{"curl", "a b"}},
+		{command: `curl 'a'"b"c`, want: []string{"curl", "abc"}},
+		{command: `curl ''`, want: []string{"curl", ""}},
+		{command: `curl 'http://h/a`, err: true},
+		{command: `curl "http://h/a`, err: true},
+	}
+	for _, c := range cases {
+		args, err := splitCurlArgs(c.command)
+		if (err != nil) != c.err {
+			t.Errorf("%q: err = %v, want err %v", c.command, err, c.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(args, c.want) {
+			t.Errorf("%q: args = %q, want %q", c.command, args, c.want)
+		}
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	cases := []struct {
+		name     string
+		command  string
+		method   string
+		url      string
+		header   map[string]string
+		cookie   string
+		bodyType string
+		rawBody  string
+		bodyFile string
+		fields   []string // 字段名:类型
+	}{
+		{
+			name:    "默认GET并补全协议",
+			command: `curl example.com/api?x=1`,
+			method:  "GET",
+			url:     "http://example.com/api?x=1",
+		},
+		{
+			name:    "json按顶层字段拆分",
+			command: `curl -X post https://h/u -H 'content-type: application/json' --data-raw '{"a":1,"b":"x"}'`,
+			method:  "POST",
+			url:     "https://h/u",
+			header:  map[string]string{"Content-Type": "application/json"},
+			fields:  []string{"a:int", "b:string"},
+		},
+		{
+			name:    "表单数据默认POST",
+			command: `curl http://h/login -d 'b=2' -d 'a=1'`,
+			method:  "POST",
+			url:     "http://h/login",
+			header:  map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			fields:  []string{"a:string", "b:string"},
+		},
+		{
+			name:    "-G把数据拼到url",
+			command: `curl -G 'http://h/s?q=1' --data-urlencode 'w=a b'`,
+			method:  "GET",
+			url:     "http://h/s?q=1&w=a+b",
+		},
+		{
+			name:     "非表单的数据作为raw",
+			command:  `curl http://h/echo -H 'Content-Type: text/plain' -d 'hello'`,
+			method:   "POST",
+			url:      "http://h/echo",
+			header:   map[string]string{"Content-Type": "text/plain"},
+			bodyType: BODY_TYPE_RAW,
+			rawBody:  "hello",
+		},
+		{
+			name:     "@文件作为二进制请求体",
+			command:  `curl --data-binary @/tmp/x.bin http://h/up`,
+			method:   "POST",
+			url:      "http://h/up",
+			bodyType: BODY_TYPE_BINARY,
+			bodyFile: "x.bin",
+		},
+		{
+			name:     "multipart去掉Content-Type",
+			command:  `curl -F 'f=@/a/b.png;type=image/png' -F n=1 -H 'Content-Type: multipart/form-data' http://h/f`,
+			method:   "POST",
+			url:      "http://h/f",
+			header:   map[string]string{},
+			bodyType: BODY_TYPE_MULTIPART,
+			fields:   []string{"f:upload", "n:string"},
+		},
+		{
+			name:    "cookie、认证与连写的选项",
+			command: `curl -sSL -u user:pw -b 'a=1' -H 'Cookie: b=2' -A ua -XPUT --url=http://h/p`,
+			method:  "PUT",
+			url:     "http://h/p",
+			header:  map[string]string{"Authorization": "Basic dXNlcjpwdw==", "User-Agent": "ua"},
+			cookie:  "a=1; b=2",
+		},
+		{
+			name:    "-I为HEAD",
+			command: `curl -I http://h/`,
+			method:  "HEAD",
+			url:     "http://h/",
+		},
+	}
+	for _, c := range cases {
+		httpRequest, err := ParseCurl(c.command)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if httpRequest.Method != c.method || httpRequest.Url != c.url {
+			t.Errorf("%s: %s %s, want %s %s", c.name, httpRequest.Method, httpRequest.Url, c.method, c.url)
+		}
+		if c.header != nil && !reflect.DeepEqual(httpRequest.Header, c.header) {
+			t.Errorf("%s: header = %v, want %v", c.name, httpRequest.Header, c.header)
+		}
+		if httpRequest.Cookie != c.cookie {
+			t.Errorf("%s: cookie = %q, want %q", c.name, httpRequest.Cookie, c.cookie)
+		}
+		if httpRequest.BodyType != c.bodyType || httpRequest.RawBody != c.rawBody || httpRequest.BodyFile != c.bodyFile {
+			t.Errorf("%s: body = %q %q %q, want %q %q %q", c.name, httpRequest.BodyType, httpRequest.RawBody, httpRequest.BodyFile, c.bodyType, c.rawBody, c.bodyFile)
+		}
+		var fields []string
+		for _, field := range httpRequest.HttpBody.Body {
+			fields = append(fields, field.Name+":"+field.Type)
+		}
+		if strings.Join(fields, ",") != strings.Join(c.fields, ",") {
+			t.Errorf("%s: fields = %v, want %v", c.name, fields, c.fields)
+		}
+	}
+}
+
+func TestParseCurlError(t *testing.T) {
+	for _, command := range []string{
+		`wget http://h/`,
+		``,
+		`curl -s`,
+		`curl http://h/ -H`,
+		`curl --foo http://h/`,
+		`curl -F 'x' http://h/`,
+		`curl -F 'x=<a.txt' http://h/`,
+		`curl 'http://h/`,
+	} {
+		if _, err := ParseCurl(command); err == nil {
+			t.Errorf("%q: want error", command)
+		}
+	}
+}
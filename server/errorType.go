@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/constant"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	ERR_TYPE_DNS        = "dns"        // 域名解析失败
+	ERR_TYPE_REFUSED    = "refused"    // 连接被拒绝
+	ERR_TYPE_RESET      = "reset"      // 连接被重置或提前关闭
+	ERR_TYPE_TLS        = "tls"        // tls握手、证书错误
+	ERR_TYPE_TIMEOUT    = "timeout"    // 超时，按阶段细分为 timeout.dns、timeout.connect 等
+	ERR_TYPE_CONNECTION = "connection" // 其他网络错误
+	ERR_TYPE_HTTP       = "http"       // http状态码错误，按类别细分为 http.4xx、http.5xx 等
+	ERR_TYPE_GRPC       = "grpc"       // grpc状态码错误
+	ERR_TYPE_ASSERTION  = "assertion"  // 响应内容不符合预期
+	ERR_TYPE_EXTRACTION = "extraction" // 脚本中提取上一步响应字段失败
+	ERR_TYPE_SCRIPT     = "script"     // 脚本、字段生成等执行错误
+	ERR_TYPE_REQUEST    = "request"    // 创建请求失败
+	ERR_TYPE_OTHER      = "other"
+
+	ERROR_MESSAGE_LIMIT = 100 // 每个分类最多记录的不同错误信息数，超出的计入“其他”
+	ERROR_MESSAGE_TOP   = 10  // 报告中每个分类展示的错误信息数
+	ERROR_MESSAGE_OTHER = "其他"
+)
+
+var errTypeCode = map[string]int{
+	ERR_TYPE_DNS:        constant.ERROR_REQUEST_DNS,
+	ERR_TYPE_REFUSED:    constant.ERROR_REQUEST_REFUSED,
+	ERR_TYPE_RESET:      constant.ERROR_REQUEST_RESET,
+	ERR_TYPE_TLS:        constant.ERROR_REQUEST_TLS,
+	ERR_TYPE_TIMEOUT:    constant.ERROR_REQUEST_TIMEOUT,
+	ERR_TYPE_CONNECTION: constant.ERROR_REQUEST_CONNECTION,
+	ERR_TYPE_ASSERTION:  constant.ERROR_REQUEST_ASSERTION,
+	ERR_TYPE_EXTRACTION: constant.ERROR_REQUEST_EXTRACTION,
+	ERR_TYPE_SCRIPT:     constant.ERROR_REQUEST_SCRIPT,
+	ERR_TYPE_REQUEST:    constant.ERROR_REQUEST_CREATED,
+	ERR_TYPE_OTHER:      constant.ERROR_REQUEST_DEFAULT,
+}
+
+// 错误信息中的本地地址每次都不同，去掉后再统计
+var errorAddrRegexp = regexp.MustCompile(`\b(tcp|udp)([46])? \S+->`)
+
+// 提取response字段失败，脚本中使用
+type extractionError struct {
+	error
+}
+
+// 响应内容不符合预期
+type assertionError struct {
+	error
+}
+
+// 按错误类型设置失败的响应，phase为超时发生的阶段
+func (resp *Response) setError(err error, phase string) {
+	errType := classifyError(err)
+	if errType == ERR_TYPE_TIMEOUT && phase != "" {
+		errType = ERR_TYPE_TIMEOUT + "." + phase
+	}
+	resp.setErrorType(errType, err.Error())
+}
+
+func (resp *Response) setErrorType(errType string, msg string) {
+	resp.IsSuccess = false
+	resp.ErrType = errType
+	resp.ErrCode = errTypeCode[strings.SplitN(errType, ".", 2)[0]]
+	resp.ErrMsg = msg
+}
+
+// 字段生成中的panic，提取response字段失败单独分类
+func (resp *Response) setPanic(err interface{}) {
+	logger.Debug(err)
+	errType := ERR_TYPE_SCRIPT
+	if e, ok := err.(error); ok && classifyError(e) == ERR_TYPE_EXTRACTION {
+		errType = ERR_TYPE_EXTRACTION
+	}
+	resp.setErrorType(errType, fmt.Sprint(err))
+}
+
+// http状态码错误，错误码保留状态码
+func (resp *Response) setStatusError(code int, msg string) {
+	resp.IsSuccess = false
+	resp.ErrType = fmt.Sprintf("%s.%dxx", ERR_TYPE_HTTP, code/100)
+	resp.ErrCode = code
+	resp.ErrMsg = msg
+}
+
+func classifyError(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		extractErr *extractionError
+		assertErr  *assertionError
+		certErr    x509.CertificateInvalidError
+		unknownErr x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		recordErr  tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &extractErr):
+		return ERR_TYPE_EXTRACTION
+	case errors.As(err, &assertErr):
+		return ERR_TYPE_ASSERTION
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return ERR_TYPE_TIMEOUT
+	case errors.As(err, &dnsErr):
+		return ERR_TYPE_DNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ERR_TYPE_REFUSED
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ERR_TYPE_RESET
+	case errors.As(err, &certErr) || errors.As(err, &unknownErr) || errors.As(err, &hostErr) || errors.As(err, &recordErr) || strings.Contains(err.Error(), "tls: "):
+		return ERR_TYPE_TLS
+	case errors.As(err, &netErr):
+		return ERR_TYPE_CONNECTION
+	}
+	return ERR_TYPE_OTHER
+}
+
+// 单个错误分类的统计
+type ErrorReport struct {
+	Count    uint64
+	messages map[string]uint64
+}
+
+type ErrorMessage struct {
+	Message string `json:"message"`
+	Count   uint64 `json:"count"`
+}
+
+func newErrorReport() *ErrorReport {
+	return &ErrorReport{messages: make(map[string]uint64)}
+}
+
+func (errorReport *ErrorReport) add(msg string, count uint64) {
+	msg = errorAddrRegexp.ReplaceAllString(msg, "$1$2 ")
+	errorReport.Count += count
+	if _, ok := errorReport.messages[msg]; !ok && len(errorReport.messages) >= ERROR_MESSAGE_LIMIT {
+		msg = ERROR_MESSAGE_OTHER
+	}
+	errorReport.messages[msg] += count
+}
+
+// 出现次数最多的错误信息
+func (errorReport *ErrorReport) Top(n int) []*ErrorMessage {
+	list := make([]*ErrorMessage, 0, len(errorReport.messages))
+	for msg, count := range errorReport.messages {
+		list = append(list, &ErrorMessage{Message: msg, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count == list[j].Count {
+			return list[i].Message < list[j].Message
+		}
+		return list[i].Count > list[j].Count
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+type errorReportJson struct {
+	Count    uint64          `json:"count"`
+	Messages []*ErrorMessage `json:"messages"`
+}
+
+func (errorReport *ErrorReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&errorReportJson{Count: errorReport.Count, Messages: errorReport.Top(ERROR_MESSAGE_TOP)})
+}
+
+// 集群节点上报的报告只包含前几条错误信息，其余的计入“其他”
+func (errorReport *ErrorReport) UnmarshalJSON(data []byte) error {
+	var v errorReportJson
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	errorReport.Count = v.Count
+	errorReport.messages = make(map[string]uint64)
+	var listed uint64
+	for _, m := range v.Messages {
+		errorReport.messages[m.Message] += m.Count
+		listed += m.Count
+	}
+	if v.Count > listed {
+		errorReport.messages[ERROR_MESSAGE_OTHER] += v.Count - listed
+	}
+	return nil
+}
+
+type ErrorReports map[string]*ErrorReport
+
+func (errorReports ErrorReports) add(errType string, msg string) {
+	if errType == "" {
+		errType = ERR_TYPE_OTHER
+	}
+	errorReport, ok := errorReports[errType]
+	if !ok {
+		errorReport = newErrorReport()
+		errorReports[errType] = errorReport
+	}
+	errorReport.add(msg, 1)
+}
+
+func (errorReports ErrorReports) Merge(other ErrorReports) {
+	for errType, v := range other {
+		errorReport, ok := errorReports[errType]
+		if !ok {
+			errorReport = newErrorReport()
+			errorReports[errType] = errorReport
+		}
+		for msg, count := range v.messages {
+			errorReport.add(msg, count)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"insane/constant"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	opError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://h", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}}
+	}
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&extractionError{errors.New("x")}, ERR_TYPE_EXTRACTION},
+		{fmt.Errorf("step: %w", &assertionError{errors.New("x")}), ERR_TYPE_ASSERTION},
+		{context.DeadlineExceeded, ERR_TYPE_TIMEOUT},
+		{&net.DNSError{Err: "no such host", Name: "h"}, ERR_TYPE_DNS},
+		{&net.DNSError{Err: "timeout", Name: "h", IsTimeout: true}, ERR_TYPE_TIMEOUT},
+		{opError(syscall.ECONNREFUSED), ERR_TYPE_REFUSED},
+		{opError(syscall.ECONNRESET), ERR_TYPE_RESET},
+		{&url.Error{Op: "Get", URL: "http://h", Err: io.EOF}, ERR_TYPE_RESET},
+		{x509.UnknownAuthorityError{}, ERR_TYPE_TLS},
+		{errors.New("remote error: tls: bad certificate"), ERR_TYPE_TLS},
+		{opError(syscall.ENETUNREACH), ERR_TYPE_CONNECTION},
+		{errors.New("x"), ERR_TYPE_OTHER},
+	}
+	for _, c := range cases {
+		if got := classifyError(c.err); got != c.want {
+			t.Errorf("%v: %s, want %s", c.err, got, c.want)
+		}
+	}
+
+	resp := new(Response)
+	resp.setError(context.DeadlineExceeded, PHASE_CONNECT)
+	if resp.ErrType != "timeout.connect" || resp.ErrCode != constant.ERROR_REQUEST_TIMEOUT || resp.IsSuccess {
+		t.Errorf("setError = %+v", resp)
+	}
+	resp.setStatusError(503, "503 Service Unavailable")
+	if resp.ErrType != "http.5xx" || resp.ErrCode != 503 {
+		t.Errorf("setStatusError = %+v", resp)
+	}
+}
+
+func TestErrorReports(t *testing.T) {
+	errorReports := make(ErrorReports)
+	errorReports.add(ERR_TYPE_REFUSED, "dial tcp 127.0.0.1:5301->127.0.0.1:80: connect: connection refused")
+	errorReports.add(ERR_TYPE_REFUSED, "dial tcp 127.0.0.1:5302->127.0.0.1:80: connect: connection refused")
+	errorReports.add(ERR_TYPE_REFUSED, "dial tcp 127.0.0.1:81: connect: connection refused")
+	errorReports.add("", "x")
+	for i := 0; i < ERROR_MESSAGE_LIMIT+5; i++ {
+		errorReports.add(ERR_TYPE_SCRIPT, fmt.Sprintf("e%d", i))
+	}
+
+	refused := errorReports[ERR_TYPE_REFUSED].Top(ERROR_MESSAGE_TOP)
+	want := []*ErrorMessage{
+		{Message: "dial tcp 127.0.0.1:80: connect: connection refused", Count: 2},
+		{Message: "dial tcp 127.0.0.1:81: connect: connection refused", Count: 1},
+	}
+	if !reflect.DeepEqual(refused, want) {
+		t.Errorf("refused = %+v", refused)
+	}
+	if errorReports[ERR_TYPE_OTHER].Count != 1 {
+		t.Error("empty type should be other")
+	}
+	script := errorReports[ERR_TYPE_SCRIPT]
+	if script.Count != ERROR_MESSAGE_LIMIT+5 || len(script.messages) != ERROR_MESSAGE_LIMIT+1 || script.messages[ERROR_MESSAGE_OTHER] != 5 {
+		t.Errorf("script: count %d, messages %d, other %d", script.Count, len(script.messages), script.messages[ERROR_MESSAGE_OTHER])
+	}
+
+	// 上报的报告只有前几条错误信息，合并后其余的计入“其他”
+	data, err := json.Marshal(errorReports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := make(ErrorReports)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	merged := make(ErrorReports)
+	merged.Merge(errorReports)
+	merged.Merge(decoded)
+	if merged[ERR_TYPE_REFUSED].Count != 6 || merged[ERR_TYPE_REFUSED].messages[want[0].Message] != 4 {
+		t.Errorf("merged refused = %+v", merged[ERR_TYPE_REFUSED])
+	}
+	if merged[ERR_TYPE_SCRIPT].Count != 2*(ERROR_MESSAGE_LIMIT+5) {
+		t.Errorf("merged script count = %d", merged[ERR_TYPE_SCRIPT].Count)
+	}
+}
+
+func TestReportMerge(t *testing.T) {
+	node := &Report{
+		RequestTime:       10,
+		MaxTime:           80,
+		MinTime:           5,
+		SuccessNum:        3,
+		FailureNum:        1,
+		ConCurrency:       2,
+		ErrCode:           map[int]int{500: 1},
+		ErrCodeMsg:        map[int]string{500: "500 Internal Server Error"},
+		AverageSuccessReq: map[uint64]int{1: 2, 2: 1},
+		Groups:            map[string]*GroupReport{"/a": {SuccessNum: 3, TotalTime: 30, AvgTime: 10, MaxTime: 80, MinTime: 5}},
+		Protocols:         map[string]uint64{"HTTP/1.1": 4},
+		FirstByte:         &GroupReport{SuccessNum: 3, TotalTime: 15, AvgTime: 5, MaxTime: 7, MinTime: 3},
+		Errors:            ErrorReports{"http.5xx": &ErrorReport{Count: 1, messages: map[string]uint64{"500": 1}}},
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := new(Report)
+	if err := json.Unmarshal(data, other); err != nil {
+		t.Fatal(err)
+	}
+
+	report := &Report{MinTime: 0, MaxTime: 50, SuccessNum: 1, AverageSuccessReq: map[uint64]int{1: 1}}
+	report.Merge(other)
+	report.Merge(other)
+	if report.RequestTime != 10 || report.MaxTime != 80 || report.MinTime != 5 || report.SuccessNum != 7 || report.FailureNum != 2 || report.ConCurrency != 4 {
+		t.Errorf("report = %+v", report)
+	}
+	if !reflect.DeepEqual(report.AverageSuccessReq, map[uint64]int{1: 5, 2: 2}) || report.ErrCode[500] != 2 || report.Protocols["HTTP/1.1"] != 8 {
+		t.Errorf("series %v, errCode %v, protocols %v", report.AverageSuccessReq, report.ErrCode, report.Protocols)
+	}
+	if group := report.Groups["/a"]; group.SuccessNum != 6 || group.AvgTime != 10 || group.MinTime != 5 {
+		t.Errorf("group = %+v", group)
+	}
+	if report.FirstByte.SuccessNum != 6 || report.FirstByte.AvgTime != 5 || report.Errors["http.5xx"].Count != 2 {
+		t.Errorf("firstByte %+v, errors %+v", report.FirstByte, report.Errors["http.5xx"])
+	}
+}
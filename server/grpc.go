@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"insane/utils"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const GRPC_PROTO = "gRPC"
+
+type GrpcRequest struct {
+	Target        string            `json:"target"`        // 服务地址，如 127.0.0.1:50051
+	Method        string            `json:"method"`        // 完整方法名，如 package.Service/Method
+	DescriptorSet string            `json:"descriptorSet"` // 上传的描述文件（protoc --include_imports --descriptor_set_out）
+	Reflection    bool              `json:"reflection"`    // 未上传描述文件时，通过服务端反射获取方法定义
+	Tls           bool              `json:"tls"`
+	Metadata      map[string]string `json:"metadata"`
+	Timeout       uint64            `json:"timeout"`     // 截止时间（毫秒），默认5秒
+	Connections   uint64            `json:"connections"` // 连接数，请求在连接上多路复用
+	Template      string            `json:"template"`    // json请求模板，{{字段名}}替换为body中同名字段的值，为空时由body字段生成
+	Body          []*BodyField      `json:"body"`
+
+	method  *desc.MethodDescriptor
+	conns   []*grpc.ClientConn
+	used    []int32      // 每条连接是否已经发送过请求，第一个请求不算复用
+	dialErr error        // 建立连接失败的原因
+	request *HttpRequest // 复用http请求体的字段生成
+	pacer   *pacer       // 按计划时间发送，见schedule.go
+}
+
+// 解析方法定义并检查请求字段，连接在任务开始执行时建立，见Connect
+func (grpcRequest *GrpcRequest) Load() (err error) {
+	if grpcRequest.Target == "" || grpcRequest.Method == "" {
+		return errors.New("target和method不能为空")
+	}
+	serviceName, methodName, err := grpcRequest.splitMethod()
+	if err != nil {
+		return err
+	}
+
+	if grpcRequest.Connections == 0 {
+		grpcRequest.Connections = 1
+	}
+
+	var service *desc.ServiceDescriptor
+	if grpcRequest.DescriptorSet != "" {
+		service, err = grpcRequest.serviceFromFile(serviceName)
+	} else if grpcRequest.Reflection {
+		service, err = grpcRequest.serviceFromReflection(serviceName)
+	} else {
+		err = errors.New("需要上传描述文件或开启服务端反射")
+	}
+	if err != nil {
+		return err
+	}
+
+	if grpcRequest.method = service.FindMethodByName(methodName); grpcRequest.method == nil {
+		return fmt.Errorf("服务%s中没有方法%s", serviceName, methodName)
+	}
+	if grpcRequest.method.IsClientStreaming() {
+		return errors.New("仅支持unary和server-streaming方法")
+	}
+
+	grpcRequest.request = GenerateHttpRequest(false)
+	grpcRequest.request.HttpBody.Body = grpcRequest.Body
+	grpcRequest.request.RawBody = grpcRequest.Template
+	return grpcRequest.request.HttpBody.verifyFields(grpcRequest.Body, false)
+}
+
+// 任务开始执行时建立连接，排队中被删除的任务不占用连接；已经建立时直接返回
+func (grpcRequest *GrpcRequest) Connect() error {
+	if len(grpcRequest.conns) > 0 {
+		return nil
+	}
+	grpcRequest.dialErr = nil
+	for i := uint64(0); i < grpcRequest.Connections; i++ {
+		conn, err := grpc.Dial(grpcRequest.Target, grpcRequest.dialOption())
+		if err != nil {
+			grpcRequest.Close()
+			grpcRequest.dialErr = err
+			return err
+		}
+		grpcRequest.conns = append(grpcRequest.conns, conn)
+	}
+	grpcRequest.used = make([]int32, len(grpcRequest.conns))
+	return nil
+}
+
+func (grpcRequest *GrpcRequest) Close() {
+	for _, conn := range grpcRequest.conns {
+		conn.Close()
+	}
+	grpcRequest.conns = nil
+	grpcRequest.used = nil
+}
+
+// 连接在第一个请求时才真正建立，之后的请求复用
+func (grpcRequest *GrpcRequest) reused(index uint64) bool {
+	return !atomic.CompareAndSwapInt32(&grpcRequest.used[index], 0, 1)
+}
+
+// 支持 package.Service/Method 与 package.Service.Method
+func (grpcRequest *GrpcRequest) splitMethod() (service string, method string, err error) {
+	name := strings.TrimPrefix(grpcRequest.Method, "/")
+	pos := strings.LastIndex(name, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(name, ".")
+	}
+	if pos <= 0 || pos == len(name)-1 {
+		return "", "", fmt.Errorf("method格式错误：%s", grpcRequest.Method)
+	}
+	return name[:pos], name[pos+1:], nil
+}
+
+func (grpcRequest *GrpcRequest) dialOption() grpc.DialOption {
+	if grpcRequest.Tls {
+		return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}))
+	}
+	return grpc.WithInsecure()
+}
+
+func (grpcRequest *GrpcRequest) serviceFromFile(serviceName string) (*desc.ServiceDescriptor, error) {
+	data, err := ioutil.ReadFile(uploadFilePath(grpcRequest.DescriptorSet))
+	if err != nil {
+		return nil, err
+	}
+	fds := new(descpb.FileDescriptorSet)
+	if err = proto.Unmarshal(data, fds); err != nil {
+		return nil, fmt.Errorf("描述文件解析失败：%s", err)
+	}
+	files, err := desc.CreateFileDescriptorsFromSet(fds)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if service := file.FindService(serviceName); service != nil {
+			return service, nil
+		}
+	}
+	return nil, fmt.Errorf("描述文件中没有服务%s", serviceName)
+}
+
+func (grpcRequest *GrpcRequest) serviceFromReflection(serviceName string) (*desc.ServiceDescriptor, error) {
+	conn, err := grpc.Dial(grpcRequest.Target, grpcRequest.dialOption())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), grpcRequest.timeout())
+	defer cancel()
+	client := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(conn))
+	defer client.Reset()
+	return client.ResolveService(serviceName)
+}
+
+func (grpcRequest *GrpcRequest) timeout() time.Duration {
+	if grpcRequest.Timeout == 0 {
+		return HTTP_RESPONSE_TIMEOUT
+	}
+	return time.Duration(grpcRequest.Timeout) * time.Millisecond
+}
+
+func (grpcRequest *GrpcRequest) Run(serial uint64, ch chan<- *Response, wg *sync.WaitGroup, stopCh <-chan int) {
+	if len(grpcRequest.conns) == 0 {
+		// 建立连接失败时记一次失败，错误出现在报告中
+		logger.Debug(fmt.Sprintf("%d号grpc协程没有可用的连接", serial))
+		err := grpcRequest.dialErr
+		if err == nil {
+			err = errors.New("没有可用的连接")
+		}
+		resp := &Response{Proto: GRPC_PROTO}
+		resp.setError(err, PHASE_CONNECT)
+		ch <- resp
+		wg.Done()
+		return
+	}
+	index := serial % uint64(len(grpcRequest.conns))
+	stub := grpcdynamic.NewStub(grpcRequest.conns[index])
+	slot := grpcRequest.pacer.slot(serial)
+	for {
+		lag, ok := slot.wait(stopCh)
+		if !ok {
+			logger.Debug(fmt.Sprintf("%d号grpc协程关闭", serial))
+			wg.Done()
+			return
+		}
+		resp := grpcRequest.GrpcSend(stub, grpcRequest.reused(index))
+		lag.set(resp)
+		ch <- resp
+	}
+}
+
+func (grpcRequest *GrpcRequest) GrpcSend(stub grpcdynamic.Stub, reused bool) *Response {
+	resp := &Response{Proto: GRPC_PROTO, ConnReused: reused}
+	start := utils.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			resp.setPanic(err)
+			resp.WasteTime = uint64(utils.Now() - start)
+		}
+	}()
+
+	msg := dynamic.NewMessage(grpcRequest.method.GetInputType())
+	if err := msg.UnmarshalJSON([]byte(grpcRequest.body())); err != nil {
+		resp.setErrorType(ERR_TYPE_REQUEST, err.Error())
+		resp.WasteTime = uint64(utils.Now() - start)
+		return resp
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcRequest.timeout())
+	defer cancel()
+	if len(grpcRequest.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(grpcRequest.Metadata))
+	}
+
+	var err error
+	if grpcRequest.method.IsServerStreaming() {
+		var messages uint64
+		messages, err = grpcRequest.receiveStream(ctx, stub, msg)
+		resp.Data = messages
+	} else {
+		_, err = stub.InvokeRpc(ctx, grpcRequest.method, msg)
+	}
+	resp.WasteTime = uint64(utils.Now() - start)
+
+	// 错误码为gRPC状态码，错误分类为 grpc.状态名
+	st := status.Convert(err)
+	resp.ErrCode = int(st.Code())
+	resp.ErrMsg = st.Code().String()
+	resp.IsSuccess = err == nil
+	if err != nil {
+		resp.ErrType = ERR_TYPE_GRPC + "." + st.Code().String()
+		resp.ErrMsg = st.Code().String() + ": " + st.Message()
+	}
+	return resp
+}
+
+// 读取完整的响应流，返回收到的消息数
+func (grpcRequest *GrpcRequest) receiveStream(ctx context.Context, stub grpcdynamic.Stub, msg proto.Message) (messages uint64, err error) {
+	stream, err := stub.InvokeRpcServerStream(ctx, grpcRequest.method, msg)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err = stream.RecvMsg(); err != nil {
+			if err == io.EOF {
+				return messages, nil
+			}
+			return messages, err
+		}
+		messages++
+	}
+}
+
+func (grpcRequest *GrpcRequest) body() string {
+	if grpcRequest.Template != "" {
+		return grpcRequest.request.createRawBody()
+	}
+	return grpcRequest.request.createJsonBody()
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc/codes"
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestGrpcSplitMethod(t *testing.T) {
+	cases := []struct {
+		method  string
+		service string
+		name    string
+		err     bool
+	}{
+		{method: "pkg.Service/Call", service: "pkg.Service", name: "Call"},
+		{method: "/pkg.Service/Call", service: "pkg.Service", name: "Call"},
+		{method: "pkg.Service.Call", service: "pkg.Service", name: "Call"},
+		{method: "Call", err: true},
+		{method: "pkg.Service/", err: true},
+		{method: "/Call", err: true},
+	}
+	for _, c := range cases {
+		grpcRequest := &GrpcRequest{Method: c.method}
+		service, name, err := grpcRequest.splitMethod()
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.method, err, c.err)
+			continue
+		}
+		if service != c.service || name != c.name {
+			t.Errorf("%s: %s %s, want %s %s", c.method, service, name, c.service, c.name)
+		}
+	}
+}
+
+// 描述文件：package test; message Req { string name = 1; int64 n = 2; } service Echo { rpc Call(Req) returns (Req); rpc Watch(Req) returns (stream Req); rpc Push(stream Req) returns (Req); }
+func writeGrpcDescriptor(t *testing.T, dir string) {
+	field := func(name string, number int32, typ descpb.FieldDescriptorProto_Type) *descpb.FieldDescriptorProto {
+		return &descpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	method := func(name string, clientStream, serverStream bool) *descpb.MethodDescriptorProto {
+		return &descpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".test.Req"),
+			OutputType:      proto.String(".test.Req"),
+			ClientStreaming: proto.Bool(clientStream),
+			ServerStreaming: proto.Bool(serverStream),
+		}
+	}
+	fds := &descpb.FileDescriptorSet{File: []*descpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descpb.DescriptorProto{{
+			Name: proto.String("Req"),
+			Field: []*descpb.FieldDescriptorProto{
+				field("name", 1, descpb.FieldDescriptorProto_TYPE_STRING),
+				field("n", 2, descpb.FieldDescriptorProto_TYPE_INT64),
+			},
+		}},
+		Service: []*descpb.ServiceDescriptorProto{{
+			Name:   proto.String("Echo"),
+			Method: []*descpb.MethodDescriptorProto{method("Call", false, false), method("Watch", false, true), method("Push", true, false)},
+		}},
+	}}}
+	data, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.pb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrpcLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	appconfig.GetConfig().File.UploadPath = dir
+	defer func() { appconfig.GetConfig().File.UploadPath = uploadPath }()
+	writeGrpcDescriptor(t, dir)
+
+	cases := []struct {
+		name    string
+		request GrpcRequest
+		stream  bool
+		err     bool
+	}{
+		{name: "unary", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo/Call", DescriptorSet: "test.pb", Connections: 2}},
+		{name: "server-streaming", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo.Watch", DescriptorSet: "../test.pb"}, stream: true},
+		{name: "client-streaming", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo/Push", DescriptorSet: "test.pb"}, err: true},
+		{name: "方法不存在", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo/None", DescriptorSet: "test.pb"}, err: true},
+		{name: "服务不存在", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.None/Call", DescriptorSet: "test.pb"}, err: true},
+		{name: "描述文件不存在", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo/Call", DescriptorSet: "none.pb"}, err: true},
+		{name: "未指定方法定义", request: GrpcRequest{Target: "127.0.0.1:1", Method: "test.Echo/Call"}, err: true},
+		{name: "缺少target", request: GrpcRequest{Method: "test.Echo/Call", DescriptorSet: "test.pb"}, err: true},
+	}
+	for _, c := range cases {
+		grpcRequest := c.request
+		err := grpcRequest.Load()
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err != nil {
+			if len(grpcRequest.conns) != 0 {
+				t.Errorf("%s: connections not closed", c.name)
+			}
+			continue
+		}
+		// 连接在任务开始执行时才建立
+		if grpcRequest.method.IsServerStreaming() != c.stream || len(grpcRequest.conns) != 0 {
+			t.Errorf("%s: stream %t, conns %d", c.name, grpcRequest.method.IsServerStreaming(), len(grpcRequest.conns))
+		}
+		for i := 0; i < 2; i++ {
+			if err := grpcRequest.Connect(); err != nil || uint64(len(grpcRequest.conns)) != grpcRequest.Connections {
+				t.Errorf("%s: connect %v, conns %d", c.name, err, len(grpcRequest.conns))
+			}
+		}
+		grpcRequest.Close()
+	}
+}
+
+func TestGrpcSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	appconfig.GetConfig().File.UploadPath = dir
+	defer func() { appconfig.GetConfig().File.UploadPath = uploadPath }()
+	writeGrpcDescriptor(t, dir)
+
+	// 监听后立即关闭，请求返回Unavailable
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := listener.Addr().String()
+	listener.Close()
+
+	cases := []struct {
+		name     string
+		template string
+		body     []*BodyField
+		errType  string
+	}{
+		{name: "字段生成", body: []*BodyField{{Name: "name", Type: "string", Default: "a"}, {Name: "n", Type: "int", Len: 2}}, errType: "grpc.Unavailable"},
+		{name: "模板", template: `{"name":"{{name}}"}`, body: []*BodyField{{Name: "name", Type: "string", Default: "a"}}, errType: "grpc.Unavailable"},
+		{name: "字段不存在", body: []*BodyField{{Name: "none", Type: "string", Default: "a"}}, errType: ERR_TYPE_REQUEST},
+	}
+	for _, c := range cases {
+		grpcRequest := &GrpcRequest{Target: target, Method: "test.Echo/Call", DescriptorSet: "test.pb", Timeout: 1000, Template: c.template, Body: c.body}
+		if err := grpcRequest.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if err := grpcRequest.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		resp := grpcRequest.GrpcSend(grpcdynamic.NewStub(grpcRequest.conns[0]), false)
+		grpcRequest.Close()
+		if resp.IsSuccess || resp.Proto != GRPC_PROTO {
+			t.Errorf("%s: %+v", c.name, resp)
+			continue
+		}
+		if resp.ErrType != c.errType {
+			t.Errorf("%s: %s %s, want %s", c.name, resp.ErrType, resp.ErrMsg, c.errType)
+		}
+		if c.errType != ERR_TYPE_REQUEST && resp.ErrCode != int(codes.Unavailable) {
+			t.Errorf("%s: code %d", c.name, resp.ErrCode)
+		}
+	}
+}
+
+func TestGrpcConnReused(t *testing.T) {
+	grpcRequest := &GrpcRequest{Target: "127.0.0.1:1", Connections: 2}
+	if err := grpcRequest.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer grpcRequest.Close()
+	// 每条连接的第一个请求不算复用
+	cases := []struct {
+		index  uint64
+		reused bool
+	}{
+		{index: 0, reused: false},
+		{index: 0, reused: true},
+		{index: 1, reused: false},
+		{index: 1, reused: true},
+	}
+	for i, c := range cases {
+		if reused := grpcRequest.reused(c.index); reused != c.reused {
+			t.Errorf("#%d conn %d: reused %t", i, c.index, reused)
+		}
+	}
+}
+
+func TestGrpcRunWithoutConns(t *testing.T) {
+	grpcRequest := &GrpcRequest{dialErr: errors.New("dial failed")}
+	ch := make(chan *Response, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	grpcRequest.Run(0, ch, &wg, nil)
+	wg.Wait()
+	// 建立连接失败时记一次失败
+	if resp := <-ch; resp.IsSuccess || resp.ErrMsg != "dial failed" || resp.Proto != GRPC_PROTO {
+		t.Errorf("resp = %+v", resp)
+	}
+}
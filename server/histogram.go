@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+)
+
+const (
+	HISTOGRAM_SUB_BITS    = 5                          // 每个2的幂区间再分成32份，相对误差约3%
+	HISTOGRAM_SUB_BUCKETS = 1 << HISTOGRAM_SUB_BITS    // 32
+	HISTOGRAM_LINEAR      = HISTOGRAM_SUB_BUCKETS << 1 // 小于64的值精确记录
+)
+
+// 对数分桶的直方图，内存占用与样本数无关，用于计算百分位
+type Histogram struct {
+	counts []uint64
+	count  uint64
+	total  uint64
+	min    uint64
+	max    uint64
+}
+
+type HistogramSummary struct {
+	Count uint64 `json:"count"`
+	Avg   uint64 `json:"avg"`
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	P50   uint64 `json:"p50"`
+	P90   uint64 `json:"p90"`
+	P95   uint64 `json:"p95"`
+	P99   uint64 `json:"p99"`
+	// 非空的桶，集群节点上报报告后用于合并百分位
+	Buckets map[int]uint64 `json:"buckets,omitempty"`
+}
+
+func NewHistogram() *Histogram {
+	return new(Histogram)
+}
+
+func (histogram *Histogram) Add(value uint64) {
+	idx := histogramIndex(value)
+	if idx >= len(histogram.counts) {
+		counts := make([]uint64, idx+1)
+		copy(counts, histogram.counts)
+		histogram.counts = counts
+	}
+	histogram.counts[idx]++
+	if histogram.count == 0 || value < histogram.min {
+		histogram.min = value
+	}
+	if value > histogram.max {
+		histogram.max = value
+	}
+	histogram.count++
+	histogram.total += value
+}
+
+// 合并另一个直方图的数据
+func (histogram *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if len(other.counts) > len(histogram.counts) {
+		counts := make([]uint64, len(other.counts))
+		copy(counts, histogram.counts)
+		histogram.counts = counts
+	}
+	for k, v := range other.counts {
+		histogram.counts[k] += v
+	}
+	if histogram.count == 0 || other.min < histogram.min {
+		histogram.min = other.min
+	}
+	if other.max > histogram.max {
+		histogram.max = other.max
+	}
+	histogram.count += other.count
+	histogram.total += other.total
+}
+
+func (histogram *Histogram) Count() uint64 {
+	return histogram.count
+}
+
+// 百分位，percent取值0-100
+func (histogram *Histogram) Percentile(percent float64) uint64 {
+	if histogram.count == 0 {
+		return 0
+	}
+	rank := uint64(float64(histogram.count)*percent/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for idx, n := range histogram.counts {
+		seen += n
+		if seen >= rank {
+			value := histogramValue(idx)
+			// 桶的代表值可能超出实际范围
+			if value > histogram.max {
+				value = histogram.max
+			}
+			if value < histogram.min {
+				value = histogram.min
+			}
+			return value
+		}
+	}
+	return histogram.max
+}
+
+func (histogram *Histogram) Summary() *HistogramSummary {
+	summary := &HistogramSummary{
+		Count: histogram.count,
+		Min:   histogram.min,
+		Max:   histogram.max,
+		P50:   histogram.Percentile(50),
+		P90:   histogram.Percentile(90),
+		P95:   histogram.Percentile(95),
+		P99:   histogram.Percentile(99),
+	}
+	if histogram.count > 0 {
+		summary.Avg = histogram.total / histogram.count
+	}
+	return summary
+}
+
+func (histogram *Histogram) MarshalJSON() ([]byte, error) {
+	summary := histogram.Summary()
+	summary.Buckets = make(map[int]uint64)
+	for idx, n := range histogram.counts {
+		if n > 0 {
+			summary.Buckets[idx] = n
+		}
+	}
+	return json.Marshal(summary)
+}
+
+func (histogram *Histogram) UnmarshalJSON(data []byte) error {
+	var summary HistogramSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return err
+	}
+	*histogram = Histogram{
+		count: summary.Count,
+		total: summary.Avg * summary.Count,
+		min:   summary.Min,
+		max:   summary.Max,
+	}
+	for idx, n := range summary.Buckets {
+		if idx < 0 || idx > histogramIndex(math.MaxUint64) {
+			continue
+		}
+		if idx >= len(histogram.counts) {
+			counts := make([]uint64, idx+1)
+			copy(counts, histogram.counts)
+			histogram.counts = counts
+		}
+		histogram.counts[idx] = n
+	}
+	return nil
+}
+
+func histogramIndex(value uint64) int {
+	if value < HISTOGRAM_LINEAR {
+		return int(value)
+	}
+	msb := bits.Len64(value) - 1
+	shift := uint(msb - HISTOGRAM_SUB_BITS)
+	sub := int(value>>shift) - HISTOGRAM_SUB_BUCKETS
+	return HISTOGRAM_LINEAR + (msb-HISTOGRAM_SUB_BITS-1)*HISTOGRAM_SUB_BUCKETS + sub
+}
+
+// 桶的代表值，取区间中点
+func histogramValue(idx int) uint64 {
+	if idx < HISTOGRAM_LINEAR {
+		return uint64(idx)
+	}
+	idx -= HISTOGRAM_LINEAR
+	shift := uint(idx/HISTOGRAM_SUB_BUCKETS + 1)
+	sub := uint64(idx%HISTOGRAM_SUB_BUCKETS + HISTOGRAM_SUB_BUCKETS)
+	return sub<<shift + (uint64(1)<<shift)/2
+}
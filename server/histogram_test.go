@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	// 小于64精确记录，之后每个桶的代表值与原值误差不超过3%
+	for _, value := range []uint64{0, 1, 63, 64, 65, 100, 1000, 12345, 1 << 20, 1<<40 + 7} {
+		idx := histogramIndex(value)
+		got := histogramValue(idx)
+		diff := float64(got) - float64(value)
+		if diff < 0 {
+			diff = -diff
+		}
+		if value < HISTOGRAM_LINEAR && got != value || diff > float64(value)*0.03 {
+			t.Errorf("%d: bucket %d value %d", value, idx, got)
+		}
+		if idx > 0 && histogramIndex(value) < histogramIndex(value-1) {
+			t.Errorf("%d: index not monotonic", value)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	histogram := NewHistogram()
+	for i := uint64(1); i <= 10000; i++ {
+		histogram.Add(i)
+	}
+	cases := []struct {
+		percent float64
+		want    uint64
+	}{
+		{percent: 0, want: 1},
+		{percent: 50, want: 5000},
+		{percent: 90, want: 9000},
+		{percent: 99, want: 9900},
+		{percent: 100, want: 10000},
+	}
+	for _, c := range cases {
+		got := histogram.Percentile(c.percent)
+		if float64(got) < float64(c.want)*0.97 || float64(got) > float64(c.want)*1.03 {
+			t.Errorf("p%v = %d, want about %d", c.percent, got, c.want)
+		}
+	}
+
+	summary := histogram.Summary()
+	if summary.Count != 10000 || summary.Min != 1 || summary.Max != 10000 || summary.Avg != 5000 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if empty := NewHistogram().Summary(); !reflect.DeepEqual(*empty, HistogramSummary{}) {
+		t.Errorf("empty summary = %+v", empty)
+	}
+	single := NewHistogram()
+	single.Add(1000)
+	if p := single.Percentile(50); p != 1000 {
+		t.Errorf("single p50 = %d, want 1000", p)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := uint64(0); i < 100; i++ {
+		a.Add(i * 3)
+		b.Add(i * 1000)
+		all.Add(i * 3)
+		all.Add(i * 1000)
+	}
+	merged := NewHistogram()
+	merged.Merge(a)
+	merged.Merge(nil)
+	merged.Merge(NewHistogram())
+	merged.Merge(b)
+	if !reflect.DeepEqual(merged.Summary(), all.Summary()) {
+		t.Errorf("merged %+v, want %+v", merged.Summary(), all.Summary())
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 节点上报的直方图反序列化后可以继续合并
+	decoded := NewHistogram()
+	if err := json.Unmarshal(data, decoded); err != nil || !reflect.DeepEqual(decoded.Summary(), all.Summary()) {
+		t.Errorf("json = %s", data)
+	}
+	decoded.Merge(a)
+	all.Merge(a)
+	// 平均值由上报的avg还原，允许取整误差
+	got, want := decoded.Summary(), all.Summary()
+	got.Avg, want.Avg = 0, 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded merge %+v, want %+v", got, want)
+	}
+}
+
+func TestPhaseHistograms(t *testing.T) {
+	start := time.Now()
+	trace := &httpTrace{
+		dnsStart:     start,
+		dnsDone:      start.Add(2 * time.Millisecond),
+		connectStart: start.Add(2 * time.Millisecond),
+		connectDone:  start.Add(5 * time.Millisecond),
+		gotConn:      start.Add(5 * time.Millisecond),
+		wroteRequest: start.Add(6 * time.Millisecond),
+		firstByte:    start.Add(16 * time.Millisecond),
+	}
+	phases := trace.phases(start.Add(20 * time.Millisecond))
+	want := HttpPhases{Dns: 2000, Connect: 3000, Write: 1000, Wait: 10000, Transfer: 4000,
+		started: map[string]bool{PHASE_DNS: true, PHASE_CONNECT: true, PHASE_WRITE: true, PHASE_WAIT: true, PHASE_TRANSFER: true}}
+	if !reflect.DeepEqual(*phases, want) {
+		t.Errorf("phases = %+v, want %+v", phases, want)
+	}
+	if traceMicroseconds(start, start.Add(-time.Millisecond)) != 0 {
+		t.Error("negative duration should be 0")
+	}
+
+	histograms := NewPhaseHistograms()
+	histograms.add(&Response{Phases: phases})
+	histograms.add(&Response{Phases: phases, ConnReused: true})
+	histograms.add(&Response{})
+	cases := []struct {
+		phase string
+		count uint64
+	}{
+		{PHASE_DNS, 1},
+		{PHASE_CONNECT, 1},
+		{PHASE_TLS, 0}, // 没有tls握手
+		{PHASE_WRITE, 2},
+		{PHASE_WAIT, 2},
+		{PHASE_TRANSFER, 2},
+	}
+	for _, c := range cases {
+		if count := histograms[c.phase].Count(); count != c.count {
+			t.Errorf("%s: count %d, want %d", c.phase, count, c.count)
+		}
+		if got := phases.get(c.phase); histograms[c.phase].Percentile(50) != got {
+			t.Errorf("%s: p50 %d, want %d", c.phase, histograms[c.phase].Percentile(50), got)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"html/template"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	CHART_WIDTH   = 860
+	CHART_HEIGHT  = 240
+	CHART_PADDING = 50 // 左侧与底部坐标轴文字的宽度
+	CHART_TICKS   = 5  // 坐标轴刻度数
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+type chartSeries struct {
+	Name   string
+	Points map[uint64]float64 // 横坐标（秒或分钟）/数值
+}
+
+type htmlTable struct {
+	Title string
+	Head  []string
+	Rows  [][]string
+}
+
+type htmlReportView struct {
+	Id       string
+	Created  string
+	Status   string
+	Summary  [][2]string
+	Warnings []string
+	Charts   []template.HTML
+	Tables   []*htmlTable
+	Config   string
+}
+
+// 任务报告，正在执行或未删除的任务从内存读取，否则读取日志目录中的文件
+func ReportContent(id string) ([]byte, error) {
+	if content := TK.TaskListInfo(id); content != "" {
+		return []byte(content), nil
+	}
+	if strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, errors.New("任务id不正确")
+	}
+	content, err := utils.FileGet(fmt.Sprintf("%s/%s.json", appconfig.GetConfig().Log.Location, id))
+	if err != nil {
+		logger.Debug(err)
+	}
+	if content == "" {
+		return nil, errors.New("报告不存在")
+	}
+	return []byte(content), nil
+}
+
+// 单文件的html报告，图表为内联的svg，不依赖外部资源
+func HtmlReport(id string, content []byte) ([]byte, error) {
+	view := &htmlReportView{Id: id, Created: time.Now().Format("2006-01-02 15:04:05")}
+	if gjson.GetBytes(content, "scriptReport").Exists() {
+		var report ScriptReportList
+		if err := json.Unmarshal(content, &report); err != nil {
+			return nil, err
+		}
+		view.script(&report)
+	} else {
+		var report Report
+		if err := json.Unmarshal(content, &report); err != nil {
+			return nil, err
+		}
+		view.request(&report)
+	}
+
+	var buf bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, view); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (view *htmlReportView) request(report *Report) {
+	view.status(report.Status)
+	total := report.SuccessNum + report.FailureNum
+	view.Summary = append(view.Summary,
+		[2]string{"持续时间", fmt.Sprintf("%ds", report.RequestTime)},
+		[2]string{"并发数", fmt.Sprint(report.ConCurrency)},
+		[2]string{"请求数", fmt.Sprint(total)},
+		[2]string{"成功", fmt.Sprint(report.SuccessNum)},
+		[2]string{"失败", fmt.Sprint(report.FailureNum)},
+		[2]string{"错误率", percentText(report.FailureNum, total)},
+	)
+	if report.RequestTime > 0 {
+		view.Summary = append(view.Summary, [2]string{"每秒成功请求数", fmt.Sprintf("%.1f", float64(report.SuccessNum)/float64(report.RequestTime))})
+	}
+	if report.Latency != nil {
+		summary := report.Latency.Summary()
+		view.Summary = append(view.Summary,
+			[2]string{"平均耗时", fmt.Sprintf("%dms", summary.Avg)},
+			[2]string{"p50 / p95 / p99", fmt.Sprintf("%d / %d / %d ms", summary.P50, summary.P95, summary.P99)},
+			[2]string{"最小 / 最大", fmt.Sprintf("%d / %d ms", summary.Min, summary.Max)},
+		)
+	}
+	view.Summary = append(view.Summary, [2]string{"发送 / 接收", fmt.Sprintf("%s / %s", bytesText(report.BytesSent), bytesText(report.BytesRecv))})
+
+	view.Charts = append(view.Charts, lineChart("吞吐量（每秒请求数）", "秒", []*chartSeries{
+		{Name: "成功", Points: intSeries(report.AverageSuccessReq)},
+		{Name: "失败", Points: intSeries(report.AverageErrorReq)},
+	}))
+	if len(report.LatencySeries) > 0 {
+		p50, p95, p99, avg := &chartSeries{Name: "p50"}, &chartSeries{Name: "p95"}, &chartSeries{Name: "p99"}, &chartSeries{Name: "平均"}
+		for _, series := range []*chartSeries{p50, p95, p99, avg} {
+			series.Points = make(map[uint64]float64)
+		}
+		for second, histogram := range report.LatencySeries {
+			summary := histogram.Summary()
+			p50.Points[second] = float64(summary.P50)
+			p95.Points[second] = float64(summary.P95)
+			p99.Points[second] = float64(summary.P99)
+			avg.Points[second] = float64(summary.Avg)
+		}
+		view.Charts = append(view.Charts, lineChart("耗时百分位（毫秒）", "秒", []*chartSeries{p50, p95, p99, avg}))
+	}
+	view.errors(report.Errors)
+
+	if len(report.Groups) > 0 {
+		table := &htmlTable{Title: "分组", Head: []string{"分组", "成功", "失败", "错误率", "平均(ms)", "最小(ms)", "最大(ms)"}}
+		for _, name := range sortedKeys(report.Groups) {
+			group := report.Groups[name]
+			table.Rows = append(table.Rows, []string{name, fmt.Sprint(group.SuccessNum), fmt.Sprint(group.FailureNum), percentText(group.FailureNum, group.SuccessNum+group.FailureNum), fmt.Sprint(group.AvgTime), fmt.Sprint(group.MinTime), fmt.Sprint(group.MaxTime)})
+		}
+		view.Tables = append(view.Tables, table)
+	}
+	if len(report.Phases) > 0 {
+		table := &htmlTable{Title: "http请求各阶段耗时（微秒）", Head: histogramHead("阶段")}
+		for _, name := range sortedKeys(report.Phases) {
+			table.Rows = append(table.Rows, histogramRow(name, report.Phases[name]))
+		}
+		view.Tables = append(view.Tables, table)
+	}
+	if schedule := report.Schedule; schedule != nil {
+		table := &htmlTable{Title: fmt.Sprintf("按计划发送（%s）", schedule.Executor), Head: histogramHead("耗时")}
+		table.Rows = append(table.Rows,
+			histogramRow("修正后（从计划时间计算）", schedule.Corrected),
+			histogramRow("修正前（从发送时间计算）", schedule.Uncorrected),
+			histogramRow("晚于计划", schedule.Lag),
+		)
+		view.Tables = append(view.Tables, table)
+		if schedule.Dropped > 0 {
+			view.Warnings = append(view.Warnings, fmt.Sprintf("没有空闲协程，放弃了%d个请求", schedule.Dropped))
+		}
+		if schedule.BehindSchedule {
+			view.Warnings = append(view.Warnings, "施压机跟不上计划的发送速率，结果偏乐观")
+		}
+	}
+	if capacity := report.Capacity; capacity != nil {
+		view.capacity(capacity)
+	}
+	view.generator(report.Generator)
+	view.targets(report.Targets)
+	view.thresholds(report.Thresholds)
+	view.baseline(report.Baseline)
+	view.annotations(report.Annotations)
+	view.config(report.Config)
+}
+
+func (view *htmlReportView) script(report *ScriptReportList) {
+	view.status(report.Status)
+	total := report.TotalSuccess + report.TotalError
+	view.Summary = append(view.Summary,
+		[2]string{"事务数", fmt.Sprint(total)},
+		[2]string{"成功", fmt.Sprint(report.TotalSuccess)},
+		[2]string{"失败", fmt.Sprint(report.TotalError)},
+		[2]string{"错误率", percentText(report.TotalError, total)},
+	)
+	view.Charts = append(view.Charts, lineChart("吞吐量（每分钟事务数）", "分钟", []*chartSeries{
+		{Name: "成功", Points: uintSeries(report.AverageSuccess)},
+		{Name: "失败", Points: uintSeries(report.AverageError)},
+	}))
+	view.errors(report.Errors)
+
+	if len(report.Steps) > 0 {
+		table := &htmlTable{Title: "步骤", Head: []string{"步骤", "成功", "失败", "错误率", "平均(ms)", "p50", "p95", "p99", "最大"}}
+		for _, name := range sortedKeys(report.Steps) {
+			step := report.Steps[name]
+			row := []string{name, fmt.Sprint(step.SuccessNum), fmt.Sprint(step.FailureNum), percentText(step.FailureNum, step.SuccessNum+step.FailureNum)}
+			if step.WasteTime != nil {
+				summary := step.WasteTime.Summary()
+				row = append(row, fmt.Sprint(summary.Avg), fmt.Sprint(summary.P50), fmt.Sprint(summary.P95), fmt.Sprint(summary.P99), fmt.Sprint(summary.Max))
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		view.Tables = append(view.Tables, table)
+	}
+	view.generator(report.Generator)
+	view.targets(report.Targets)
+	view.thresholds(report.Thresholds)
+	view.baseline(report.Baseline)
+	view.annotations(report.Annotations)
+	view.config(report.Config)
+}
+
+func (view *htmlReportView) status(finished bool) {
+	view.Status = "执行中"
+	if finished {
+		view.Status = "已完成"
+	}
+}
+
+func (view *htmlReportView) errors(errorReports ErrorReports) {
+	if len(errorReports) == 0 {
+		return
+	}
+	names := sortedKeys(errorReports)
+	values := make([]float64, len(names))
+	table := &htmlTable{Title: "错误信息", Head: []string{"分类", "次数", "错误信息"}}
+	for i, name := range names {
+		errorReport := errorReports[name]
+		values[i] = float64(errorReport.Count)
+		for _, msg := range errorReport.Top(ERROR_MESSAGE_TOP) {
+			table.Rows = append(table.Rows, []string{name, fmt.Sprint(msg.Count), msg.Message})
+		}
+	}
+	view.Charts = append(view.Charts, barChart("错误分类", names, values))
+	view.Tables = append(view.Tables, table)
+}
+
+func (view *htmlReportView) capacity(capacity *CapacityReport) {
+	table := &htmlTable{Title: fmt.Sprintf("容量查找（%s，%s）", capacity.Mode, capacity.Target), Head: []string{capacity.Target, "每秒成功请求数", "错误率", "平均(ms)", "p50", "p95", "p99", "满足SLO", "说明"}}
+	rps := &chartSeries{Name: "每秒成功请求数", Points: make(map[uint64]float64)}
+	p99 := &chartSeries{Name: "p99(ms)", Points: make(map[uint64]float64)}
+	for _, step := range capacity.Curve {
+		mark := ""
+		if capacity.Best != nil && step.Level == capacity.Best.Level {
+			mark = "最大满足SLO "
+		}
+		if capacity.Knee != nil && step.Level == capacity.Knee.Level {
+			mark += "拐点 "
+		}
+		passed := "否"
+		if step.Passed {
+			passed = "是"
+		}
+		table.Rows = append(table.Rows, []string{fmt.Sprint(step.Level), fmt.Sprintf("%.1f", step.Rps), fmt.Sprintf("%.2f%%", step.ErrorRate), fmt.Sprint(step.Avg), fmt.Sprint(step.P50), fmt.Sprint(step.P95), fmt.Sprint(step.P99), passed, mark + step.Reason})
+		rps.Points[step.Level] = step.Rps
+		p99.Points[step.Level] = float64(step.P99)
+	}
+	view.Tables = append(view.Tables, table)
+	view.Charts = append(view.Charts, lineChart("容量曲线", capacity.Target, []*chartSeries{rps, p99}))
+	if capacity.Best != nil {
+		view.Summary = append(view.Summary, [2]string{"满足SLO的最大" + capacity.Target, fmt.Sprint(capacity.Best.Level)})
+	}
+	if capacity.Knee != nil {
+		view.Summary = append(view.Summary, [2]string{"拐点", fmt.Sprint(capacity.Knee.Level)})
+	}
+}
+
+func (view *htmlReportView) generator(generator *GeneratorReport) {
+	if generator == nil || len(generator.Samples) == 0 {
+		return
+	}
+	cpu, sysCpu, sysMem := &chartSeries{Name: "施压进程cpu"}, &chartSeries{Name: "整机cpu"}, &chartSeries{Name: "整机内存"}
+	for _, series := range []*chartSeries{cpu, sysCpu, sysMem} {
+		series.Points = make(map[uint64]float64)
+	}
+	for second, sample := range generator.Samples {
+		cpu.Points[second] = sample.Cpu
+		sysCpu.Points[second] = sample.SysCpu
+		sysMem.Points[second] = sample.SysMem
+	}
+	view.Charts = append(view.Charts, lineChart("施压机负载（%）", "秒", []*chartSeries{cpu, sysCpu, sysMem}))
+	for _, warning := range generator.Warnings {
+		view.Warnings = append(view.Warnings, fmt.Sprintf("第%d秒起共%d秒：%s", warning.First, warning.Seconds, warning.Message))
+	}
+}
+
+func (view *htmlReportView) targets(targets TargetReports) {
+	for _, name := range sortedKeys(targets) {
+		target := targets[name]
+		cpu, mem := &chartSeries{Name: "cpu", Points: make(map[uint64]float64)}, &chartSeries{Name: "内存", Points: make(map[uint64]float64)}
+		for second, sample := range target.Samples {
+			cpu.Points[second] = float64(sample.Cpu)
+			mem.Points[second] = float64(sample.Mem)
+		}
+		view.Charts = append(view.Charts, lineChart(fmt.Sprintf("被测机器 %s 负载（%%，%d核 %dG）", name, target.ServerInfo.Cpu, target.ServerInfo.Mem), "秒", []*chartSeries{cpu, mem}))
+	}
+}
+
+func (view *htmlReportView) thresholds(results []*ThresholdResult) {
+	if len(results) == 0 {
+		return
+	}
+	table := &htmlTable{Title: "阈值", Head: []string{"阈值", "实际值", "结果", "说明"}}
+	for _, result := range results {
+		passed := "通过"
+		if !result.Passed {
+			passed = "未通过"
+			view.Warnings = append(view.Warnings, fmt.Sprintf("阈值未通过：%s", result.Name))
+		}
+		table.Rows = append(table.Rows, []string{result.Name, thresholdNumber(result.Actual), passed, result.Message})
+	}
+	view.Tables = append(view.Tables, table)
+}
+
+func (view *htmlReportView) baseline(comparison *Comparison) {
+	if comparison == nil {
+		return
+	}
+	if comparison.Regressions > 0 {
+		view.Warnings = append(view.Warnings, fmt.Sprintf("与基准%s相比，%d个指标超出容差", comparison.Base, comparison.Regressions))
+	}
+	table := &htmlTable{Title: fmt.Sprintf("与基准%s对比", comparison.Base), Head: []string{"步骤", "指标", "基准", "当前", "变化", "结果"}}
+	for _, diff := range comparison.Diffs {
+		result := ""
+		if diff.Regression {
+			result = "退化"
+		}
+		table.Rows = append(table.Rows, []string{diff.Step, diff.Metric, thresholdNumber(diff.Base), thresholdNumber(diff.Value), diff.changeText(), result})
+	}
+	view.Tables = append(view.Tables, table)
+}
+
+func (view *htmlReportView) annotations(annotations []*Annotation) {
+	if len(annotations) == 0 {
+		return
+	}
+	table := &htmlTable{Title: "执行中的调整", Head: []string{"秒", "时间", "操作", "说明"}}
+	for _, annotation := range annotations {
+		at := time.Unix(0, annotation.At*int64(time.Millisecond)).Format("15:04:05")
+		table.Rows = append(table.Rows, []string{fmt.Sprint(annotation.Time), at, annotation.Action, annotation.Message})
+	}
+	view.Tables = append(view.Tables, table)
+}
+
+func (view *htmlReportView) config(config json.RawMessage) {
+	if len(config) == 0 || string(config) == "null" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, config, "", "  "); err != nil {
+		view.Config = string(config)
+		return
+	}
+	view.Config = buf.String()
+}
+
+// 折线图，横坐标为秒、分钟或并发数
+func lineChart(title string, unit string, list []*chartSeries) template.HTML {
+	var xs []uint64
+	seen := make(map[uint64]bool)
+	var maxY float64
+	for _, series := range list {
+		for x, y := range series.Points {
+			if !seen[x] {
+				seen[x] = true
+				xs = append(xs, x)
+			}
+			maxY = math.Max(maxY, y)
+		}
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i] < xs[j] })
+	if len(xs) == 0 {
+		return ""
+	}
+	maxY = chartCeil(maxY)
+	minX, maxX := float64(xs[0]), float64(xs[len(xs)-1])
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	plotW, plotH := float64(CHART_WIDTH-CHART_PADDING-20), float64(CHART_HEIGHT-CHART_PADDING)
+	px := func(x float64) float64 { return CHART_PADDING + (x-minX)/(maxX-minX)*plotW }
+	py := func(y float64) float64 { return 20 + plotH - y/maxY*(plotH-20) }
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `<figure><figcaption>%s</figcaption><svg viewBox="0 0 %d %d" width="100%%">`, template.HTMLEscapeString(title), CHART_WIDTH, CHART_HEIGHT)
+	for i := 0; i <= CHART_TICKS; i++ {
+		y := maxY * float64(i) / CHART_TICKS
+		fmt.Fprintf(&buf, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" class="grid"/><text x="%d" y="%.1f" class="axis" text-anchor="end">%s</text>`,
+			CHART_PADDING, CHART_WIDTH-20, py(y), py(y), CHART_PADDING-6, py(y)+4, chartNumber(y))
+		x := minX + (maxX-minX)*float64(i)/CHART_TICKS
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%d" class="axis" text-anchor="middle">%s</text>`, px(x), CHART_HEIGHT-CHART_PADDING+18, chartNumber(x))
+	}
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" class="axis" text-anchor="end">%s</text>`, CHART_WIDTH-20, CHART_HEIGHT-CHART_PADDING+36, template.HTMLEscapeString(unit))
+	for i, series := range list {
+		color := chartColors[i%len(chartColors)]
+		points := make([]string, 0, len(series.Points))
+		for _, x := range xs {
+			if y, ok := series.Points[x]; ok {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", px(float64(x)), py(y)))
+			}
+		}
+		fmt.Fprintf(&buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`, strings.Join(points, " "), color)
+		fmt.Fprintf(&buf, `<rect x="%d" y="4" width="10" height="10" fill="%s"/><text x="%d" y="13" class="legend">%s</text>`,
+			CHART_PADDING+i*120, color, CHART_PADDING+i*120+14, template.HTMLEscapeString(series.Name))
+	}
+	buf.WriteString(`</svg></figure>`)
+	return template.HTML(buf.String())
+}
+
+// 横向条形图
+func barChart(title string, names []string, values []float64) template.HTML {
+	var maxV float64
+	for _, v := range values {
+		maxV = math.Max(maxV, v)
+	}
+	if maxV == 0 {
+		maxV = 1
+	}
+	rowH := 24
+	height := len(names)*rowH + 10
+	labelW := 160
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `<figure><figcaption>%s</figcaption><svg viewBox="0 0 %d %d" width="100%%">`, template.HTMLEscapeString(title), CHART_WIDTH, height)
+	for i, name := range names {
+		y := 5 + i*rowH
+		w := values[i] / maxV * float64(CHART_WIDTH-labelW-80)
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" class="axis" text-anchor="end">%s</text><rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/><text x="%.1f" y="%d" class="axis">%s</text>`,
+			labelW-6, y+15, template.HTMLEscapeString(name), labelW, y+3, w, rowH-8, chartColors[3], float64(labelW)+w+6, y+15, chartNumber(values[i]))
+	}
+	buf.WriteString(`</svg></figure>`)
+	return template.HTML(buf.String())
+}
+
+// 坐标轴上限取1、2、5的整数倍
+func chartCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	base := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*base {
+			return m * base
+		}
+	}
+	return 10 * base
+}
+
+func chartNumber(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func intSeries(series map[uint64]int) map[uint64]float64 {
+	points := make(map[uint64]float64, len(series))
+	for k, v := range series {
+		points[k] = float64(v)
+	}
+	return points
+}
+
+func uintSeries(series map[uint64]uint64) map[uint64]float64 {
+	points := make(map[uint64]float64, len(series))
+	for k, v := range series {
+		points[k] = float64(v)
+	}
+	return points
+}
+
+func histogramHead(name string) []string {
+	return []string{name, "次数", "平均", "p50", "p90", "p95", "p99", "最小", "最大"}
+}
+
+func histogramRow(name string, histogram *Histogram) []string {
+	if histogram == nil {
+		return []string{name}
+	}
+	summary := histogram.Summary()
+	return []string{name, fmt.Sprint(summary.Count), fmt.Sprint(summary.Avg), fmt.Sprint(summary.P50), fmt.Sprint(summary.P90), fmt.Sprint(summary.P95), fmt.Sprint(summary.P99), fmt.Sprint(summary.Min), fmt.Sprint(summary.Max)}
+}
+
+func percentText(n uint64, total uint64) string {
+	if total == 0 {
+		return "0.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(n)*100/float64(total))
+}
+
+func bytesText(n uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+// map的key按名称排序
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]*GroupReport:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case PhaseHistograms:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case ErrorReports:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*StepReport:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case TargetReports:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]float64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>insane 压测报告 {{.Id}}</title>
+<style>
+body{font-family:-apple-system,"Segoe UI",Helvetica,Arial,"PingFang SC","Microsoft YaHei",sans-serif;margin:24px auto;max-width:920px;color:#222}
+h1{font-size:22px}h2{font-size:17px;margin-top:28px;border-bottom:1px solid #ddd;padding-bottom:4px}
+table{border-collapse:collapse;width:100%;font-size:13px}th,td{border:1px solid #ddd;padding:4px 8px;text-align:left}th{background:#f5f5f5}
+figure{margin:16px 0}figcaption{font-weight:bold;font-size:14px;margin-bottom:4px}
+.grid{stroke:#eee}.axis{font-size:11px;fill:#666}.legend{font-size:12px;fill:#333}
+.warning{color:#b94a00}pre{background:#f7f7f7;padding:12px;overflow:auto;font-size:12px}
+</style>
+</head>
+<body>
+<h1>insane 压测报告</h1>
+<p>任务：{{.Id}}　状态：{{.Status}}　生成时间：{{.Created}}</p>
+<h2>概览</h2>
+<table>{{range .Summary}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>{{end}}</table>
+{{if .Warnings}}<h2>提示</h2><ul>{{range .Warnings}}<li class="warning">{{.}}</li>{{end}}</ul>{{end}}
+<h2>图表</h2>
+{{range .Charts}}{{.}}{{end}}
+{{range .Tables}}<h2>{{.Title}}</h2>
+<table><tr>{{range .Head}}<th>{{.}}</th>{{end}}</tr>{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</table>
+{{end}}
+{{if .Config}}<h2>任务配置</h2><pre>{{.Config}}</pre>{{end}}
+</body>
+</html>
+`))
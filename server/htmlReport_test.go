@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChartCeil(t *testing.T) {
+	cases := []struct {
+		v    float64
+		want float64
+	}{
+		{v: 0, want: 1},
+		{v: -3, want: 1},
+		{v: 0.3, want: 0.5},
+		{v: 1, want: 1},
+		{v: 13, want: 20},
+		{v: 320, want: 500},
+		{v: 501, want: 1000},
+	}
+	for _, c := range cases {
+		if got := chartCeil(c.v); got != c.want {
+			t.Errorf("chartCeil(%v) = %v, want %v", c.v, got, c.want)
+		}
+	}
+}
+
+func TestReportText(t *testing.T) {
+	cases := []struct {
+		got  string
+		want string
+	}{
+		{got: chartNumber(3), want: "3"},
+		{got: chartNumber(2.25), want: "2.2"},
+		{got: percentText(1, 0), want: "0.00%"},
+		{got: percentText(1, 3), want: "33.33%"},
+		{got: bytesText(1000), want: "1000.0B"},
+		{got: bytesText(1536), want: "1.5KB"},
+		{got: bytesText(3 << 30), want: "3.0GB"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s, want %s", c.got, c.want)
+		}
+	}
+}
+
+func TestLineChart(t *testing.T) {
+	if chart := lineChart("empty", "秒", []*chartSeries{{Name: "a"}}); chart != "" {
+		t.Errorf("empty chart = %s", chart)
+	}
+	chart := string(lineChart("<rps>", "秒", []*chartSeries{
+		{Name: "成功", Points: map[uint64]float64{1: 10, 2: 30, 3: 20}},
+		{Name: "失败", Points: map[uint64]float64{2: 1}},
+	}))
+	if strings.Count(chart, "<polyline") != 2 || !strings.Contains(chart, "&lt;rps&gt;") || strings.Contains(chart, "<rps>") {
+		t.Errorf("chart = %s", chart)
+	}
+	// 纵坐标上限取50，30在五分之三的高度
+	if !strings.Contains(chart, ">50</text>") || !strings.Contains(chart, "445.0,108.0") {
+		t.Errorf("axis = %s", chart)
+	}
+}
+
+func TestHtmlReport(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+		err     bool
+	}{
+		{
+			name:    "请求报告",
+			content: `{"status":true,"requestTime":2,"successNum":9,"failureNum":1,"averageSuccessReq":{"1":4,"2":5},"groups":{"/a":{"successNum":9}},"config":{"url":"<x>"}}`,
+			want:    []string{"已完成", "10.00%", "4.5", "/a", "&#34;&lt;x&gt;&#34;", "<svg"},
+		},
+		{
+			name:    "脚本报告",
+			content: `{"scriptReport":{},"totalSuccess":3,"totalError":1,"averageSuccess":{"1":3},"steps":{"login":{"successNum":3}}}`,
+			want:    []string{"事务数", "25.00%", "login", "每分钟事务数"},
+		},
+		{name: "格式错误", content: `{"successNum":"x"}`, err: true},
+	}
+	for _, c := range cases {
+		html, err := HtmlReport("t1", []byte(c.content))
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v", c.name, err)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(string(html), want) {
+				t.Errorf("%s: missing %s", c.name, want)
+			}
+		}
+	}
+}
@@ -1,19 +1,20 @@
 package server
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/tidwall/gjson"
-	"insane/constant"
-	"insane/general/base/appconfig"
 	"insane/utils"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"strings"
@@ -31,22 +32,37 @@ type HttpRequest struct {
 	Cookie       string            `json:"cookie"`
 	Header       map[string]string `json:"header"`
 	HttpBody     *HttpBody         `json:"body"`
+	BodyType     string            `json:"bodyType"` // 请求体格式：空（body字段）|raw|multipart|binary
+	RawBody      string            `json:"rawBody"`  // raw格式的请求体模板，{{字段名}}替换为body中同名字段的值
+	BodyFile     string            `json:"bodyFile"` // binary格式发送的上传文件
 	HttpResponse map[string]string `json:"-"`
 	ReadResponse bool              `json:"-"`
-	client       *http.Client      `json:"-"`
+	Protocol     string            `json:"protocol"`    // http1|h2|h2c
+	KeepAlive    bool              `json:"keepAlive"`   // HTTP/1.1是否复用连接
+	Connections  uint64            `json:"connections"` // HTTP/2连接数，请求在连接上多路复用
+	Timeout      uint64            `json:"timeout"`     // 超时时间（毫秒），默认5秒，长轮询需要调大
+	Stream       bool              `json:"stream"`      // 流式读取响应，统计首个数据到达时间
+	Group        string            `json:"-"`           // 统计分组，回放时为路径模板
+	keepBody     bool              // 响应内容放入Response.Data，只在校验脚本时使用
+	pacer        *pacer            // 按计划时间发送，见schedule.go
+	clients      map[string]*httpClientPool
+	clientM      sync.Mutex
 }
 
 type HttpBody struct {
 	Body         []*BodyField             `json:"body"`
 	BodyFileData map[string]*BodyFileData `json:"-"`
+	UploadData   map[string][]byte        `json:"-"` // 上传文件内容缓存
+	m            sync.Mutex
 }
 
 type BodyField struct {
-	Name    string      `json:"name"`
-	Type    string      `json:"type"` // int|string
-	Len     int64       `json:"len"`
-	Default interface{} `json:"default"`
-	Dynamic string      `json:"dynamic"`
+	Name     string       `json:"name"`
+	Type     string       `json:"type"` // int|string|bool|enum|object|array|file|upload|response
+	Len      int64        `json:"len"`  // array为元素个数
+	Default  interface{}  `json:"default"`
+	Dynamic  string       `json:"dynamic"`  // enum为逗号分隔的可选值
+	Children []*BodyField `json:"children"` // object的属性，array的元素
 }
 
 type BodyFileData struct {
@@ -56,21 +72,13 @@ type BodyFileData struct {
 }
 
 func GenerateHttpRequest(ReadResponse bool) *HttpRequest {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-		MaxIdleConnsPerHost: appconfig.GetConfig().Http.MaxIdleConnsPerHost,
-		DisableCompression:  false,
-		DisableKeepAlives:   true,
-	}
 	return &HttpRequest{
-		// Timeout: 10 * time.Second 连接超时是等待响应之后判断请求消耗时间来决定是否超时，暂时没找到解决方案
-		// 临时解决：在每个协程里面增加一个定时器，如果超时，直接丢弃该协程，开启下一个协程进行Http请求
-		client: &http.Client{Transport: tr},
+		// 超时时间通过每次请求的context设置，见HttpSend
+		clients: make(map[string]*httpClientPool),
 		HttpBody: &HttpBody{
 			Body:         make([]*BodyField, 0),
 			BodyFileData: make(map[string]*BodyFileData),
+			UploadData:   make(map[string][]byte),
 		},
 		HttpResponse: make(map[string]string),
 		ReadResponse: ReadResponse,
@@ -82,108 +90,149 @@ func (httpRequest *HttpRequest) Parse(data gjson.Result) {
 	httpRequest.Url = data.Get("url").String()
 	httpRequest.Method = data.Get("method").String()
 	httpRequest.Cookie = data.Get("cookie").String()
+	httpRequest.Header = nil // 脚本中复用同一个HttpRequest，避免上一步的header残留
 	json.Unmarshal([]byte(data.Get("header").String()), &httpRequest.Header)
 	json.Unmarshal([]byte(data.Get("body").String()), &httpRequest.HttpBody.Body)
+	httpRequest.BodyType = data.Get("bodyType").String()
+	httpRequest.RawBody = data.Get("rawBody").String()
+	httpRequest.BodyFile = data.Get("bodyFile").String()
+	httpRequest.Protocol = data.Get("protocol").String()
+	httpRequest.KeepAlive = data.Get("keepAlive").Bool()
+	httpRequest.Connections = data.Get("connections").Uint()
+	httpRequest.Timeout = data.Get("timeout").Uint()
+	httpRequest.Stream = data.Get("stream").Bool()
+}
+
+// 输出与Parse一致的结构，导入生成的请求可以直接提交
+func (httpRequest *HttpRequest) MarshalJSON() ([]byte, error) {
+	type httpRequestAlias HttpRequest
+	return json.Marshal(&struct {
+		*httpRequestAlias
+		Body []*BodyField `json:"body"`
+	}{
+		httpRequestAlias: (*httpRequestAlias)(httpRequest),
+		Body:             httpRequest.HttpBody.Body,
+	})
 }
 
 func (httpRequest *HttpRequest) Run(serial uint64, ch chan<- *Response, wg *sync.WaitGroup, stopCh <-chan int) {
-	sentCh := make(chan bool)
+	slot := httpRequest.pacer.slot(serial)
 	for {
-		select {
-		case <-stopCh:
+		lag, ok := slot.wait(stopCh)
+		if !ok {
 			logger.Debug(fmt.Sprintf("%d号协程关闭", serial))
-			close(sentCh)
 			wg.Done()
 			return
-		default:
-			go httpRequest.HttpSend(ch, sentCh)
-			<-sentCh
 		}
+		resp := httpRequest.send()
+		lag.set(resp)
+		httpSendRespCh(ch, resp)
 	}
 }
 
 func (httpRequest *HttpRequest) HttpSend(respCh chan<- *Response, sentCh chan bool) {
-	var (
-		status    = false
-		isSuccess = false
-		errCode   = http.StatusOK
-		errMsg    = ""
-		respData  = make([]byte, 0)
-		start     = utils.Now()
-	)
-	resp := new(Response)
-	go func() {
-		t := time.NewTicker(HTTP_RESPONSE_TIMEOUT)
-		<-t.C
-		if status == false {
-			end := utils.Now()
-			resp.ErrCode = constant.ERROR_REQUEST_TIMEOUT
-			resp.ErrMsg = fmt.Sprintf("默认超时时间%ds, 请重试", HTTP_RESPONSE_TIMEOUT/1000000000)
-			resp.IsSuccess = isSuccess
-			resp.WasteTime = uint64(end - start)
-			resp.Data = respData
-
-			if err := recover(); err != nil {
-				logger.Debug(err)
-				resp.ErrMsg = err.(error).Error()
-			}
-			httpSendSentCh(sentCh)
-			httpSendRespCh(respCh, resp)
-		}
-	}()
-	defer func() {
-		status = true
-		end := utils.Now()
-		resp.ErrCode = errCode
-		resp.ErrMsg = errMsg
-		resp.IsSuccess = isSuccess
-		resp.WasteTime = uint64(end - start)
-		resp.Data = string(respData)
+	resp := httpRequest.send()
+	httpSendSentCh(sentCh)
+	httpSendRespCh(respCh, resp)
+}
 
+func (httpRequest *HttpRequest) send() (resp *Response) {
+	start := utils.Now()
+	resp = &Response{Group: httpRequest.Group}
+	defer func() {
 		if err := recover(); err != nil {
-			logger.Debug(err)
-			resp.ErrMsg = err.(error).Error()
+			resp.setPanic(err)
 		}
-		httpSendSentCh(sentCh)
-		httpSendRespCh(respCh, resp)
+		resp.WasteTime = uint64(utils.Now() - start)
 	}()
 
 	req, err := httpRequest.getRequest()
 	if err != nil {
-		resp.ErrCode = constant.ERROR_REQUEST_CREATED // 创建连接失败
-		resp.ErrMsg = err.Error()
+		resp.setErrorType(ERR_TYPE_REQUEST, err.Error()) // 创建请求失败
 		return
 	}
 
-	rp, err := httpRequest.client.Do(req)
+	// 超时由请求的context控制，超时后连接和响应读取都会被取消
+	ctx, cancel := context.WithTimeout(req.Context(), httpRequest.timeout())
+	defer cancel()
+	trace := newHttpTrace()
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+	resp.BytesSent = requestSize(req)
+	rp, err := httpRequest.getClient().Do(req)
 	if err != nil {
-		resp.ErrCode = constant.ERROR_REQUEST_CONNECTION // 连接失败
-		resp.ErrMsg = err.Error()
+		httpRequest.setError(ctx, trace, resp, err)
 		return
 	}
+	resp.Proto = rp.Proto
+	resp.ConnReused = trace.reused
+	resp.BytesRecv = responseHeaderSize(rp)
+
+	if httpRequest.Stream {
+		httpRequest.verifyStream(ctx, trace, rp, resp, start)
+	} else {
+		httpRequest.verify(ctx, trace, rp, resp)
+	}
+	if !trace.firstByte.IsZero() {
+		end := time.Now()
+		resp.FirstByteTime = uint64(trace.firstByte.Sub(trace.start) / time.Millisecond)
+		resp.DownloadTime = uint64(end.Sub(trace.firstByte) / time.Millisecond)
+		resp.Phases = trace.phases(end)
+	}
+	return
+}
 
-	isSuccess, errCode, respData, errMsg = httpRequest.verify(rp)
+// 请求失败时按错误类型分类，超时按发生的阶段细分
+func (httpRequest *HttpRequest) setError(ctx context.Context, trace *httpTrace, resp *Response, err error) {
+	if ctx.Err() == context.DeadlineExceeded {
+		resp.setErrorType(ERR_TYPE_TIMEOUT+"."+trace.timeoutPhase(), fmt.Sprintf("超时时间%dms, 请重试", httpRequest.timeout()/time.Millisecond))
+		return
+	}
+	resp.setError(err, trace.timeoutPhase())
 }
 
-func (httpRequest *HttpRequest) verify(resp *http.Response) (isSuccess bool, code int, respData []byte, msg string) {
-	defer resp.Body.Close()
-	// 是否读取响应内容
-	if httpRequest.ReadResponse {
-		respData, _ = ioutil.ReadAll(resp.Body)
-		key := httpRequest.Name
-		if key == "" {
-			key = httpRequest.Url
-		}
-		httpRequest.HttpResponse[key] = string(respData)
+func (httpRequest *HttpRequest) timeout() time.Duration {
+	if httpRequest.Timeout == 0 {
+		return HTTP_RESPONSE_TIMEOUT
 	}
+	return time.Duration(httpRequest.Timeout) * time.Millisecond
+}
+
+// 需要提取响应字段（ReadResponse）或返回响应内容（keepBody）时缓存响应体，否则边读边丢弃，只统计字节数
+func (httpRequest *HttpRequest) verify(ctx context.Context, trace *httpTrace, rp *http.Response, resp *Response) {
+	defer rp.Body.Close()
+	resp.ErrCode = rp.StatusCode
+	resp.ErrMsg = rp.Status
 
-	code = resp.StatusCode
-	msg = resp.Status
-	if code == http.StatusOK {
-		isSuccess = true
+	if httpRequest.ReadResponse || httpRequest.keepBody {
+		respData, err := ioutil.ReadAll(rp.Body)
+		resp.BytesRecv += uint64(len(respData))
+		if err != nil {
+			httpRequest.setError(ctx, trace, resp, err)
+			return
+		}
+		if httpRequest.ReadResponse {
+			key := httpRequest.Name
+			if key == "" {
+				key = httpRequest.Url
+			}
+			httpRequest.HttpResponse[key] = string(respData)
+		}
+		if httpRequest.keepBody {
+			resp.Data = string(respData)
+		}
+	} else {
+		n, err := io.Copy(ioutil.Discard, rp.Body)
+		resp.BytesRecv += uint64(n)
+		if err != nil {
+			httpRequest.setError(ctx, trace, resp, err)
+			return
+		}
+	}
+	if rp.StatusCode != http.StatusOK {
+		resp.setStatusError(rp.StatusCode, rp.Status)
 		return
 	}
-	return
+	resp.IsSuccess = true
 }
 
 func httpSendSentCh(sentCh chan bool) {
@@ -205,30 +254,35 @@ func httpSendRespCh(respCh chan<- *Response, response *Response) {
 }
 
 func (request *HttpRequest) getRequest() (req *http.Request, err error) {
-	body := request.getBody()
+	body, contentType, err := request.getBody()
+	if err != nil {
+		return nil, err
+	}
 	req, err = http.NewRequest(request.Method, request.Url, body)
-	setHeader(request.Header, req)
-	setCookie(request.Cookie, req)
 	if err != nil {
 		return nil, err
 	}
+	setHeader(request.Header, contentType, req)
+	setCookie(request.Cookie, req)
 	return req, nil
 }
 
-func setHeader(header map[string]string, req *http.Request) {
-	// default content-type:application/json
-	req.Header.Add("Content-Type", "application/json")
+// Content-Type只设置一次，以请求体实际格式为准
+func setHeader(header map[string]string, contentType string, req *http.Request) {
 	for k, v := range header {
-		if k != "" && v != "" {
+		if k != "" && v != "" && !strings.EqualFold(k, "content-type") {
 			req.Header.Add(k, v)
 		}
 	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 }
 
 func setCookie(ck string, req *http.Request) {
 	cookies := strings.Split(ck, "; ")
 	for _, v := range cookies {
-		s := strings.Split(v, "=")
+		s := strings.SplitN(v, "=", 2)
 		if len(s) > 1 {
 			httpCk := http.Cookie{Name: s[0], Value: s[1]}
 			req.AddCookie(&httpCk)
@@ -236,28 +290,60 @@ func setCookie(ck string, req *http.Request) {
 	}
 }
 
-func (request *HttpRequest) getBody() io.Reader {
-	var body string
-	switch request.Header["content-type"] {
+// header中设置的Content-Type，不区分大小写
+func (request *HttpRequest) contentType() string {
+	for k, v := range request.Header {
+		if strings.EqualFold(k, "content-type") {
+			return v
+		}
+	}
+	return ""
+}
+
+func (request *HttpRequest) getBody() (body io.Reader, contentType string, err error) {
+	contentType = request.contentType()
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch request.BodyType {
+	case BODY_TYPE_RAW:
+		raw := request.createRawBody()
+		if contentType == "" {
+			contentType = detectContentType(raw)
+		}
+		return strings.NewReader(raw), contentType, nil
+	case BODY_TYPE_MULTIPART:
+		return request.createMultipartBody()
+	case BODY_TYPE_BINARY:
+		data, err := request.HttpBody.uploadFile(request.BodyFile)
+		if err != nil {
+			return nil, "", request.getErrorMsg(err.Error())
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return bytes.NewReader(data), contentType, nil
+	}
+
+	// 字段格式：没有字段时不发送请求体
+	if len(request.HttpBody.Body) == 0 {
+		return nil, contentType, nil
+	}
+	switch mediaType {
 	case "application/x-www-form-urlencoded":
-		body = request.createFormBody()
-	case "application/json":
-		body = request.createJsonBody()
-	default:
-		body = request.createJsonBody()
+		return strings.NewReader(request.createFormBody()), contentType, nil
+	case "multipart/form-data":
+		return request.createMultipartBody()
+	}
+	if contentType == "" {
+		contentType = "application/json"
 	}
-	logger.Info("http send body: ", body)
-	return strings.NewReader(body)
+	return strings.NewReader(request.createJsonBody()), contentType, nil
 }
 
 func (request *HttpRequest) createJsonBody() string {
 	body := make(map[string]interface{})
 	for _, v := range request.HttpBody.Body {
-		if v.Default == nil || v.Default == "" {
-			body[v.Name] = request.getBodyValue(v)
-		} else {
-			body[v.Name] = v.Default
-		}
+		body[v.Name] = request.getFieldValue(v)
 	}
 	s, err := json.Marshal(body)
 	if err != nil {
@@ -269,21 +355,33 @@ func (request *HttpRequest) createJsonBody() string {
 func (request *HttpRequest) createFormBody() string {
 	body := url.Values{}
 	for _, v := range request.HttpBody.Body {
-		if v.Default == nil || v.Default == "" {
-			body.Set(v.Name, utils.ConvString(request.getBodyValue(v)))
-		} else {
-			body.Set(v.Name, utils.ConvString(v.Default))
-		}
+		body.Set(v.Name, utils.ConvString(request.getFieldValue(v)))
 	}
 	return body.Encode()
 }
 
+// 字段值：有默认值时使用默认值，否则按类型生成
+func (request *HttpRequest) getFieldValue(bodyField *BodyField) interface{} {
+	if bodyField.Default == nil || bodyField.Default == "" {
+		return request.getBodyValue(bodyField)
+	}
+	return bodyField.Default
+}
+
 func (request *HttpRequest) getBodyValue(bodyField *BodyField) (val interface{}) {
 	switch bodyField.Type {
 	case "int":
 		val = utils.GetRandomintegers(bodyField.Len)
 	case "string":
 		val = utils.GetRandomStrings(bodyField.Len)
+	case "bool":
+		val = utils.GetRandomBool()
+	case "enum":
+		val = utils.GetRandomItem(strings.Split(bodyField.Dynamic, ","))
+	case "object":
+		val = request.getObjectValue(bodyField.Children)
+	case "array":
+		val = request.getArrayValue(bodyField)
 	case "file":
 		val = request.getFileValue(bodyField.Dynamic)
 	case "response":
@@ -294,9 +392,10 @@ func (request *HttpRequest) getBodyValue(bodyField *BodyField) (val interface{})
 	return
 }
 
+// 数据文件按行轮流取值，共用请求体的协程加锁后共用读取位置
 func (request *HttpRequest) getFileValue(fileInfo string) (val interface{}) {
 
-	info := strings.Split(fileInfo, "---")
+	info := strings.Split(fileInfo, HTTP_RESPONSE_FIELD_SEP)
 	if len(info) != 2 {
 		panic(request.getErrorMsg("文件信息获取失败"))
 	}
@@ -308,48 +407,107 @@ func (request *HttpRequest) getFileValue(fileInfo string) (val interface{}) {
 		panic(request.getErrorMsg("文件名不能为空"))
 	}
 
-	fileData, ok := request.HttpBody.BodyFileData[fileName]
+	httpBody := request.HttpBody
+	httpBody.m.Lock()
+	defer httpBody.m.Unlock()
+	fileData, ok := httpBody.BodyFileData[fileName]
 	if !ok {
-		file, err := os.Open(fmt.Sprintf("./%s/%s", appconfig.GetConfig().File.UploadPath, fileName))
-		if err != nil {
-			panic(request.getErrorMsg(err.Error()))
-		}
-		csv := csv.NewReader(file)
-		csvData, err := csv.ReadAll()
-		if err != nil {
+		var err error
+		if fileData, err = loadFileData(fileName); err != nil {
 			panic(request.getErrorMsg(err.Error()))
 		}
-		fileData = &BodyFileData{
-			Index:  0,
-			Column: csvData[0],
-			Data:   csvData[1:],
-		}
+		httpBody.BodyFileData[fileName] = fileData
 	}
 
-	n := -1
-	for k, v := range fileData.Column {
-		if v == field {
-			n = k
-		}
-	}
+	n := fileData.column(field)
 	if n == -1 {
 		panic(request.getErrorMsg(fmt.Sprintf("%s字段不存在数据文件中", field)))
 	}
 
-	if fileData.Index == uint64(len(fileData.Data)) {
+	if fileData.Index >= uint64(len(fileData.Data)) {
 		fileData.Index = 0
 	}
+	row := fileData.Data[fileData.Index]
 	fileData.Index += 1
+	if n >= len(row) {
+		panic(request.getErrorMsg(fmt.Sprintf("数据文件第%d行没有%s字段", fileData.Index, field)))
+	}
+	return row[n]
+}
 
-	request.HttpBody.BodyFileData[fileName] = fileData
-	return fileData.Data[fileData.Index][n]
+// 读取上传的csv文件，第一行为列名，至少需要一行数据
+func loadFileData(fileName string) (*BodyFileData, error) {
+	file, err := os.Open(uploadFilePath(fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	csvData, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(csvData) < 2 {
+		return nil, fmt.Errorf("数据文件%s没有数据", fileName)
+	}
+	return &BodyFileData{
+		Index:  0,
+		Column: csvData[0],
+		Data:   csvData[1:],
+	}, nil
+}
+
+func (fileData *BodyFileData) column(field string) int {
+	for k, v := range fileData.Column {
+		if v == field {
+			return k
+		}
+	}
+	return -1
+}
+
+// 检查字段引用的数据文件和列，读取的数据缓存后执行时直接使用
+// 只有脚本步骤之间可以引用上一步的响应，allowResponse为false时不允许response字段
+func (httpBody *HttpBody) verifyFields(fields []*BodyField, allowResponse bool) error {
+	for _, field := range fields {
+		switch field.Type {
+		case "response":
+			if !allowResponse {
+				return fmt.Errorf("字段%s：只有脚本步骤可以使用response字段", field.Name)
+			}
+		case "file":
+			info := strings.Split(field.Dynamic, HTTP_RESPONSE_FIELD_SEP)
+			if len(info) != 2 || info[0] == "" || info[1] == "" {
+				return fmt.Errorf("字段%s的数据文件格式为 文件---列", field.Name)
+			}
+			httpBody.m.Lock()
+			fileData, ok := httpBody.BodyFileData[info[0]]
+			if !ok {
+				var err error
+				if fileData, err = loadFileData(info[0]); err != nil {
+					httpBody.m.Unlock()
+					return fmt.Errorf("字段%s：%s", field.Name, err)
+				}
+				httpBody.BodyFileData[info[0]] = fileData
+			}
+			httpBody.m.Unlock()
+			if fileData.column(info[1]) == -1 {
+				return fmt.Errorf("字段%s：数据文件%s中没有%s列", field.Name, info[0], info[1])
+			}
+		}
+		if err := httpBody.verifyFields(field.Children, allowResponse); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (request *HttpRequest) getResponseValue(field string) (val interface{}) {
 	fieldArr := strings.Split(field, HTTP_RESPONSE_FIELD_SEP)
 	respData, ok := request.HttpResponse[fieldArr[0]]
 	if len(fieldArr) <= 1 || !ok {
-		panic(request.getErrorMsg("解析Response字段失败"))
+		panic(&extractionError{request.getErrorMsg("解析Response字段失败")})
 	}
 
 	jsonStr := ""
@@ -361,7 +519,7 @@ func (request *HttpRequest) getResponseValue(field string) (val interface{}) {
 	// 解析json数据
 	value := gjson.Get(respData, jsonStr)
 	if !value.Exists() {
-		panic(request.getErrorMsg(fmt.Sprintf("%s没有%s字段", request.Name, jsonStr)))
+		panic(&extractionError{request.getErrorMsg(fmt.Sprintf("%s没有%s字段", request.Name, jsonStr))})
 	}
 	return value.String()
 }
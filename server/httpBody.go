@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"insane/utils"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	BODY_TYPE_RAW       = "raw"
+	BODY_TYPE_MULTIPART = "multipart"
+	BODY_TYPE_BINARY    = "binary"
+)
+
+var rawBodyVarRegexp = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// raw请求体：模板中的{{字段名}}替换为同名body字段的值
+func (request *HttpRequest) createRawBody() string {
+	fields := make(map[string]*BodyField)
+	for _, v := range request.HttpBody.Body {
+		fields[v.Name] = v
+	}
+	return rawBodyVarRegexp.ReplaceAllStringFunc(request.RawBody, func(s string) string {
+		name := rawBodyVarRegexp.FindStringSubmatch(s)[1]
+		field, ok := fields[name]
+		if !ok {
+			return s
+		}
+		return utils.ConvString(request.getFieldValue(field))
+	})
+}
+
+// multipart请求体，upload类型的字段作为文件发送
+func (request *HttpRequest) createMultipartBody() (body io.Reader, contentType string, err error) {
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+	for _, v := range request.HttpBody.Body {
+		if v.Type != "upload" {
+			if err = writer.WriteField(v.Name, utils.ConvString(request.getFieldValue(v))); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		data, err := request.HttpBody.uploadFile(v.Dynamic)
+		if err != nil {
+			return nil, "", request.getErrorMsg(err.Error())
+		}
+		partType := mime.TypeByExtension(filepath.Ext(v.Dynamic))
+		if partType == "" {
+			partType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(v.Name), escapeQuotes(v.Dynamic)))
+		header.Set("Content-Type", partType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = part.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+	// boundary由writer生成，不能使用header中设置的值
+	return buf, writer.FormDataContentType(), nil
+}
+
+func (request *HttpRequest) getObjectValue(children []*BodyField) map[string]interface{} {
+	obj := make(map[string]interface{})
+	for _, v := range children {
+		obj[v.Name] = request.getFieldValue(v)
+	}
+	return obj
+}
+
+// 数组：生成len个元素，只有一个无名子字段时元素为该字段的值，否则为对象
+func (request *HttpRequest) getArrayValue(bodyField *BodyField) []interface{} {
+	n := bodyField.Len
+	if n <= 0 {
+		n = 1
+	}
+	arr := make([]interface{}, 0, n)
+	for i := int64(0); i < n; i++ {
+		if len(bodyField.Children) == 1 && bodyField.Children[0].Name == "" {
+			arr = append(arr, request.getFieldValue(bodyField.Children[0]))
+		} else {
+			arr = append(arr, request.getObjectValue(bodyField.Children))
+		}
+	}
+	return arr
+}
+
+// 读取上传目录中的文件，读取后缓存
+func (httpBody *HttpBody) uploadFile(fileName string) ([]byte, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("文件名不能为空")
+	}
+	httpBody.m.Lock()
+	defer httpBody.m.Unlock()
+	if data, ok := httpBody.UploadData[fileName]; ok {
+		return data, nil
+	}
+	data, err := ioutil.ReadFile(uploadFilePath(fileName))
+	if err != nil {
+		return nil, err
+	}
+	httpBody.UploadData[fileName] = data
+	return data, nil
+}
+
+// 未指定Content-Type时按内容判断
+func detectContentType(body string) string {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case trimmed == "":
+		return "text/plain; charset=utf-8"
+	case gjson.Valid(trimmed):
+		return "application/json"
+	case strings.HasPrefix(trimmed, "<"):
+		return "application/xml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
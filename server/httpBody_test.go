@@ -0,0 +1,145 @@
+package server
+
+import (
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHttpRequestGetBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	appconfig.GetConfig().File.UploadPath = dir
+	defer func() { appconfig.GetConfig().File.UploadPath = uploadPath }()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.bin"), []byte("\x00bin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nested := []*BodyField{
+		{Name: "user", Type: "object", Children: []*BodyField{{Name: "name", Type: "string", Default: "tom"}}},
+		{Name: "ids", Type: "array", Len: 2, Children: []*BodyField{{Type: "string", Default: "x"}}},
+	}
+	cases := []struct {
+		name        string
+		header      map[string]string
+		bodyType    string
+		rawBody     string
+		bodyFile    string
+		body        []*BodyField
+		contentType string
+		want        string
+		err         bool
+	}{
+		{name: "无字段不发送", want: ""},
+		{name: "嵌套json", body: nested, contentType: "application/json", want: `{"ids":["x","x"],"user":{"name":"tom"}}`},
+		{
+			name:        "表单",
+			header:      map[string]string{"content-type": "application/x-www-form-urlencoded"},
+			body:        []*BodyField{{Name: "a", Type: "string", Default: "1 2"}},
+			contentType: "application/x-www-form-urlencoded",
+			want:        "a=1+2",
+		},
+		{
+			name:        "raw模板",
+			bodyType:    BODY_TYPE_RAW,
+			rawBody:     `{"id":"{{ id }}","x":"{{missing}}"}`,
+			body:        []*BodyField{{Name: "id", Type: "string", Default: "7"}},
+			contentType: "application/json",
+			want:        `{"id":"7","x":"{{missing}}"}`,
+		},
+		{name: "raw xml", bodyType: BODY_TYPE_RAW, rawBody: " <a/>", contentType: "application/xml", want: " <a/>"},
+		{name: "raw文本", bodyType: BODY_TYPE_RAW, rawBody: "hi", contentType: "text/plain; charset=utf-8", want: "hi"},
+		{
+			name:        "raw使用header",
+			header:      map[string]string{"Content-Type": "text/csv"},
+			bodyType:    BODY_TYPE_RAW,
+			rawBody:     "a,b",
+			contentType: "text/csv",
+			want:        "a,b",
+		},
+		{name: "二进制", bodyType: BODY_TYPE_BINARY, bodyFile: "a.bin", contentType: "application/octet-stream", want: "\x00bin"},
+		{name: "二进制文件不存在", bodyType: BODY_TYPE_BINARY, bodyFile: "b.bin", err: true},
+		{name: "二进制未指定文件", bodyType: BODY_TYPE_BINARY, err: true},
+	}
+	for _, c := range cases {
+		request := GenerateHttpRequest(false)
+		request.Header = c.header
+		request.BodyType = c.bodyType
+		request.RawBody = c.rawBody
+		request.BodyFile = c.bodyFile
+		request.HttpBody.Body = c.body
+		body, contentType, err := request.getBody()
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var data []byte
+		if body != nil {
+			data, _ = ioutil.ReadAll(body)
+		}
+		if string(data) != c.want || contentType != c.contentType {
+			t.Errorf("%s: %q %q, want %q %q", c.name, data, contentType, c.want, c.contentType)
+		}
+	}
+}
+
+func TestHttpRequestMultipartBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	appconfig.GetConfig().File.UploadPath = dir
+	defer func() { appconfig.GetConfig().File.UploadPath = uploadPath }()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	request := GenerateHttpRequest(false)
+	// header中的boundary不可用，需要使用生成的Content-Type
+	request.Header = map[string]string{"Content-Type": "multipart/form-data; boundary=x"}
+	request.HttpBody.Body = []*BodyField{
+		{Name: "n", Type: "string", Default: "1"},
+		{Name: "f", Type: "upload", Dynamic: "../a.png"},
+	}
+	body, contentType, err := request.getBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "x" {
+		t.Fatalf("content-type = %s", contentType)
+	}
+	form, err := multipart.NewReader(body, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(form.Value["n"], ",") != "1" || len(form.File["f"]) != 1 {
+		t.Fatalf("form = %v %v", form.Value, form.File)
+	}
+	file := form.File["f"][0]
+	if filepath.Base(file.Filename) != "a.png" {
+		t.Errorf("filename = %s", file.Filename)
+	}
+	if file.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("part content-type = %s", file.Header.Get("Content-Type"))
+	}
+
+	request.HttpBody.Body = []*BodyField{{Name: "f", Type: "upload", Dynamic: "none.png"}}
+	if _, _, err := request.getBody(); err == nil {
+		t.Error("missing upload file: want error")
+	}
+}
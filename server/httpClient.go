@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http2"
+	"insane/general/base/appconfig"
+	"net"
+	"net/http"
+)
+
+const (
+	PROTOCOL_HTTP1 = "http1" // HTTP/1.1，默认
+	PROTOCOL_H2    = "h2"    // 基于TLS的HTTP/2
+	PROTOCOL_H2C   = "h2c"   // 明文HTTP/2（prior knowledge）
+)
+
+// 同一协议的client，HTTP/2时每个client对应一条连接，请求轮流分配到各个连接上复用
+type httpClientPool struct {
+	clients []*http.Client
+	next    uint64
+}
+
+func (httpRequest *HttpRequest) VerifyProtocol() error {
+	switch httpRequest.Protocol {
+	case "", PROTOCOL_HTTP1, PROTOCOL_H2, PROTOCOL_H2C:
+		return nil
+	}
+	return fmt.Errorf("protocol必须是%s | %s | %s", PROTOCOL_HTTP1, PROTOCOL_H2, PROTOCOL_H2C)
+}
+
+func (httpRequest *HttpRequest) getClient() *http.Client {
+	httpRequest.clientM.Lock()
+	defer httpRequest.clientM.Unlock()
+
+	key := fmt.Sprintf("%s-%t-%d", httpRequest.Protocol, httpRequest.KeepAlive, httpRequest.Connections)
+	pool, ok := httpRequest.clients[key]
+	if !ok {
+		pool = newHttpClientPool(httpRequest.Protocol, httpRequest.KeepAlive, httpRequest.Connections)
+		httpRequest.clients[key] = pool
+	}
+	client := pool.clients[pool.next%uint64(len(pool.clients))]
+	pool.next++
+	return client
+}
+
+func newHttpClientPool(protocol string, keepAlive bool, connections uint64) *httpClientPool {
+	pool := new(httpClientPool)
+	switch protocol {
+	case PROTOCOL_H2, PROTOCOL_H2C:
+		if connections == 0 {
+			connections = 1
+		}
+		for i := uint64(0); i < connections; i++ {
+			pool.clients = append(pool.clients, &http.Client{Transport: newHttp2Transport(protocol)})
+		}
+	default:
+		// HTTP/1.1每个并发请求占用一条连接，连接数由并发数决定
+		pool.clients = append(pool.clients, &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			MaxIdleConnsPerHost: appconfig.GetConfig().Http.MaxIdleConnsPerHost,
+			DisableCompression:  false,
+			DisableKeepAlives:   !keepAlive,
+		}})
+	}
+	return pool
+}
+
+// 超过服务端允许的并发流数时等待，而不是新建连接，保证连接数固定
+func newHttp2Transport(protocol string) *http2.Transport {
+	tr := &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{http2.NextProtoTLS},
+		},
+		StrictMaxConcurrentStreams: true,
+	}
+	if protocol == PROTOCOL_H2C {
+		tr.AllowHTTP = true
+		tr.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}
+	}
+	return tr
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+)
+
+func TestHttpRequestVerifyProtocol(t *testing.T) {
+	cases := []struct {
+		protocol string
+		err      bool
+	}{
+		{protocol: ""},
+		{protocol: PROTOCOL_HTTP1},
+		{protocol: PROTOCOL_H2},
+		{protocol: PROTOCOL_H2C},
+		{protocol: "http3", err: true},
+		{protocol: "H2", err: true},
+	}
+	for _, c := range cases {
+		httpRequest := &HttpRequest{Protocol: c.protocol}
+		if err := httpRequest.VerifyProtocol(); (err != nil) != c.err {
+			t.Errorf("%q: err = %v, want err %v", c.protocol, err, c.err)
+		}
+	}
+}
+
+func TestHttpRequestGetClient(t *testing.T) {
+	httpRequest := GenerateHttpRequest(false)
+	httpRequest.Protocol = PROTOCOL_H2
+	httpRequest.Connections = 2
+	first, second, third := httpRequest.getClient(), httpRequest.getClient(), httpRequest.getClient()
+	if first == second || first != third {
+		t.Error("h2: requests should rotate over connections")
+	}
+
+	httpRequest.Protocol = PROTOCOL_HTTP1
+	if client := httpRequest.getClient(); client == first || client != httpRequest.getClient() {
+		t.Error("http1: one client per protocol")
+	}
+	if len(httpRequest.clients) != 2 {
+		t.Errorf("pools = %d, want 2", len(httpRequest.clients))
+	}
+}
+
+func TestHttpRequestProtocol(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	cases := []struct {
+		name      string
+		protocol  string
+		keepAlive bool
+		url       string
+		proto     string
+		reused    []bool
+	}{
+		{name: "http1短连接", url: plain.URL, proto: "HTTP/1.1", reused: []bool{false, false}},
+		{name: "http1长连接", keepAlive: true, url: plain.URL, proto: "HTTP/1.1", reused: []bool{false, true}},
+		{name: "h2", protocol: PROTOCOL_H2, url: tlsServer.URL, proto: "HTTP/2.0", reused: []bool{false, true}},
+	}
+	for _, c := range cases {
+		httpRequest := GenerateHttpRequest(false)
+		httpRequest.Protocol = c.protocol
+		httpRequest.KeepAlive = c.keepAlive
+		for i, reused := range c.reused {
+			trace := new(httpTrace)
+			req, _ := http.NewRequest("GET", c.url, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+			rp, err := httpRequest.getClient().Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			body, _ := ioutil.ReadAll(rp.Body)
+			rp.Body.Close()
+			if rp.Proto != c.proto || string(body) != c.proto || trace.reused != reused {
+				t.Errorf("%s #%d: %s %s reused %t, want %s reused %t", c.name, i, rp.Proto, body, trace.reused, c.proto, reused)
+			}
+		}
+	}
+}
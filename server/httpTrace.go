@@ -0,0 +1,229 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	PHASE_DNS      = "dns"      // 域名解析
+	PHASE_CONNECT  = "connect"  // 建立tcp连接
+	PHASE_TLS      = "tls"      // tls握手
+	PHASE_WRITE    = "write"    // 发送请求
+	PHASE_WAIT     = "wait"     // 服务端处理：发送完请求到收到第一个字节
+	PHASE_TRANSFER = "transfer" // 读取响应体
+)
+
+// 连接阶段只在新建连接时有值
+var connPhases = map[string]bool{PHASE_DNS: true, PHASE_CONNECT: true, PHASE_TLS: true}
+
+// 请求各阶段耗时（微秒）
+type HttpPhases struct {
+	Dns      uint64 `json:"dns"`
+	Connect  uint64 `json:"connect"`
+	Tls      uint64 `json:"tls"`
+	Write    uint64 `json:"write"`
+	Wait     uint64 `json:"wait"`
+	Transfer uint64 `json:"transfer"`
+
+	started map[string]bool // 经过的阶段，如http请求没有tls握手、ip地址没有域名解析
+}
+
+// 单次请求的连接信息与各阶段时间点
+type httpTrace struct {
+	reused       bool
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	m            sync.Mutex
+}
+
+func newHttpTrace() *httpTrace {
+	return &httpTrace{start: time.Now()}
+}
+
+func (trace *httpTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			trace.mark(&trace.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			trace.mark(&trace.dnsDone)
+		},
+		ConnectStart: func(string, string) {
+			// 多个地址时可能尝试多次，从第一次开始计算
+			trace.m.Lock()
+			if trace.connectStart.IsZero() {
+				trace.connectStart = time.Now()
+			}
+			trace.m.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			trace.mark(&trace.connectDone)
+		},
+		TLSHandshakeStart: func() {
+			trace.mark(&trace.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			trace.mark(&trace.tlsDone)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			trace.m.Lock()
+			trace.reused = info.Reused
+			trace.m.Unlock()
+			trace.mark(&trace.gotConn)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			trace.mark(&trace.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			trace.mark(&trace.firstByte)
+		},
+	}
+}
+
+// 超时后连接可能仍在其他协程中建立，时间点的读写需要加锁
+func (trace *httpTrace) mark(t *time.Time) {
+	trace.m.Lock()
+	*t = time.Now()
+	trace.m.Unlock()
+}
+
+// 读取完响应后计算各阶段耗时
+func (trace *httpTrace) phases(end time.Time) *HttpPhases {
+	trace.m.Lock()
+	defer trace.m.Unlock()
+	phases := &HttpPhases{
+		Dns:      traceMicroseconds(trace.dnsStart, trace.dnsDone),
+		Connect:  traceMicroseconds(trace.connectStart, trace.connectDone),
+		Tls:      traceMicroseconds(trace.tlsStart, trace.tlsDone),
+		Write:    traceMicroseconds(trace.gotConn, trace.wroteRequest),
+		Wait:     traceMicroseconds(trace.wroteRequest, trace.firstByte),
+		Transfer: traceMicroseconds(trace.firstByte, end),
+		started:  make(map[string]bool),
+	}
+	starts := map[string]time.Time{
+		PHASE_DNS:      trace.dnsStart,
+		PHASE_CONNECT:  trace.connectStart,
+		PHASE_TLS:      trace.tlsStart,
+		PHASE_WRITE:    trace.gotConn,
+		PHASE_WAIT:     trace.wroteRequest,
+		PHASE_TRANSFER: trace.firstByte,
+	}
+	for name, start := range starts {
+		if !start.IsZero() {
+			phases.started[name] = true
+		}
+	}
+	return phases
+}
+
+// 超时发生在哪个阶段
+func (trace *httpTrace) timeoutPhase() string {
+	trace.m.Lock()
+	defer trace.m.Unlock()
+	switch {
+	case !trace.dnsStart.IsZero() && trace.dnsDone.IsZero():
+		return PHASE_DNS
+	case !trace.connectStart.IsZero() && trace.connectDone.IsZero():
+		return PHASE_CONNECT
+	case !trace.tlsStart.IsZero() && trace.tlsDone.IsZero():
+		return PHASE_TLS
+	case trace.gotConn.IsZero():
+		return PHASE_CONNECT
+	case trace.wroteRequest.IsZero():
+		return PHASE_WRITE
+	case trace.firstByte.IsZero():
+		return PHASE_WAIT
+	}
+	return PHASE_TRANSFER
+}
+
+func traceMicroseconds(start, end time.Time) uint64 {
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return uint64(end.Sub(start) / time.Microsecond)
+}
+
+func (phases *HttpPhases) get(name string) uint64 {
+	switch name {
+	case PHASE_DNS:
+		return phases.Dns
+	case PHASE_CONNECT:
+		return phases.Connect
+	case PHASE_TLS:
+		return phases.Tls
+	case PHASE_WRITE:
+		return phases.Write
+	case PHASE_WAIT:
+		return phases.Wait
+	case PHASE_TRANSFER:
+		return phases.Transfer
+	}
+	return 0
+}
+
+// 按阶段统计的直方图
+type PhaseHistograms map[string]*Histogram
+
+func NewPhaseHistograms() PhaseHistograms {
+	histograms := make(PhaseHistograms)
+	for _, name := range []string{PHASE_DNS, PHASE_CONNECT, PHASE_TLS, PHASE_WRITE, PHASE_WAIT, PHASE_TRANSFER} {
+		histograms[name] = NewHistogram()
+	}
+	return histograms
+}
+
+func (histograms PhaseHistograms) add(data *Response) {
+	if data.Phases == nil {
+		return
+	}
+	for name, histogram := range histograms {
+		// 没有经过的阶段不计入，避免0值拉低分位数
+		if !data.Phases.started[name] || (data.ConnReused && connPhases[name]) {
+			continue
+		}
+		histogram.Add(data.Phases.get(name))
+	}
+}
+
+type byteCounter uint64
+
+func (counter *byteCounter) Write(p []byte) (int, error) {
+	*counter += byteCounter(len(p))
+	return len(p), nil
+}
+
+// 请求大小：请求行、头部与请求体，按HTTP/1.1格式计算，HTTP/2的头部压缩不计算在内
+func requestSize(req *http.Request) uint64 {
+	var counter byteCounter
+	req.Header.Write(&counter)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	size := uint64(counter) + uint64(len(req.Method)+1+len(req.URL.RequestURI())+len(" HTTP/1.1\r\n"))
+	size += uint64(len("Host: \r\n")+len(host)) + 2
+	if req.ContentLength > 0 {
+		size += uint64(req.ContentLength)
+	}
+	return size
+}
+
+// 响应头大小：状态行与头部
+func responseHeaderSize(rp *http.Response) uint64 {
+	var counter byteCounter
+	rp.Header.Write(&counter)
+	return uint64(counter) + uint64(len(rp.Proto)+1+len(rp.Status)+2) + 2
+}
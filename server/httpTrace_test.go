@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/tidwall/gjson"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestSize(t *testing.T) {
+	get, _ := http.NewRequest("GET", "http://h/a?b=1", nil)
+	post, _ := http.NewRequest("POST", "http://h/a", strings.NewReader("abc"))
+	post.Header.Set("X", "1")
+	rp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", Header: http.Header{"Content-Length": {"5"}}}
+
+	cases := []struct {
+		name string
+		size uint64
+		want uint64
+	}{
+		{name: "GET /a?b=1 HTTP/1.1、Host", size: requestSize(get), want: 32},
+		{name: "请求头与请求体", size: requestSize(post), want: 18 + 11 + 6 + 3},
+		{name: "响应状态行与头部", size: responseHeaderSize(rp), want: 17 + 19 + 2},
+	}
+	for _, c := range cases {
+		if c.size != c.want {
+			t.Errorf("%s: %d, want %d", c.name, c.size, c.want)
+		}
+	}
+}
+
+func TestHttpSendBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name         string
+		readResponse bool
+		keepBody     bool
+		stream       bool
+		data         interface{}
+	}{
+		{name: "丢弃响应体"},
+		{name: "缓存响应", readResponse: true},
+		{name: "返回响应内容", keepBody: true, data: "hello"},
+		{name: "流式读取", stream: true},
+	}
+	for _, c := range cases {
+		httpRequest := GenerateHttpRequest(c.readResponse)
+		httpRequest.Method = "GET"
+		httpRequest.Url = server.URL
+		httpRequest.keepBody = c.keepBody
+		httpRequest.Stream = c.stream
+		respCh := make(chan *Response, 1)
+		sentCh := make(chan bool, 1)
+		httpRequest.HttpSend(respCh, sentCh)
+		resp := <-respCh
+
+		if !resp.IsSuccess || resp.BytesSent == 0 || resp.BytesRecv <= 5 || resp.Data != c.data {
+			t.Errorf("%s: %+v", c.name, resp)
+		}
+		if c.readResponse && httpRequest.HttpResponse[server.URL] != "hello" {
+			t.Errorf("%s: response = %q", c.name, httpRequest.HttpResponse[server.URL])
+		}
+		if resp.FirstByteTime > resp.WasteTime || resp.DownloadTime > resp.WasteTime {
+			t.Errorf("%s: firstByte %d download %d waste %d", c.name, resp.FirstByteTime, resp.DownloadTime, resp.WasteTime)
+		}
+	}
+}
+
+func TestScriptIsExtracted(t *testing.T) {
+	data := gjson.Parse(`[
+		{"data": {"name": "login", "url": "http://h/login"}},
+		{"data": {"url": "http://h/list"}},
+		{"data": {"name": "order", "body": [
+			{"name": "token", "type": "response", "dynamic": "login---data---token"},
+			{"name": "item", "type": "object", "children": [{"name": "id", "type": "response", "dynamic": "http://h/list---0---id"}]}
+		]}}
+	]`).Array()
+	scriptRequest := &ScriptRequest{Data: data}
+	cases := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{name: "login", url: "http://h/login", want: true},
+		{url: "http://h/list", want: true},
+		{name: "order"},
+		{url: "http://h/login"},
+	}
+	for _, c := range cases {
+		if got := scriptRequest.isExtracted(&HttpRequest{Name: c.name, Url: c.url}); got != c.want {
+			t.Errorf("%s %s: %t, want %t", c.name, c.url, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"insane/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	LIVE_CLUSTER     = "cluster" // 订阅集群合并后的报告
+	LIVE_UNIT_SECOND = "second"
+	LIVE_UNIT_MINUTE = "minute" // 脚本任务按分钟统计
+)
+
+// 实时推送的一帧：当前汇总与上一帧之后新增的数据点
+type LiveFrame struct {
+	Id       string       `json:"id"`
+	Status   uint32       `json:"status"`   // 任务状态 1：已完成 2：待执行 3：执行中
+	Finished bool         `json:"finished"` // 最后一帧，之后不再推送
+	Error    string       `json:"error,omitempty"`
+	Unit     string       `json:"unit"` // 数据点的时间单位 second | minute
+	Summary  *LiveSummary `json:"summary"`
+	Points   []*LivePoint `json:"points"` // 只包含统计完的时间段，正在统计的一秒（一分钟）下一帧发送
+}
+
+type LiveSummary struct {
+	SuccessNum  uint64             `json:"successNum"`
+	FailureNum  uint64             `json:"failureNum"`
+	ErrorRate   float64            `json:"errorRate"` // 错误率（%）
+	Rps         float64            `json:"rps"`       // 平均每秒成功请求数
+	Elapsed     uint64             `json:"elapsed"`   // 已执行时间（秒）
+	Latency     *HistogramSummary  `json:"latency"`   // 成功请求的耗时（毫秒）
+	BytesSent   uint64             `json:"bytesSent"`
+	BytesRecv   uint64             `json:"bytesRecv"`
+	Thresholds  []*ThresholdResult `json:"thresholds,omitempty"` // 任务结束后才有
+	Baseline    *Comparison        `json:"baseline,omitempty"`
+	Annotations []*Annotation      `json:"annotations"` // 执行中的暂停、恢复与调整
+}
+
+type LivePoint struct {
+	Time      uint64 `json:"time"` // 第几秒（分钟）
+	Success   uint64 `json:"success"`
+	Failure   uint64 `json:"failure"`
+	Avg       uint64 `json:"avg"`
+	P50       uint64 `json:"p50"`
+	P95       uint64 `json:"p95"`
+	P99       uint64 `json:"p99"`
+	Max       uint64 `json:"max"`
+	BytesSent uint64 `json:"bytesSent"`
+	BytesRecv uint64 `json:"bytesRecv"`
+}
+
+// 一个连接的订阅，每个任务记录已经发送到的时间点
+type LiveSubscription struct {
+	cursors map[string]uint64
+	m       sync.Mutex
+}
+
+func NewLiveSubscription() *LiveSubscription {
+	return &LiveSubscription{cursors: make(map[string]uint64)}
+}
+
+// 重复订阅不会重置已发送的位置
+func (subscription *LiveSubscription) Subscribe(ids ...string) {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	for _, id := range ids {
+		if _, ok := subscription.cursors[id]; !ok && id != "" {
+			subscription.cursors[id] = 0
+		}
+	}
+}
+
+func (subscription *LiveSubscription) Unsubscribe(ids ...string) {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	for _, id := range ids {
+		delete(subscription.cursors, id)
+	}
+}
+
+func (subscription *LiveSubscription) Len() int {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	return len(subscription.cursors)
+}
+
+// sse的事件id，记录每个订阅已经发送到的位置
+func (subscription *LiveSubscription) EventId() string {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	list := make([]string, 0, len(subscription.cursors))
+	for id, cursor := range subscription.cursors {
+		list = append(list, fmt.Sprintf("%s:%d", id, cursor))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// 断线重连时从Last-Event-ID恢复已经发送到的位置
+func (subscription *LiveSubscription) Resume(eventId string) {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	for _, item := range strings.Split(eventId, ",") {
+		i := strings.LastIndex(item, ":")
+		if i <= 0 {
+			continue
+		}
+		if cursor, err := strconv.ParseUint(item[i+1:], 10, 64); err == nil {
+			subscription.cursors[item[:i]] = cursor
+		}
+	}
+}
+
+// 每个订阅生成一帧，任务结束或不存在时发送最后一帧并取消订阅
+func (subscription *LiveSubscription) Frames() []*LiveFrame {
+	subscription.m.Lock()
+	defer subscription.m.Unlock()
+	ids := make([]string, 0, len(subscription.cursors))
+	for id := range subscription.cursors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	frames := make([]*LiveFrame, 0, len(ids))
+	for _, id := range ids {
+		frame, next, err := LiveDelta(id, subscription.cursors[id])
+		if err != nil {
+			frame = &LiveFrame{Id: id, Finished: true, Error: err.Error()}
+		}
+		if frame.Finished {
+			delete(subscription.cursors, id)
+		} else {
+			subscription.cursors[id] = next
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// 任务从from开始的数据点，返回下次开始的位置
+func LiveDelta(id string, from uint64) (*LiveFrame, uint64, error) {
+	if id == LIVE_CLUSTER {
+		frame, next := InsaneMaster.Report().liveFrame(from, false)
+		frame.Id = id
+		return frame, next, nil
+	}
+	task, status := TK.findTask(id)
+	if task == nil {
+		return nil, from, errors.New("任务不存在")
+	}
+	var (
+		frame    *LiveFrame
+		next     uint64
+		finished = status == COMPLETED_TASK
+	)
+	if task.InsaneRequest.Form == TYPE_SCRIPT {
+		frame, next = task.InsaneRequest.ScriptReportList.liveFrame(from, finished)
+	} else {
+		frame, next = task.InsaneRequest.Report.liveFrame(from, finished)
+	}
+	frame.Id, frame.Status, frame.Finished = id, status, finished
+	return frame, next, nil
+}
+
+func (report *Report) liveFrame(from uint64, finished bool) (*LiveFrame, uint64) {
+	report.m.Lock()
+	defer report.m.Unlock()
+	summary := &LiveSummary{
+		SuccessNum:  report.SuccessNum,
+		FailureNum:  report.FailureNum,
+		Elapsed:     liveElapsed(report.startTime, report.RequestTime, finished),
+		BytesSent:   report.BytesSent,
+		BytesRecv:   report.BytesRecv,
+		Thresholds:  report.Thresholds,
+		Baseline:    report.Baseline,
+		Annotations: report.Annotations,
+	}
+	if report.Latency != nil {
+		summary.Latency = report.Latency.Summary()
+	}
+	summary.rates()
+
+	frame := &LiveFrame{Unit: LIVE_UNIT_SECOND, Summary: summary, Points: make([]*LivePoint, 0)}
+	end := liveEnd(finished, intSeries(report.AverageSuccessReq), intSeries(report.AverageErrorReq))
+	for second := from; second < end; second++ {
+		success, ok1 := report.AverageSuccessReq[second]
+		failure, ok2 := report.AverageErrorReq[second]
+		if !ok1 && !ok2 {
+			continue
+		}
+		point := &LivePoint{Time: second, Success: uint64(success), Failure: uint64(failure), BytesSent: report.AverageBytesSent[second], BytesRecv: report.AverageBytesRecv[second]}
+		if histogram, ok := report.LatencySeries[second]; ok {
+			latency := histogram.Summary()
+			point.Avg, point.P50, point.P95, point.P99, point.Max = latency.Avg, latency.P50, latency.P95, latency.P99, latency.Max
+		}
+		frame.Points = append(frame.Points, point)
+	}
+	if end < from {
+		end = from
+	}
+	return frame, end
+}
+
+func (scriptReportList *ScriptReportList) liveFrame(from uint64, finished bool) (*LiveFrame, uint64) {
+	scriptReportList.m.Lock()
+	defer scriptReportList.m.Unlock()
+	summary := &LiveSummary{
+		SuccessNum:  scriptReportList.TotalSuccess,
+		FailureNum:  scriptReportList.TotalError,
+		Elapsed:     liveElapsed(scriptReportList.startTime, scriptReportList.RequestTime, finished),
+		Thresholds:  scriptReportList.Thresholds,
+		Baseline:    scriptReportList.Baseline,
+		Annotations: scriptReportList.Annotations,
+	}
+	if scriptReportList.WasteTime != nil {
+		summary.Latency = scriptReportList.WasteTime.Summary()
+	}
+	summary.rates()
+
+	frame := &LiveFrame{Unit: LIVE_UNIT_MINUTE, Summary: summary, Points: make([]*LivePoint, 0)}
+	end := liveEnd(finished, uintSeries(scriptReportList.AverageSuccess), uintSeries(scriptReportList.AverageError))
+	for minute := from; minute < end; minute++ {
+		success, ok1 := scriptReportList.AverageSuccess[minute]
+		failure, ok2 := scriptReportList.AverageError[minute]
+		if ok1 || ok2 {
+			frame.Points = append(frame.Points, &LivePoint{Time: minute, Success: success, Failure: failure})
+		}
+	}
+	if end < from {
+		end = from
+	}
+	return frame, end
+}
+
+// 执行中最后一个时间段还在统计，不发送
+func liveEnd(finished bool, list ...map[uint64]float64) uint64 {
+	keys := seriesKeys(list...)
+	if len(keys) == 0 {
+		return 0
+	}
+	last := keys[len(keys)-1]
+	if finished {
+		return last + 1
+	}
+	return last
+}
+
+func liveElapsed(startTime int64, requestTime uint64, finished bool) uint64 {
+	if finished || startTime == 0 {
+		return requestTime
+	}
+	return uint64(utils.Now()-startTime) / 1000
+}
+
+func (summary *LiveSummary) rates() {
+	if total := summary.SuccessNum + summary.FailureNum; total > 0 {
+		summary.ErrorRate = float64(summary.FailureNum) * 100 / float64(total)
+	}
+	if summary.Elapsed > 0 {
+		summary.Rps = float64(summary.SuccessNum) / float64(summary.Elapsed)
+	}
+}
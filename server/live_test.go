@@ -0,0 +1,146 @@
+package server
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestLiveSubscription(t *testing.T) {
+	subscription := NewLiveSubscription()
+	subscription.Subscribe("b", "a", "")
+	subscription.Resume("a:5,bad,c:x,:3")
+	subscription.Subscribe("a") // 重复订阅不重置位置
+	if got := subscription.EventId(); got != "a:5,b:0" {
+		t.Errorf("event id = %s", got)
+	}
+
+	// 从Last-Event-ID恢复时可以加入新的订阅
+	resumed := NewLiveSubscription()
+	resumed.Resume(subscription.EventId() + ",task:1:7")
+	if got := resumed.EventId(); got != "a:5,b:0,task:1:7" || resumed.Len() != 3 {
+		t.Errorf("resumed = %s", got)
+	}
+	subscription.Unsubscribe("a", "none")
+	if subscription.Len() != 1 {
+		t.Errorf("len = %d", subscription.Len())
+	}
+
+	// 不存在的任务发送最后一帧后取消订阅
+	frames := subscription.Frames()
+	if len(frames) != 1 || frames[0].Id != "b" || !frames[0].Finished || frames[0].Error == "" || subscription.Len() != 0 {
+		t.Errorf("frames = %+v", frames[0])
+	}
+}
+
+func TestLiveEnd(t *testing.T) {
+	series := map[uint64]float64{1: 1, 3: 1}
+	cases := []struct {
+		finished bool
+		list     []map[uint64]float64
+		want     uint64
+	}{
+		{want: 0},
+		{list: []map[uint64]float64{series}, want: 3},
+		{finished: true, list: []map[uint64]float64{series}, want: 4},
+		{list: []map[uint64]float64{series, {5: 1}}, want: 5},
+	}
+	for _, c := range cases {
+		if got := liveEnd(c.finished, c.list...); got != c.want {
+			t.Errorf("finished %t, %v: %d, want %d", c.finished, c.list, got, c.want)
+		}
+	}
+}
+
+func TestReportLiveFrame(t *testing.T) {
+	latency := NewHistogram()
+	latency.Add(20)
+	report := &Report{
+		SuccessNum:        6,
+		FailureNum:        2,
+		RequestTime:       3,
+		AverageSuccessReq: map[uint64]int{1: 2, 2: 3, 3: 1},
+		AverageErrorReq:   map[uint64]int{2: 2},
+		AverageBytesSent:  map[uint64]uint64{1: 10},
+		LatencySeries:     map[uint64]*Histogram{2: latency},
+		Latency:           latency,
+	}
+	cases := []struct {
+		from     uint64
+		finished bool
+		times    []uint64
+		next     uint64
+	}{
+		{from: 0, times: []uint64{1, 2}, next: 3}, // 执行中第3秒还在统计
+		{from: 3, next: 3},
+		{from: 3, finished: true, times: []uint64{3}, next: 4},
+		{from: 9, finished: true, next: 9},
+	}
+	for _, c := range cases {
+		frame, next := report.liveFrame(c.from, c.finished)
+		var times []uint64
+		for _, point := range frame.Points {
+			times = append(times, point.Time)
+		}
+		if next != c.next || len(times) != len(c.times) {
+			t.Errorf("from %d: points %v, next %d", c.from, times, next)
+			continue
+		}
+		for i := range times {
+			if times[i] != c.times[i] {
+				t.Errorf("from %d: points %v", c.from, times)
+			}
+		}
+	}
+
+	frame, _ := report.liveFrame(0, true)
+	summary := frame.Summary
+	if frame.Unit != LIVE_UNIT_SECOND || summary.ErrorRate != 25 || summary.Rps != 2 || summary.Latency.Max != 20 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if point := frame.Points[1]; point.Failure != 2 || point.P95 != 20 || frame.Points[0].BytesSent != 10 {
+		t.Errorf("point = %+v", point)
+	}
+}
+
+func TestScriptReportLiveFrame(t *testing.T) {
+	scriptReportList := &ScriptReportList{
+		TotalSuccess:   4,
+		RequestTime:    120,
+		AverageSuccess: map[uint64]uint64{1: 3, 2: 1},
+		AverageError:   map[uint64]uint64{},
+	}
+	frame, next := scriptReportList.liveFrame(0, false)
+	if frame.Unit != LIVE_UNIT_MINUTE || len(frame.Points) != 1 || frame.Points[0].Success != 3 || next != 2 {
+		t.Errorf("frame = %+v, next %d", frame, next)
+	}
+	if frame.Summary.Latency != nil {
+		t.Error("no transactions: latency should be nil")
+	}
+}
+
+func TestReportGet(t *testing.T) {
+	report := &Report{
+		Name:              "order",
+		AverageSuccessReq: make(map[uint64]int),
+		AverageErrorReq:   map[uint64]int{1: 1},
+		LatencySeries:     map[uint64]*Histogram{1: NewHistogram()},
+	}
+	for second := uint64(1); second <= REPORT_RECENT_SECONDS+100; second++ {
+		report.AverageSuccessReq[second] = 1
+	}
+	// 每秒的统计只返回最近的部分，每秒的耗时分布不返回
+	content := gjson.Parse(report.Get())
+	success := content.Get("averageSuccessReq").Map()
+	if content.Get("name").String() != "order" || len(success) != REPORT_RECENT_SECONDS || !success["101"].Exists() || success["100"].Exists() {
+		t.Errorf("averageSuccessReq = %d seconds", len(success))
+	}
+	if len(content.Get("averageErrorReq").Map()) != 1 || content.Get("latencySeries").Exists() {
+		t.Errorf("report = %s", content.Get("latencySeries").Raw)
+	}
+
+	// 集群推送的是完整的报告
+	other, err := report.copy()
+	if err != nil || len(other.AverageSuccessReq) != REPORT_RECENT_SECONDS+100 || len(other.LatencySeries) != 1 {
+		t.Errorf("copy = %v, %d seconds", err, len(other.AverageSuccessReq))
+	}
+}
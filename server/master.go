@@ -1,9 +1,14 @@
 package server
 
-import "insane/utils"
+import (
+	"insane/utils"
+	"sync"
+)
 
+// 子节点的注册与上报来自各自的websocket连接，实时推送每秒读取合并后的报告，ClusterList需要加锁访问
 type Master struct {
 	ClusterList map[uint64]*Cluster
+	m           sync.Mutex
 }
 
 type ReplyData struct {
@@ -18,6 +23,8 @@ type ProtoReplyMsg struct {
 var InsaneMaster Master
 
 func (master *Master) Init() {
+	master.m.Lock()
+	defer master.m.Unlock()
 	master.ClusterList = make(map[uint64]*Cluster)
 }
 
@@ -26,5 +33,39 @@ func (master *Master) GenerateClusterId() uint64 {
 }
 
 func (master *Master) AddCluster(cluster *Cluster) {
+	master.m.Lock()
+	defer master.m.Unlock()
+	if master.ClusterList == nil {
+		master.ClusterList = make(map[uint64]*Cluster)
+	}
 	master.ClusterList[cluster.ClusterId] = cluster
 }
+
+// 子节点断开连接
+func (master *Master) RemoveCluster(clusterId uint64) {
+	master.m.Lock()
+	defer master.m.Unlock()
+	delete(master.ClusterList, clusterId)
+}
+
+// 子节点上报的报告，每次上报整体替换，不会修改已经上报的报告
+func (master *Master) SetReport(clusterId uint64, report *Report) {
+	master.m.Lock()
+	defer master.m.Unlock()
+	if cluster, ok := master.ClusterList[clusterId]; ok && report != nil {
+		cluster.ClusterInfo.Report = report
+	}
+}
+
+// 合并所有子节点的报告
+func (master *Master) Report() *Report {
+	master.m.Lock()
+	defer master.m.Unlock()
+	report := new(Report)
+	for _, cluster := range master.ClusterList {
+		if cluster.ClusterInfo.Report != nil {
+			report.Merge(cluster.ClusterInfo.Report)
+		}
+	}
+	return report
+}
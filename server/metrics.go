@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+	METRICS_PREFIX       = "insane_"
+)
+
+// 耗时直方图的桶上限（秒）
+var metricsBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 正在执行与已完成（未删除）任务的实时指标，按Prometheus文本格式输出
+// 标签：task为任务id，scenario为请求类型，step为脚本步骤名或请求分组
+type Metrics struct {
+	tasks map[string]*TaskMetrics
+	m     sync.Mutex
+}
+
+type TaskMetrics struct {
+	id        string
+	scenario  string
+	vus       uint64
+	steps     map[string]*stepMetrics
+	generator *GeneratorSample
+	windows   map[string]*pushWindow // 推送间隔内的统计，不推送时为nil
+	m         *sync.Mutex
+}
+
+type stepMetrics struct {
+	success   uint64
+	failure   uint64
+	errors    map[string]uint64 // 错误分类/次数
+	buckets   []uint64          // 每个桶的请求数，不累加
+	count     uint64
+	sum       float64 // 总耗时（秒）
+	bytesSent uint64
+	bytesRecv uint64
+}
+
+var InsaneMetrics = &Metrics{
+	tasks: make(map[string]*TaskMetrics),
+}
+
+// 任务开始时注册，同一id重复注册时重新计数
+func (metrics *Metrics) task(id string, scenario string) *TaskMetrics {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+	taskMetrics := &TaskMetrics{
+		id:       id,
+		scenario: scenario,
+		steps:    make(map[string]*stepMetrics),
+		m:        &metrics.m,
+	}
+	if InsanePush.enabled() {
+		taskMetrics.windows = make(map[string]*pushWindow)
+	}
+	metrics.tasks[id] = taskMetrics
+	return taskMetrics
+}
+
+// 删除任务的指标，包括容量模式每一步的指标
+func (metrics *Metrics) remove(id string) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+	for taskId := range metrics.tasks {
+		if taskId == id || strings.HasPrefix(taskId, id+"_") {
+			delete(metrics.tasks, taskId)
+		}
+	}
+}
+
+func (taskMetrics *TaskMetrics) add(step string, data *Response) {
+	if taskMetrics == nil || !data.Stream.isRequest() {
+		return
+	}
+	taskMetrics.m.Lock()
+	defer taskMetrics.m.Unlock()
+	cur, ok := taskMetrics.steps[step]
+	if !ok {
+		cur = &stepMetrics{errors: make(map[string]uint64), buckets: make([]uint64, len(metricsBuckets)+1)}
+		taskMetrics.steps[step] = cur
+	}
+	cur.bytesSent += data.BytesSent
+	cur.bytesRecv += data.BytesRecv
+	if taskMetrics.windows != nil {
+		window, ok := taskMetrics.windows[step]
+		if !ok {
+			window = &pushWindow{errors: make(map[string]uint64), latency: NewHistogram()}
+			taskMetrics.windows[step] = window
+		}
+		window.add(data)
+	}
+	if !data.IsSuccess {
+		cur.failure++
+		errType := data.ErrType
+		if errType == "" {
+			errType = ERR_TYPE_OTHER
+		}
+		cur.errors[errType]++
+		return
+	}
+	cur.success++
+	seconds := float64(data.WasteTime) / 1000
+	cur.buckets[sort.SearchFloat64s(metricsBuckets, seconds)]++
+	cur.count++
+	cur.sum += seconds
+}
+
+func (taskMetrics *TaskMetrics) setVus(vus uint64) {
+	if taskMetrics == nil {
+		return
+	}
+	taskMetrics.m.Lock()
+	defer taskMetrics.m.Unlock()
+	taskMetrics.vus = vus
+}
+
+func (taskMetrics *TaskMetrics) setGenerator(sample *GeneratorSample) {
+	if taskMetrics == nil {
+		return
+	}
+	taskMetrics.m.Lock()
+	defer taskMetrics.m.Unlock()
+	taskMetrics.generator = sample
+}
+
+// Prometheus文本格式
+func (metrics *Metrics) Export() []byte {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	ids := make([]string, 0, len(metrics.tasks))
+	for id := range metrics.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w := new(metricsWriter)
+	w.family("requests_total", "counter", "请求数，result为success或failure")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		for _, step := range task.stepNames() {
+			cur := task.steps[step]
+			w.sample("requests_total", task.labels(step, "result", "success"), float64(cur.success))
+			w.sample("requests_total", task.labels(step, "result", "failure"), float64(cur.failure))
+		}
+	}
+	w.family("errors_total", "counter", "按错误分类统计的失败请求数")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		for _, step := range task.stepNames() {
+			cur := task.steps[step]
+			types := make([]string, 0, len(cur.errors))
+			for errType := range cur.errors {
+				types = append(types, errType)
+			}
+			sort.Strings(types)
+			for _, errType := range types {
+				w.sample("errors_total", task.labels(step, "type", errType), float64(cur.errors[errType]))
+			}
+		}
+	}
+	w.family("request_duration_seconds", "histogram", "成功请求的耗时")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		for _, step := range task.stepNames() {
+			cur := task.steps[step]
+			var cumulative uint64
+			for i, le := range metricsBuckets {
+				cumulative += cur.buckets[i]
+				w.sample("request_duration_seconds_bucket", task.labels(step, "le", strconv.FormatFloat(le, 'g', -1, 64)), float64(cumulative))
+			}
+			w.sample("request_duration_seconds_bucket", task.labels(step, "le", "+Inf"), float64(cur.count))
+			w.sample("request_duration_seconds_sum", task.labels(step), cur.sum)
+			w.sample("request_duration_seconds_count", task.labels(step), float64(cur.count))
+		}
+	}
+	w.family("bytes_sent_total", "counter", "发送字节数")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		for _, step := range task.stepNames() {
+			w.sample("bytes_sent_total", task.labels(step), float64(task.steps[step].bytesSent))
+		}
+	}
+	w.family("bytes_received_total", "counter", "接收字节数")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		for _, step := range task.stepNames() {
+			w.sample("bytes_received_total", task.labels(step), float64(task.steps[step].bytesRecv))
+		}
+	}
+
+	w.family("active_vus", "gauge", "正在执行请求的协程数")
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		w.sample("active_vus", task.taskLabels(), float64(task.vus))
+	}
+	generators := []struct {
+		name  string
+		help  string
+		value func(sample *GeneratorSample) float64
+	}{
+		{"generator_cpu_percent", "施压进程cpu使用率（%）", func(sample *GeneratorSample) float64 { return sample.Cpu }},
+		{"generator_system_cpu_percent", "施压机整机cpu使用率（%）", func(sample *GeneratorSample) float64 { return sample.SysCpu }},
+		{"generator_memory_bytes", "施压进程占用内存", func(sample *GeneratorSample) float64 { return float64(sample.Mem) * 1024 * 1024 }},
+		{"generator_goroutines", "施压进程协程数", func(sample *GeneratorSample) float64 { return float64(sample.Goroutines) }},
+		{"generator_backlog", "结果通道中等待统计的数量", func(sample *GeneratorSample) float64 { return float64(sample.Backlog) }},
+	}
+	for _, generator := range generators {
+		w.family(generator.name, "gauge", generator.help)
+		for _, id := range ids {
+			if task := metrics.tasks[id]; task.generator != nil {
+				w.sample(generator.name, task.taskLabels(), generator.value(task.generator))
+			}
+		}
+	}
+	return w.Bytes()
+}
+
+func (taskMetrics *TaskMetrics) stepNames() []string {
+	names := make([]string, 0, len(taskMetrics.steps))
+	for name := range taskMetrics.steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (taskMetrics *TaskMetrics) taskLabels() string {
+	return metricsLabels("task", taskMetrics.id, "scenario", taskMetrics.scenario)
+}
+
+// 在task、scenario、step之后追加其他标签
+func (taskMetrics *TaskMetrics) labels(step string, extra ...string) string {
+	return metricsLabels(append([]string{"task", taskMetrics.id, "scenario", taskMetrics.scenario, "step", step}, extra...)...)
+}
+
+func metricsLabels(pairs ...string) string {
+	var buf strings.Builder
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(pairs[i])
+		buf.WriteString(`="`)
+		buf.WriteString(metricsEscape(pairs[i+1]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+var metricsReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricsEscape(value string) string {
+	return metricsReplacer.Replace(value)
+}
+
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+func (w *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", METRICS_PREFIX, name, help, METRICS_PREFIX, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s%s %s\n", METRICS_PREFIX, name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	cases := []struct {
+		pairs []string
+		want  string
+	}{
+		{want: "{}"},
+		{pairs: []string{"task", "t1"}, want: `{task="t1"}`},
+		{pairs: []string{"task", "t1", "step", `a"b\c` + "\n"}, want: `{task="t1",step="a\"b\\c\n"}`},
+		{pairs: []string{"task", "t1", "odd"}, want: `{task="t1"}`},
+	}
+	for _, c := range cases {
+		if got := metricsLabels(c.pairs...); got != c.want {
+			t.Errorf("%q: %s, want %s", c.pairs, got, c.want)
+		}
+	}
+}
+
+func TestMetricsExport(t *testing.T) {
+	metrics := &Metrics{tasks: make(map[string]*TaskMetrics)}
+	task := metrics.task("t1", TYPE_HTTP)
+	task.add("login", &Response{IsSuccess: true, WasteTime: 3, BytesSent: 10, BytesRecv: 100})
+	task.add("login", &Response{IsSuccess: true, WasteTime: 200, BytesSent: 10, BytesRecv: 100})
+	task.add("login", &Response{ErrType: ERR_TYPE_TIMEOUT, BytesSent: 10})
+	task.add("login", &Response{})
+	task.add("login", &Response{IsSuccess: true, Stream: &StreamResponse{Kind: STREAM_CONNECT}}) // 连接建立不计入请求
+	task.setVus(5)
+	task.setGenerator(&GeneratorSample{Cpu: 12.5, Mem: 2})
+	metrics.task("t1_1", TYPE_HTTP) // 容量模式每一步的指标
+	metrics.task("t2", TYPE_GRPC)
+	var nilTask *TaskMetrics
+	nilTask.add("x", &Response{IsSuccess: true})
+
+	export := string(metrics.Export())
+	for _, line := range []string{
+		"# TYPE insane_requests_total counter",
+		`insane_requests_total{task="t1",scenario="http",step="login",result="success"} 2`,
+		`insane_requests_total{task="t1",scenario="http",step="login",result="failure"} 2`,
+		`insane_errors_total{task="t1",scenario="http",step="login",type="` + ERR_TYPE_TIMEOUT + `"} 1`,
+		`insane_errors_total{task="t1",scenario="http",step="login",type="` + ERR_TYPE_OTHER + `"} 1`,
+		`insane_request_duration_seconds_bucket{task="t1",scenario="http",step="login",le="0.001"} 0`,
+		`insane_request_duration_seconds_bucket{task="t1",scenario="http",step="login",le="0.005"} 1`,
+		`insane_request_duration_seconds_bucket{task="t1",scenario="http",step="login",le="0.25"} 2`,
+		`insane_request_duration_seconds_bucket{task="t1",scenario="http",step="login",le="+Inf"} 2`,
+		`insane_request_duration_seconds_sum{task="t1",scenario="http",step="login"} 0.203`,
+		`insane_request_duration_seconds_count{task="t1",scenario="http",step="login"} 2`,
+		`insane_bytes_sent_total{task="t1",scenario="http",step="login"} 30`,
+		`insane_bytes_received_total{task="t1",scenario="http",step="login"} 200`,
+		`insane_active_vus{task="t1",scenario="http"} 5`,
+		`insane_active_vus{task="t2",scenario="grpc"} 0`,
+		`insane_generator_cpu_percent{task="t1",scenario="http"} 12.5`,
+		`insane_generator_memory_bytes{task="t1",scenario="http"} 2.097152e+06`,
+	} {
+		if !strings.Contains(export, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if strings.Contains(export, `insane_generator_cpu_percent{task="t2"`) {
+		t.Error("task without generator sample should be skipped")
+	}
+
+	metrics.remove("t1")
+	if _, ok := metrics.tasks["t1_1"]; ok || len(metrics.tasks) != 1 {
+		t.Errorf("tasks after remove = %d", len(metrics.tasks))
+	}
+}
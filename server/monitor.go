@@ -0,0 +1,278 @@
+package server
+
+import (
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/process"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	MONITOR_CPU        = "cpu"
+	MONITOR_MEM        = "mem"
+	MONITOR_GOROUTINES = "goroutines"
+	MONITOR_GC_PAUSE   = "gcPause"
+	MONITOR_FD         = "fd"
+	MONITOR_BACKLOG    = "backlog"
+
+	// 配置为0时的默认上限
+	MONITOR_DEFAULT_CPU        = 90
+	MONITOR_DEFAULT_MEM        = 90
+	MONITOR_DEFAULT_GOROUTINES = 100000
+	MONITOR_DEFAULT_GC_PAUSE   = 100
+	MONITOR_DEFAULT_FD         = 80
+	MONITOR_DEFAULT_BACKLOG    = 80
+)
+
+// 施压机每秒的状态
+type GeneratorSample struct {
+	Cpu        float64 `json:"cpu"`        // 施压进程cpu使用率，占全部核心的比例（%）
+	SysCpu     float64 `json:"sysCpu"`     // 整机cpu使用率（%）
+	Mem        uint64  `json:"mem"`        // 施压进程占用内存（MB）
+	SysMem     float64 `json:"sysMem"`     // 整机内存使用率（%）
+	Goroutines uint64  `json:"goroutines"` // 协程数
+	GcNum      uint32  `json:"gcNum"`      // 这一秒的GC次数
+	GcPause    uint64  `json:"gcPause"`    // 这一秒的GC暂停时间（微秒）
+	Fds        uint64  `json:"fds"`        // 打开的文件描述符数
+	FdLimit    uint64  `json:"fdLimit"`    // 文件描述符上限
+	Backlog    uint64  `json:"backlog"`    // 结果通道中等待统计的数量
+	BacklogCap uint64  `json:"backlogCap"` // 结果通道容量
+}
+
+// 同一指标多次超出上限只记录一条
+type GeneratorWarning struct {
+	Metric  string  `json:"metric"`
+	Limit   float64 `json:"limit"`
+	Max     float64 `json:"max"`     // 超出期间的最大值
+	First   uint64  `json:"first"`   // 第一次超出的秒数
+	Seconds uint64  `json:"seconds"` // 超出的总秒数
+	Message string  `json:"message"`
+}
+
+type GeneratorReport struct {
+	Samples   map[uint64]*GeneratorSample `json:"samples"`   // 每秒的施压机状态
+	Warnings  []*GeneratorWarning         `json:"warnings"`  // 超出上限的指标
+	Saturated bool                        `json:"saturated"` // 施压机曾经饱和，延迟可能来自施压机自身而不是目标服务
+}
+
+// 任务执行期间每秒采集一次施压机状态，写入任务的报告
+type generatorMonitor struct {
+	report  *GeneratorReport
+	m       sync.Locker // 报告的锁
+	backlog func() (int, int)
+	metrics *TaskMetrics
+	proc    *process.Process
+	memStat runtime.MemStats
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newGeneratorReport() *GeneratorReport {
+	return &GeneratorReport{Samples: make(map[uint64]*GeneratorSample)}
+}
+
+func newGeneratorMonitor(report *GeneratorReport, m sync.Locker, backlog func() (int, int)) *generatorMonitor {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		logger.Debug(err)
+	}
+	return &generatorMonitor{
+		report:  report,
+		m:       m,
+		backlog: backlog,
+		proc:    proc,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (monitor *generatorMonitor) run() {
+	defer close(monitor.stopped)
+	// cpu使用率按两次采集之间计算，先采集一次作为起点
+	monitor.sample()
+	startTime := utils.Now()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-monitor.done:
+			return
+		case <-t.C:
+		}
+		sample := monitor.sample()
+		curSecond := utils.CurSecond(uint64(startTime))
+		monitor.m.Lock()
+		monitor.report.Samples[curSecond] = sample
+		monitor.report.check(curSecond, sample)
+		monitor.m.Unlock()
+		monitor.metrics.setGenerator(sample)
+	}
+}
+
+// 停止采集，返回后不会再写入报告
+func (monitor *generatorMonitor) close() {
+	close(monitor.done)
+	<-monitor.stopped
+}
+
+func (monitor *generatorMonitor) sample() *GeneratorSample {
+	sample := &GeneratorSample{Goroutines: uint64(runtime.NumGoroutine())}
+
+	if c, err := cpu.Percent(0, false); err == nil && len(c) > 0 {
+		sample.SysCpu = c[0]
+	}
+	if virtualMem, err := mem.VirtualMemory(); err == nil {
+		sample.SysMem = virtualMem.UsedPercent
+	}
+	if monitor.proc != nil {
+		if c, err := monitor.proc.Percent(0); err == nil {
+			sample.Cpu = c / float64(runtime.NumCPU())
+		}
+		if memInfo, err := monitor.proc.MemoryInfo(); err == nil {
+			sample.Mem = memInfo.RSS / 1024 / 1024
+		}
+		if fds, err := monitor.proc.NumFDs(); err == nil {
+			sample.Fds = uint64(fds)
+		}
+		if limits, err := monitor.proc.Rlimit(); err == nil {
+			for _, limit := range limits {
+				if limit.Resource == process.RLIMIT_NOFILE && limit.Soft > 0 {
+					sample.FdLimit = uint64(limit.Soft)
+				}
+			}
+		}
+	}
+
+	lastNum, lastPause := monitor.memStat.NumGC, monitor.memStat.PauseTotalNs
+	runtime.ReadMemStats(&monitor.memStat)
+	sample.GcNum = monitor.memStat.NumGC - lastNum
+	sample.GcPause = (monitor.memStat.PauseTotalNs - lastPause) / uint64(time.Microsecond)
+
+	backlog, backlogCap := monitor.backlog()
+	sample.Backlog, sample.BacklogCap = uint64(backlog), uint64(backlogCap)
+	return sample
+}
+
+// 检查各指标是否超出配置的上限
+func (generator *GeneratorReport) check(second uint64, sample *GeneratorSample) {
+	config := appconfig.GetConfig().Monitor
+	generator.limit(second, MONITOR_CPU, sample.SysCpu, monitorLimit(config.Cpu, MONITOR_DEFAULT_CPU), "整机cpu使用率%.0f%%，超过上限%.0f%%")
+	generator.limit(second, MONITOR_MEM, sample.SysMem, monitorLimit(config.Mem, MONITOR_DEFAULT_MEM), "整机内存使用率%.0f%%，超过上限%.0f%%")
+	generator.limit(second, MONITOR_GOROUTINES, float64(sample.Goroutines), monitorLimit(float64(config.Goroutines), MONITOR_DEFAULT_GOROUTINES), "协程数%.0f，超过上限%.0f")
+	generator.limit(second, MONITOR_GC_PAUSE, float64(sample.GcPause)/1000, monitorLimit(float64(config.GcPause), MONITOR_DEFAULT_GC_PAUSE), "每秒GC暂停%.0fms，超过上限%.0fms")
+	if sample.FdLimit > 0 {
+		generator.limit(second, MONITOR_FD, float64(sample.Fds)*100/float64(sample.FdLimit), monitorLimit(config.Fd, MONITOR_DEFAULT_FD), "文件描述符占上限的%.0f%%，超过%.0f%%")
+	}
+	if sample.BacklogCap > 0 {
+		generator.limit(second, MONITOR_BACKLOG, float64(sample.Backlog)*100/float64(sample.BacklogCap), monitorLimit(config.Backlog, MONITOR_DEFAULT_BACKLOG), "结果通道积压%.0f%%，超过上限%.0f%%")
+	}
+}
+
+func (generator *GeneratorReport) limit(second uint64, metric string, value float64, limit float64, format string) {
+	if value < limit {
+		return
+	}
+	generator.Saturated = true
+	for _, warning := range generator.Warnings {
+		if warning.Metric == metric {
+			warning.Seconds++
+			if value > warning.Max {
+				warning.Max = value
+				warning.Message = generatorWarningMessage(format, value, limit)
+			}
+			return
+		}
+	}
+	generator.Warnings = append(generator.Warnings, &GeneratorWarning{
+		Metric:  metric,
+		Limit:   limit,
+		Max:     value,
+		First:   second,
+		Seconds: 1,
+		Message: generatorWarningMessage(format, value, limit),
+	})
+}
+
+func generatorWarningMessage(format string, value float64, limit float64) string {
+	return fmt.Sprintf(format, value, limit) + "，延迟可能来自施压机自身"
+}
+
+func monitorLimit(limit float64, def float64) float64 {
+	if limit <= 0 {
+		return def
+	}
+	return limit
+}
+
+// 合并集群中其他节点的施压机状态，每秒取各节点中的最大值
+func (generator *GeneratorReport) merge(other *GeneratorReport) {
+	for second, sample := range other.Samples {
+		cur, ok := generator.Samples[second]
+		if !ok {
+			cur = new(GeneratorSample)
+			generator.Samples[second] = cur
+		}
+		cur.max(sample)
+	}
+	for _, warning := range other.Warnings {
+		merged := false
+		for _, cur := range generator.Warnings {
+			if cur.Metric == warning.Metric {
+				cur.Seconds += warning.Seconds
+				if warning.First < cur.First {
+					cur.First = warning.First
+				}
+				if warning.Max > cur.Max {
+					cur.Max = warning.Max
+					cur.Message = warning.Message
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			w := *warning
+			generator.Warnings = append(generator.Warnings, &w)
+		}
+	}
+	generator.Saturated = generator.Saturated || other.Saturated
+}
+
+func (sample *GeneratorSample) max(other *GeneratorSample) {
+	if other.Cpu > sample.Cpu {
+		sample.Cpu = other.Cpu
+	}
+	if other.SysCpu > sample.SysCpu {
+		sample.SysCpu = other.SysCpu
+	}
+	if other.Mem > sample.Mem {
+		sample.Mem = other.Mem
+	}
+	if other.SysMem > sample.SysMem {
+		sample.SysMem = other.SysMem
+	}
+	if other.Goroutines > sample.Goroutines {
+		sample.Goroutines = other.Goroutines
+	}
+	if other.GcNum > sample.GcNum {
+		sample.GcNum = other.GcNum
+	}
+	if other.GcPause > sample.GcPause {
+		sample.GcPause = other.GcPause
+	}
+	if other.Fds > sample.Fds {
+		sample.Fds = other.Fds
+		sample.FdLimit = other.FdLimit
+	}
+	if other.Backlog > sample.Backlog {
+		sample.Backlog = other.Backlog
+		sample.BacklogCap = other.BacklogCap
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"insane/general/base/appconfig"
+	"sync"
+	"testing"
+)
+
+func TestGeneratorReportCheck(t *testing.T) {
+	config := appconfig.GetConfig().Monitor
+	defer func() { appconfig.GetConfig().Monitor = config }()
+	appconfig.GetConfig().Monitor = appconfig.Monitor{Cpu: 50}
+
+	cases := []struct {
+		name    string
+		sample  *GeneratorSample
+		metrics []string
+	}{
+		{name: "正常", sample: &GeneratorSample{SysCpu: 49, SysMem: 89, Goroutines: 10, Fds: 10, FdLimit: 100, Backlog: 1, BacklogCap: 100}},
+		{name: "配置的cpu上限", sample: &GeneratorSample{SysCpu: 50}, metrics: []string{MONITOR_CPU}},
+		{name: "默认的内存上限", sample: &GeneratorSample{SysMem: MONITOR_DEFAULT_MEM}, metrics: []string{MONITOR_MEM}},
+		{name: "协程数", sample: &GeneratorSample{Goroutines: MONITOR_DEFAULT_GOROUTINES}, metrics: []string{MONITOR_GOROUTINES}},
+		{name: "GC暂停按毫秒比较", sample: &GeneratorSample{GcPause: MONITOR_DEFAULT_GC_PAUSE * 1000}, metrics: []string{MONITOR_GC_PAUSE}},
+		{name: "文件描述符", sample: &GeneratorSample{Fds: 80, FdLimit: 100}, metrics: []string{MONITOR_FD}},
+		{name: "没有上限时不检查文件描述符", sample: &GeneratorSample{Fds: 80}},
+		{name: "结果通道积压", sample: &GeneratorSample{Backlog: 90, BacklogCap: 100, SysCpu: 60}, metrics: []string{MONITOR_CPU, MONITOR_BACKLOG}},
+	}
+	for _, c := range cases {
+		generator := newGeneratorReport()
+		generator.check(3, c.sample)
+		var metrics []string
+		for _, warning := range generator.Warnings {
+			metrics = append(metrics, warning.Metric)
+		}
+		if len(metrics) != len(c.metrics) || generator.Saturated != (len(c.metrics) > 0) {
+			t.Errorf("%s: warnings %v, saturated %t", c.name, metrics, generator.Saturated)
+			continue
+		}
+		for i := range metrics {
+			if metrics[i] != c.metrics[i] || generator.Warnings[i].First != 3 {
+				t.Errorf("%s: warning %+v", c.name, generator.Warnings[i])
+			}
+		}
+	}
+
+	// 同一指标多次超出只记录一条，保留最大值
+	generator := newGeneratorReport()
+	for second, cpu := range []float64{60, 80, 70} {
+		generator.check(uint64(second), &GeneratorSample{SysCpu: cpu})
+	}
+	if len(generator.Warnings) != 1 {
+		t.Fatalf("warnings = %d", len(generator.Warnings))
+	}
+	warning := generator.Warnings[0]
+	if warning.Seconds != 3 || warning.Max != 80 || warning.First != 0 || warning.Limit != 50 {
+		t.Errorf("warning = %+v", warning)
+	}
+}
+
+func TestGeneratorReportMerge(t *testing.T) {
+	generator := newGeneratorReport()
+	generator.Samples[1] = &GeneratorSample{Cpu: 10, Fds: 5, FdLimit: 100}
+	generator.Warnings = []*GeneratorWarning{{Metric: MONITOR_CPU, Max: 95, First: 4, Seconds: 2, Message: "a"}}
+	other := newGeneratorReport()
+	other.Samples[1] = &GeneratorSample{Cpu: 5, Fds: 50, FdLimit: 64}
+	other.Samples[2] = &GeneratorSample{Goroutines: 3}
+	other.Warnings = []*GeneratorWarning{
+		{Metric: MONITOR_CPU, Max: 99, First: 2, Seconds: 1, Message: "b"},
+		{Metric: MONITOR_MEM, Max: 91, First: 5, Seconds: 1},
+	}
+	other.Saturated = true
+
+	generator.merge(other)
+	if s := generator.Samples[1]; s.Cpu != 10 || s.Fds != 50 || s.FdLimit != 64 || generator.Samples[2].Goroutines != 3 {
+		t.Errorf("samples = %+v %+v", generator.Samples[1], generator.Samples[2])
+	}
+	cpu := generator.Warnings[0]
+	if len(generator.Warnings) != 2 || cpu.Seconds != 3 || cpu.First != 2 || cpu.Max != 99 || cpu.Message != "b" || !generator.Saturated {
+		t.Errorf("warnings = %+v %+v", cpu, generator.Warnings)
+	}
+	// 合并的告警是副本，不影响其他节点的报告
+	generator.Warnings[1].Seconds++
+	if other.Warnings[1].Seconds != 1 {
+		t.Error("merged warning should be copied")
+	}
+}
+
+func TestGeneratorMonitorSample(t *testing.T) {
+	var m sync.Mutex
+	monitor := newGeneratorMonitor(newGeneratorReport(), &m, func() (int, int) { return 3, 10 })
+	sample := monitor.sample()
+	if sample.Goroutines == 0 || sample.Backlog != 3 || sample.BacklogCap != 10 {
+		t.Errorf("sample = %+v", sample)
+	}
+	go monitor.run()
+	monitor.close()
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"insane/utils"
+	"net/url"
+	"strings"
+)
+
+const OPENAPI_MAX_DEPTH = 8 // $ref/嵌套对象解析的最大深度，避免循环引用
+
+var openApiMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+type OpenApi struct {
+	root    gjson.Result
+	baseUrl string
+}
+
+// 将OpenAPI 3文档（json或yaml）转换为脚本步骤，baseUrl为空时使用servers中的第一个地址
+func ParseOpenApi(doc string, baseUrl string) (steps []*ScriptStep, err error) {
+	if !gjson.Valid(doc) {
+		if doc, err = utils.YamlToJson(doc); err != nil {
+			return nil, err
+		}
+	}
+	openApi := &OpenApi{root: gjson.Parse(doc)}
+	if !strings.HasPrefix(openApi.root.Get("openapi").String(), "3") {
+		return nil, errors.New("仅支持OpenAPI 3文档")
+	}
+
+	openApi.baseUrl = strings.TrimRight(baseUrl, "/")
+	if openApi.baseUrl == "" {
+		openApi.baseUrl = openApi.serverUrl()
+	}
+	if !strings.Contains(openApi.baseUrl, "://") {
+		return nil, errors.New("文档中没有可用的服务地址，请指定baseUrl")
+	}
+
+	openApi.root.Get("paths").ForEach(func(path, pathItem gjson.Result) bool {
+		pathItem = openApi.resolve(pathItem)
+		pathItem.ForEach(func(method, operation gjson.Result) bool {
+			if openApiMethods[method.String()] {
+				steps = append(steps, &ScriptStep{
+					Data: openApi.operationRequest(path.String(), strings.ToUpper(method.String()), pathItem, operation),
+				})
+			}
+			return true
+		})
+		return true
+	})
+
+	if len(steps) == 0 {
+		return nil, errors.New("文档中没有可用的接口")
+	}
+	return
+}
+
+func (openApi *OpenApi) serverUrl() string {
+	server := openApi.root.Get("servers.0")
+	serverUrl := server.Get("url").String()
+	server.Get("variables").ForEach(func(key, value gjson.Result) bool {
+		serverUrl = strings.Replace(serverUrl, "{"+key.String()+"}", value.Get("default").String(), -1)
+		return true
+	})
+	return strings.TrimRight(serverUrl, "/")
+}
+
+func (openApi *OpenApi) operationRequest(path, method string, pathItem, operation gjson.Result) *HttpRequest {
+	httpRequest := GenerateHttpRequest(false)
+	httpRequest.Header = make(map[string]string)
+	httpRequest.Method = method
+
+	httpRequest.Name = operation.Get("operationId").String()
+	if httpRequest.Name == "" {
+		httpRequest.Name = operation.Get("summary").String()
+	}
+	if httpRequest.Name == "" {
+		httpRequest.Name = fmt.Sprintf("%s %s", method, path)
+	}
+
+	// 接口参数覆盖路径参数
+	params := make(map[string]gjson.Result)
+	var order []string
+	for _, list := range []gjson.Result{pathItem.Get("parameters"), operation.Get("parameters")} {
+		for _, param := range list.Array() {
+			param = openApi.resolve(param)
+			key := param.Get("in").String() + ":" + param.Get("name").String()
+			if _, ok := params[key]; !ok {
+				order = append(order, key)
+			}
+			params[key] = param
+		}
+	}
+
+	query := url.Values{}
+	var cookies []string
+	for _, key := range order {
+		param := params[key]
+		name := param.Get("name").String()
+		value := fmt.Sprint(openApi.paramValue(param))
+		switch param.Get("in").String() {
+		case "path":
+			path = strings.Replace(path, "{"+name+"}", url.PathEscape(value), -1)
+		case "query":
+			if param.Get("required").Bool() || param.Get("example").Exists() || param.Get("schema.example").Exists() {
+				query.Set(name, value)
+			}
+		case "header":
+			httpRequest.Header[name] = value
+		case "cookie":
+			cookies = append(cookies, name+"="+value)
+		}
+	}
+
+	httpRequest.Url = openApi.baseUrl + path
+	if len(query) > 0 {
+		httpRequest.Url += "?" + query.Encode()
+	}
+	httpRequest.Cookie = strings.Join(cookies, "; ")
+
+	openApi.requestBody(httpRequest, openApi.resolve(operation.Get("requestBody")))
+	return httpRequest
+}
+
+// 根据请求体的schema生成body字段
+func (openApi *OpenApi) requestBody(httpRequest *HttpRequest, requestBody gjson.Result) {
+	if !requestBody.Exists() {
+		return
+	}
+	var (
+		contentType string
+		media       gjson.Result
+	)
+	requestBody.Get("content").ForEach(func(key, value gjson.Result) bool {
+		if contentType == "" {
+			contentType, media = key.String(), value
+		}
+		if strings.Contains(key.String(), "json") {
+			contentType, media = key.String(), value
+			return false // 优先使用json
+		}
+		return true
+	})
+	if contentType == "" {
+		return
+	}
+
+	schema := openApi.resolve(media.Get("schema"))
+	properties := openApi.properties(schema, 0)
+	mediaType := strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/form-data"):
+		httpRequest.BodyType = BODY_TYPE_MULTIPART
+	case strings.HasPrefix(mediaType, "application/octet-stream") || schema.Get("format").String() == "binary":
+		// 二进制请求体需要指定上传文件
+		httpRequest.BodyType = BODY_TYPE_BINARY
+	case len(properties) == 0 || (!strings.Contains(mediaType, "json") && !strings.Contains(mediaType, "x-www-form-urlencoded")):
+		// 非对象或非json、表单格式，使用示例值作为raw请求体
+		httpRequest.BodyType = BODY_TYPE_RAW
+		if example := media.Get("example"); example.Exists() {
+			httpRequest.RawBody = openApiRaw(example.Value())
+		} else {
+			httpRequest.RawBody = openApiRaw(openApi.sample(schema, 0))
+		}
+		properties = nil
+	}
+	for _, property := range properties {
+		httpRequest.HttpBody.Body = append(httpRequest.HttpBody.Body, openApi.bodyField(property.name, property.schema, 0))
+	}
+	if httpRequest.BodyType != BODY_TYPE_MULTIPART {
+		httpRequest.Header["Content-Type"] = contentType
+	}
+}
+
+func openApiRaw(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+type openApiProperty struct {
+	name   string
+	schema gjson.Result
+}
+
+// 对象的所有属性，合并allOf
+func (openApi *OpenApi) properties(schema gjson.Result, depth int) (properties []openApiProperty) {
+	if depth > OPENAPI_MAX_DEPTH {
+		return
+	}
+	schema.Get("properties").ForEach(func(key, value gjson.Result) bool {
+		properties = append(properties, openApiProperty{name: key.String(), schema: openApi.resolve(value)})
+		return true
+	})
+	for _, sub := range schema.Get("allOf").Array() {
+		properties = append(properties, openApi.properties(openApi.resolve(sub), depth+1)...)
+	}
+	return
+}
+
+// schema字段映射为对应的生成器
+func (openApi *OpenApi) bodyField(name string, schema gjson.Result, depth int) *BodyField {
+	field := &BodyField{Name: name}
+	if example := openApi.example(schema); example != nil {
+		field.Type = "string"
+		field.Default = example
+		return field
+	}
+
+	if enum := schema.Get("enum").Array(); len(enum) > 0 {
+		values := make([]string, 0, len(enum))
+		for _, v := range enum {
+			values = append(values, v.String())
+		}
+		field.Type = "enum"
+		field.Dynamic = strings.Join(values, ",")
+		return field
+	}
+
+	schemaType := schema.Get("type").String()
+	if schemaType == "" && (schema.Get("properties").Exists() || schema.Get("allOf").Exists()) {
+		schemaType = "object"
+	}
+	switch schemaType {
+	case "integer", "number":
+		field.Type = "int"
+		field.Len = 5
+		if max := schema.Get("maximum"); max.Exists() {
+			field.Len = int64(len(fmt.Sprint(max.Int()))) - 1
+			if field.Len < 1 {
+				field.Len = 1
+			}
+		}
+	case "boolean":
+		field.Type = "bool"
+	case "object":
+		field.Type = "object"
+		if depth >= OPENAPI_MAX_DEPTH {
+			field.Default = map[string]interface{}{}
+			break
+		}
+		for _, property := range openApi.properties(schema, depth) {
+			field.Children = append(field.Children, openApi.bodyField(property.name, property.schema, depth+1))
+		}
+	case "array":
+		field.Type = "array"
+		field.Len = 1
+		if depth >= OPENAPI_MAX_DEPTH {
+			field.Default = []interface{}{}
+			break
+		}
+		field.Children = []*BodyField{openApi.bodyField("", openApi.resolve(schema.Get("items")), depth+1)}
+	default:
+		field.Type = "string"
+		if schema.Get("format").String() == "binary" {
+			// multipart中的文件字段，需要指定上传文件
+			field.Type = "upload"
+			return field
+		}
+		if sample, ok := openApiFormatSample[schema.Get("format").String()]; ok {
+			field.Default = sample
+			return field
+		}
+		field.Len = 10
+		if max := schema.Get("maxLength").Int(); max > 0 && max < field.Len {
+			field.Len = max
+		}
+		if min := schema.Get("minLength").Int(); min > field.Len {
+			field.Len = min
+		}
+	}
+	return field
+}
+
+// 有格式要求的字符串，随机字符串无法通过校验，使用固定示例值
+var openApiFormatSample = map[string]string{
+	"date":      "2020-01-01",
+	"date-time": "2020-01-01T00:00:00Z",
+	"email":     "insane@example.com",
+	"uuid":      "00000000-0000-4000-8000-000000000000",
+	"uri":       "http://example.com",
+	"ipv4":      "127.0.0.1",
+}
+
+func (openApi *OpenApi) example(schema gjson.Result) interface{} {
+	for _, key := range []string{"example", "default"} {
+		if v := schema.Get(key); v.Exists() {
+			return v.Value()
+		}
+	}
+	return nil
+}
+
+func (openApi *OpenApi) paramValue(param gjson.Result) interface{} {
+	if v := param.Get("example"); v.Exists() {
+		return v.Value()
+	}
+	return openApi.sample(openApi.resolve(param.Get("schema")), 0)
+}
+
+// 按schema生成一个固定的示例值
+func (openApi *OpenApi) sample(schema gjson.Result, depth int) interface{} {
+	if example := openApi.example(schema); example != nil {
+		return example
+	}
+	if enum := schema.Get("enum.0"); enum.Exists() {
+		return enum.Value()
+	}
+	if depth > OPENAPI_MAX_DEPTH {
+		return nil
+	}
+	switch schema.Get("type").String() {
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	case "array":
+		return []interface{}{openApi.sample(openApi.resolve(schema.Get("items")), depth+1)}
+	case "object", "":
+		properties := openApi.properties(schema, depth)
+		if len(properties) == 0 && schema.Get("type").String() == "" {
+			return "string"
+		}
+		obj := make(map[string]interface{})
+		for _, property := range properties {
+			obj[property.name] = openApi.sample(property.schema, depth+1)
+		}
+		return obj
+	}
+	if sample, ok := openApiFormatSample[schema.Get("format").String()]; ok {
+		return sample
+	}
+	return "string"
+}
+
+// 解析本文档内的$ref引用
+func (openApi *OpenApi) resolve(node gjson.Result) gjson.Result {
+	for i := 0; i < OPENAPI_MAX_DEPTH; i++ {
+		ref := node.Get("$ref").String()
+		if !strings.HasPrefix(ref, "#/") {
+			return node
+		}
+		parts := strings.Split(ref[2:], "/")
+		for k, v := range parts {
+			v = strings.Replace(strings.Replace(v, "~1", "/", -1), "~0", "~", -1)
+			parts[k] = gjsonEscape(v)
+		}
+		node = openApi.root.Get(strings.Join(parts, "."))
+	}
+	return node
+}
+
+func gjsonEscape(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		if c == '.' || c == '*' || c == '?' || c == '|' || c == '#' || c == '@' || c == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"testing"
+)
+
+const openApiTestDoc = `{
+  "openapi": "3.0.1",
+  "servers": [{"url": "http://{host}/v1/", "variables": {"host": {"default": "api.local"}}}],
+  "paths": {
+    "/users/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "get": {
+        "operationId": "getUser",
+        "parameters": [
+          {"name": "fields", "in": "query", "schema": {"type": "string"}},
+          {"name": "lang", "in": "query", "required": true, "schema": {"enum": ["zh", "en"]}},
+          {"name": "X-Trace", "in": "header", "example": "t1"},
+          {"name": "sid", "in": "cookie", "schema": {"type": "string", "example": "s1"}}
+        ]
+      }
+    },
+    "/users": {
+      "post": {
+        "requestBody": {"$ref": "#/components/requestBodies/User"}
+      }
+    },
+    "/echo": {
+      "post": {
+        "requestBody": {"content": {"text/plain": {"schema": {"type": "string"}, "example": "hi"}}}
+      }
+    },
+    "/avatar": {
+      "put": {
+        "requestBody": {"content": {"multipart/form-data": {"schema": {"properties": {"file": {"type": "string", "format": "binary"}}}}}}
+      }
+    }
+  },
+  "components": {
+    "requestBodies": {
+      "User": {"content": {"application/x-www-form-urlencoded": {"schema": {"type": "object"}}, "application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}
+    },
+    "schemas": {
+      "Base": {"properties": {"email": {"type": "string", "format": "email"}}},
+      "User": {
+        "allOf": [{"$ref": "#/components/schemas/Base"}],
+        "properties": {
+          "name": {"type": "string", "minLength": 3, "maxLength": 8},
+          "age": {"type": "integer", "maximum": 150},
+          "role": {"type": "string", "enum": ["admin", "guest"]},
+          "active": {"type": "boolean", "default": true},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        }
+      }
+    }
+  }
+}`
+
+func TestParseOpenApi(t *testing.T) {
+	steps, err := ParseOpenApi(openApiTestDoc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(map[string]*HttpRequest)
+	for _, step := range steps {
+		requests[step.Data.Method+" "+step.Data.Name] = step.Data
+	}
+
+	get, ok := requests["GET getUser"]
+	if !ok {
+		t.Fatalf("getUser not found in %v", requests)
+	}
+	if get.Url != "http://api.local/v1/users/1?lang=zh" {
+		t.Errorf("url = %s", get.Url)
+	}
+	if get.Header["X-Trace"] != "t1" || get.Cookie != "sid=s1" {
+		t.Errorf("header = %v, cookie = %s", get.Header, get.Cookie)
+	}
+
+	post, ok := requests["POST POST /users"]
+	if !ok {
+		t.Fatalf("POST /users not found in %v", requests)
+	}
+	if post.Header["Content-Type"] != "application/json" || post.BodyType != "" {
+		t.Errorf("content-type = %s, body type = %s", post.Header["Content-Type"], post.BodyType)
+	}
+	cases := []struct {
+		name    string
+		typ     string
+		len     int64
+		dynamic string
+		value   interface{}
+	}{
+		{name: "name", typ: "string", len: 8},
+		{name: "age", typ: "int", len: 2},
+		{name: "role", typ: "enum", dynamic: "admin,guest"},
+		{name: "active", typ: "string", value: true},
+		{name: "email", typ: "string", value: "insane@example.com"},
+	}
+	fields := make(map[string]*BodyField)
+	for _, field := range post.HttpBody.Body {
+		fields[field.Name] = field
+	}
+	for _, c := range cases {
+		field, ok := fields[c.name]
+		if !ok {
+			t.Errorf("%s: field not found", c.name)
+			continue
+		}
+		if field.Type != c.typ || field.Len != c.len || field.Dynamic != c.dynamic {
+			t.Errorf("%s: %s %d %q, want %s %d %q", c.name, field.Type, field.Len, field.Dynamic, c.typ, c.len, c.dynamic)
+		}
+		if c.value != nil && field.Default != c.value {
+			t.Errorf("%s: default = %v, want %v", c.name, field.Default, c.value)
+		}
+	}
+	if tags := fields["tags"]; tags.Type != "array" || len(tags.Children) != 1 || tags.Children[0].Type != "string" {
+		t.Errorf("tags: %s %v", tags.Type, tags.Children)
+	}
+
+	echo := requests["POST POST /echo"]
+	if echo == nil || echo.BodyType != BODY_TYPE_RAW || echo.RawBody != "hi" || echo.Header["Content-Type"] != "text/plain" {
+		t.Errorf("echo = %+v", echo)
+	}
+	avatar := requests["PUT PUT /avatar"]
+	if avatar == nil {
+		t.Fatal("PUT /avatar not found")
+	}
+	if avatar.BodyType != BODY_TYPE_MULTIPART || len(avatar.HttpBody.Body) != 1 || avatar.HttpBody.Body[0].Type != "upload" {
+		t.Errorf("avatar = %+v", avatar)
+	}
+	if _, ok := avatar.Header["Content-Type"]; ok {
+		t.Errorf("avatar: content-type = %s", avatar.Header["Content-Type"])
+	}
+}
+
+func TestParseOpenApiError(t *testing.T) {
+	cases := []struct {
+		doc     string
+		baseUrl string
+	}{
+		{doc: `{"swagger": "2.0", "paths": {"/a": {"get": {}}}}`, baseUrl: "http://h"},
+		{doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {}}}}`},
+		{doc: `{"openapi": "3.0.0", "paths": {}}`, baseUrl: "http://h"},
+	}
+	for _, c := range cases {
+		if _, err := ParseOpenApi(c.doc, c.baseUrl); err == nil {
+			t.Errorf("%s: want error", c.doc)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/general/base/appconfig"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PUSH_DEFAULT_INTERVAL       = 1      // 默认聚合间隔（秒）
+	PUSH_DEFAULT_BATCH          = 500    // 默认每次发送的最大行数
+	PUSH_DEFAULT_RETRY_INTERVAL = 500    // 默认重试间隔（毫秒）
+	PUSH_DEFAULT_BUFFER         = 100000 // 默认缓存的最大行数
+	PUSH_DEFAULT_PACKET_SIZE    = 1400   // 默认udp数据报的最大字节数
+	PUSH_DEFAULT_PREFIX         = "insane"
+	PUSH_HTTP_TIMEOUT           = 5 // http发送超时（秒）
+)
+
+// 按间隔聚合正在执行的任务的指标，推送到InfluxDB与StatsD
+type Pusher struct {
+	dests []*pushDest
+}
+
+// 一个间隔内某个步骤的统计
+type pushWindow struct {
+	success   uint64
+	failure   uint64
+	errors    map[string]uint64
+	latency   *Histogram
+	bytesSent uint64
+	bytesRecv uint64
+}
+
+// 聚合后的一条数据，tags按顺序输出
+type pushPoint struct {
+	name   string // requests | errors | vus | generator
+	tags   []pushTag
+	fields []pushField
+	time   time.Time
+}
+
+type pushTag struct {
+	key   string
+	value string
+}
+
+type pushField struct {
+	key     string
+	value   float64
+	counter bool // 间隔内的计数，否则为当前值
+}
+
+// 推送目标，发送失败的行缓存到下次发送
+type pushDest struct {
+	name    string
+	format  func(point *pushPoint) []string
+	send    func(lines []string) error
+	pending []string
+	busy    bool
+	m       sync.Mutex
+}
+
+var InsanePush = new(Pusher)
+
+func (pusher *Pusher) enabled() bool {
+	config := appconfig.GetConfig().Push
+	return config.Influx.Url != "" || config.Statsd.Addr != ""
+}
+
+// 按配置的间隔推送，未配置推送目标时直接返回
+func (pusher *Pusher) Start() error {
+	config := appconfig.GetConfig().Push
+	if config.Influx.Url != "" {
+		dest, err := newInfluxDest(config.Influx)
+		if err != nil {
+			logger.Debug(err)
+			return err
+		}
+		pusher.dests = append(pusher.dests, dest)
+	}
+	if config.Statsd.Addr != "" {
+		dest, err := newStatsdDest(config.Statsd)
+		if err != nil {
+			logger.Debug(err)
+			return err
+		}
+		pusher.dests = append(pusher.dests, dest)
+	}
+	if len(pusher.dests) == 0 {
+		return nil
+	}
+
+	interval := config.Interval
+	if interval == 0 {
+		interval = PUSH_DEFAULT_INTERVAL
+	}
+	t := time.NewTicker(time.Duration(interval) * time.Second)
+	defer t.Stop()
+	for now := range t.C {
+		points := InsaneMetrics.collect(now, float64(interval))
+		if len(points) == 0 {
+			continue
+		}
+		for _, dest := range pusher.dests {
+			lines := make([]string, 0, len(points))
+			for _, point := range points {
+				lines = append(lines, dest.format(point)...)
+			}
+			dest.push(lines)
+		}
+	}
+	return nil
+}
+
+// 取出每个任务间隔内的统计，生成推送的数据
+func (metrics *Metrics) collect(now time.Time, seconds float64) (points []*pushPoint) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+	ids := make([]string, 0, len(metrics.tasks))
+	for id := range metrics.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		task := metrics.tasks[id]
+		taskTags := []pushTag{{"task", task.id}, {"scenario", task.scenario}}
+		steps := make([]string, 0, len(task.windows))
+		for step := range task.windows {
+			steps = append(steps, step)
+		}
+		sort.Strings(steps)
+		for _, step := range steps {
+			window := task.windows[step]
+			tags := append(append([]pushTag{}, taskTags...), pushTag{"step", step})
+			summary := window.latency.Summary()
+			points = append(points, &pushPoint{
+				name: "requests",
+				tags: tags,
+				fields: []pushField{
+					{key: "success", value: float64(window.success), counter: true},
+					{key: "failure", value: float64(window.failure), counter: true},
+					{key: "rps", value: float64(window.success) / seconds},
+					{key: "avg", value: float64(summary.Avg)},
+					{key: "p50", value: float64(summary.P50)},
+					{key: "p95", value: float64(summary.P95)},
+					{key: "p99", value: float64(summary.P99)},
+					{key: "max", value: float64(summary.Max)},
+					{key: "bytesSent", value: float64(window.bytesSent), counter: true},
+					{key: "bytesRecv", value: float64(window.bytesRecv), counter: true},
+				},
+				time: now,
+			})
+			types := make([]string, 0, len(window.errors))
+			for errType := range window.errors {
+				types = append(types, errType)
+			}
+			sort.Strings(types)
+			for _, errType := range types {
+				points = append(points, &pushPoint{
+					name:   "errors",
+					tags:   append(append([]pushTag{}, tags...), pushTag{"type", errType}),
+					fields: []pushField{{key: "count", value: float64(window.errors[errType]), counter: true}},
+					time:   now,
+				})
+			}
+		}
+		if len(task.windows) > 0 {
+			task.windows = make(map[string]*pushWindow)
+		}
+
+		// 只推送正在执行的任务的当前值
+		if task.vus == 0 {
+			continue
+		}
+		points = append(points, &pushPoint{
+			name:   "vus",
+			tags:   taskTags,
+			fields: []pushField{{key: "value", value: float64(task.vus)}},
+			time:   now,
+		})
+		if sample := task.generator; sample != nil {
+			points = append(points, &pushPoint{
+				name: "generator",
+				tags: taskTags,
+				fields: []pushField{
+					{key: "cpu", value: sample.Cpu},
+					{key: "sysCpu", value: sample.SysCpu},
+					{key: "mem", value: float64(sample.Mem)},
+					{key: "goroutines", value: float64(sample.Goroutines)},
+					{key: "backlog", value: float64(sample.Backlog)},
+				},
+				time: now,
+			})
+		}
+	}
+	return
+}
+
+func (window *pushWindow) add(data *Response) {
+	window.bytesSent += data.BytesSent
+	window.bytesRecv += data.BytesRecv
+	if !data.IsSuccess {
+		window.failure++
+		errType := data.ErrType
+		if errType == "" {
+			errType = ERR_TYPE_OTHER
+		}
+		window.errors[errType]++
+		return
+	}
+	window.success++
+	window.latency.Add(data.WasteTime)
+}
+
+// 加入发送队列，上一次发送未结束时只加入队列
+func (dest *pushDest) push(lines []string) {
+	config := appconfig.GetConfig().Push
+	limit := config.Buffer
+	if limit <= 0 {
+		limit = PUSH_DEFAULT_BUFFER
+	}
+	dest.m.Lock()
+	dest.pending = append(dest.pending, lines...)
+	if over := len(dest.pending) - limit; over > 0 {
+		logger.Debug(fmt.Sprintf("%s推送积压，丢弃最早的%d行", dest.name, over))
+		dest.pending = dest.pending[over:]
+	}
+	if dest.busy {
+		dest.m.Unlock()
+		return
+	}
+	dest.busy = true
+	dest.m.Unlock()
+	go dest.flush()
+}
+
+// 分批发送，重试后仍失败的批次放回队列等下次发送
+func (dest *pushDest) flush() {
+	config := appconfig.GetConfig().Push
+	size := config.Batch
+	if size <= 0 {
+		size = PUSH_DEFAULT_BATCH
+	}
+	retryInterval := config.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = PUSH_DEFAULT_RETRY_INTERVAL
+	}
+	for {
+		dest.m.Lock()
+		if len(dest.pending) == 0 {
+			dest.busy = false
+			dest.m.Unlock()
+			return
+		}
+		n := size
+		if n > len(dest.pending) {
+			n = len(dest.pending)
+		}
+		batch := dest.pending[:n:n]
+		dest.pending = dest.pending[n:]
+		dest.m.Unlock()
+
+		err := dest.send(batch)
+		for i := 0; err != nil && i < config.Retry; i++ {
+			time.Sleep(time.Duration(retryInterval) * time.Millisecond)
+			err = dest.send(batch)
+		}
+		if err != nil {
+			logger.Debug(dest.name, err)
+			dest.m.Lock()
+			dest.pending = append(batch, dest.pending...)
+			dest.busy = false
+			dest.m.Unlock()
+			return
+		}
+	}
+}
+
+// InfluxDB行协议，支持http与udp
+func newInfluxDest(config appconfig.Influx) (*pushDest, error) {
+	u, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, err
+	}
+	measurement := config.Measurement
+	if measurement == "" {
+		measurement = PUSH_DEFAULT_PREFIX
+	}
+	dest := &pushDest{
+		name: "influx",
+		format: func(point *pushPoint) []string {
+			return []string{influxLine(measurement, point)}
+		},
+	}
+	switch u.Scheme {
+	case "http", "https":
+		client := &http.Client{Timeout: PUSH_HTTP_TIMEOUT * time.Second}
+		dest.send = func(lines []string) error {
+			req, err := http.NewRequest(http.MethodPost, config.Url, strings.NewReader(strings.Join(lines, "\n")))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			if config.Token != "" {
+				req.Header.Set("Authorization", config.Token)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("influx返回状态码：%d", resp.StatusCode)
+			}
+			return nil
+		}
+	case "udp":
+		send, err := udpSender(u.Host, config.PacketSize)
+		if err != nil {
+			return nil, err
+		}
+		dest.send = send
+	default:
+		return nil, errors.New("influx地址必须是http | https | udp")
+	}
+	return dest, nil
+}
+
+// measurement,tag=value field=value 时间戳（纳秒）
+func influxLine(measurement string, point *pushPoint) string {
+	var buf strings.Builder
+	buf.WriteString(influxEscape(measurement + "_" + point.name))
+	for _, tag := range pushTags(point.tags) {
+		// 空值的标签不允许写入
+		if tag.value == "" {
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(influxEscape(tag.key))
+		buf.WriteByte('=')
+		buf.WriteString(influxEscape(tag.value))
+	}
+	for i, field := range point.fields {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(influxEscape(field.key))
+		buf.WriteByte('=')
+		if field.counter {
+			buf.WriteString(strconv.FormatUint(uint64(field.value), 10))
+			buf.WriteByte('i')
+		} else {
+			buf.WriteString(strconv.FormatFloat(field.value, 'f', -1, 64))
+		}
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(point.time.UnixNano(), 10))
+	return buf.String()
+}
+
+var influxReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`, "\n", " ")
+
+func influxEscape(value string) string {
+	return influxReplacer.Replace(value)
+}
+
+// StatsD数据报，计数为c，当前值为g
+func newStatsdDest(config appconfig.Statsd) (*pushDest, error) {
+	send, err := udpSender(config.Addr, config.PacketSize)
+	if err != nil {
+		return nil, err
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = PUSH_DEFAULT_PREFIX
+	}
+	return &pushDest{
+		name: "statsd",
+		format: func(point *pushPoint) []string {
+			return statsdLines(prefix, config.Dogstatsd, point)
+		},
+		send: send,
+	}, nil
+}
+
+// dogstatsd：insane.requests.success:10|c|#task:1,scenario:http
+// statsd：标签值按顺序拼接到指标名中，insane.1.http.requests.success:10|c
+func statsdLines(prefix string, dogstatsd bool, point *pushPoint) []string {
+	tags := pushTags(point.tags)
+	name := prefix + "." + point.name
+	var suffix string
+	if dogstatsd {
+		list := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if tag.value != "" {
+				list = append(list, statsdEscape(tag.key)+":"+statsdEscape(tag.value))
+			}
+		}
+		if len(list) > 0 {
+			suffix = "|#" + strings.Join(list, ",")
+		}
+	} else {
+		parts := []string{prefix}
+		for _, tag := range tags {
+			if tag.value != "" {
+				parts = append(parts, statsdEscape(strings.Replace(tag.value, ".", "_", -1)))
+			}
+		}
+		name = strings.Join(append(parts, point.name), ".")
+	}
+
+	lines := make([]string, 0, len(point.fields))
+	for _, field := range point.fields {
+		kind := "g"
+		if field.counter {
+			kind = "c"
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s:%s|%s%s", name, field.key, strconv.FormatFloat(field.value, 'f', -1, 64), kind, suffix))
+	}
+	return lines
+}
+
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+func statsdEscape(value string) string {
+	return statsdReplacer.Replace(value)
+}
+
+// 配置的全局标签在前，按名称排序
+func pushTags(tags []pushTag) []pushTag {
+	global := appconfig.GetConfig().Push.Tags
+	if len(global) == 0 {
+		return tags
+	}
+	keys := make([]string, 0, len(global))
+	for key := range global {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]pushTag, 0, len(keys)+len(tags))
+	for _, key := range keys {
+		list = append(list, pushTag{key, global[key]})
+	}
+	return append(list, tags...)
+}
+
+// 多行合并到一个数据报中，不超过packetSize
+func udpSender(addr string, packetSize int) (func(lines []string) error, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if packetSize <= 0 {
+		packetSize = PUSH_DEFAULT_PACKET_SIZE
+	}
+	return func(lines []string) error {
+		var packet []byte
+		for _, line := range lines {
+			if len(packet) > 0 && len(packet)+1+len(line) > packetSize {
+				if _, err := conn.Write(packet); err != nil {
+					return err
+				}
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+		if len(packet) > 0 {
+			_, err := conn.Write(packet)
+			return err
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const PUSH_LISTEN_BUFFER = 65536 // udp数据报的最大字节数
+
+// 本地模拟的接收端，打印收到的每一行，用于验证推送配置
+// udp://:8125 模拟StatsD或InfluxDB的udp接口，http://:8086 模拟InfluxDB的/write接口
+func PushListen(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "udp":
+		conn, err := net.ListenPacket("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		logger.Info("push listener on ", addr)
+		buf := make([]byte, PUSH_LISTEN_BUFFER)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				fmt.Println(line)
+			}
+		}
+	case "http":
+		logger.Info("push listener on ", addr)
+		return http.ListenAndServe(u.Host, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer r.Body.Close()
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				fmt.Println(scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				logger.Debug(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	return errors.New("监听地址必须是udp | http")
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"insane/general/base/appconfig"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxLine(t *testing.T) {
+	config := appconfig.GetConfig().Push
+	defer func() { appconfig.GetConfig().Push = config }()
+
+	now := time.Unix(1, 5)
+	point := &pushPoint{
+		name:   "requests",
+		tags:   []pushTag{{"task", "t 1"}, {"scenario", "http"}, {"step", ""}},
+		fields: []pushField{{key: "success", value: 10, counter: true}, {key: "p95", value: 12.5}},
+		time:   now,
+	}
+	cases := []struct {
+		tags map[string]string
+		want string
+	}{
+		{want: `insane_requests,task=t\ 1,scenario=http success=10i,p95=12.5 1000000005`},
+		{tags: map[string]string{"region": "a,b", "env": "dev"}, want: `insane_requests,env=dev,region=a\,b,task=t\ 1,scenario=http success=10i,p95=12.5 1000000005`},
+	}
+	for _, c := range cases {
+		appconfig.GetConfig().Push.Tags = c.tags
+		if got := influxLine("insane", point); got != c.want {
+			t.Errorf("%v: %s, want %s", c.tags, got, c.want)
+		}
+	}
+}
+
+func TestStatsdLines(t *testing.T) {
+	config := appconfig.GetConfig().Push
+	defer func() { appconfig.GetConfig().Push = config }()
+	appconfig.GetConfig().Push.Tags = nil
+
+	point := &pushPoint{
+		name:   "errors",
+		tags:   []pushTag{{"task", "t:1"}, {"scenario", "http"}, {"step", "a.b"}},
+		fields: []pushField{{key: "count", value: 3, counter: true}, {key: "rps", value: 1.5}},
+	}
+	cases := []struct {
+		dogstatsd bool
+		want      []string
+	}{
+		{dogstatsd: true, want: []string{"insane.errors.count:3|c|#task:t_1,scenario:http,step:a.b", "insane.errors.rps:1.5|g|#task:t_1,scenario:http,step:a.b"}},
+		{want: []string{"insane.t_1.http.a_b.errors.count:3|c", "insane.t_1.http.a_b.errors.rps:1.5|g"}},
+	}
+	for _, c := range cases {
+		if got := statsdLines("insane", c.dogstatsd, point); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("dogstatsd %t: %q, want %q", c.dogstatsd, got, c.want)
+		}
+	}
+}
+
+func TestMetricsCollect(t *testing.T) {
+	metrics := &Metrics{tasks: make(map[string]*TaskMetrics)}
+	task := metrics.task("t1", TYPE_HTTP)
+	task.windows = make(map[string]*pushWindow)
+	task.add("login", &Response{IsSuccess: true, WasteTime: 10, BytesSent: 5})
+	task.add("login", &Response{IsSuccess: true, WasteTime: 30, BytesSent: 5})
+	task.add("login", &Response{ErrType: ERR_TYPE_TIMEOUT})
+	task.setVus(2)
+	metrics.task("t2", TYPE_GRPC) // 未推送且未执行的任务没有数据
+
+	now := time.Now()
+	points := metrics.collect(now, 2)
+	var names []string
+	for _, point := range points {
+		names = append(names, point.name)
+	}
+	if !reflect.DeepEqual(names, []string{"requests", "errors", "vus"}) {
+		t.Fatalf("points = %v", names)
+	}
+	fields := make(map[string]float64)
+	for _, field := range points[0].fields {
+		fields[field.key] = field.value
+	}
+	if fields["success"] != 2 || fields["failure"] != 1 || fields["rps"] != 1 || fields["bytesSent"] != 10 || fields["max"] < 30 {
+		t.Errorf("requests = %v", fields)
+	}
+	if tags := points[1].tags; len(tags) != 4 || tags[3].value != ERR_TYPE_TIMEOUT || !points[1].time.Equal(now) {
+		t.Errorf("errors = %+v", points[1])
+	}
+
+	// 每个间隔重新统计
+	task.setVus(0)
+	if points := metrics.collect(now, 1); len(points) != 0 {
+		t.Errorf("next interval = %d points", len(points))
+	}
+}
+
+func TestPushDest(t *testing.T) {
+	config := appconfig.GetConfig().Push
+	defer func() { appconfig.GetConfig().Push = config }()
+	appconfig.GetConfig().Push = appconfig.Push{Batch: 2, Buffer: 3, Retry: 1, RetryInterval: 1}
+
+	var sent [][]string
+	fail := true
+	done := make(chan bool, 10)
+	dest := &pushDest{name: "test", send: func(lines []string) error {
+		defer func() { done <- true }()
+		if fail {
+			return errors.New("down")
+		}
+		sent = append(sent, lines)
+		return nil
+	}}
+
+	// 发送失败的批次放回队列，超出缓存时丢弃最早的行
+	dest.push([]string{"a", "b"})
+	<-done
+	<-done
+	waitPushIdle(dest)
+	dest.push([]string{"c", "d"})
+	<-done
+	<-done
+	waitPushIdle(dest)
+	if !reflect.DeepEqual(dest.pending, []string{"b", "c", "d"}) {
+		t.Fatalf("pending = %q", dest.pending)
+	}
+
+	fail = false
+	dest.push(nil)
+	<-done
+	<-done
+	waitPushIdle(dest)
+	if !reflect.DeepEqual(sent, [][]string{{"b", "c"}, {"d"}}) || len(dest.pending) != 0 {
+		t.Errorf("sent = %q, pending = %q", sent, dest.pending)
+	}
+}
+
+func waitPushIdle(dest *pushDest) {
+	for {
+		dest.m.Lock()
+		busy := dest.busy
+		dest.m.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUdpSender(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send, err := udpSender(conn.LocalAddr().String(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过数据报大小时拆分，单行超出时单独发送
+	if err := send([]string{"aaa", "bb", "cccccccccc", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	var packets []string
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(packets) < 3 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	if want := []string{"aaa\nbb", "cccccccccc", "d"}; strings.Join(packets, "|") != strings.Join(want, "|") {
+		t.Errorf("packets = %q", packets)
+	}
+}
@@ -0,0 +1,599 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/tidwall/gjson"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RECORD_MIN_VALUE_LEN  = 6    // 长度小于该值的响应字段不参与变量提取检测
+	RECORD_MAX_VALUES     = 500  // 最多记录的响应值，超出时丢弃最早的
+	RECORD_MAX_CANDIDATES = 1000 // 最多记录的可提取变量
+)
+
+// 响应值的来源
+const (
+	RECORD_SOURCE_BODY   = "body"
+	RECORD_SOURCE_HEADER = "header"
+)
+
+// 不转发、不录制的逐跳header
+var recordHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// 录制时默认忽略的静态资源
+var recordStaticExt = map[string]bool{
+	".js": true, ".css": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".ico": true, ".woff": true, ".woff2": true, ".ttf": true, ".map": true, ".webp": true,
+}
+
+type Recorder struct {
+	Sessions  map[string]*RecordSession
+	active    *RecordSession
+	server    *http.Server
+	transport *http.Transport
+	ca        *tls.Certificate
+	caPem     []byte
+	certs     map[string]*tls.Certificate
+	tunnels   map[*recordTunnel]bool // 正在使用的CONNECT连接，停止录制时关闭
+	m         sync.Mutex
+}
+
+type RecordSession struct {
+	Id         string             `json:"id"`
+	Host       string             `json:"host"`   // 只录制域名包含该值的请求，为空时全部录制
+	Static     bool               `json:"static"` // 是否录制静态资源
+	Status     bool               `json:"status"` // 是否正在录制
+	Steps      []*ScriptStep      `json:"steps"`
+	Responses  []*RecordResponse  `json:"responses"`
+	Candidates []*RecordCandidate `json:"candidates"`
+	values     map[string]*recordSource
+	order      []string // 响应值的记录顺序，用于淘汰最早的值
+	m          sync.Mutex
+}
+
+type RecordResponse struct {
+	Name   string            `json:"name"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	Body   string            `json:"body"`
+}
+
+// 前面响应中出现、后面请求中又被使用的值，可作为变量提取
+type RecordCandidate struct {
+	Step       string `json:"step"`       // 使用该值的步骤
+	Location   string `json:"location"`   // url | header | cookie | body
+	Field      string `json:"field"`      // header名或body字段名
+	Value      string `json:"value"`      // 值
+	SourceStep string `json:"sourceStep"` // 值来源的步骤
+	Source     string `json:"source"`     // 值在来源响应中的位置 body | header
+	SourcePath string `json:"sourcePath"` // body中的json路径，或header名与cookie、参数名，如Set-Cookie.sid
+	Applied    bool   `json:"applied"`    // 已自动替换为response类型的body字段
+}
+
+type recordSource struct {
+	step     string
+	location string
+	path     []string
+}
+
+var InsaneRecorder = &Recorder{
+	Sessions: make(map[string]*RecordSession),
+	certs:    make(map[string]*tls.Certificate),
+	tunnels:  make(map[*recordTunnel]bool),
+	transport: &http.Transport{
+		Proxy: nil,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		MaxIdleConnsPerHost: 10,
+	},
+}
+
+// 开始录制，同一时间只能有一个录制任务
+func (recorder *Recorder) Start(host string, static bool) (session *RecordSession, err error) {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+	if recorder.active != nil {
+		return nil, errors.New("已有录制任务正在进行")
+	}
+	if err = recorder.loadCa(); err != nil {
+		return nil, err
+	}
+
+	bind := appconfig.GetConfig().Record.Bind
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		return nil, err
+	}
+	recorder.server = &http.Server{Handler: recorder}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Debug(err)
+		}
+	}(recorder.server)
+
+	session = &RecordSession{
+		Id:         strconv.FormatInt(utils.Now(), 10),
+		Host:       host,
+		Static:     static,
+		Status:     true,
+		Steps:      make([]*ScriptStep, 0),
+		Responses:  make([]*RecordResponse, 0),
+		Candidates: make([]*RecordCandidate, 0),
+		values:     make(map[string]*recordSource),
+	}
+	recorder.Sessions[session.Id] = session
+	recorder.active = session
+	logger.Debug("录制代理已启动: ", bind)
+	return session, nil
+}
+
+func (recorder *Recorder) Stop(id string) (session *RecordSession, err error) {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+	session, ok := recorder.Sessions[id]
+	if !ok {
+		return nil, errors.New("录制任务不存在")
+	}
+	if recorder.active == session {
+		recorder.active = nil
+		recorder.server.Close()
+		// 被劫持的连接不受server管理，需要单独关闭
+		for tunnel := range recorder.tunnels {
+			tunnel.Conn.Close()
+		}
+		recorder.tunnels = make(map[*recordTunnel]bool)
+		session.m.Lock()
+		session.Status = false
+		session.m.Unlock()
+	}
+	return session, nil
+}
+
+func (recorder *Recorder) Get(id string) (session *RecordSession, err error) {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+	session, ok := recorder.Sessions[id]
+	if !ok {
+		return nil, errors.New("录制任务不存在")
+	}
+	return session, nil
+}
+
+// 根证书，安装到浏览器或测试设备后才能录制https
+func (recorder *Recorder) CaPem() ([]byte, error) {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+	if err := recorder.loadCa(); err != nil {
+		return nil, err
+	}
+	return recorder.caPem, nil
+}
+
+// 录制过程中也可以查询，序列化时加锁
+func (session *RecordSession) MarshalJSON() ([]byte, error) {
+	type recordSessionAlias RecordSession
+	session.m.Lock()
+	defer session.m.Unlock()
+	return json.Marshal((*recordSessionAlias)(session))
+}
+
+func (recorder *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		recorder.handleConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "insane录制代理，请将设备的代理地址设置为本端口", http.StatusBadRequest)
+		return
+	}
+	recorder.forward(w, r)
+}
+
+// https请求：使用根证书签发的证书解密后再转发
+func (recorder *Recorder) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "不支持CONNECT", http.StatusInternalServerError)
+		return
+	}
+	hijacked, _, err := hijacker.Hijack()
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	conn := recorder.track(hijacked)
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	host := r.URL.Hostname()
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return recorder.certificate(name)
+		},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Debug(err)
+		tlsConn.Close()
+		return
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+		if req.URL.Host == "" {
+			req.URL.Host = r.Host
+		}
+		recorder.forward(w, req)
+	})
+	http.Serve(&recordConnListener{conn: tlsConn}, handler)
+}
+
+func (recorder *Recorder) forward(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	outReq, err := http.NewRequest(r.Method, r.URL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for k, v := range r.Header {
+		outReq.Header[k] = v
+	}
+	for _, k := range recordHopHeaders {
+		outReq.Header.Del(k)
+	}
+	// 录制需要明文响应
+	outReq.Header.Del("Accept-Encoding")
+
+	resp, err := recorder.transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	for _, k := range recordHopHeaders {
+		w.Header().Del(k)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(respBody)))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+
+	recorder.m.Lock()
+	session := recorder.active
+	recorder.m.Unlock()
+	if session != nil {
+		session.capture(outReq, reqBody, resp, respBody)
+	}
+}
+
+// 请求记录为脚本步骤，并检测其中是否使用了前面响应中的值
+func (session *RecordSession) capture(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte) {
+	if session.Host != "" && !strings.Contains(req.URL.Host, session.Host) {
+		return
+	}
+	if !session.Static && recordStaticExt[strings.ToLower(path.Ext(req.URL.Path))] {
+		return
+	}
+
+	session.m.Lock()
+	defer session.m.Unlock()
+	if !session.Status {
+		return
+	}
+
+	httpRequest := GenerateHttpRequest(false)
+	httpRequest.Name = fmt.Sprintf("%d %s %s", len(session.Steps)+1, req.Method, req.URL.Path)
+	httpRequest.Url = req.URL.String()
+	httpRequest.Method = req.Method
+	httpRequest.Header = make(map[string]string)
+	for k := range req.Header {
+		switch {
+		case k == "Cookie":
+			httpRequest.Cookie = strings.Join(req.Header[k], "; ")
+		case k != "Content-Length":
+			httpRequest.Header[k] = req.Header.Get(k)
+		}
+	}
+	if len(reqBody) > 0 {
+		if err := httpRequest.parseRawBody(string(reqBody)); err != nil {
+			logger.Debug(httpRequest.Name, " ", err)
+		}
+	}
+
+	session.detect(httpRequest)
+	session.Steps = append(session.Steps, &ScriptStep{Data: httpRequest})
+
+	header := make(map[string]string)
+	for k := range resp.Header {
+		header[k] = resp.Header.Get(k)
+	}
+	session.Responses = append(session.Responses, &RecordResponse{
+		Name:   httpRequest.Name,
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   string(respBody),
+	})
+
+	session.collectHeader(httpRequest.Name, resp)
+	if gjson.ValidBytes(respBody) {
+		session.collect(httpRequest.Name, gjson.ParseBytes(respBody), nil)
+	}
+}
+
+// 记录响应中可能被后续请求使用的值
+func (session *RecordSession) collect(step string, value gjson.Result, path []string) {
+	switch {
+	case value.IsObject():
+		value.ForEach(func(key, item gjson.Result) bool {
+			session.collect(step, item, append(append([]string{}, path...), gjsonEscape(key.String())))
+			return true
+		})
+	case value.IsArray():
+		for i, item := range value.Array() {
+			session.collect(step, item, append(append([]string{}, path...), strconv.Itoa(i)))
+		}
+	case value.Type == gjson.String || value.Type == gjson.Number:
+		if len(path) > 0 {
+			session.addValue(value.String(), &recordSource{step: step, location: RECORD_SOURCE_BODY, path: path})
+		}
+	}
+}
+
+// 记录Set-Cookie中的cookie值与Location中的参数值，常见于登录与跳转
+func (session *RecordSession) collectHeader(step string, resp *http.Response) {
+	for _, cookie := range resp.Cookies() {
+		session.addValue(cookie.Value, &recordSource{step: step, location: RECORD_SOURCE_HEADER, path: []string{"Set-Cookie", cookie.Name}})
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	for name, values := range location.Query() {
+		for _, value := range values {
+			session.addValue(value, &recordSource{step: step, location: RECORD_SOURCE_HEADER, path: []string{"Location", name}})
+		}
+	}
+}
+
+func (session *RecordSession) addValue(value string, source *recordSource) {
+	if len(value) < RECORD_MIN_VALUE_LEN {
+		return
+	}
+	if _, ok := session.values[value]; !ok {
+		if len(session.order) >= RECORD_MAX_VALUES {
+			delete(session.values, session.order[0])
+			session.order = session.order[1:]
+		}
+		session.order = append(session.order, value)
+	}
+	session.values[value] = source
+}
+
+func (session *RecordSession) detect(httpRequest *HttpRequest) {
+	for value, source := range session.values {
+		candidate := func(location, field string) *RecordCandidate {
+			c := &RecordCandidate{
+				Step:       httpRequest.Name,
+				Location:   location,
+				Field:      field,
+				Value:      value,
+				SourceStep: source.step,
+				Source:     source.location,
+				SourcePath: strings.Join(source.path, "."),
+			}
+			if len(session.Candidates) < RECORD_MAX_CANDIDATES {
+				session.Candidates = append(session.Candidates, c)
+			}
+			return c
+		}
+
+		if strings.Contains(httpRequest.Url, value) || strings.Contains(httpRequest.Url, url.QueryEscape(value)) {
+			candidate("url", "")
+		}
+		for k, v := range httpRequest.Header {
+			if strings.Contains(v, value) {
+				candidate("header", k)
+			}
+		}
+		if strings.Contains(httpRequest.Cookie, value) {
+			candidate("cookie", "")
+		}
+		for _, field := range httpRequest.HttpBody.Body {
+			def := utils.ConvString(field.Default)
+			if def == value && source.location == RECORD_SOURCE_BODY {
+				// 整个字段等于响应body中的值，直接改为从响应中提取
+				field.Type = "response"
+				field.Dynamic = source.step + HTTP_RESPONSE_FIELD_SEP + strings.Join(source.path, HTTP_RESPONSE_FIELD_SEP)
+				field.Default = nil
+				candidate("body", field.Name).Applied = true
+			} else if strings.Contains(def, value) {
+				candidate("body", field.Name)
+			}
+		}
+	}
+}
+
+func (recorder *Recorder) certificate(host string) (*tls.Certificate, error) {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+	if cert, ok := recorder.certs[host]; ok {
+		return cert, nil
+	}
+
+	caCert, err := x509.ParseCertificate(recorder.ca.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, recorder.ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, recorder.ca.Certificate[0]},
+		PrivateKey:  key,
+	}
+	recorder.certs[host] = cert
+	return cert, nil
+}
+
+// 读取根证书，不存在时生成并保存
+func (recorder *Recorder) loadCa() error {
+	if recorder.ca != nil {
+		return nil
+	}
+	certFile := appconfig.GetConfig().Record.CaCert
+	keyFile := appconfig.GetConfig().Record.CaKey
+
+	certPem, err1 := ioutil.ReadFile(certFile)
+	keyPem, err2 := ioutil.ReadFile(keyFile)
+	if err1 != nil || err2 != nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(utils.Now()),
+			Subject:               pkix.Name{CommonName: "insane record CA", Organization: []string{"insane"}},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().AddDate(10, 0, 0),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			return err
+		}
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		certPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		keyPem = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+		if err := utils.FileWrite(certFile, string(certPem)); err != nil {
+			logger.Debug(err)
+		}
+		if err := utils.FileWrite(keyFile, string(keyPem)); err != nil {
+			logger.Debug(err)
+		}
+	}
+
+	ca, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return err
+	}
+	recorder.ca = &ca
+	recorder.caPem = certPem
+	return nil
+}
+
+// 记录被劫持的连接，连接关闭时移除
+type recordTunnel struct {
+	net.Conn
+	recorder *Recorder
+	once     sync.Once
+}
+
+func (recorder *Recorder) track(conn net.Conn) *recordTunnel {
+	tunnel := &recordTunnel{Conn: conn, recorder: recorder}
+	recorder.m.Lock()
+	recorder.tunnels[tunnel] = true
+	recorder.m.Unlock()
+	return tunnel
+}
+
+func (tunnel *recordTunnel) Close() error {
+	tunnel.once.Do(func() {
+		tunnel.recorder.m.Lock()
+		delete(tunnel.recorder.tunnels, tunnel)
+		tunnel.recorder.m.Unlock()
+	})
+	return tunnel.Conn.Close()
+}
+
+// 把一个已建立的连接包装成Listener，交给http.Serve处理keep-alive、chunked等细节
+type recordConnListener struct {
+	conn net.Conn
+	once sync.Once
+}
+
+func (l *recordConnListener) Accept() (conn net.Conn, err error) {
+	err = io.EOF
+	l.once.Do(func() {
+		conn, err = l.conn, nil
+	})
+	return
+}
+
+func (l *recordConnListener) Close() error {
+	return nil
+}
+
+func (l *recordConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordExchange struct {
+	method     string
+	url        string
+	header     map[string]string
+	body       string
+	response   string
+	respHeader http.Header
+}
+
+func recordSession(host string, static bool, exchanges []recordExchange) *RecordSession {
+	session := &RecordSession{Host: host, Static: static, Status: true, values: make(map[string]*recordSource)}
+	for _, exchange := range exchanges {
+		req := httptest.NewRequest(exchange.method, exchange.url, strings.NewReader(exchange.body))
+		for k, v := range exchange.header {
+			req.Header.Set(k, v)
+		}
+		resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json"}}}
+		for k, v := range exchange.respHeader {
+			resp.Header[k] = v
+		}
+		session.capture(req, []byte(exchange.body), resp, []byte(exchange.response))
+	}
+	return session
+}
+
+func TestRecordSessionCapture(t *testing.T) {
+	cases := []struct {
+		name   string
+		host   string
+		static bool
+		url    string
+		steps  int
+	}{
+		{name: "全部录制", url: "http://api.local/users", steps: 1},
+		{name: "域名匹配", host: "api", url: "http://api.local/users", steps: 1},
+		{name: "域名不匹配", host: "cdn", url: "http://api.local/users", steps: 0},
+		{name: "忽略静态资源", url: "http://api.local/app.JS", steps: 0},
+		{name: "录制静态资源", static: true, url: "http://api.local/app.js", steps: 1},
+	}
+	for _, c := range cases {
+		session := recordSession(c.host, c.static, []recordExchange{{method: "GET", url: c.url}})
+		if len(session.Steps) != c.steps || len(session.Responses) != c.steps {
+			t.Errorf("%s: steps = %d, responses = %d, want %d", c.name, len(session.Steps), len(session.Responses), c.steps)
+		}
+	}
+}
+
+func TestRecordSessionDetect(t *testing.T) {
+	session := recordSession("", false, []recordExchange{
+		{method: "POST", url: "http://api.local/login", body: `{"user":"tom"}`, response: `{"data":{"token":"abcdef123"},"id":42,"list":[{"sku":"sku-000001"}]}`},
+		{
+			method: "POST",
+			url:    "http://api.local/orders?token=abcdef123",
+			header: map[string]string{"Authorization": "Bearer abcdef123", "Content-Type": "application/json"},
+			body:   `{"token":"abcdef123","note":"by abcdef123","sku":"sku-000001","id":42}`,
+		},
+	})
+	if len(session.Steps) != 2 {
+		t.Fatalf("steps = %d", len(session.Steps))
+	}
+
+	cases := []struct {
+		location string
+		field    string
+		value    string
+		path     string
+		applied  bool
+	}{
+		{location: "url", value: "abcdef123", path: "data.token"},
+		{location: "header", field: "Authorization", value: "abcdef123", path: "data.token"},
+		{location: "body", field: "token", value: "abcdef123", path: "data.token", applied: true},
+		{location: "body", field: "note", value: "abcdef123", path: "data.token"},
+		{location: "body", field: "sku", value: "sku-000001", path: "list.0.sku", applied: true},
+	}
+	found := make(map[string]*RecordCandidate)
+	for _, candidate := range session.Candidates {
+		found[candidate.Location+":"+candidate.Field+":"+candidate.Value] = candidate
+	}
+	if len(found) != len(cases) {
+		t.Errorf("candidates = %d, want %d", len(found), len(cases))
+	}
+	for _, c := range cases {
+		candidate, ok := found[c.location+":"+c.field+":"+c.value]
+		if !ok {
+			t.Errorf("%s %s %s: not detected", c.location, c.field, c.value)
+			continue
+		}
+		if candidate.SourcePath != c.path || candidate.Applied != c.applied || !strings.HasPrefix(candidate.SourceStep, "1 ") {
+			t.Errorf("%s %s: %+v", c.location, c.field, candidate)
+		}
+	}
+
+	for _, field := range session.Steps[1].Data.HttpBody.Body {
+		if field.Name == "token" && (field.Type != "response" || field.Dynamic != "1 POST /login---data---token") {
+			t.Errorf("token field = %+v", field)
+		}
+	}
+}
+
+func TestRecordSessionHeaderValues(t *testing.T) {
+	session := recordSession("", false, []recordExchange{
+		{
+			method: "POST",
+			url:    "http://api.local/login",
+			respHeader: http.Header{
+				"Set-Cookie": {"sid=session001; Path=/", "lang=zh"},
+				"Location":   {"/home?ticket=ticket001"},
+			},
+		},
+		{
+			method: "POST",
+			url:    "http://api.local/home?ticket=ticket001",
+			header: map[string]string{"Cookie": "sid=session001"},
+			body:   `{"sid":"session001"}`,
+		},
+	})
+
+	cases := map[string]string{
+		"url::ticket001":      "header:Location.ticket",
+		"cookie::session001":  "header:Set-Cookie.sid",
+		"body:sid:session001": "header:Set-Cookie.sid",
+	}
+	found := make(map[string]*RecordCandidate)
+	for _, candidate := range session.Candidates {
+		found[candidate.Location+":"+candidate.Field+":"+candidate.Value] = candidate
+	}
+	if len(found) != len(cases) {
+		t.Errorf("candidates = %d, want %d", len(found), len(cases))
+	}
+	for key, want := range cases {
+		candidate, ok := found[key]
+		if !ok {
+			t.Errorf("%s: not detected", key)
+			continue
+		}
+		// header中的值不能用response类型的字段提取，只作为候选
+		if got := candidate.Source + ":" + candidate.SourcePath; got != want || candidate.Applied {
+			t.Errorf("%s: %+v", key, candidate)
+		}
+	}
+}
+
+func TestRecordSessionLimit(t *testing.T) {
+	session := recordSession("", false, nil)
+	for i := 0; i < RECORD_MAX_VALUES+10; i++ {
+		session.addValue(fmt.Sprintf("value%04d", i), &recordSource{location: RECORD_SOURCE_BODY, path: []string{"v"}})
+	}
+	session.addValue("value0100", &recordSource{location: RECORD_SOURCE_HEADER})
+	if len(session.values) != RECORD_MAX_VALUES || len(session.order) != RECORD_MAX_VALUES {
+		t.Fatalf("values = %d, order = %d", len(session.values), len(session.order))
+	}
+	if _, ok := session.values["value0009"]; ok {
+		t.Error("oldest value not dropped")
+	}
+	if session.values["value0100"].location != RECORD_SOURCE_HEADER {
+		t.Error("existing value not updated")
+	}
+
+	var body []string
+	for i := 10; i < 20; i++ {
+		body = append(body, fmt.Sprintf("value%04d", i))
+	}
+	for len(session.Candidates) < RECORD_MAX_CANDIDATES {
+		session.detect(&HttpRequest{Name: "x", Url: strings.Join(body, ","), HttpBody: &HttpBody{}})
+	}
+	session.detect(&HttpRequest{Name: "x", Url: strings.Join(body, ","), HttpBody: &HttpBody{}})
+	if len(session.Candidates) != RECORD_MAX_CANDIDATES {
+		t.Errorf("candidates = %d", len(session.Candidates))
+	}
+}
+
+func TestRecorderStopTunnels(t *testing.T) {
+	session := &RecordSession{Id: "1", Status: true}
+	recorder := &Recorder{
+		Sessions: map[string]*RecordSession{session.Id: session},
+		active:   session,
+		server:   &http.Server{},
+		tunnels:  make(map[*recordTunnel]bool),
+	}
+	closed, client := net.Pipe()
+	defer client.Close()
+	recorder.track(closed)
+	done, other := net.Pipe()
+	defer other.Close()
+	recorder.track(done).Close()
+	if len(recorder.tunnels) != 1 {
+		t.Fatalf("tunnels = %d", len(recorder.tunnels))
+	}
+
+	if _, err := recorder.Stop(session.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF || len(recorder.tunnels) != 0 || session.Status {
+		t.Errorf("read = %v, tunnels = %d", err, len(recorder.tunnels))
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/tidwall/gjson"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REPLAY_FORMAT_COMBINED = "combined"
+	REPLAY_FORMAT_REGEX    = "regex"
+	REPLAY_FORMAT_JSON     = "json"
+	REPLAY_MODE_TIMING     = "timing" // 按日志中的请求间隔回放
+	REPLAY_MODE_RATE       = "rate"   // 按固定速率回放
+
+	REPLAY_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+)
+
+// nginx/apache combined（兼容common）格式
+var replayCombinedRegexp = regexp.MustCompile(`^\S+ \S+ \S+ \[(?P<time>[^\]]+)\] "(?P<method>[A-Z]+) (?P<path>\S+)[^"]*" \d{3}`)
+
+var (
+	replayUuidRegexp  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	replayHashRegexp  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	replayNumRegexp   = regexp.MustCompile(`^\d+$`)
+	replayTokenRegexp = regexp.MustCompile(`^[0-9A-Za-z_\-]{20,}$`)
+	replayDigitRegexp = regexp.MustCompile(`\d`)
+)
+
+type ReplayRequest struct {
+	File       string            `json:"file"`       // 上传的日志文件名
+	Format     string            `json:"format"`     // combined | regex | json
+	Pattern    string            `json:"pattern"`    // regex格式的正则，需要包含method、path命名分组，timing模式还需要time分组
+	Fields     map[string]string `json:"fields"`     // json格式中time、method、path对应的字段
+	TimeLayout string            `json:"timeLayout"` // 时间格式，默认combined格式
+	Target     string            `json:"target"`     // 目标地址，替换日志中的域名，如 http://127.0.0.1:8080
+	Mode       string            `json:"mode"`       // timing | rate
+	Speed      float64           `json:"speed"`      // timing模式的回放倍速
+	Rate       uint64            `json:"rate"`       // rate模式每秒请求数
+	Templates  []string          `json:"templates"`  // 路径模板，如 /users/{id}，未匹配的路径自动生成模板
+	Header     map[string]string `json:"header"`
+
+	entries []*replayEntry
+	queue   chan *replaySend
+	done    chan int
+}
+
+type replayEntry struct {
+	offset time.Duration // 距离最早一条日志的时间
+	method string
+	path   string
+	group  string
+}
+
+// 分发给协程的请求，协程取到时晚于计划的时间记为延迟
+type replaySend struct {
+	entry     *replayEntry
+	due       time.Time     // 计划发送时间
+	generator time.Duration // 分发协程自身唤醒的延迟
+}
+
+// 读取并解析日志
+func (replayRequest *ReplayRequest) Load() (err error) {
+	if replayRequest.File == "" || replayRequest.Target == "" {
+		return errors.New("日志文件和目标地址不能为空")
+	}
+	if !strings.Contains(replayRequest.Target, "://") {
+		replayRequest.Target = "http://" + replayRequest.Target
+	}
+	replayRequest.Target = strings.TrimRight(replayRequest.Target, "/")
+	if replayRequest.Mode == "" {
+		replayRequest.Mode = REPLAY_MODE_TIMING
+	}
+	if replayRequest.Speed <= 0 {
+		replayRequest.Speed = 1
+	}
+	if replayRequest.Mode == REPLAY_MODE_RATE && (replayRequest.Rate == 0 || replayRequest.Rate > SCHEDULE_MAX_RATE) {
+		return fmt.Errorf("rate模式需要设置每秒请求数，范围1-%d", SCHEDULE_MAX_RATE)
+	}
+
+	parse, err := replayRequest.parser()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(uploadFilePath(replayRequest.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		first   time.Time // 最早一条日志的时间
+		times   []time.Time
+		skipped int
+	)
+	replayRequest.entries = make([]*replayEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		t, method, path, err := parse(line)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			// 日志中是完整url时去掉域名
+			if n := strings.Index(path, "://"); n >= 0 {
+				path = path[n+3:]
+				if n = strings.Index(path, "/"); n >= 0 {
+					path = path[n:]
+				} else {
+					path = "/"
+				}
+			} else {
+				path = "/" + path
+			}
+		}
+
+		entry := &replayEntry{method: method, path: path, group: replayRequest.PathTemplate(path)}
+		if !t.IsZero() {
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+		} else if replayRequest.Mode == REPLAY_MODE_TIMING {
+			return errors.New("timing模式需要日志中包含请求时间")
+		}
+		replayRequest.entries = append(replayRequest.entries, entry)
+		times = append(times, t)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(replayRequest.entries) == 0 {
+		return errors.New("日志中没有可回放的请求")
+	}
+	for i, entry := range replayRequest.entries {
+		if !times[i].IsZero() {
+			entry.offset = times[i].Sub(first)
+		}
+	}
+	// 日志不一定按时间顺序，timing模式按时间排序，循环的长度为最后一条的时间
+	if replayRequest.Mode == REPLAY_MODE_TIMING {
+		sort.SliceStable(replayRequest.entries, func(i, j int) bool {
+			return replayRequest.entries[i].offset < replayRequest.entries[j].offset
+		})
+	}
+	logger.Debug(fmt.Sprintf("回放日志%s: %d条请求，跳过%d行", replayRequest.File, len(replayRequest.entries), skipped))
+	return nil
+}
+
+// 按格式返回单行日志的解析函数
+func (replayRequest *ReplayRequest) parser() (func(line string) (time.Time, string, string, error), error) {
+	layout := replayRequest.TimeLayout
+	parseTime := func(s string) (time.Time, error) {
+		if s == "" {
+			return time.Time{}, nil
+		}
+		if layout == "" {
+			return time.Parse(REPLAY_TIME_LAYOUT, s)
+		}
+		return time.Parse(layout, s)
+	}
+
+	switch replayRequest.Format {
+	case REPLAY_FORMAT_COMBINED, "":
+		return replayRegexpParser(replayCombinedRegexp, parseTime), nil
+
+	case REPLAY_FORMAT_REGEX:
+		re, err := regexp.Compile(replayRequest.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		names := strings.Join(re.SubexpNames(), ",")
+		if !strings.Contains(names, "method") || !strings.Contains(names, "path") {
+			return nil, errors.New("正则需要包含method、path命名分组")
+		}
+		return replayRegexpParser(re, parseTime), nil
+
+	case REPLAY_FORMAT_JSON:
+		fields := map[string]string{"time": "time", "method": "method", "path": "path"}
+		for k, v := range replayRequest.Fields {
+			fields[k] = v
+		}
+		return func(line string) (t time.Time, method, path string, err error) {
+			if !gjson.Valid(line) {
+				return t, "", "", errors.New("invalid json")
+			}
+			data := gjson.Parse(line)
+			method = strings.ToUpper(data.Get(fields["method"]).String())
+			path = data.Get(fields["path"]).String()
+			if method == "" || path == "" {
+				return t, "", "", errors.New("缺少method或path")
+			}
+			value := data.Get(fields["time"])
+			if value.Type == gjson.Number {
+				// 时间戳：秒或毫秒
+				n := value.Float()
+				if n > 1e12 {
+					n = n / 1000
+				}
+				sec := int64(n)
+				return time.Unix(sec, int64((n-float64(sec))*1e9)), method, path, nil
+			}
+			if layout == "" && value.String() != "" {
+				t, err = time.Parse(time.RFC3339Nano, value.String())
+			} else {
+				t, err = parseTime(value.String())
+			}
+			return
+		}, nil
+	}
+	return nil, errors.New("日志格式必须是combined | regex | json")
+}
+
+func replayRegexpParser(re *regexp.Regexp, parseTime func(string) (time.Time, error)) func(line string) (time.Time, string, string, error) {
+	return func(line string) (t time.Time, method, path string, err error) {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			return t, "", "", errors.New("日志格式不匹配")
+		}
+		var timeStr string
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "time":
+				timeStr = match[i]
+			case "method":
+				method = strings.ToUpper(match[i])
+			case "path":
+				path = match[i]
+			}
+		}
+		t, err = parseTime(timeStr)
+		return
+	}
+}
+
+// 生成路径模板，数字、uuid、hash等动态段替换为占位符
+func (replayRequest *ReplayRequest) PathTemplate(path string) string {
+	if n := strings.IndexAny(path, "?#"); n >= 0 {
+		path = path[:n]
+	}
+	segments := strings.Split(path, "/")
+
+	for _, template := range replayRequest.Templates {
+		tplSegments := strings.Split(template, "/")
+		if len(tplSegments) != len(segments) {
+			continue
+		}
+		match := true
+		for i, v := range tplSegments {
+			if v != segments[i] && !(strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}")) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return template
+		}
+	}
+
+	for i, v := range segments {
+		switch {
+		case v == "":
+		case replayNumRegexp.MatchString(v):
+			segments[i] = "{id}"
+		case replayUuidRegexp.MatchString(v):
+			segments[i] = "{uuid}"
+		case replayHashRegexp.MatchString(v):
+			segments[i] = "{hash}"
+		case replayTokenRegexp.MatchString(v) && replayDigitRegexp.MatchString(v):
+			segments[i] = "{token}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// 按模式把日志中的请求分发给各个协程，日志回放完后从头循环
+func (replayRequest *ReplayRequest) Dispatch() {
+	replayRequest.queue = make(chan *replaySend)
+	replayRequest.done = make(chan int)
+
+	go func() {
+		var (
+			ticker   *time.Ticker
+			start    = time.Now()
+			loopBase time.Duration
+		)
+		if replayRequest.Mode == REPLAY_MODE_RATE {
+			ticker = time.NewTicker(time.Second / time.Duration(replayRequest.Rate))
+			defer ticker.Stop()
+		}
+		for {
+			for _, entry := range replayRequest.entries {
+				send := &replaySend{entry: entry}
+				if ticker != nil {
+					select {
+					case send.due = <-ticker.C:
+					case <-replayRequest.done:
+						return
+					}
+				} else {
+					send.due = start.Add(time.Duration(float64(loopBase+entry.offset) / replayRequest.Speed))
+					if wait := time.Until(send.due); wait > 0 {
+						select {
+						case <-time.After(wait):
+						case <-replayRequest.done:
+							return
+						}
+					}
+				}
+				if send.generator = time.Since(send.due); send.generator < 0 {
+					send.generator = 0
+				}
+				select {
+				case replayRequest.queue <- send:
+				case <-replayRequest.done:
+					return
+				}
+			}
+			loopBase += replayRequest.entries[len(replayRequest.entries)-1].offset + time.Second
+		}
+	}()
+}
+
+func (replayRequest *ReplayRequest) Close() {
+	close(replayRequest.done)
+}
+
+func (replayRequest *ReplayRequest) Run(serial uint64, ch chan<- *Response, wg *sync.WaitGroup, stopCh <-chan int) {
+	httpRequest := GenerateHttpRequest(false)
+	for {
+		select {
+		case <-stopCh:
+			logger.Debug(fmt.Sprintf("%d号回放协程关闭", serial))
+			wg.Done()
+			return
+		case send := <-replayRequest.queue:
+			entry := send.entry
+			lag := pacerLag{schedule: time.Since(send.due), generator: send.generator}
+			httpRequest.Name = entry.group
+			httpRequest.Group = entry.group
+			httpRequest.Url = replayRequest.Target + entry.path
+			httpRequest.Method = entry.method
+			httpRequest.Header = replayRequest.Header
+			resp := httpRequest.send()
+			lag.set(resp)
+			httpSendRespCh(ch, resp)
+		}
+	}
+}
+
+// 回放的调度统计：协程取到请求时晚于日志时间（timing）或速率（rate）的时间
+func (replayRequest *ReplayRequest) scheduleReport() *ScheduleReport {
+	schedule := &ScheduleReport{
+		Executor:    TYPE_REPLAY,
+		Corrected:   NewHistogram(),
+		Uncorrected: NewHistogram(),
+		Lag:         NewHistogram(),
+	}
+	if replayRequest.Mode == REPLAY_MODE_RATE {
+		schedule.Rate = replayRequest.Rate
+	}
+	return schedule
+}
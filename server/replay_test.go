@@ -0,0 +1,200 @@
+package server
+
+import (
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayPathTemplate(t *testing.T) {
+	replayRequest := &ReplayRequest{Templates: []string{"/shops/{shop}/items"}}
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/users/123", "/users/{id}"},
+		{"/users/123/orders?page=2", "/users/{id}/orders"},
+		{"/files/0f8fad5b-d9cb-469f-a165-70867728950e#top", "/files/{uuid}"},
+		{"/blobs/9e107d9d372bb6826bd81d3542a419d6", "/blobs/{hash}"},
+		{"/reset/Ab3dEf6hIj9kLm2nOp5q", "/reset/{token}"},
+		{"/pages/about-this-site-version", "/pages/about-this-site-version"},
+		{"/shops/s1/items", "/shops/{shop}/items"},
+		{"/shops/s1/items/7", "/shops/s1/items/{id}"},
+		{"/", "/"},
+	}
+	for _, c := range cases {
+		if got := replayRequest.PathTemplate(c.path); got != c.want {
+			t.Errorf("%s: %s, want %s", c.path, got, c.want)
+		}
+	}
+}
+
+func TestReplayParser(t *testing.T) {
+	cases := []struct {
+		name    string
+		request ReplayRequest
+		line    string
+		method  string
+		path    string
+		time    time.Time
+		err     bool
+	}{
+		{
+			name:   "combined",
+			line:   `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /a?x=1 HTTP/1.1" 200 12 "-" "curl"`,
+			method: "GET", path: "/a?x=1", time: time.Date(2020, 10, 10, 13, 55, 36, 0, time.UTC),
+		},
+		{
+			name: "combined不匹配",
+			line: `GET /a`,
+			err:  true,
+		},
+		{
+			name:    "regex",
+			request: ReplayRequest{Format: REPLAY_FORMAT_REGEX, Pattern: `^(?P<method>\w+) (?P<path>\S+)$`},
+			line:    "post /b",
+			method:  "POST", path: "/b",
+		},
+		{
+			name:    "json毫秒时间戳",
+			request: ReplayRequest{Format: REPLAY_FORMAT_JSON, Fields: map[string]string{"path": "req.uri"}},
+			line:    `{"time":1602338136500,"method":"put","req":{"uri":"/c"}}`,
+			method:  "PUT", path: "/c", time: time.Unix(1602338136, 5e8),
+		},
+		{
+			name:    "json RFC3339",
+			request: ReplayRequest{Format: REPLAY_FORMAT_JSON},
+			line:    `{"time":"2020-10-10T13:55:36Z","method":"GET","path":"/d"}`,
+			method:  "GET", path: "/d", time: time.Date(2020, 10, 10, 13, 55, 36, 0, time.UTC),
+		},
+		{
+			name:    "json缺少path",
+			request: ReplayRequest{Format: REPLAY_FORMAT_JSON},
+			line:    `{"method":"GET"}`,
+			err:     true,
+		},
+	}
+	for _, c := range cases {
+		parse, err := c.request.parser()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		tm, method, path, err := parse(c.line)
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && (method != c.method || path != c.path || !tm.Equal(c.time)) {
+			t.Errorf("%s: %s %s %v, want %s %s %v", c.name, method, path, tm, c.method, c.path, c.time)
+		}
+	}
+
+	for _, request := range []ReplayRequest{
+		{Format: "csv"},
+		{Format: REPLAY_FORMAT_REGEX, Pattern: `(?P<path>\S+)`},
+		{Format: REPLAY_FORMAT_REGEX, Pattern: `(`},
+	} {
+		if _, err := request.parser(); err == nil {
+			t.Errorf("%+v: want error", request)
+		}
+	}
+}
+
+func TestReplayLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	appconfig.GetConfig().File.UploadPath = dir
+	defer func() { appconfig.GetConfig().File.UploadPath = uploadPath }()
+
+	log := `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /users/1 HTTP/1.1" 200 12
+broken line
+10.0.0.1 - - [10/Oct/2020:13:55:38 +0000] "POST http://api.local/users HTTP/1.1" 201 2
+10.0.0.1 - - [10/Oct/2020:13:55:35 +0000] "GET api.local HTTP/1.1" 200 2
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "access.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	replayRequest := &ReplayRequest{File: "access.log", Target: "127.0.0.1:8080/"}
+	if err := replayRequest.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if replayRequest.Target != "http://127.0.0.1:8080" || replayRequest.Mode != REPLAY_MODE_TIMING || replayRequest.Speed != 1 {
+		t.Errorf("defaults: %s %s %v", replayRequest.Target, replayRequest.Mode, replayRequest.Speed)
+	}
+	want := []struct {
+		method string
+		path   string
+		group  string
+		offset time.Duration
+	}{
+		{"GET", "/api.local", "/api.local", 0},
+		{"GET", "/users/1", "/users/{id}", time.Second},
+		{"POST", "/users", "/users", 3 * time.Second},
+	}
+	if len(replayRequest.entries) != len(want) {
+		t.Fatalf("entries = %d, want %d", len(replayRequest.entries), len(want))
+	}
+	for i, w := range want {
+		entry := replayRequest.entries[i]
+		if entry.method != w.method || entry.path != w.path || entry.group != w.group || entry.offset != w.offset {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, w)
+		}
+	}
+
+	for _, request := range []*ReplayRequest{
+		{Target: "h"},
+		{File: "access.log"},
+		{File: "access.log", Target: "h", Mode: REPLAY_MODE_RATE},
+		{File: "missing.log", Target: "h"},
+		{File: "access.log", Target: "h", Format: REPLAY_FORMAT_JSON},
+	} {
+		if err := request.Load(); err == nil {
+			t.Errorf("%+v: want error", request)
+		}
+	}
+}
+
+func TestReplayRunLag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	replayRequest := &ReplayRequest{
+		Target:  server.URL,
+		Mode:    REPLAY_MODE_TIMING,
+		Speed:   1,
+		entries: []*replayEntry{{method: "GET", path: "/a", group: "/a"}, {offset: 20 * time.Millisecond, method: "GET", path: "/b", group: "/b"}},
+	}
+	replayRequest.Dispatch()
+	defer replayRequest.Close()
+
+	// 协程晚启动，取到请求时已经晚于日志时间
+	time.Sleep(100 * time.Millisecond)
+	ch := make(chan *Response, 2)
+	stop := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go replayRequest.Run(0, ch, &wg, stop)
+	first, second := <-ch, <-ch
+	close(stop)
+	wg.Wait()
+	if first.Group != "/a" || first.ScheduleLag < 90 || second.Group != "/b" || second.ScheduleLag >= first.ScheduleLag {
+		t.Errorf("lag = %s %d, %s %d", first.Group, first.ScheduleLag, second.Group, second.ScheduleLag)
+	}
+
+	schedule := (&ReplayRequest{Mode: REPLAY_MODE_RATE, Rate: 50}).scheduleReport()
+	if schedule.Executor != TYPE_REPLAY || schedule.Rate != 50 || schedule.Lag == nil {
+		t.Errorf("schedule = %+v", schedule)
+	}
+}
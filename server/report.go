@@ -3,25 +3,79 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 
+	"github.com/donnie4w/go-logger/logger"
 	"insane/general/base/appconfig"
 	"insane/utils"
 )
 
+const REPORT_RECENT_SECONDS = 600 // 查询执行中的报告时，每秒的统计只返回最近的秒数
+
 type Report struct {
-	RequestTime       uint64         `json:"requestTime"`       // 请求总时间
-	MaxTime           uint64         `json:"maxTime"`           // 最大时长
-	MinTime           uint64         `json:"minTime"`           // 最小时长
-	SuccessNum        uint64         `json:"successNum"`        // 成功请求数
-	FailureNum        uint64         `json:"failureNum"`        // 失败请求数
-	ConCurrency       uint64         `json:"conCurrency"`       // 并发数
-	ErrCode           map[int]int    `json:"errCode"`           // 错误码/错误个数
-	ErrCodeMsg        map[int]string `json:"errCodeMsg"`        // 错误码描述
-	AverageSuccessReq map[uint64]int `json:"averageSuccessReq"` // 每个时间段的成功请求数
-	AverageErrorReq   map[uint64]int `json:"averageErrorReq"`   // 每个时间段的错误请求数
-	Status            bool           `json:"status"`
+	Name              string                  `json:"name"`              // 任务名称
+	RequestTime       uint64                  `json:"requestTime"`       // 请求总时间
+	MaxTime           uint64                  `json:"maxTime"`           // 最大时长
+	MinTime           uint64                  `json:"minTime"`           // 最小时长
+	SuccessNum        uint64                  `json:"successNum"`        // 成功请求数
+	FailureNum        uint64                  `json:"failureNum"`        // 失败请求数
+	ConCurrency       uint64                  `json:"conCurrency"`       // 并发数
+	ErrCode           map[int]int             `json:"errCode"`           // 错误码/错误个数
+	ErrCodeMsg        map[int]string          `json:"errCodeMsg"`        // 错误码描述
+	AverageSuccessReq map[uint64]int          `json:"averageSuccessReq"` // 每个时间段的成功请求数
+	AverageErrorReq   map[uint64]int          `json:"averageErrorReq"`   // 每个时间段的错误请求数
+	Groups            map[string]*GroupReport `json:"groups"`            // 按分组（回放时为路径模板）统计
+	Protocols         map[string]uint64       `json:"protocols"`         // 实际使用的协议/请求数
+	ConnReused        uint64                  `json:"connReused"`        // 复用连接的请求数
+	ConnNew           uint64                  `json:"connNew"`           // 新建连接的请求数
+	Stream            *StreamReport           `json:"stream"`            // 流式响应统计，sse或http流式读取时才有
+	BytesSent         uint64                  `json:"bytesSent"`         // 发送总字节数
+	BytesRecv         uint64                  `json:"bytesRecv"`         // 接收总字节数
+	AverageBytesSent  map[uint64]uint64       `json:"averageBytesSent"`  // 每秒发送字节数
+	AverageBytesRecv  map[uint64]uint64       `json:"averageBytesRecv"`  // 每秒接收字节数
+	FirstByte         *GroupReport            `json:"firstByte"`         // 首字节时间
+	Download          *GroupReport            `json:"download"`          // 下载时间
+	Latency           *Histogram              `json:"latency"`           // 成功请求的耗时分布（毫秒）
+	LatencySeries     map[uint64]*Histogram   `json:"latencySeries"`     // 每秒成功请求的耗时分布（毫秒）
+	Phases            PhaseHistograms         `json:"phases"`            // http请求各阶段耗时分布（微秒）
+	Errors            ErrorReports            `json:"errors"`            // 按错误分类统计，包含出现最多的错误信息
+	Schedule          *ScheduleReport         `json:"schedule"`          // 按计划时间发送时的统计，设置了interval或rate模式才有
+	Generator         *GeneratorReport        `json:"generator"`         // 施压机自身每秒的状态与饱和提示
+	Targets           TargetReports           `json:"targets"`           // 被测机器每秒的负载，由agent上报
+	Capacity          *CapacityReport         `json:"capacity"`          // 容量模式每一步的结果
+	Config            json.RawMessage         `json:"config"`            // 提交的任务配置
+	Thresholds        []*ThresholdResult      `json:"thresholds"`        // 阈值检查结果
+	Baseline          *Comparison             `json:"baseline"`          // 与同名任务基准的对比
+	Annotations       []*Annotation           `json:"annotations"`       // 执行中的暂停、恢复与调整
+	Status            bool                    `json:"status"`
 	m                 sync.Mutex
+	pacer             *pacer
+	replay            *ReplayRequest // 回放按日志时间或速率分发，统计方式与pacer相同
+	metrics           *TaskMetrics
+	samples           *sampleWriter
+	thresholds        []*Threshold
+	correctLatency    bool  // 耗时统计使用从计划发送时间开始计算的耗时
+	startTime         int64 // 开始接收结果的时间（毫秒）
+}
+
+type StreamReport struct {
+	Connections   uint64       `json:"connections"`   // 建立的连接数
+	Reconnects    uint64       `json:"reconnects"`    // 重连次数
+	Events        uint64       `json:"events"`        // 收到的事件数
+	EventsPerConn uint64       `json:"eventsPerConn"` // 平均每个连接收到的事件数
+	MaxEvents     uint64       `json:"maxEvents"`     // 单个连接收到的最多事件数
+	FirstTime     *GroupReport `json:"firstTime"`     // 首个事件（数据块）到达时间
+	EventGap      *GroupReport `json:"eventGap"`      // 事件间隔
+}
+
+type GroupReport struct {
+	SuccessNum uint64 `json:"successNum"`
+	FailureNum uint64 `json:"failureNum"`
+	TotalTime  uint64 `json:"totalTime"` // 总耗时（毫秒）
+	AvgTime    uint64 `json:"avgTime"`
+	MaxTime    uint64 `json:"maxTime"`
+	MinTime    uint64 `json:"minTime"`
 }
 
 func (report *Report) ReceivingResults(id string, conCurrency uint64, ch <-chan *Response, wgReceiving *sync.WaitGroup) {
@@ -37,9 +91,33 @@ func (report *Report) ReceivingResults(id string, conCurrency uint64, ch <-chan
 		errCodeMsg        = make(map[int]string) // 错误码描述
 		averageSuccessReq = make(map[uint64]int) // 每个时间段的成功请求数
 		averageErrorReq   = make(map[uint64]int) // 每个时间段的错误请求数
+		groups            = make(map[string]*GroupReport)
+		protocols         = make(map[string]uint64)
+		connReused        uint64
+		connNew           uint64
+		stream            *StreamReport
+		bytesSent         uint64
+		bytesRecv         uint64
+		averageBytesSent  = make(map[uint64]uint64)
+		averageBytesRecv  = make(map[uint64]uint64)
+		firstByte         = new(GroupReport)
+		download          = new(GroupReport)
+		latency           = NewHistogram()
+		latencySeries     = make(map[uint64]*Histogram)
+		phases            = NewPhaseHistograms()
+		errorReports      = make(ErrorReports)
+		schedule          *ScheduleReport
 	)
+	if report.replay != nil {
+		schedule = report.replay.scheduleReport()
+	} else if report.pacer != nil {
+		schedule = newScheduleReport(report.pacer)
+	}
 
 	startTime := utils.Now()
+	report.m.Lock()
+	report.startTime = startTime
+	report.m.Unlock()
 	for data := range ch {
 		report.m.Lock()
 		curSecond := utils.CurSecond(uint64(startTime))
@@ -52,28 +130,79 @@ func (report *Report) ReceivingResults(id string, conCurrency uint64, ch <-chan
 			averageErrorReq[curSecond] = 0
 		}
 
-		if data.IsSuccess {
+		// 调度统计使用修正前的耗时，修正后的耗时同时用于报告、指标和样本
+		if schedule != nil && data.Stream.isRequest() {
+			schedule.add(data)
+			schedule.setDropped(report.pacer.droppedNum())
+			if report.correctLatency {
+				data.WasteTime += data.ScheduleLag
+			}
+		}
+		report.metrics.add(data.Group, data)
+		report.samples.write(data.Group, data)
+		bytesSent += data.BytesSent
+		bytesRecv += data.BytesRecv
+		averageBytesSent[curSecond] += data.BytesSent
+		averageBytesRecv[curSecond] += data.BytesRecv
+		if data.IsSuccess && data.Stream == nil && strings.HasPrefix(data.Proto, "HTTP/") {
+			firstByte.addTime(data.FirstByteTime)
+			download.addTime(data.DownloadTime)
+			phases.add(data)
+		}
+
+		if data.Stream != nil {
+			if stream == nil {
+				stream = &StreamReport{FirstTime: new(GroupReport), EventGap: new(GroupReport)}
+			}
+			stream.add(data)
+		}
+
+		switch {
+		case !data.Stream.isRequest():
+			// 连接建立与断开不计入请求数
+		case data.IsSuccess:
 			averageSuccessReq[curSecond]++
 			successNum++
+			latency.Add(data.WasteTime)
+			if _, ok := latencySeries[curSecond]; !ok {
+				latencySeries[curSecond] = NewHistogram()
+			}
+			latencySeries[curSecond].Add(data.WasteTime)
 			if data.WasteTime > maxTime {
 				maxTime = data.WasteTime
 			}
 			if minTime == 0 || data.WasteTime < minTime {
 				minTime = data.WasteTime
 			}
-		} else {
+		default:
+			// 同一错误码只保留第一条描述，不同的错误信息在errors中按分类统计
 			errCode[data.ErrCode]++
 			if _, ok := errCodeMsg[data.ErrCode]; !ok {
 				errCodeMsg[data.ErrCode] = data.ErrMsg
-			} else {
-				if errCodeMsg[data.ErrCode] != data.ErrMsg {
-					errCodeMsg[data.ErrCode+1] = data.ErrMsg
-				}
 			}
+			errorReports.add(data.ErrType, data.ErrMsg)
 			averageErrorReq[curSecond]++
 			failureNum++
 		}
 
+		if data.Proto != "" {
+			protocols[data.Proto]++
+			if data.ConnReused {
+				connReused++
+			} else {
+				connNew++
+			}
+		}
+
+		if data.Group != "" && data.Stream.isRequest() {
+			group, ok := groups[data.Group]
+			if !ok {
+				group = new(GroupReport)
+				groups[data.Group] = group
+			}
+			group.add(data)
+		}
+
 		report.MaxTime = maxTime
 		report.MinTime = minTime
 		report.SuccessNum = successNum
@@ -83,24 +212,104 @@ func (report *Report) ReceivingResults(id string, conCurrency uint64, ch <-chan
 		report.ErrCodeMsg = errCodeMsg
 		report.AverageSuccessReq = averageSuccessReq
 		report.AverageErrorReq = averageErrorReq
+		report.Groups = groups
+		report.Protocols = protocols
+		report.ConnReused = connReused
+		report.ConnNew = connNew
+		report.Stream = stream
+		report.BytesSent = bytesSent
+		report.BytesRecv = bytesRecv
+		report.AverageBytesSent = averageBytesSent
+		report.AverageBytesRecv = averageBytesRecv
+		report.FirstByte = firstByte
+		report.Download = download
+		report.Latency = latency
+		report.LatencySeries = latencySeries
+		report.Phases = phases
+		report.Errors = errorReports
+		report.Schedule = schedule
 
 		report.m.Unlock()
 	}
 	endTime := utils.Now()
+	report.m.Lock()
+	if schedule != nil {
+		schedule.setDropped(report.pacer.droppedNum())
+	}
+	report.Schedule = schedule
 	report.RequestTime = uint64((endTime - startTime) / 1000)
+	if len(report.thresholds) > 0 {
+		report.Thresholds = checkThresholds(report.thresholds, report.thresholdSource)
+	}
+	var run *CompareRun
+	if report.Name != "" {
+		run = report.compareRun(id)
+	}
+	report.m.Unlock()
+	var baseline *Comparison
+	if run != nil {
+		baseline = InsaneBaselines.check(run)
+	}
+	report.m.Lock()
+	report.Baseline = baseline
 	report.Status = true
+	report.m.Unlock()
+	report.samples.close()
+
+	report.save(id)
+}
 
+// 报告写入日志目录
+func (report *Report) save(id string) {
+	report.m.Lock()
 	content, err := json.Marshal(report)
-	if err == nil {
-		filename := fmt.Sprintf("%s/%s.json", appconfig.GetConfig().Log.Location, id)
-		utils.FileWrite(filename, string(content))
+	report.m.Unlock()
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	filename := fmt.Sprintf("%s/%s.json", appconfig.GetConfig().Log.Location, id)
+	utils.FileWrite(filename, string(content))
+}
+
+// 分组只统计了平均与最大耗时
+func (report *Report) thresholdSource(step string) *thresholdSource {
+	if step == "" {
+		return &thresholdSource{success: report.SuccessNum, failure: report.FailureNum, seconds: report.RequestTime, latency: report.Latency}
+	}
+	group, ok := report.Groups[step]
+	if !ok {
+		return nil
 	}
+	return &thresholdSource{success: group.SuccessNum, failure: group.FailureNum, seconds: report.RequestTime, group: group}
+}
+
+func (report *Report) setPacer(pacer *pacer, correctLatency bool) {
+	report.pacer = pacer
+	report.correctLatency = correctLatency
+}
+
+// 查询时返回的报告，长时间执行时每秒的统计不会无限增长，完整的数据在结束后写入日志文件
+type reportView struct {
+	*Report
+	AverageSuccessReq map[uint64]int        `json:"averageSuccessReq"`
+	AverageErrorReq   map[uint64]int        `json:"averageErrorReq"`
+	AverageBytesSent  map[uint64]uint64     `json:"averageBytesSent"`
+	AverageBytesRecv  map[uint64]uint64     `json:"averageBytesRecv"`
+	LatencySeries     map[uint64]*Histogram `json:"latencySeries,omitempty"` // 每秒的耗时分布通过实时推送获取，不返回
 }
 
 func (report *Report) Get() (content string) {
 	report.m.Lock()
 	defer report.m.Unlock()
-	con, err := json.Marshal(report)
+	view := &reportView{
+		Report:            report,
+		AverageSuccessReq: recentInts(report.AverageSuccessReq),
+		AverageErrorReq:   recentInts(report.AverageErrorReq),
+		AverageBytesSent:  recentUints(report.AverageBytesSent),
+		AverageBytesRecv:  recentUints(report.AverageBytesRecv),
+	}
+	con, err := json.Marshal(view)
 	if err != nil {
 		return ""
 	}
@@ -114,3 +323,268 @@ func (report *Report) Get() (content string) {
 	//return
 
 }
+
+// 完整的报告，集群中推送给主节点合并
+func (report *Report) copy() (*Report, error) {
+	report.m.Lock()
+	data, err := json.Marshal(report)
+	report.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	other := new(Report)
+	if err := json.Unmarshal(data, other); err != nil {
+		return nil, err
+	}
+	return other, nil
+}
+
+// 只保留最近REPORT_RECENT_SECONDS秒
+func recentInts(series map[uint64]int) map[uint64]int {
+	var last uint64
+	for second := range series {
+		if second > last {
+			last = second
+		}
+	}
+	recent := make(map[uint64]int)
+	for second, v := range series {
+		if second+REPORT_RECENT_SECONDS > last {
+			recent[second] = v
+		}
+	}
+	return recent
+}
+
+func recentUints(series map[uint64]uint64) map[uint64]uint64 {
+	var last uint64
+	for second := range series {
+		if second > last {
+			last = second
+		}
+	}
+	recent := make(map[uint64]uint64)
+	for second, v := range series {
+		if second+REPORT_RECENT_SECONDS > last {
+			recent[second] = v
+		}
+	}
+	return recent
+}
+
+func (group *GroupReport) add(data *Response) {
+	if !data.IsSuccess {
+		group.FailureNum++
+		return
+	}
+	group.addTime(data.WasteTime)
+}
+
+func (group *GroupReport) addTime(wasteTime uint64) {
+	group.SuccessNum++
+	group.TotalTime += wasteTime
+	group.AvgTime = group.TotalTime / group.SuccessNum
+	if wasteTime > group.MaxTime {
+		group.MaxTime = wasteTime
+	}
+	if group.MinTime == 0 || wasteTime < group.MinTime {
+		group.MinTime = wasteTime
+	}
+}
+
+func (stream *StreamReport) add(data *Response) {
+	switch data.Stream.Kind {
+	case STREAM_CONNECT:
+		stream.Connections++
+		if data.Stream.Reconnect {
+			stream.Reconnects++
+		}
+	case STREAM_CLOSE:
+		if data.Stream.Events > stream.MaxEvents {
+			stream.MaxEvents = data.Stream.Events
+		}
+	case STREAM_EVENT:
+		stream.Events++
+		if data.Stream.First {
+			stream.FirstTime.addTime(data.WasteTime)
+		} else {
+			stream.EventGap.addTime(data.WasteTime)
+		}
+	case STREAM_BODY:
+		if data.IsSuccess {
+			stream.FirstTime.addTime(data.Stream.FirstTime)
+		}
+	}
+	if stream.Connections > 0 {
+		stream.EventsPerConn = stream.Events / stream.Connections
+	}
+}
+
+// 合并集群中其他节点的报告
+func (report *Report) Merge(other *Report) {
+	report.m.Lock()
+	defer report.m.Unlock()
+
+	if other.RequestTime > report.RequestTime {
+		report.RequestTime = other.RequestTime
+	}
+	if other.MaxTime > report.MaxTime {
+		report.MaxTime = other.MaxTime
+	}
+	if report.MinTime == 0 || (other.MinTime > 0 && other.MinTime < report.MinTime) {
+		report.MinTime = other.MinTime
+	}
+	report.SuccessNum += other.SuccessNum
+	report.FailureNum += other.FailureNum
+	report.ConCurrency += other.ConCurrency
+	report.ConnReused += other.ConnReused
+	report.ConnNew += other.ConnNew
+	report.BytesSent += other.BytesSent
+	report.BytesRecv += other.BytesRecv
+
+	if report.ErrCode == nil {
+		report.ErrCode = make(map[int]int)
+		report.ErrCodeMsg = make(map[int]string)
+	}
+	for code, n := range other.ErrCode {
+		report.ErrCode[code] += n
+		if _, ok := report.ErrCodeMsg[code]; !ok {
+			report.ErrCodeMsg[code] = other.ErrCodeMsg[code]
+		}
+	}
+	report.AverageSuccessReq = mergeIntSeries(report.AverageSuccessReq, other.AverageSuccessReq)
+	report.AverageErrorReq = mergeIntSeries(report.AverageErrorReq, other.AverageErrorReq)
+	report.AverageBytesSent = mergeUintSeries(report.AverageBytesSent, other.AverageBytesSent)
+	report.AverageBytesRecv = mergeUintSeries(report.AverageBytesRecv, other.AverageBytesRecv)
+
+	if report.Groups == nil {
+		report.Groups = make(map[string]*GroupReport)
+	}
+	for name, group := range other.Groups {
+		if _, ok := report.Groups[name]; !ok {
+			report.Groups[name] = new(GroupReport)
+		}
+		report.Groups[name].merge(group)
+	}
+	if report.Protocols == nil {
+		report.Protocols = make(map[string]uint64)
+	}
+	for proto, n := range other.Protocols {
+		report.Protocols[proto] += n
+	}
+
+	if other.Stream != nil {
+		if report.Stream == nil {
+			report.Stream = &StreamReport{FirstTime: new(GroupReport), EventGap: new(GroupReport)}
+		}
+		report.Stream.merge(other.Stream)
+	}
+	if report.FirstByte == nil {
+		report.FirstByte = new(GroupReport)
+		report.Download = new(GroupReport)
+	}
+	report.FirstByte.merge(other.FirstByte)
+	report.Download.merge(other.Download)
+	if other.Latency != nil {
+		if report.Latency == nil {
+			report.Latency = NewHistogram()
+		}
+		report.Latency.Merge(other.Latency)
+	}
+	if report.LatencySeries == nil {
+		report.LatencySeries = make(map[uint64]*Histogram)
+	}
+	for second, histogram := range other.LatencySeries {
+		if _, ok := report.LatencySeries[second]; !ok {
+			report.LatencySeries[second] = NewHistogram()
+		}
+		report.LatencySeries[second].Merge(histogram)
+	}
+	if report.Phases == nil {
+		report.Phases = NewPhaseHistograms()
+	}
+	for name, histogram := range other.Phases {
+		if _, ok := report.Phases[name]; !ok {
+			report.Phases[name] = NewHistogram()
+		}
+		report.Phases[name].Merge(histogram)
+	}
+	if report.Errors == nil {
+		report.Errors = make(ErrorReports)
+	}
+	report.Errors.Merge(other.Errors)
+	if other.Schedule != nil {
+		if report.Schedule == nil {
+			report.Schedule = &ScheduleReport{
+				Executor:    other.Schedule.Executor,
+				Interval:    other.Schedule.Interval,
+				Corrected:   NewHistogram(),
+				Uncorrected: NewHistogram(),
+				Lag:         NewHistogram(),
+			}
+		}
+		report.Schedule.merge(other.Schedule)
+	}
+	if other.Generator != nil {
+		if report.Generator == nil {
+			report.Generator = newGeneratorReport()
+		}
+		report.Generator.merge(other.Generator)
+	}
+	if report.Targets == nil {
+		report.Targets = make(TargetReports)
+	}
+	report.Targets.merge(other.Targets)
+}
+
+func mergeIntSeries(series map[uint64]int, other map[uint64]int) map[uint64]int {
+	if series == nil {
+		series = make(map[uint64]int)
+	}
+	for k, v := range other {
+		series[k] += v
+	}
+	return series
+}
+
+func mergeUintSeries(series map[uint64]uint64, other map[uint64]uint64) map[uint64]uint64 {
+	if series == nil {
+		series = make(map[uint64]uint64)
+	}
+	for k, v := range other {
+		series[k] += v
+	}
+	return series
+}
+
+func (group *GroupReport) merge(other *GroupReport) {
+	if other == nil {
+		return
+	}
+	group.SuccessNum += other.SuccessNum
+	group.FailureNum += other.FailureNum
+	group.TotalTime += other.TotalTime
+	if group.SuccessNum > 0 {
+		group.AvgTime = group.TotalTime / group.SuccessNum
+	}
+	if other.MaxTime > group.MaxTime {
+		group.MaxTime = other.MaxTime
+	}
+	if group.MinTime == 0 || (other.MinTime > 0 && other.MinTime < group.MinTime) {
+		group.MinTime = other.MinTime
+	}
+}
+
+func (stream *StreamReport) merge(other *StreamReport) {
+	stream.Connections += other.Connections
+	stream.Reconnects += other.Reconnects
+	stream.Events += other.Events
+	if other.MaxEvents > stream.MaxEvents {
+		stream.MaxEvents = other.MaxEvents
+	}
+	if stream.Connections > 0 {
+		stream.EventsPerConn = stream.Events / stream.Connections
+	}
+	stream.FirstTime.merge(other.FirstTime)
+	stream.EventGap.merge(other.EventGap)
+}
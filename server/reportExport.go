@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	REPORT_FORMAT_HTML    = "html"
+	REPORT_FORMAT_JSON    = "json"
+	REPORT_FORMAT_CSV     = "csv"     // 每秒（脚本为每分钟）的时间序列
+	REPORT_FORMAT_JUNIT   = "junit"   // 每个阈值为一个测试用例
+	REPORT_FORMAT_SAMPLES = "samples" // 每个请求的原始结果，gzip压缩的jsonl
+)
+
+// 导出的文件
+type ReportFile struct {
+	Content     []byte
+	ContentType string
+	Name        string // 下载时的文件名
+}
+
+// 按格式导出任务报告
+func ExportReport(id string, format string) (*ReportFile, error) {
+	if format == "" {
+		format = REPORT_FORMAT_HTML
+	}
+	if format == REPORT_FORMAT_SAMPLES {
+		if strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+			return nil, errors.New("任务id不正确")
+		}
+		content, err := ioutil.ReadFile(samplesFile(id))
+		if err != nil {
+			return nil, errors.New("没有原始结果，提交任务时需要设置samples")
+		}
+		return &ReportFile{Content: content, ContentType: "application/gzip", Name: filepath.Base(samplesFile(id))}, nil
+	}
+	content, err := ReportContent(id)
+	if err != nil {
+		return nil, err
+	}
+	return FormatReport(id, content, format)
+}
+
+// 报告json转换为其他格式
+func FormatReport(id string, content []byte, format string) (*ReportFile, error) {
+	var (
+		data        []byte
+		contentType string
+		err         error
+	)
+	switch format {
+	case REPORT_FORMAT_HTML:
+		data, err = HtmlReport(id, content)
+		contentType = "text/html; charset=utf-8"
+	case REPORT_FORMAT_JSON:
+		data, contentType = content, "application/json"
+	case REPORT_FORMAT_CSV:
+		data, err = CsvReport(content)
+		contentType = "text/csv; charset=utf-8"
+	case REPORT_FORMAT_JUNIT:
+		data, err = JunitReport(id, content)
+		contentType = "application/xml; charset=utf-8"
+	default:
+		return nil, fmt.Errorf("导出格式必须是%s | %s | %s | %s | %s", REPORT_FORMAT_HTML, REPORT_FORMAT_JSON, REPORT_FORMAT_CSV, REPORT_FORMAT_JUNIT, REPORT_FORMAT_SAMPLES)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ext := format
+	if format == REPORT_FORMAT_JUNIT {
+		ext = "xml"
+	}
+	return &ReportFile{Content: data, ContentType: contentType, Name: fmt.Sprintf("insane-%s.%s", id, ext)}, nil
+}
+
+// 命令行导出：source为任务id或报告json文件路径，out为空时写入当前目录
+func ExportReportFile(source string, format string, out string) (string, error) {
+	var (
+		file *ReportFile
+		err  error
+	)
+	if strings.HasSuffix(source, ".json") && format != REPORT_FORMAT_SAMPLES {
+		var content []byte
+		if content, err = ioutil.ReadFile(source); err != nil {
+			return "", err
+		}
+		if format == "" {
+			format = REPORT_FORMAT_HTML
+		}
+		file, err = FormatReport(strings.TrimSuffix(filepath.Base(source), ".json"), content, format)
+	} else {
+		file, err = ExportReport(strings.TrimSuffix(filepath.Base(source), ".json"), format)
+	}
+	if err != nil {
+		return "", err
+	}
+	if out == "" {
+		out = file.Name
+	}
+	return out, ioutil.WriteFile(out, file.Content, 0644)
+}
+
+// 每秒一行，脚本任务为每分钟一行
+func CsvReport(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if gjson.GetBytes(content, "scriptReport").Exists() {
+		var report ScriptReportList
+		if err := json.Unmarshal(content, &report); err != nil {
+			return nil, err
+		}
+		w.Write([]string{"minute", "success", "failure"})
+		for _, minute := range seriesKeys(uintSeries(report.AverageSuccess), uintSeries(report.AverageError)) {
+			w.Write([]string{fmt.Sprint(minute), fmt.Sprint(report.AverageSuccess[minute]), fmt.Sprint(report.AverageError[minute])})
+		}
+	} else {
+		var report Report
+		if err := json.Unmarshal(content, &report); err != nil {
+			return nil, err
+		}
+		w.Write([]string{"second", "success", "failure", "avg_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms", "bytes_sent", "bytes_recv", "generator_cpu", "generator_sys_cpu"})
+		for _, second := range seriesKeys(intSeries(report.AverageSuccessReq), intSeries(report.AverageErrorReq)) {
+			row := []string{fmt.Sprint(second), fmt.Sprint(report.AverageSuccessReq[second]), fmt.Sprint(report.AverageErrorReq[second])}
+			if histogram, ok := report.LatencySeries[second]; ok {
+				summary := histogram.Summary()
+				row = append(row, fmt.Sprint(summary.Avg), fmt.Sprint(summary.P50), fmt.Sprint(summary.P95), fmt.Sprint(summary.P99), fmt.Sprint(summary.Max))
+			} else {
+				row = append(row, "", "", "", "", "")
+			}
+			row = append(row, fmt.Sprint(report.AverageBytesSent[second]), fmt.Sprint(report.AverageBytesRecv[second]))
+			if report.Generator != nil && report.Generator.Samples[second] != nil {
+				sample := report.Generator.Samples[second]
+				row = append(row, fmt.Sprintf("%.1f", sample.Cpu), fmt.Sprintf("%.1f", sample.SysCpu))
+			} else {
+				row = append(row, "", "")
+			}
+			w.Write(row)
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func seriesKeys(list ...map[uint64]float64) []uint64 {
+	seen := make(map[uint64]bool)
+	var keys []uint64
+	for _, series := range list {
+		for k := range series {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     uint64       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     uint64      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      uint64        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// 每个阈值为一个测试用例，没有设置阈值时没有测试用例
+func JunitReport(id string, content []byte) ([]byte, error) {
+	var v struct {
+		RequestTime uint64             `json:"requestTime"`
+		Thresholds  []*ThresholdResult `json:"thresholds"`
+	}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	suite := junitSuite{Name: "insane-" + id, Tests: len(v.Thresholds), Time: v.RequestTime}
+	for _, result := range v.Thresholds {
+		testCase := junitCase{ClassName: "insane." + id, Name: result.Name, Time: v.RequestTime}
+		if !result.Passed {
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: result.Message,
+				Type:    "threshold",
+				Text:    fmt.Sprintf("%s: actual=%s expected %s %s", result.Name, thresholdNumber(result.Actual), result.Op, thresholdNumber(result.Value)),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suites := junitSuites{Name: "insane", Tests: suite.Tests, Failures: suite.Failures, Time: suite.Time, Suites: []junitSuite{suite}}
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
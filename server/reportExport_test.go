@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCsvReport(t *testing.T) {
+	latency := NewHistogram()
+	for i := 0; i < 4; i++ {
+		latency.Add(10)
+	}
+	request, err := json.Marshal(&Report{
+		AverageSuccessReq: map[uint64]int{2: 5, 1: 4},
+		AverageErrorReq:   map[uint64]int{3: 1},
+		AverageBytesSent:  map[uint64]uint64{1: 100},
+		LatencySeries:     map[uint64]*Histogram{1: latency},
+		Generator:         &GeneratorReport{Samples: map[uint64]*GeneratorSample{2: {Cpu: 12.34, SysCpu: 50}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+		err     bool
+	}{
+		{
+			name:    "请求报告",
+			content: string(request),
+			want: []string{
+				"second,success,failure,avg_ms,p50_ms,p95_ms,p99_ms,max_ms,bytes_sent,bytes_recv,generator_cpu,generator_sys_cpu",
+				"1,4,0,10,10,10,10,10,100,0,,",
+				"2,5,0,,,,,,0,0,12.3,50.0",
+				"3,0,1,,,,,,0,0,,",
+			},
+		},
+		{
+			name:    "脚本报告",
+			content: `{"scriptReport":{},"averageSuccess":{"1":3},"averageError":{"2":1}}`,
+			want:    []string{"minute,success,failure", "1,3,0", "2,0,1"},
+		},
+		{name: "格式错误", content: `[`, err: true},
+	}
+	for _, c := range cases {
+		csv, err := CsvReport([]byte(c.content))
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v", c.name, err)
+			continue
+		}
+		if got := strings.Split(strings.TrimSpace(string(csv)), "\n"); !c.err && strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("%s: %q", c.name, got)
+		}
+	}
+}
+
+func TestJunitReport(t *testing.T) {
+	content := `{"requestTime":30,"thresholds":[
+		{"name":"p95 <= 100","metric":"p95","op":"<=","value":100,"actual":80,"passed":true},
+		{"name":"errorRate < 1","metric":"errorRate","op":"<","value":1,"actual":2.5,"passed":false,"message":"实际值2.5"}]}`
+	data, err := JunitReport("t1", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if suites.Tests != 2 || suites.Failures != 1 || suites.Time != 30 || len(suites.Suites) != 1 {
+		t.Fatalf("suites = %+v", suites)
+	}
+	cases := suites.Suites[0].Cases
+	if len(cases) != 2 || cases[0].Failure != nil || cases[1].ClassName != "insane.t1" {
+		t.Fatalf("cases = %+v", cases)
+	}
+	if failure := cases[1].Failure; failure == nil || failure.Message != "实际值2.5" || failure.Text != "errorRate < 1: actual=2.5 expected < 1" {
+		t.Errorf("failure = %+v", failure)
+	}
+
+	// 没有阈值时没有测试用例
+	if data, err := JunitReport("t1", []byte(`{}`)); err != nil || !strings.Contains(string(data), `tests="0"`) {
+		t.Errorf("empty = %s, %v", data, err)
+	}
+}
+
+func TestFormatReport(t *testing.T) {
+	cases := []struct {
+		format      string
+		name        string
+		contentType string
+		err         bool
+	}{
+		{format: REPORT_FORMAT_HTML, name: "insane-t1.html", contentType: "text/html; charset=utf-8"},
+		{format: REPORT_FORMAT_JSON, name: "insane-t1.json", contentType: "application/json"},
+		{format: REPORT_FORMAT_CSV, name: "insane-t1.csv", contentType: "text/csv; charset=utf-8"},
+		{format: REPORT_FORMAT_JUNIT, name: "insane-t1.xml", contentType: "application/xml; charset=utf-8"},
+		{format: "pdf", err: true},
+	}
+	for _, c := range cases {
+		file, err := FormatReport("t1", []byte(`{"successNum":1}`), c.format)
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v", c.format, err)
+			continue
+		}
+		if err == nil && (file.Name != c.name || file.ContentType != c.contentType || len(file.Content) == 0) {
+			t.Errorf("%s: %s %s", c.format, file.Name, file.ContentType)
+		}
+	}
+}
+
+func TestSampleWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	location := appconfig.GetConfig().Log.Location
+	defer func() { appconfig.GetConfig().Log.Location = location }()
+	appconfig.GetConfig().Log.Location = dir
+
+	writer, err := newSampleWriter("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.write("login", &Response{IsSuccess: true, WasteTime: 12, BytesSent: 3})
+	writer.write("login", &Response{Stream: &StreamResponse{Kind: STREAM_CONNECT}}) // 连接事件不写入
+	writer.write("", &Response{ErrCode: 500, ErrType: ERR_TYPE_HTTP, ErrMsg: "boom"})
+	writer.close()
+	var nilWriter *sampleWriter
+	nilWriter.write("x", &Response{})
+	nilWriter.close()
+
+	file, err := os.Open(samplesFile("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []*RawSample
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		sample := new(RawSample)
+		if err := json.Unmarshal(scanner.Bytes(), sample); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, sample)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("samples = %d", len(samples))
+	}
+	if s := samples[0]; s.Step != "login" || !s.Success || s.Latency != 12 || s.BytesSent != 3 || s.Time == 0 {
+		t.Errorf("sample = %+v", s)
+	}
+	if s := samples[1]; s.Success || s.Code != 500 || s.ErrType != ERR_TYPE_HTTP || s.Error != "boom" {
+		t.Errorf("sample = %+v", s)
+	}
+
+	if file, err := ExportReport("t1", REPORT_FORMAT_SAMPLES); err != nil || file.Name != "t1.samples.jsonl.gz" {
+		t.Errorf("export = %+v, %v", file, err)
+	}
+	if _, err := ExportReport("../t1", REPORT_FORMAT_SAMPLES); err == nil {
+		t.Error("invalid id: want error")
+	}
+	if _, err := ExportReport("t2", REPORT_FORMAT_SAMPLES); err == nil {
+		t.Error("missing samples: want error")
+	}
+}
+
+func TestExportReportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "t1.json")
+	if err := ioutil.WriteFile(source, []byte(`{"successNum":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		format string
+		out    string
+		want   string
+		err    bool
+	}{
+		{out: filepath.Join(dir, "out.html"), want: "<!DOCTYPE html>"},
+		{format: REPORT_FORMAT_CSV, out: filepath.Join(dir, "out.csv"), want: "second,success"},
+		{format: "pdf", out: filepath.Join(dir, "out.pdf"), err: true},
+	}
+	for _, c := range cases {
+		path, err := ExportReportFile(source, c.format, c.out)
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v", c.format, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if content, _ := ioutil.ReadFile(path); path != c.out || !strings.HasPrefix(string(content), c.want) {
+			t.Errorf("%s: %s = %.40s", c.format, path, content)
+		}
+	}
+	if _, err := ExportReportFile(filepath.Join(dir, "none.json"), "", ""); err == nil {
+		t.Error("missing file: want error")
+	}
+}
@@ -1,12 +1,12 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/tidwall/gjson"
 	"net/http"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -15,35 +15,62 @@ type InsaneRequest struct {
 	// 请求赋值
 	HttpRequest   *HttpRequest   `json:"httpRequest"`
 	ScriptRequest *ScriptRequest `json:"scriptRequest"`
-	ConCurrency   uint64         `json:"conCurrent"` // 并发数
-	Duration      uint64         `json:"duration"`   // 持续时间（秒）
-	Interval      int32          `json:"interval"`   // 请求间隔时间
-	Form          string         `json:"form"`       // http|websocket
-	Type          string         `json:"type"`       // 请求模式 （common | capacity） default：common
+	ReplayRequest *ReplayRequest `json:"replayRequest"`
+	GrpcRequest   *GrpcRequest   `json:"grpcRequest"`
+	SocketRequest *SocketRequest `json:"socketRequest"`
+	// 容量模式参数
+	CapacityRequest *CapacityRequest `json:"capacityRequest"`
+	ConCurrency     uint64           `json:"conCurrent"`     // 并发数
+	Duration        uint64           `json:"duration"`       // 持续时间（秒）
+	Interval        int32            `json:"interval"`       // 每个协程的请求间隔（毫秒），按计划时间发送
+	Executor        string           `json:"executor"`       // 执行方式：concurrency（固定并发数，默认）| rate（固定到达速率）
+	Rate            uint64           `json:"rate"`           // rate模式每秒请求数
+	CorrectLatency  bool             `json:"correctLatency"` // 耗时从计划发送时间开始计算，包含目标服务卡顿导致的晚发时间
+	Form            string           `json:"form"`           // http|websocket|script|replay|grpc|tcp|udp|sse
+	Type            string           `json:"type"`           // 请求模式 （common | capacity） default：common
+	Thresholds      []*Threshold     `json:"thresholds"`     // 任务结束时检查的阈值
+	Samples         bool             `json:"samples"`        // 记录每个请求的原始结果
+	Name            string           `json:"name"`           // 任务名称，同名任务与设置的基准对比
 
 	// 系统赋值
 	Id               string            `json:"id"`
 	Status           bool              `json:"status"`
 	Report           *Report           `json:"report"`
 	ScriptReportList *ScriptReportList `json:"scriptReportList"`
-	Stop             chan int
+	keepOpen         bool              // 容量模式的一步，结束时不关闭共用的连接
+	raw              []byte            // 提交的任务配置，写入报告
+	control          *taskControl      // 执行中的暂停、恢复与调整
 }
 
 type Response struct {
-	WasteTime uint64      `json:"wasteTime"` // 消耗时间（毫秒）
-	IsSuccess bool        `json:"isSuccess"` // 是否请求成功
-	ErrCode   int         `json:"errCode"`   // 错误码
-	ErrMsg    string      `json:"errMsg"`    // 错误提示
-	Data      interface{} `json:"data"`      // 响应数据
+	WasteTime     uint64          `json:"wasteTime"`     // 消耗时间（毫秒）
+	IsSuccess     bool            `json:"isSuccess"`     // 是否请求成功
+	ErrCode       int             `json:"errCode"`       // 错误码
+	ErrMsg        string          `json:"errMsg"`        // 错误提示
+	ErrType       string          `json:"errType"`       // 错误分类，见errorType.go
+	Data          interface{}     `json:"data"`          // 响应数据
+	Group         string          `json:"group"`         // 统计分组
+	Proto         string          `json:"proto"`         // 实际使用的协议，如HTTP/2.0
+	ConnReused    bool            `json:"connReused"`    // 是否复用了已有连接
+	Stream        *StreamResponse `json:"stream"`        // 流式响应（sse、http流式读取）的附加信息
+	BytesSent     uint64          `json:"bytesSent"`     // 发送字节数
+	BytesRecv     uint64          `json:"bytesRecv"`     // 接收字节数
+	FirstByteTime uint64          `json:"firstByteTime"` // 首字节时间：发出请求到收到响应第一个字节（毫秒）
+	DownloadTime  uint64          `json:"downloadTime"`  // 下载时间：收到第一个字节到读完响应体（毫秒）
+	Phases        *HttpPhases     `json:"phases"`        // http请求各阶段耗时（微秒）
+	ScheduleLag   uint64          `json:"scheduleLag"`   // 实际发送比计划晚的时间（毫秒）
+	GeneratorLag  uint64          `json:"generatorLag"`  // 其中施压机自身造成的延迟（毫秒）
 }
 
 const (
 	TYPE_HTTP      = "http"
 	TYPE_WEBSOCKET = "websocket"
 	TYPE_SCRIPT    = "script"
-	ADVAMCE_CPU    = 5   // 预请求前，计算cpu时间（秒）
-	ADVANCE_COUNT  = 100 // 预请求协程数
-	ADVANCE_DATE   = 5   // 预请求时间（秒）
+	TYPE_REPLAY    = "replay"
+	TYPE_GRPC      = "grpc"
+	TYPE_TCP       = "tcp"
+	TYPE_UDP       = "udp"
+	TYPE_SSE       = "sse"
 )
 
 func GenerateInsaneRequest() *InsaneRequest {
@@ -54,14 +81,92 @@ func GenerateInsaneRequest() *InsaneRequest {
 
 func (insaneRequest *InsaneRequest) Parse(vc []byte) {
 	data := gjson.ParseBytes(vc)
+	insaneRequest.raw = append([]byte(nil), vc...)
 	insaneRequest.Form = data.Get("form").String()
 	insaneRequest.ConCurrency = data.Get("conCurrent").Uint()
 	insaneRequest.Duration = data.Get("duration").Uint()
+	insaneRequest.Interval = int32(data.Get("interval").Int())
+	insaneRequest.Executor = data.Get("executor").String()
+	insaneRequest.Rate = data.Get("rate").Uint()
+	insaneRequest.CorrectLatency = data.Get("correctLatency").Bool()
+	insaneRequest.Type = data.Get("type").String()
+	insaneRequest.Samples = data.Get("samples").Bool()
+	insaneRequest.Name = data.Get("name").String()
+	if thresholds := data.Get("thresholds"); thresholds.IsArray() {
+		if err := json.Unmarshal([]byte(thresholds.Raw), &insaneRequest.Thresholds); err != nil {
+			logger.Debug(err)
+		}
+	}
 	insaneRequest.Id = data.Get("id").String()
 	insaneRequest.HttpRequest.Parse(data)
+	if steps := data.Get("scriptRequest.data"); steps.IsArray() {
+		insaneRequest.ScriptRequest = &ScriptRequest{
+			Data: steps.Array(),
+		}
+	}
+	if replay := data.Get("replayRequest"); replay.IsObject() {
+		insaneRequest.ReplayRequest = new(ReplayRequest)
+		if err := json.Unmarshal([]byte(replay.Raw), insaneRequest.ReplayRequest); err != nil {
+			logger.Debug(err)
+		}
+	}
+	if grpcData := data.Get("grpcRequest"); grpcData.IsObject() {
+		insaneRequest.GrpcRequest = new(GrpcRequest)
+		if err := json.Unmarshal([]byte(grpcData.Raw), insaneRequest.GrpcRequest); err != nil {
+			logger.Debug(err)
+		}
+	}
+	if socket := data.Get("socketRequest"); socket.IsObject() {
+		insaneRequest.SocketRequest = new(SocketRequest)
+		if err := json.Unmarshal([]byte(socket.Raw), insaneRequest.SocketRequest); err != nil {
+			logger.Debug(err)
+		}
+	}
+	if capacity := data.Get("capacityRequest"); capacity.IsObject() {
+		insaneRequest.CapacityRequest = new(CapacityRequest)
+		if err := json.Unmarshal([]byte(capacity.Raw), insaneRequest.CapacityRequest); err != nil {
+			logger.Debug(err)
+		}
+	}
 }
 
 func (insaneRequest *InsaneRequest) Dispose() {
+	if json.Valid(insaneRequest.raw) {
+		insaneRequest.Report.Config = insaneRequest.raw
+		insaneRequest.ScriptReportList.Config = insaneRequest.raw
+	}
+	insaneRequest.Report.Name = insaneRequest.Name
+	insaneRequest.ScriptReportList.Name = insaneRequest.Name
+	if insaneRequest.Type == TYPE_CAPACITY {
+		insaneRequest.CapacityRequest.run(insaneRequest)
+		if insaneRequest.Form == TYPE_GRPC {
+			insaneRequest.GrpcRequest.Close()
+		}
+		insaneRequest.Status = true
+		logger.Debug("dispose out...")
+		return
+	}
+
+	if insaneRequest.Form == TYPE_GRPC {
+		if err := insaneRequest.GrpcRequest.Connect(); err != nil {
+			logger.Debug(err)
+		}
+	}
+
+	// 按计划时间发送请求的http、grpc、tcp、udp共用一个调度器，在统计协程启动前设置
+	pacer := newPacer(insaneRequest)
+	insaneRequest.Report.setPacer(pacer, insaneRequest.CorrectLatency)
+	if insaneRequest.Form == TYPE_REPLAY {
+		insaneRequest.Report.replay = insaneRequest.ReplayRequest
+	}
+	insaneRequest.HttpRequest.pacer = pacer
+	if insaneRequest.GrpcRequest != nil {
+		insaneRequest.GrpcRequest.pacer = pacer
+	}
+	if insaneRequest.SocketRequest != nil {
+		insaneRequest.SocketRequest.pacer = pacer
+	}
+
 	respCh := make(chan *Response, 1000)
 	scriptRespCh := make(chan *ScriptReport, 1000)
 
@@ -70,45 +175,108 @@ func (insaneRequest *InsaneRequest) Dispose() {
 		wgReceiving sync.WaitGroup // 请求数据统计完成
 	)
 
-	// 统计数据
+	// 统计数据，每个任务只有一个统计协程
+	// 施压机自身的状态与agent上报的被测机器负载每秒写入任务报告
+	generator := newGeneratorReport()
+	targets := make(TargetReports)
+	var monitor *generatorMonitor
+	metrics := InsaneMetrics.task(insaneRequest.Id, insaneRequest.Form)
+	var samples *sampleWriter
+	if insaneRequest.Samples {
+		var err error
+		if samples, err = newSampleWriter(insaneRequest.Id); err != nil {
+			logger.Debug(err)
+		}
+	}
 	wgReceiving.Add(1)
+	switch insaneRequest.Form {
+	case TYPE_SCRIPT:
+		insaneRequest.ScriptReportList.Generator = generator
+		insaneRequest.ScriptReportList.Targets = targets
+		monitor = newGeneratorMonitor(generator, &insaneRequest.ScriptReportList.m, func() (int, int) { return len(scriptRespCh), cap(scriptRespCh) })
+		InsaneAgents.attach(insaneRequest.Id, &insaneRequest.ScriptReportList.m, targets)
+		insaneRequest.ScriptReportList.metrics = metrics
+		insaneRequest.ScriptReportList.samples = samples
+		insaneRequest.ScriptReportList.thresholds = insaneRequest.Thresholds
+		go insaneRequest.ScriptReportList.ReceivingResults(insaneRequest.Id, insaneRequest.ConCurrency, scriptRespCh, &wgReceiving)
+	default:
+		insaneRequest.Report.Generator = generator
+		insaneRequest.Report.Targets = targets
+		monitor = newGeneratorMonitor(generator, &insaneRequest.Report.m, func() (int, int) { return len(respCh), cap(respCh) })
+		InsaneAgents.attach(insaneRequest.Id, &insaneRequest.Report.m, targets)
+		insaneRequest.Report.metrics = metrics
+		insaneRequest.Report.samples = samples
+		insaneRequest.Report.thresholds = insaneRequest.Thresholds
+		go insaneRequest.Report.ReceivingResults(insaneRequest.Id, insaneRequest.ConCurrency, respCh, &wgReceiving)
+	}
+	monitor.metrics = metrics
+	go monitor.run()
 
-	// request.duration时间后,结束所有请求
-	go insaneRequest.timeClosure()
-
-	for i := uint64(0); i < insaneRequest.ConCurrency; i++ {
-		wg.Add(1)
-		switch insaneRequest.Form {
-
-		case TYPE_HTTP:
-			go insaneRequest.Report.ReceivingResults(insaneRequest.Id, insaneRequest.ConCurrency, respCh, &wgReceiving)
-			go insaneRequest.HttpRequest.Run(i, respCh, &wg, insaneRequest.Stop)
+	if insaneRequest.Form == TYPE_REPLAY {
+		insaneRequest.ReplayRequest.Dispatch()
+	}
 
-		case TYPE_WEBSOCKET:
-			go insaneRequest.Report.ReceivingResults(insaneRequest.Id, insaneRequest.ConCurrency, respCh, &wgReceiving)
-			go Websocket(respCh, &wg, insaneRequest)
+	pacer.run()
 
-		case TYPE_SCRIPT:
-			go insaneRequest.ScriptReportList.ReceivingResults(insaneRequest.Id, insaneRequest.ConCurrency, scriptRespCh, &wgReceiving)
-			go insaneRequest.ScriptRequest.Run(i, scriptRespCh, &wg, insaneRequest.Stop)
+	// 启动协程，request.duration时间后结束所有请求，执行中可以暂停或调整
+	insaneRequest.control.start(&wg, pacer, metrics, func(serial uint64, stop <-chan int, done *sync.WaitGroup) {
+		insaneRequest.runWorker(serial, stop, respCh, scriptRespCh, done)
+	})
 
-		default:
-			wg.Done()
+	wg.Wait()
+	insaneRequest.control.finish()
+	metrics.setVus(0)
+	pacer.close()
+	monitor.close()
+	InsaneAgents.detach(insaneRequest.Id)
+	switch insaneRequest.Form {
+	case TYPE_REPLAY:
+		insaneRequest.ReplayRequest.Close()
+	case TYPE_GRPC:
+		if !insaneRequest.keepOpen {
+			insaneRequest.GrpcRequest.Close()
 		}
 	}
-
-	wg.Wait()
 	// 延时1毫秒 确保数据都处理完成了
 	time.Sleep(1 * time.Millisecond)
 	close(respCh)
 	close(scriptRespCh)
-	close(insaneRequest.Stop)
 	insaneRequest.Status = true
 
 	wgReceiving.Wait()
 	logger.Debug("dispose out...")
 }
 
+func (insaneRequest *InsaneRequest) runWorker(serial uint64, stop <-chan int, respCh chan<- *Response, scriptRespCh chan<- *ScriptReport, wg *sync.WaitGroup) {
+	wg.Add(1)
+	switch insaneRequest.Form {
+
+	case TYPE_HTTP:
+		go insaneRequest.HttpRequest.Run(serial, respCh, wg, stop)
+
+	case TYPE_SSE:
+		go insaneRequest.HttpRequest.SseRun(serial, respCh, wg, stop)
+
+	case TYPE_WEBSOCKET:
+		go Websocket(respCh, wg, insaneRequest, stop)
+
+	case TYPE_SCRIPT:
+		go insaneRequest.ScriptRequest.Run(serial, scriptRespCh, wg, stop)
+
+	case TYPE_REPLAY:
+		go insaneRequest.ReplayRequest.Run(serial, respCh, wg, stop)
+
+	case TYPE_GRPC:
+		go insaneRequest.GrpcRequest.Run(serial, respCh, wg, stop)
+
+	case TYPE_TCP, TYPE_UDP:
+		go insaneRequest.SocketRequest.Run(serial, respCh, wg, stop)
+
+	default:
+		wg.Done()
+	}
+}
+
 func (insaneRequest *InsaneRequest) Close() (err error) {
 	defer func() {
 		if err2 := recover(); err2 != nil {
@@ -120,10 +288,61 @@ func (insaneRequest *InsaneRequest) Close() (err error) {
 }
 
 func (insaneRequest *InsaneRequest) VerifyParam() (err error) {
+	switch insaneRequest.Type {
+	case "":
+		insaneRequest.Type = TYPE_COMMON
+	case TYPE_COMMON:
+	case TYPE_CAPACITY:
+		if insaneRequest.CapacityRequest == nil {
+			return errors.New("容量模式参数不能为空")
+		}
+		if err = insaneRequest.CapacityRequest.Load(insaneRequest); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("type必须是%s | %s", TYPE_COMMON, TYPE_CAPACITY)
+	}
+	if err = insaneRequest.verifySchedule(); err != nil {
+		return
+	}
+	if err = verifyThresholds(insaneRequest.Thresholds); err != nil {
+		return
+	}
+	if insaneRequest.Form == TYPE_SCRIPT {
+		if insaneRequest.ScriptRequest == nil || len(insaneRequest.ScriptRequest.Data) == 0 {
+			err = errors.New("脚本步骤不能为空")
+		}
+		return
+	}
+	if insaneRequest.Form == TYPE_REPLAY {
+		if insaneRequest.ReplayRequest == nil {
+			return errors.New("回放参数不能为空")
+		}
+		return insaneRequest.ReplayRequest.Load()
+	}
+	if insaneRequest.Form == TYPE_GRPC {
+		if insaneRequest.GrpcRequest == nil {
+			return errors.New("grpc参数不能为空")
+		}
+		return insaneRequest.GrpcRequest.Load()
+	}
+	if insaneRequest.Form == TYPE_TCP || insaneRequest.Form == TYPE_UDP {
+		if insaneRequest.SocketRequest == nil {
+			return errors.New("socket参数不能为空")
+		}
+		return insaneRequest.SocketRequest.Load(insaneRequest.Form)
+	}
 	if insaneRequest.HttpRequest.Url == "" || insaneRequest.Form == "" {
 		err = errors.New("参数缺少")
+		return
 	}
-	return
+	if insaneRequest.Form == TYPE_HTTP || insaneRequest.Form == TYPE_SSE {
+		httpBody := insaneRequest.HttpRequest.HttpBody
+		if err = httpBody.verifyFields(httpBody.Body, false); err != nil {
+			return
+		}
+	}
+	return insaneRequest.HttpRequest.VerifyProtocol()
 }
 
 func (insaneRequest *InsaneRequest) VerifyUrl() (err error) {
@@ -131,7 +350,7 @@ func (insaneRequest *InsaneRequest) VerifyUrl() (err error) {
 	if err != nil {
 		return
 	}
-	resp, err := insaneRequest.HttpRequest.client.Do(req)
+	resp, err := insaneRequest.HttpRequest.getClient().Do(req)
 	if err != nil {
 		return
 	}
@@ -141,137 +360,14 @@ func (insaneRequest *InsaneRequest) VerifyUrl() (err error) {
 	return
 }
 
-func (insaneRequest *InsaneRequest) timeClosure() {
-	// recover一下，避免提前结束任务后,关闭stop导致的panic
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Debug(err)
-		}
-	}()
-
-	t := time.After(time.Duration(insaneRequest.Duration) * time.Second)
-	<-t
-
-	if !insaneRequest.Status { // 如果请求正在执行，终止它
-		insaneRequest.closeRequest()
-	}
-}
-
-func (insaneRequest *InsaneRequest) initStopCh() {
-	if insaneRequest.ConCurrency > 0 {
-		insaneRequest.Stop = make(chan int, insaneRequest.ConCurrency)
-	}
+func (insaneRequest *InsaneRequest) initControl() {
+	insaneRequest.control = &taskControl{insaneRequest: insaneRequest}
 }
 
 func (insaneRequest *InsaneRequest) closeRequest() {
-	for i := uint64(0); i < insaneRequest.ConCurrency; i++ {
-		insaneRequest.Stop <- 1
-	}
-	logger.Debug("close signal len: ", len(insaneRequest.Stop))
-}
-
-// 智能模式
-// 生成一些请求来计算服务器负载，在计算出并发数
-// （生成请求数） / （cpu消耗百分比）= （单个请求消耗cpu百分比）
-func (insaneRequest *InsaneRequest) Capacity() (avgLoad float64, err error) {
-
-	logger.Debug("统计请求前的cpu负载...")
-	// 获取最近五次的cpu百分比
-	cpuLoad := InsaneLoad.GetLatelyCpuLoad(ADVAMCE_CPU)
-
-	logger.Debug("智能模式开始...")
-
-	if err = insaneRequest.VerifyUrl(); err != nil {
-		return 0, err
-	}
-
-	if insaneRequest.Form == "http" {
-		curlCh := make(chan uint32)
-		go insaneRequest.advanceHttp(curlCh)
-		reqCpuLoad := insaneRequest.requestCpuLoad(curlCh)
-
-		logger.Debug("统计负载结束...")
-
-		// 放弃负载slice的最后两个,请求结束时负载会瞬间降下来，这时统计负载的协程可能还没结束，导致统计不准确
-		if len(reqCpuLoad) > 2 {
-			reqCpuLoad = reqCpuLoad[0 : len(reqCpuLoad)-2]
-		}
-		var cpuLoadBeforeCount uint32 = 0
-		for _, load := range cpuLoad {
-			cpuLoadBeforeCount += load
-		}
-		var cpuLoadAfterCount uint32 = 0
-		for _, load := range reqCpuLoad {
-			cpuLoadAfterCount += load
-		}
-
-		logger.Debug(cpuLoad)
-		logger.Debug(reqCpuLoad)
-
-		reqLen := float64(len(reqCpuLoad))
-		reqBeforeAvg, err := strconv.ParseFloat(fmt.Sprintf("%.2f", float64(cpuLoadBeforeCount)/ADVAMCE_CPU), 64)
-		reqAfterAvg, err := strconv.ParseFloat(fmt.Sprintf("%.2f", float64(cpuLoadAfterCount)/reqLen), 64)
-
-		// 请求中的cpu负载平均值小于请求前的cpu负载平均值
-		// 可能是目标网站带宽性能等问题，导致负载上不去
-		if reqBeforeAvg >= reqAfterAvg {
-			return ADVANCE_COUNT, nil
-		}
-
-		reqAvg := reqAfterAvg - reqBeforeAvg
-		avgLoad, err = strconv.ParseFloat(fmt.Sprintf("%.1f", reqAvg/ADVANCE_COUNT), 64)
-		return avgLoad, err
-	}
-
-	if insaneRequest.Form == "websocket" {
-
-	}
-
-	return 0, errors.New("智能模式必须是http | websocket")
-}
-
-func (insaneRequest *InsaneRequest) requestCpuLoad(curlCh chan uint32) (reqCpuLoad []uint32) {
-	logger.Debug("统计负载开始...")
-	for {
-		select {
-		case <-curlCh:
-			return
-		default:
-			reqCpuLoad = append(reqCpuLoad, InsaneLoad.getCpuLoad())
-		}
-	}
-}
-
-func (insaneRequest *InsaneRequest) advanceHttp(curlCh chan uint32) {
-
-	logger.Debug("正在执行预请求...")
-
-	var adMutex sync.Mutex
-	var reqNum uint64
-	for i := 0; i < ADVANCE_COUNT; i++ {
-		go func() {
-			t := time.NewTicker(time.Duration(ADVANCE_DATE) * time.Second)
-			for {
-				select {
-				case <-t.C:
-					adMutex.Lock()
-					reqNum++
-					if reqNum >= ADVANCE_COUNT {
-						curlCh <- 1
-						logger.Debug("预请求结束...")
-					}
-					adMutex.Unlock()
-					return
-				default:
-					req, _ := http.NewRequest(insaneRequest.HttpRequest.Method, insaneRequest.HttpRequest.Url, nil)
-					resp, err := insaneRequest.HttpRequest.client.Do(req)
-					if err != nil {
-						logger.Debug(err)
-						continue
-					}
-					resp.Body.Close()
-				}
-			}
-		}()
+	if insaneRequest.Type == TYPE_CAPACITY {
+		insaneRequest.CapacityRequest.stop()
+		return
 	}
+	insaneRequest.control.stop()
 }
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"os"
+)
+
+// 每个请求的原始结果，任务执行期间写入 <id>.samples.jsonl.gz
+type RawSample struct {
+	Time      int64  `json:"time"`           // 统计时间（毫秒）
+	Step      string `json:"step,omitempty"` // 分组或脚本步骤名
+	Latency   uint64 `json:"latency"`        // 耗时（毫秒）
+	Success   bool   `json:"success"`
+	Code      int    `json:"code,omitempty"` // 状态码或错误码
+	BytesSent uint64 `json:"bytesSent"`
+	BytesRecv uint64 `json:"bytesRecv"`
+	ErrType   string `json:"errType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type sampleWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+func samplesFile(id string) string {
+	return fmt.Sprintf("%s/%s.samples.jsonl.gz", appconfig.GetConfig().Log.Location, id)
+}
+
+func newSampleWriter(id string) (*sampleWriter, error) {
+	file, err := os.Create(samplesFile(id))
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	buf := bufio.NewWriter(gz)
+	return &sampleWriter{file: file, gz: gz, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (writer *sampleWriter) write(step string, data *Response) {
+	if writer == nil || !data.Stream.isRequest() {
+		return
+	}
+	sample := &RawSample{
+		Time:      utils.Now(),
+		Step:      step,
+		Latency:   data.WasteTime,
+		Success:   data.IsSuccess,
+		Code:      data.ErrCode,
+		BytesSent: data.BytesSent,
+		BytesRecv: data.BytesRecv,
+		ErrType:   data.ErrType,
+		Error:     data.ErrMsg,
+	}
+	if err := writer.enc.Encode(sample); err != nil {
+		logger.Debug(err)
+	}
+}
+
+func (writer *sampleWriter) close() {
+	if writer == nil {
+		return
+	}
+	if err := writer.buf.Flush(); err != nil {
+		logger.Debug(err)
+	}
+	if err := writer.gz.Close(); err != nil {
+		logger.Debug(err)
+	}
+	if err := writer.file.Close(); err != nil {
+		logger.Debug(err)
+	}
+}
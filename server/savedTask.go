@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	SAVED_TASK_PATH     = "./data/test_task"
+	SAVED_SCRIPT_PATH   = "./data/test_script"
+	SAVED_DATA_PATH     = "./data/test_data"
+	SAVED_TASK_DURATION = 60 // 保存的任务没有设置执行时间时的默认值（秒）
+)
+
+// 保存的任务解析后的来源，写入请求参数，随配置快照保存在报告中
+type SavedTaskSource struct {
+	Task      string            `json:"task"`
+	Script    string            `json:"script,omitempty"`
+	Overrides json.RawMessage   `json:"overrides,omitempty"`
+	Files     []*SavedTaskFile  `json:"files"`
+	Data      map[string]string `json:"data,omitempty"` // 引用的test_data与对应的文件
+	Resolved  string            `json:"resolved"`
+}
+
+// 任务引用的上传文件，记录大小和md5，复现时可以确认数据文件没有变化
+type SavedTaskFile struct {
+	Name    string   `json:"name"`
+	Size    int64    `json:"size"`
+	Md5     string   `json:"md5"`
+	Columns []string `json:"columns,omitempty"` // csv文件用到的列
+}
+
+// 保存的任务解析时的状态
+type savedTaskResolver struct {
+	source *SavedTaskSource
+	files  map[string]*SavedTaskFile
+	header map[string][]string // 已经读取过的csv表头
+}
+
+// 读取保存的任务并加入任务列表需要的请求
+func NewSavedTaskRequest(name string, overrides []byte) (*InsaneRequest, error) {
+	body, err := SavedTaskRequest(name, overrides)
+	if err != nil {
+		return nil, err
+	}
+	insaneRequest := GenerateInsaneRequest()
+	insaneRequest.Parse(body)
+	return insaneRequest, nil
+}
+
+// 读取data/test_task中保存的任务，转换成/request的请求参数
+// 文件中有form时就是完整的请求参数，否则按前端保存的格式；testScript引用test_script中的脚本
+// overrides中的字段覆盖任务中的同名字段，引用的数据文件和列在加入任务列表前检查
+func SavedTaskRequest(name string, overrides []byte) ([]byte, error) {
+	content, err := savedFile(SAVED_TASK_PATH, name)
+	if err != nil {
+		return nil, fmt.Errorf("任务%s不存在", name)
+	}
+	task := gjson.ParseBytes(content)
+	request := make(map[string]interface{})
+	if task.Get("form").Exists() {
+		if err := decodeJson(content, &request); err != nil {
+			return nil, fmt.Errorf("任务%s格式错误", name)
+		}
+	} else {
+		conCurrent, _ := strconv.ParseUint(task.Get("testConCurrent").String(), 10, 64)
+		duration, _ := strconv.ParseUint(task.Get("testDuration").String(), 10, 64)
+		if duration == 0 {
+			duration = SAVED_TASK_DURATION
+		}
+		request["form"] = TYPE_SCRIPT
+		request["name"] = task.Get("testName").String()
+		request["conCurrent"] = conCurrent
+		request["duration"] = duration
+	}
+
+	resolver := &savedTaskResolver{
+		source: &SavedTaskSource{Task: name, Script: task.Get("testScript").String()},
+		files:  make(map[string]*SavedTaskFile),
+		header: make(map[string][]string),
+	}
+	if _, ok := request["scriptRequest"]; !ok && resolver.source.Script != "" {
+		steps, err := savedScriptSteps(resolver.source.Script)
+		if err != nil {
+			return nil, err
+		}
+		request["scriptRequest"] = map[string]interface{}{"data": steps}
+	}
+
+	if len(bytes.TrimSpace(overrides)) > 0 {
+		values := make(map[string]interface{})
+		if err := decodeJson(overrides, &values); err != nil {
+			return nil, errors.New("overrides必须是json对象")
+		}
+		for key, value := range values {
+			request[key] = value
+		}
+		resolver.source.Overrides = overrides
+	}
+	delete(request, "id")
+
+	if err := resolver.resolve(request); err != nil {
+		return nil, fmt.Errorf("任务%s：%s", name, err.Error())
+	}
+	for _, file := range resolver.files {
+		sort.Strings(file.Columns)
+		resolver.source.Files = append(resolver.source.Files, file)
+	}
+	sort.Slice(resolver.source.Files, func(i, j int) bool { return resolver.source.Files[i].Name < resolver.source.Files[j].Name })
+	resolver.source.Resolved = time.Now().Format("2006-01-02 15:04:05")
+	request["savedTask"] = resolver.source
+	return json.Marshal(request)
+}
+
+// 前端保存的脚本步骤，header、body与data同级，请求参数中在data内
+func savedScriptSteps(script string) ([]interface{}, error) {
+	content, err := savedFile(SAVED_SCRIPT_PATH, script)
+	if err != nil {
+		return nil, fmt.Errorf("脚本%s不存在", script)
+	}
+	transaction := gjson.GetBytes(content, "testTransaction")
+	raw := transaction.Raw
+	if transaction.Type == gjson.String {
+		raw = transaction.String()
+	}
+	var list []map[string]interface{}
+	if err := decodeJson([]byte(raw), &list); err != nil || len(list) == 0 {
+		return nil, fmt.Errorf("脚本%s没有步骤", script)
+	}
+	steps := make([]interface{}, 0, len(list))
+	for _, step := range list {
+		data, ok := step["data"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("脚本%s的步骤格式错误", script)
+		}
+		for _, key := range []string{"header", "body"} {
+			if _, ok := data[key]; !ok && step[key] != nil {
+				data[key] = step[key]
+			}
+		}
+		steps = append(steps, map[string]interface{}{"data": data})
+	}
+	return steps, nil
+}
+
+// 遍历请求参数，把引用test_data的字段换成对应的文件，检查文件与csv的列是否存在
+func (resolver *savedTaskResolver) resolve(value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := resolver.resolve(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if err := resolver.field(value); err != nil {
+			return err
+		}
+		for _, item := range value {
+			if err := resolver.resolve(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (resolver *savedTaskResolver) field(value map[string]interface{}) error {
+	if testData, _ := value["testData"].(string); testData != "" {
+		file, err := resolver.dataFile(testData)
+		if err != nil {
+			return err
+		}
+		if _, err := resolver.file(file); err != nil {
+			return err
+		}
+	}
+	if bodyFile, _ := value["bodyFile"].(string); bodyFile != "" {
+		if _, err := resolver.file(bodyFile); err != nil {
+			return err
+		}
+	}
+	dynamic, _ := value["dynamic"].(string)
+	switch value["type"] {
+	case "upload":
+		_, err := resolver.file(dynamic)
+		return err
+	case "file":
+		info := strings.Split(dynamic, HTTP_RESPONSE_FIELD_SEP)
+		if len(info) != 2 || info[0] == "" || info[1] == "" {
+			return fmt.Errorf("字段%v的数据文件格式为 文件---列", value["name"])
+		}
+		fileName, column := info[0], info[1]
+		if _, err := os.Stat(uploadFilePath(fileName)); err != nil {
+			// 不是上传的文件时按test_data中保存的数据查找
+			if fileName, err = resolver.dataFile(fileName); err != nil {
+				return fmt.Errorf("数据文件%s不存在", info[0])
+			}
+			value["dynamic"] = fileName + HTTP_RESPONSE_FIELD_SEP + column
+		}
+		return resolver.column(fileName, column)
+	}
+	return nil
+}
+
+// test_data中保存的数据对应的上传文件
+func (resolver *savedTaskResolver) dataFile(name string) (string, error) {
+	content, err := savedFile(SAVED_DATA_PATH, name)
+	if err != nil {
+		return "", fmt.Errorf("数据%s不存在", name)
+	}
+	file := gjson.GetBytes(content, "file.name").String()
+	if file == "" {
+		return "", fmt.Errorf("数据%s没有文件", name)
+	}
+	if resolver.source.Data == nil {
+		resolver.source.Data = make(map[string]string)
+	}
+	resolver.source.Data[name] = file
+	return file, nil
+}
+
+// csv文件的表头中有这一列，并且至少有一行数据
+func (resolver *savedTaskResolver) column(fileName string, column string) error {
+	file, err := resolver.file(fileName)
+	if err != nil {
+		return err
+	}
+	header, ok := resolver.header[fileName]
+	if !ok {
+		f, err := os.Open(uploadFilePath(fileName))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader := csv.NewReader(f)
+		if header, err = reader.Read(); err != nil {
+			return fmt.Errorf("数据文件%s不是csv文件", fileName)
+		}
+		if _, err := reader.Read(); err != nil {
+			return fmt.Errorf("数据文件%s没有数据", fileName)
+		}
+		resolver.header[fileName] = header
+	}
+	for _, name := range header {
+		if name != column {
+			continue
+		}
+		for _, used := range file.Columns {
+			if used == column {
+				return nil
+			}
+		}
+		file.Columns = append(file.Columns, column)
+		return nil
+	}
+	return fmt.Errorf("数据文件%s中没有%s列", fileName, column)
+}
+
+// 上传目录中的文件，同一个文件只计算一次md5
+func (resolver *savedTaskResolver) file(fileName string) (*SavedTaskFile, error) {
+	if file, ok := resolver.files[fileName]; ok {
+		return file, nil
+	}
+	f, err := os.Open(uploadFilePath(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("文件%s不存在", fileName)
+	}
+	defer f.Close()
+	hash := md5.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	file := &SavedTaskFile{Name: fileName, Size: size, Md5: hex.EncodeToString(hash.Sum(nil))}
+	resolver.files[fileName] = file
+	return file, nil
+}
+
+func uploadFilePath(fileName string) string {
+	return fmt.Sprintf("%s/%s", appconfig.GetConfig().File.UploadPath, filepath.Base(fileName))
+}
+
+// 保留数字的原始格式，避免大整数转成float64
+func decodeJson(content []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// 任务、脚本和数据的名称就是文件名，不能包含路径
+func savedFile(path string, name string) ([]byte, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.Contains(name, "..") {
+		return nil, errors.New("文件名错误")
+	}
+	content, err := utils.FileGet(fmt.Sprintf("%s/%s.json", path, name))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"github.com/tidwall/gjson"
+	"insane/general/base/appconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 在临时目录中准备保存的任务、脚本、数据与上传文件
+func prepareSavedTasks(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadPath := appconfig.GetConfig().File.UploadPath
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	appconfig.GetConfig().File.UploadPath = filepath.Join(dir, "upload")
+	return func() {
+		appconfig.GetConfig().File.UploadPath = uploadPath
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSavedTaskRequest(t *testing.T) {
+	defer prepareSavedTasks(t, map[string]string{
+		"data/test_task/order.json": `{"form":"http","id":"old","name":"order","conCurrent":1,"duration":10,"seq":12345678901234567890,
+			"httpRequest":{"url":"http://127.0.0.1/order","query":[{"name":"u","type":"file","dynamic":"users---name"},{"name":"p","type":"file","dynamic":"users.csv---pass"}]}}`,
+		"data/test_task/login.json":    `{"testName":"login","testConCurrent":"3","testScript":"flow"}`,
+		"data/test_task/nodata.json":   `{"form":"http","httpRequest":{"query":[{"name":"u","type":"file","dynamic":"empty.csv---name"}]}}`,
+		"data/test_task/nocol.json":    `{"form":"http","httpRequest":{"query":[{"name":"u","type":"file","dynamic":"users.csv---age"}]}}`,
+		"data/test_task/badfile.json":  `{"form":"http","httpRequest":{"query":[{"name":"u","type":"file","dynamic":"users.csv"}]}}`,
+		"data/test_task/noupload.json": `{"form":"http","httpRequest":{"body":[{"name":"f","type":"upload","dynamic":"none.png"}]}}`,
+		"data/test_task/noscript.json": `{"testName":"x","testScript":"none"}`,
+		"data/test_script/flow.json":   `{"testTransaction":"[{\"data\":{\"url\":\"http://127.0.0.1/login\",\"testData\":\"users\"},\"header\":[{\"name\":\"a\"}],\"body\":[]}]"}`,
+		"data/test_data/users.json":    `{"file":{"name":"users.csv"}}`,
+		"upload/users.csv":             "name,pass\ntom,123\n",
+		"upload/empty.csv":             "name\n",
+	})()
+
+	body, err := SavedTaskRequest("order", []byte(`{"conCurrent":5,"duration":20}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := gjson.ParseBytes(body)
+	if request.Get("id").Exists() || request.Get("conCurrent").Int() != 5 || request.Get("duration").Int() != 20 || request.Get("name").String() != "order" {
+		t.Errorf("request = %s", body)
+	}
+	// 大整数保留原始格式，test_data换成对应的上传文件
+	if request.Get("seq").Raw != "12345678901234567890" || request.Get("httpRequest.query.0.dynamic").String() != "users.csv---name" {
+		t.Errorf("request = %s", body)
+	}
+	source := request.Get("savedTask")
+	files := source.Get("files").Array()
+	if source.Get("task").String() != "order" || source.Get("overrides.conCurrent").Int() != 5 || source.Get("data.users").String() != "users.csv" || len(files) != 1 {
+		t.Fatalf("source = %s", source.Raw)
+	}
+	if file := files[0]; file.Get("name").String() != "users.csv" || file.Get("size").Int() != 18 || len(file.Get("md5").String()) != 32 ||
+		file.Get("columns").Raw != `["name","pass"]` {
+		t.Errorf("file = %s", file.Raw)
+	}
+
+	body, err = SavedTaskRequest("login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = gjson.ParseBytes(body)
+	step := request.Get("scriptRequest.data.0.data")
+	if request.Get("form").String() != TYPE_SCRIPT || request.Get("conCurrent").Int() != 3 || request.Get("duration").Int() != SAVED_TASK_DURATION ||
+		step.Get("header.0.name").String() != "a" || !step.Get("body").IsArray() || request.Get("savedTask.script").String() != "flow" {
+		t.Errorf("request = %s", body)
+	}
+
+	cases := []struct {
+		name      string
+		overrides string
+	}{
+		{name: "none"},
+		{name: "../order"},
+		{name: "order", overrides: `[1]`},
+		{name: "nodata"},
+		{name: "nocol"},
+		{name: "badfile"},
+		{name: "noupload"},
+		{name: "noscript"},
+	}
+	for _, c := range cases {
+		if _, err := SavedTaskRequest(c.name, []byte(c.overrides)); err == nil {
+			t.Errorf("%s %s: want error", c.name, c.overrides)
+		}
+	}
+}
+
+func TestSavedFile(t *testing.T) {
+	for _, name := range []string{"", "a/b", `a\b`, "..", "a..b"} {
+		if _, err := savedFile(SAVED_TASK_PATH, name); err == nil {
+			t.Errorf("%q: want error", name)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EXECUTOR_CONCURRENCY = "concurrency" // 固定并发数，每个协程发完一个请求再发下一个，默认
+	EXECUTOR_RATE        = "rate"        // 固定到达速率，每秒发出rate个请求，由conCurrent个协程执行
+
+	SCHEDULE_LAG_LIMIT = 10     // 施压机自身造成的延迟超过该值（毫秒）视为跟不上计划
+	SCHEDULE_MAX_RATE  = 100000 // rate模式单个施压机的最大速率
+)
+
+// 按计划发送时间调度请求。每个请求都有计划发送时间，目标服务卡顿时后续请求会晚于计划发送，
+// 从计划发送时间开始计算耗时可以避免卡顿期间的延迟被少算（coordinated omission）
+type pacer struct {
+	executor string
+	interval time.Duration // concurrency：每个协程的请求间隔
+	period   time.Duration // rate：全局的请求间隔
+	workers  uint64        // concurrency：当前协程数，调整协程数后新启动的协程按新的数量错开
+	start    time.Time
+	ticks    chan pacerTick // rate：按最大速率分配，积压的数量由当前速率限制
+	done     chan struct{}
+	adjust   chan uint64 // rate：执行中调整每秒请求数，0为暂停
+	dropped  uint64      // rate：没有空闲协程、积压超过1秒的请求量而放弃的请求数
+}
+
+type pacerTick struct {
+	intended time.Time
+	late     time.Duration // 调度协程自身的延迟
+}
+
+// 每个协程的调度状态
+type pacerSlot struct {
+	pacer *pacer
+	next  time.Time
+}
+
+// 请求实际发送与计划发送的差
+type pacerLag struct {
+	schedule  time.Duration
+	generator time.Duration
+}
+
+// 没有设置请求间隔且不是rate模式时不需要调度，返回nil
+func newPacer(insaneRequest *InsaneRequest) *pacer {
+	switch {
+	case insaneRequest.Executor == EXECUTOR_RATE:
+		return &pacer{
+			executor: EXECUTOR_RATE,
+			period:   time.Second / time.Duration(insaneRequest.Rate),
+			workers:  insaneRequest.ConCurrency,
+			ticks:    make(chan pacerTick, SCHEDULE_MAX_RATE),
+			done:     make(chan struct{}),
+			adjust:   make(chan uint64),
+		}
+	case insaneRequest.Interval > 0:
+		return &pacer{
+			executor: EXECUTOR_CONCURRENCY,
+			interval: time.Duration(insaneRequest.Interval) * time.Millisecond,
+			workers:  insaneRequest.ConCurrency,
+			done:     make(chan struct{}),
+		}
+	}
+	return nil
+}
+
+func (insaneRequest *InsaneRequest) verifySchedule() error {
+	switch insaneRequest.Executor {
+	case "":
+		insaneRequest.Executor = EXECUTOR_CONCURRENCY
+	case EXECUTOR_CONCURRENCY:
+	case EXECUTOR_RATE:
+		if insaneRequest.Rate == 0 || insaneRequest.Rate > SCHEDULE_MAX_RATE {
+			return fmt.Errorf("rate模式需要设置rate，范围1-%d", SCHEDULE_MAX_RATE)
+		}
+	default:
+		return fmt.Errorf("executor必须是%s | %s", EXECUTOR_CONCURRENCY, EXECUTOR_RATE)
+	}
+	if insaneRequest.Interval < 0 {
+		return errors.New("interval不能小于0")
+	}
+	if insaneRequest.Executor == EXECUTOR_RATE || insaneRequest.Interval > 0 {
+		switch insaneRequest.Form {
+		case TYPE_HTTP, TYPE_GRPC, TYPE_TCP, TYPE_UDP:
+		default:
+			return fmt.Errorf("rate模式和interval只支持%s | %s | %s | %s", TYPE_HTTP, TYPE_GRPC, TYPE_TCP, TYPE_UDP)
+		}
+	}
+	return nil
+}
+
+func (pacer *pacer) run() {
+	if pacer == nil {
+		return
+	}
+	pacer.start = time.Now()
+	if pacer.executor == EXECUTOR_RATE {
+		go pacer.dispatch()
+	}
+}
+
+func (pacer *pacer) close() {
+	if pacer != nil {
+		close(pacer.done)
+	}
+}
+
+func (pacer *pacer) droppedNum() uint64 {
+	if pacer == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&pacer.dropped)
+}
+
+// rate：按计划时间发出请求，一次唤醒发出所有到期的请求，避免高速率时频繁休眠
+// 调整速率后从调整的时间重新计算计划时间
+func (pacer *pacer) dispatch() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var (
+		n      int64
+		start  = pacer.start
+		period = pacer.period
+		rate   = uint64(time.Second / period)
+		paused bool
+	)
+	for {
+		select {
+		case <-pacer.done:
+			return
+		case rate = <-pacer.adjust:
+			if !timer.Stop() && !paused {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if paused = rate == 0; paused {
+				pacer.drain(0)
+				continue
+			}
+			// 降低速率时放弃超过1秒的积压
+			pacer.drain(rate)
+			start, period, n = time.Now(), time.Second/time.Duration(rate), 0
+			timer.Reset(0)
+			continue
+		case <-timer.C:
+		}
+		now := time.Now()
+		for ; ; n++ {
+			intended := start.Add(time.Duration(n) * period)
+			if intended.After(now) {
+				timer.Reset(intended.Sub(now))
+				break
+			}
+			// 只有这个协程发送，检查积压后发送不会阻塞
+			if uint64(len(pacer.ticks)) < rate {
+				pacer.ticks <- pacerTick{intended: intended, late: now.Sub(intended)}
+			} else {
+				atomic.AddUint64(&pacer.dropped, 1)
+			}
+		}
+	}
+}
+
+// rate：积压的请求只保留limit个，暂停时全部丢弃
+func (pacer *pacer) drain(limit uint64) {
+	for uint64(len(pacer.ticks)) > limit {
+		select {
+		case <-pacer.ticks:
+			if limit > 0 {
+				atomic.AddUint64(&pacer.dropped, 1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// rate：调整每秒请求数，0为暂停
+func (pacer *pacer) setRate(rate uint64) {
+	if pacer == nil || pacer.executor != EXECUTOR_RATE {
+		return
+	}
+	select {
+	case pacer.adjust <- rate:
+	case <-pacer.done:
+	}
+}
+
+// concurrency：调整协程数
+func (pacer *pacer) setWorkers(workers uint64) {
+	if pacer == nil || pacer.executor != EXECUTOR_CONCURRENCY {
+		return
+	}
+	atomic.StoreUint64(&pacer.workers, workers)
+}
+
+// 协程错开计划时间，避免同时发出请求
+func (pacer *pacer) slot(serial uint64) *pacerSlot {
+	slot := &pacerSlot{pacer: pacer}
+	if pacer != nil && pacer.executor == EXECUTOR_CONCURRENCY {
+		// 执行中新增或恢复的协程从当前时间开始，不补发之前的请求
+		start := pacer.start
+		if now := time.Now(); now.Sub(start) > pacer.interval {
+			start = now
+		}
+		workers := atomic.LoadUint64(&pacer.workers)
+		slot.next = start.Add(pacer.interval * time.Duration(serial%workers) / time.Duration(workers))
+	}
+	return slot
+}
+
+// 等待下一个请求的计划发送时间，收到结束信号时返回false
+func (slot *pacerSlot) wait(stopCh <-chan int) (lag pacerLag, ok bool) {
+	pacer := slot.pacer
+	if pacer == nil {
+		select {
+		case <-stopCh:
+			return lag, false
+		default:
+			return lag, true
+		}
+	}
+
+	if pacer.executor == EXECUTOR_RATE {
+		select {
+		case <-stopCh:
+			return lag, false
+		case tick := <-pacer.ticks:
+			// 排队等待空闲协程的时间计入计划延迟
+			lag.schedule = time.Since(tick.intended)
+			lag.generator = tick.late
+			return lag, true
+		}
+	}
+
+	intended := slot.next
+	slot.next = slot.next.Add(pacer.interval)
+	if wait := time.Until(intended); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-stopCh:
+			return lag, false
+		case <-timer.C:
+		}
+		// 休眠后醒来的延迟是施压机自身造成的
+		lag.schedule = time.Since(intended)
+		lag.generator = lag.schedule
+		return lag, true
+	}
+	// 上一个请求超过了间隔时间，立即发送，延迟由目标服务造成
+	select {
+	case <-stopCh:
+		return lag, false
+	default:
+	}
+	lag.schedule = time.Since(intended)
+	return lag, true
+}
+
+func (lag pacerLag) set(resp *Response) {
+	resp.ScheduleLag = uint64(lag.schedule / time.Millisecond)
+	resp.GeneratorLag = uint64(lag.generator / time.Millisecond)
+}
+
+// 按计划发送时间统计的耗时
+type ScheduleReport struct {
+	Executor       string     `json:"executor"`       // concurrency | rate | replay
+	Rate           uint64     `json:"rate"`           // rate：每秒请求数
+	Interval       uint64     `json:"interval"`       // concurrency：每个协程的请求间隔（毫秒）
+	Corrected      *Histogram `json:"corrected"`      // 从计划发送时间开始计算的耗时（毫秒）
+	Uncorrected    *Histogram `json:"uncorrected"`    // 从实际发送时间开始计算的耗时（毫秒）
+	Lag            *Histogram `json:"lag"`            // 实际发送比计划晚的时间（毫秒）
+	GeneratorLag   uint64     `json:"generatorLag"`   // 施压机自身造成的最大延迟（毫秒）
+	Dropped        uint64     `json:"dropped"`        // rate：没有空闲协程而放弃的请求数
+	BehindSchedule bool       `json:"behindSchedule"` // 施压机自身跟不上计划，结果偏乐观，需要增加协程数或施压机
+}
+
+func newScheduleReport(pacer *pacer) *ScheduleReport {
+	schedule := &ScheduleReport{
+		Executor:    pacer.executor,
+		Corrected:   NewHistogram(),
+		Uncorrected: NewHistogram(),
+		Lag:         NewHistogram(),
+	}
+	if pacer.executor == EXECUTOR_RATE {
+		schedule.Rate = uint64(time.Second / pacer.period)
+	} else {
+		schedule.Interval = uint64(pacer.interval / time.Millisecond)
+	}
+	return schedule
+}
+
+func (schedule *ScheduleReport) add(data *Response) {
+	schedule.Lag.Add(data.ScheduleLag)
+	if data.IsSuccess {
+		schedule.Uncorrected.Add(data.WasteTime)
+		schedule.Corrected.Add(data.WasteTime + data.ScheduleLag)
+	}
+	if data.GeneratorLag > schedule.GeneratorLag {
+		schedule.GeneratorLag = data.GeneratorLag
+	}
+	schedule.check()
+}
+
+func (schedule *ScheduleReport) setDropped(dropped uint64) {
+	schedule.Dropped = dropped
+	schedule.check()
+}
+
+func (schedule *ScheduleReport) check() {
+	schedule.BehindSchedule = schedule.Dropped > 0 || schedule.GeneratorLag > SCHEDULE_LAG_LIMIT
+}
+
+func (schedule *ScheduleReport) merge(other *ScheduleReport) {
+	schedule.Rate += other.Rate
+	schedule.Corrected.Merge(other.Corrected)
+	schedule.Uncorrected.Merge(other.Uncorrected)
+	schedule.Lag.Merge(other.Lag)
+	if other.GeneratorLag > schedule.GeneratorLag {
+		schedule.GeneratorLag = other.GeneratorLag
+	}
+	schedule.Dropped += other.Dropped
+	schedule.check()
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifySchedule(t *testing.T) {
+	cases := []struct {
+		name    string
+		request InsaneRequest
+		err     bool
+	}{
+		{name: "默认并发模式", request: InsaneRequest{Form: TYPE_WEBSOCKET}},
+		{name: "rate", request: InsaneRequest{Form: TYPE_HTTP, Executor: EXECUTOR_RATE, Rate: 100}},
+		{name: "interval", request: InsaneRequest{Form: TYPE_TCP, Interval: 100}},
+		{name: "rate缺少速率", request: InsaneRequest{Form: TYPE_HTTP, Executor: EXECUTOR_RATE}, err: true},
+		{name: "rate超过上限", request: InsaneRequest{Form: TYPE_HTTP, Executor: EXECUTOR_RATE, Rate: SCHEDULE_MAX_RATE + 1}, err: true},
+		{name: "executor错误", request: InsaneRequest{Form: TYPE_HTTP, Executor: "open"}, err: true},
+		{name: "interval小于0", request: InsaneRequest{Form: TYPE_HTTP, Interval: -1}, err: true},
+		{name: "websocket不支持rate", request: InsaneRequest{Form: TYPE_WEBSOCKET, Executor: EXECUTOR_RATE, Rate: 10}, err: true},
+		{name: "脚本不支持interval", request: InsaneRequest{Form: TYPE_SCRIPT, Interval: 10}, err: true},
+	}
+	for _, c := range cases {
+		insaneRequest := c.request
+		err := insaneRequest.verifySchedule()
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && insaneRequest.Executor == "" {
+			t.Errorf("%s: executor not set", c.name)
+		}
+	}
+}
+
+func TestPacerSlot(t *testing.T) {
+	if newPacer(&InsaneRequest{ConCurrency: 4}) != nil {
+		t.Error("no interval: pacer should be nil")
+	}
+	pacer := newPacer(&InsaneRequest{Executor: EXECUTOR_CONCURRENCY, Interval: 100, ConCurrency: 4})
+	pacer.run()
+	defer pacer.close()
+
+	// 4个协程在100ms内错开
+	for serial := uint64(0); serial < 4; serial++ {
+		slot := pacer.slot(serial)
+		if want := pacer.start.Add(time.Duration(serial) * 25 * time.Millisecond); !slot.next.Equal(want) {
+			t.Errorf("slot %d: next %v, want %v", serial, slot.next.Sub(pacer.start), want.Sub(pacer.start))
+		}
+	}
+
+	// 上一个请求已超过计划时间时立即发送，延迟计入schedule而不是generator
+	slot := pacer.slot(0)
+	slot.next = time.Now().Add(-30 * time.Millisecond)
+	lag, ok := slot.wait(nil)
+	if !ok || lag.schedule < 30*time.Millisecond || lag.generator != 0 || !slot.next.After(time.Now()) {
+		t.Errorf("late slot: %+v %t", lag, ok)
+	}
+	stopCh := make(chan int, 1)
+	stopCh <- 1
+	if _, ok := slot.wait(stopCh); ok {
+		t.Error("stopped slot should return false")
+	}
+}
+
+func TestPacerNil(t *testing.T) {
+	var pacer *pacer
+	pacer.run()
+	defer pacer.close()
+	if _, ok := pacer.slot(0).wait(nil); !ok || pacer.droppedNum() != 0 {
+		t.Error("nil pacer should not wait")
+	}
+}
+
+func TestPacerDispatch(t *testing.T) {
+	pacer := newPacer(&InsaneRequest{Executor: EXECUTOR_RATE, Rate: 1000, ConCurrency: 1})
+	pacer.run()
+	time.Sleep(50 * time.Millisecond)
+	slot := pacer.slot(0)
+	var received int
+	for i := 0; i < 10; i++ {
+		lag, ok := slot.wait(nil)
+		if !ok || lag.schedule < lag.generator {
+			t.Errorf("tick %d: %+v", i, lag)
+		}
+		received++
+	}
+	pacer.close()
+	if received != 10 || pacer.droppedNum() != 0 {
+		t.Errorf("received %d, dropped %d", received, pacer.droppedNum())
+	}
+
+	// 积压超过1秒的请求量时放弃
+	pacer = newPacer(&InsaneRequest{Executor: EXECUTOR_RATE, Rate: 10, ConCurrency: 1})
+	pacer.start = time.Now().Add(-2 * time.Second)
+	go pacer.dispatch()
+	time.Sleep(20 * time.Millisecond)
+	pacer.close()
+	if dropped := pacer.droppedNum(); dropped < 10 {
+		t.Errorf("dropped = %d", dropped)
+	}
+}
+
+func TestScheduleReport(t *testing.T) {
+	schedule := newScheduleReport(&pacer{executor: EXECUTOR_RATE, period: 10 * time.Millisecond})
+	if schedule.Rate != 100 {
+		t.Errorf("rate = %d", schedule.Rate)
+	}
+	cases := []struct {
+		data   *Response
+		behind bool
+	}{
+		{data: &Response{IsSuccess: true, WasteTime: 10, ScheduleLag: 90, GeneratorLag: 1}},
+		{data: &Response{WasteTime: 5, ScheduleLag: 5}},
+		{data: &Response{IsSuccess: true, WasteTime: 10, GeneratorLag: SCHEDULE_LAG_LIMIT + 1}, behind: true},
+	}
+	for i, c := range cases {
+		schedule.add(c.data)
+		if schedule.BehindSchedule != c.behind {
+			t.Errorf("#%d: behind %t", i, schedule.BehindSchedule)
+		}
+	}
+	if schedule.Corrected.Count() != 2 || schedule.Corrected.Percentile(100) != 100 || schedule.Uncorrected.Percentile(100) != 10 || schedule.Lag.Count() != 3 {
+		t.Errorf("corrected %+v, uncorrected %+v", schedule.Corrected.Summary(), schedule.Uncorrected.Summary())
+	}
+
+	other := newScheduleReport(&pacer{executor: EXECUTOR_RATE, period: 10 * time.Millisecond})
+	other.setDropped(3)
+	if !other.BehindSchedule {
+		t.Error("dropped requests: want behind schedule")
+	}
+	schedule.merge(other)
+	if schedule.Rate != 200 || schedule.Dropped != 3 || schedule.Corrected.Count() != 2 {
+		t.Errorf("merged = %+v", schedule)
+	}
+}
+
+func TestPacerSetRate(t *testing.T) {
+	pacer := newPacer(&InsaneRequest{Executor: EXECUTOR_RATE, Rate: 1000, ConCurrency: 1})
+	pacer.run()
+	defer pacer.close()
+
+	// 暂停后丢弃积压的请求，不再产生新的请求
+	pacer.setRate(0)
+	time.Sleep(20 * time.Millisecond)
+	if n := len(pacer.ticks); n != 0 {
+		t.Errorf("paused: %d ticks", n)
+	}
+	pacer.setRate(100)
+	select {
+	case <-pacer.ticks:
+	case <-time.After(time.Second):
+		t.Fatal("resumed: no tick")
+	}
+}
@@ -0,0 +1,489 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/general/base/appconfig"
+	"insane/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SCHEDULER_FILE          = "./data/schedules.json"
+	SCHEDULER_OVERLAP_SKIP  = "skip"  // 上次执行还没结束时跳过
+	SCHEDULER_OVERLAP_QUEUE = "queue" // 上次执行还没结束时排队
+	SCHEDULER_RUN_QUEUED    = "queued"
+	SCHEDULER_RUN_SKIPPED   = "skipped"
+	SCHEDULER_RUN_FAILED    = "failed"
+	SCHEDULER_HISTORY_LIMIT = 500 // 保留的执行记录数
+	SCHEDULER_UPCOMING      = 10
+	SCHEDULER_TIME_FORMAT   = "2006-01-02 15:04:05"
+	SCHEDULER_CLOCK_FORMAT  = "15:04"
+)
+
+// 定时执行data/test_task中保存的任务，cron与at二选一
+type TaskSchedule struct {
+	Id        string          `json:"id"`
+	Task      string          `json:"task"`                // data/test_task中的任务名
+	Cron      string          `json:"cron"`                // 分 时 日 月 周
+	At        string          `json:"at"`                  // 单次执行的时间 2006-01-02 15:04:05，执行后停用
+	Window    *ScheduleWindow `json:"window"`              // 只在窗口内执行
+	Overrides json.RawMessage `json:"overrides,omitempty"` // 覆盖任务中的字段，如 {"conCurrent":100,"duration":3600}
+	Overlap   string          `json:"overlap"`             // skip | queue
+	Enabled   bool            `json:"enabled"`
+	Created   string          `json:"created"`
+	Next      string          `json:"next,omitempty"` // 下次执行时间，只在查询时返回
+	cron      *cronSpec
+	at        time.Time
+}
+
+// 时间窗口，为空的字段不限制
+type ScheduleWindow struct {
+	From  string `json:"from"`  // 每天开始时间 15:04
+	To    string `json:"to"`    // 每天结束时间，小于from时跨过零点
+	Start string `json:"start"` // 生效时间 2006-01-02 15:04:05
+	End   string `json:"end"`   // 失效时间
+	from  int    // 每天的第几分钟，-1为不限制
+	to    int
+	start time.Time
+	end   time.Time
+}
+
+// 一次计划的执行
+type ScheduleRun struct {
+	ScheduleId string `json:"scheduleId"`
+	Task       string `json:"task"`
+	Planned    string `json:"planned"` // 计划执行时间
+	Time       string `json:"time"`    // 实际处理时间
+	Status     string `json:"status"`  // queued | skipped | failed
+	TaskId     string `json:"taskId,omitempty"`
+	TaskStatus uint32 `json:"taskStatus,omitempty"` // 任务状态 1：已完成 2：待执行 3：执行中，只在查询时返回
+	Message    string `json:"message,omitempty"`
+}
+
+type ScheduleUpcoming struct {
+	ScheduleId string `json:"scheduleId"`
+	Task       string `json:"task"`
+	Time       string `json:"time"`
+}
+
+type Scheduler struct {
+	list    map[string]*TaskSchedule
+	history []*ScheduleRun
+	next    map[string]time.Time // 每个计划的下次执行时间
+	loaded  bool
+	m       sync.Mutex
+}
+
+var InsaneScheduler = &Scheduler{}
+
+func schedulerHistoryFile() string {
+	return fmt.Sprintf("%s/schedule_history.json", appconfig.GetConfig().Log.Location)
+}
+
+// 每秒检查一次到期的计划，停机期间错过的周期不补执行，错过的单次执行在启动后立即执行
+func (scheduler *Scheduler) Start() {
+	t := time.NewTicker(1 * time.Second)
+	defer t.Stop()
+	for now := range t.C {
+		scheduler.tick(now)
+	}
+}
+
+func (scheduler *Scheduler) tick(now time.Time) {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	for _, id := range scheduler.ids() {
+		schedule := scheduler.list[id]
+		next, ok := scheduler.next[id]
+		if !ok || !schedule.Enabled || now.Before(next) {
+			continue
+		}
+		scheduler.fire(schedule, next)
+		if schedule.at.IsZero() {
+			scheduler.plan(schedule, now)
+			continue
+		}
+		schedule.Enabled = false
+		delete(scheduler.next, id)
+		if err := scheduler.save(); err != nil {
+			logger.Debug(err)
+		}
+	}
+}
+
+// 把保存的任务加入任务列表，上次执行还没结束时按overlap跳过或排队
+func (scheduler *Scheduler) fire(schedule *TaskSchedule, planned time.Time) {
+	run := &ScheduleRun{
+		ScheduleId: schedule.Id,
+		Task:       schedule.Task,
+		Planned:    planned.Format(SCHEDULER_TIME_FORMAT),
+		Time:       time.Now().Format(SCHEDULER_TIME_FORMAT),
+		Status:     SCHEDULER_RUN_QUEUED,
+	}
+	defer scheduler.record(run)
+
+	if last := scheduler.lastTask(schedule.Id); last != "" && schedule.Overlap != SCHEDULER_OVERLAP_QUEUE {
+		if status := TK.TaskListStatus(last); status == RUN_TASK || status == UNFINISHED_TASK {
+			run.Status, run.Message = SCHEDULER_RUN_SKIPPED, fmt.Sprintf("上次执行的任务%s还没有结束", last)
+			return
+		}
+	}
+	insaneRequest, err := NewSavedTaskRequest(schedule.Task, schedule.Overrides)
+	if err != nil {
+		logger.Debug(err)
+		run.Status, run.Message = SCHEDULER_RUN_FAILED, err.Error()
+		return
+	}
+	if err := TK.TaskListAdd(insaneRequest); err != nil {
+		logger.Debug(err)
+		run.Status, run.Message = SCHEDULER_RUN_FAILED, err.Error()
+		return
+	}
+	run.TaskId = insaneRequest.Id
+}
+
+// 添加或修改计划，id为空时新建
+func (scheduler *Scheduler) Save(schedule *TaskSchedule) (*TaskSchedule, error) {
+	if err := schedule.parse(); err != nil {
+		return nil, err
+	}
+	if !schedule.at.IsZero() && !schedule.at.After(time.Now()) {
+		return nil, errors.New("at不能早于当前时间")
+	}
+	if _, err := SavedTaskRequest(schedule.Task, schedule.Overrides); err != nil {
+		return nil, err
+	}
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	if schedule.Id == "" {
+		schedule.Id = nextTaskId()
+		schedule.Created = time.Now().Format(SCHEDULER_TIME_FORMAT)
+	} else if old, ok := scheduler.list[schedule.Id]; ok {
+		schedule.Created = old.Created
+	} else {
+		return nil, errors.New("计划不存在")
+	}
+	scheduler.list[schedule.Id] = schedule
+	scheduler.plan(schedule, time.Now())
+	return scheduler.view(schedule), scheduler.save()
+}
+
+func (scheduler *Scheduler) Get(id string) *TaskSchedule {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	if schedule, ok := scheduler.list[id]; ok {
+		return scheduler.view(schedule)
+	}
+	return nil
+}
+
+func (scheduler *Scheduler) List() []*TaskSchedule {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	list := make([]*TaskSchedule, 0, len(scheduler.list))
+	for _, id := range scheduler.ids() {
+		list = append(list, scheduler.view(scheduler.list[id]))
+	}
+	return list
+}
+
+func (scheduler *Scheduler) Delete(id string) error {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	if _, ok := scheduler.list[id]; !ok {
+		return errors.New("计划不存在")
+	}
+	delete(scheduler.list, id)
+	delete(scheduler.next, id)
+	return scheduler.save()
+}
+
+// 接下来的执行时间，id为空时返回所有计划的
+func (scheduler *Scheduler) Upcoming(id string, n int) []*ScheduleUpcoming {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	if n <= 0 {
+		n = SCHEDULER_UPCOMING
+	}
+	list := make([]*ScheduleUpcoming, 0)
+	times := make([]time.Time, 0)
+	for _, scheduleId := range scheduler.ids() {
+		schedule := scheduler.list[scheduleId]
+		if (id != "" && id != scheduleId) || !schedule.Enabled {
+			continue
+		}
+		next, ok := scheduler.next[scheduleId]
+		for i := 0; ok && i < n; i++ {
+			list = append(list, &ScheduleUpcoming{ScheduleId: scheduleId, Task: schedule.Task, Time: next.Format(SCHEDULER_TIME_FORMAT)})
+			times = append(times, next)
+			if !schedule.at.IsZero() {
+				break
+			}
+			next, ok = schedule.nextTime(next)
+		}
+	}
+	sort.Sort(upcomingSort{list, times})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// 执行记录，新的在前，id为空时返回所有计划的
+func (scheduler *Scheduler) History(id string, n int) []*ScheduleRun {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+	scheduler.load()
+	list := make([]*ScheduleRun, 0)
+	for i := len(scheduler.history) - 1; i >= 0 && (n <= 0 || len(list) < n); i-- {
+		run := scheduler.history[i]
+		if id != "" && run.ScheduleId != id {
+			continue
+		}
+		view := *run
+		if view.TaskId != "" {
+			view.TaskStatus = TK.TaskListStatus(view.TaskId)
+		}
+		list = append(list, &view)
+	}
+	return list
+}
+
+// 计算after之后的下次执行时间，没有时不再执行
+func (scheduler *Scheduler) plan(schedule *TaskSchedule, after time.Time) {
+	delete(scheduler.next, schedule.Id)
+	if !schedule.Enabled {
+		return
+	}
+	if !schedule.at.IsZero() {
+		scheduler.next[schedule.Id] = schedule.at
+		return
+	}
+	if next, ok := schedule.nextTime(after); ok {
+		scheduler.next[schedule.Id] = next
+	}
+}
+
+func (scheduler *Scheduler) view(schedule *TaskSchedule) *TaskSchedule {
+	view := *schedule
+	if next, ok := scheduler.next[schedule.Id]; ok {
+		view.Next = next.Format(SCHEDULER_TIME_FORMAT)
+	}
+	return &view
+}
+
+func (scheduler *Scheduler) ids() []string {
+	ids := make([]string, 0, len(scheduler.list))
+	for id := range scheduler.list {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// 计划最近一次加入任务列表的任务
+func (scheduler *Scheduler) lastTask(id string) string {
+	for i := len(scheduler.history) - 1; i >= 0; i-- {
+		if run := scheduler.history[i]; run.ScheduleId == id && run.TaskId != "" {
+			return run.TaskId
+		}
+	}
+	return ""
+}
+
+func (scheduler *Scheduler) record(run *ScheduleRun) {
+	scheduler.history = append(scheduler.history, run)
+	if len(scheduler.history) > SCHEDULER_HISTORY_LIMIT {
+		scheduler.history = scheduler.history[len(scheduler.history)-SCHEDULER_HISTORY_LIMIT:]
+	}
+	content, err := json.Marshal(scheduler.history)
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	if err := utils.FileWrite(schedulerHistoryFile(), string(content)); err != nil {
+		logger.Debug(err)
+	}
+}
+
+func (scheduler *Scheduler) load() {
+	if scheduler.loaded {
+		return
+	}
+	scheduler.loaded = true
+	scheduler.list = make(map[string]*TaskSchedule)
+	scheduler.next = make(map[string]time.Time)
+	if content, err := utils.FileGet(SCHEDULER_FILE); err == nil && content != "" {
+		if err := json.Unmarshal([]byte(content), &scheduler.list); err != nil {
+			logger.Debug(err)
+		}
+	}
+	if content, err := utils.FileGet(schedulerHistoryFile()); err == nil && content != "" {
+		if err := json.Unmarshal([]byte(content), &scheduler.history); err != nil {
+			logger.Debug(err)
+		}
+	}
+	now := time.Now()
+	for id, schedule := range scheduler.list {
+		if err := schedule.parse(); err != nil {
+			logger.Debug(err)
+			delete(scheduler.list, id)
+			continue
+		}
+		scheduler.plan(schedule, now)
+	}
+}
+
+func (scheduler *Scheduler) save() error {
+	content, err := json.MarshalIndent(scheduler.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.FileWrite(SCHEDULER_FILE, string(content))
+}
+
+func (schedule *TaskSchedule) parse() (err error) {
+	if schedule.Task == "" {
+		return errors.New("task不能为空")
+	}
+	switch schedule.Overlap {
+	case "":
+		schedule.Overlap = SCHEDULER_OVERLAP_SKIP
+	case SCHEDULER_OVERLAP_SKIP, SCHEDULER_OVERLAP_QUEUE:
+	default:
+		return fmt.Errorf("overlap必须是%s | %s", SCHEDULER_OVERLAP_SKIP, SCHEDULER_OVERLAP_QUEUE)
+	}
+	if schedule.Window != nil {
+		if err = schedule.Window.parse(); err != nil {
+			return err
+		}
+	}
+	schedule.Next = ""
+	schedule.cron, schedule.at = nil, time.Time{}
+	switch {
+	case schedule.Cron != "" && schedule.At != "":
+		return errors.New("cron与at只能设置一个")
+	case schedule.Cron != "":
+		if schedule.cron, err = parseCron(schedule.Cron); err != nil {
+			return err
+		}
+		if _, ok := schedule.cron.next(time.Now()); !ok {
+			return fmt.Errorf("cron表达式%s没有执行时间", schedule.Cron)
+		}
+		return nil
+	case schedule.At != "":
+		if schedule.at, err = time.ParseInLocation(SCHEDULER_TIME_FORMAT, schedule.At, time.Local); err != nil {
+			return fmt.Errorf("at的格式为%s", SCHEDULER_TIME_FORMAT)
+		}
+		if schedule.Window != nil && !schedule.Window.advance(schedule.at).Equal(schedule.at) {
+			return errors.New("at不在时间窗口内")
+		}
+		return nil
+	}
+	return errors.New("cron与at不能都为空")
+}
+
+// cron在after之后、落在时间窗口内的下次执行时间
+func (schedule *TaskSchedule) nextTime(after time.Time) (time.Time, bool) {
+	t := after
+	for i := 0; i < CRON_SEARCH_LIMIT; i++ {
+		next, ok := schedule.cron.next(t)
+		if !ok || schedule.Window == nil {
+			return next, ok
+		}
+		in := schedule.Window.advance(next)
+		if in.IsZero() {
+			return in, false
+		}
+		if in.Equal(next) {
+			return next, true
+		}
+		t = in.Add(-time.Minute) // 从窗口打开的时间继续找
+	}
+	return time.Time{}, false
+}
+
+func (window *ScheduleWindow) parse() (err error) {
+	window.from, window.to = -1, -1
+	if window.from, err = parseClock(window.From); err != nil {
+		return err
+	}
+	if window.to, err = parseClock(window.To); err != nil {
+		return err
+	}
+	if (window.from < 0) != (window.to < 0) {
+		return errors.New("时间窗口的from和to需要同时设置")
+	}
+	window.start, window.end = time.Time{}, time.Time{}
+	if window.Start != "" {
+		if window.start, err = time.ParseInLocation(SCHEDULER_TIME_FORMAT, window.Start, time.Local); err != nil {
+			return fmt.Errorf("start的格式为%s", SCHEDULER_TIME_FORMAT)
+		}
+	}
+	if window.End != "" {
+		if window.end, err = time.ParseInLocation(SCHEDULER_TIME_FORMAT, window.End, time.Local); err != nil {
+			return fmt.Errorf("end的格式为%s", SCHEDULER_TIME_FORMAT)
+		}
+	}
+	return nil
+}
+
+// t之后第一个在窗口内的时间，窗口已经失效时返回零值
+func (window *ScheduleWindow) advance(t time.Time) time.Time {
+	if !window.start.IsZero() && t.Before(window.start) {
+		t = window.start
+	}
+	if window.from >= 0 {
+		minute := t.Hour()*60 + t.Minute()
+		in := minute >= window.from && minute < window.to
+		if window.from >= window.to { // 跨过零点，相等时为全天
+			in = minute >= window.from || minute < window.to
+		}
+		if !in {
+			day := t.Day()
+			if minute > window.from {
+				day++
+			}
+			t = time.Date(t.Year(), t.Month(), day, window.from/60, window.from%60, 0, 0, t.Location())
+		}
+	}
+	if !window.end.IsZero() && t.After(window.end) {
+		return time.Time{}
+	}
+	return t
+}
+
+func parseClock(clock string) (int, error) {
+	if clock == "" {
+		return -1, nil
+	}
+	t, err := time.Parse(SCHEDULER_CLOCK_FORMAT, clock)
+	if err != nil {
+		return -1, fmt.Errorf("时间窗口的格式为%s", SCHEDULER_CLOCK_FORMAT)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// 按执行时间排序
+type upcomingSort struct {
+	list  []*ScheduleUpcoming
+	times []time.Time
+}
+
+func (s upcomingSort) Len() int           { return len(s.list) }
+func (s upcomingSort) Less(i, j int) bool { return s.times[i].Before(s.times[j]) }
+func (s upcomingSort) Swap(i, j int) {
+	s.list[i], s.list[j] = s.list[j], s.list[i]
+	s.times[i], s.times[j] = s.times[j], s.times[i]
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWindowAdvance(t *testing.T) {
+	cases := []struct {
+		name   string
+		window ScheduleWindow
+		t      string
+		want   string // 为空时窗口已经失效
+	}{
+		{"窗口前", ScheduleWindow{From: "09:00", To: "18:00"}, "2026-10-19 08:30", "2026-10-19 09:00"},
+		{"窗口内", ScheduleWindow{From: "09:00", To: "18:00"}, "2026-10-19 12:00", "2026-10-19 12:00"},
+		{"结束时间不在窗口内", ScheduleWindow{From: "09:00", To: "18:00"}, "2026-10-19 18:00", "2026-10-20 09:00"},
+		{"窗口后到第二天", ScheduleWindow{From: "09:00", To: "18:00"}, "2026-10-19 20:00", "2026-10-20 09:00"},
+		{"窗口后跨月", ScheduleWindow{From: "09:00", To: "18:00"}, "2026-10-31 19:00", "2026-11-01 09:00"},
+		{"跨零点前半段", ScheduleWindow{From: "22:00", To: "06:00"}, "2026-10-19 23:00", "2026-10-19 23:00"},
+		{"跨零点后半段", ScheduleWindow{From: "22:00", To: "06:00"}, "2026-10-20 03:00", "2026-10-20 03:00"},
+		{"跨零点窗口外", ScheduleWindow{From: "22:00", To: "06:00"}, "2026-10-20 06:00", "2026-10-20 22:00"},
+		{"from与to相等为全天", ScheduleWindow{From: "08:00", To: "08:00"}, "2026-10-19 07:59", "2026-10-19 07:59"},
+		{"生效前", ScheduleWindow{Start: "2026-10-20 00:00:00"}, "2026-10-19 12:00", "2026-10-20 00:00"},
+		{"生效前再按窗口", ScheduleWindow{From: "09:00", To: "18:00", Start: "2026-10-20 00:00:00"}, "2026-10-19 12:00", "2026-10-20 09:00"},
+		{"失效后", ScheduleWindow{End: "2026-10-19 12:00:00"}, "2026-10-19 13:00", ""},
+		{"下次窗口在失效后", ScheduleWindow{From: "09:00", To: "18:00", End: "2026-10-19 20:00:00"}, "2026-10-19 19:00", ""},
+	}
+	for _, c := range cases {
+		window := c.window
+		if err := window.parse(); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := window.advance(cronTime(c.t))
+		if c.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%s: advance = %v, want zero", c.name, got)
+			}
+			continue
+		}
+		if !got.Equal(cronTime(c.want)) {
+			t.Errorf("%s: advance = %v, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestScheduleWindowParse(t *testing.T) {
+	cases := []struct {
+		window ScheduleWindow
+		err    bool
+	}{
+		{window: ScheduleWindow{}},
+		{window: ScheduleWindow{From: "22:00", To: "06:00"}},
+		{window: ScheduleWindow{From: "09:00"}, err: true},
+		{window: ScheduleWindow{From: "9点", To: "18:00"}, err: true},
+		{window: ScheduleWindow{Start: "2026-10-20"}, err: true},
+	}
+	for _, c := range cases {
+		window := c.window
+		if err := window.parse(); (err != nil) != c.err {
+			t.Errorf("%+v: err = %v, want err %v", c.window, err, c.err)
+		}
+	}
+}
+
+func TestTaskScheduleNextTime(t *testing.T) {
+	cases := []struct {
+		name   string
+		cron   string
+		window *ScheduleWindow
+		after  string
+		want   string // 为空时没有执行时间
+	}{
+		{"没有窗口", "0 * * * *", nil, "2026-10-19 10:30", "2026-10-19 11:00"},
+		{"等到窗口打开", "0 * * * *", &ScheduleWindow{From: "22:00", To: "02:00"}, "2026-10-19 10:30", "2026-10-19 22:00"},
+		{"跨零点窗口内", "0 * * * *", &ScheduleWindow{From: "22:00", To: "02:00"}, "2026-10-19 23:30", "2026-10-20 00:00"},
+		{"窗口结束时间不执行", "0 * * * *", &ScheduleWindow{From: "22:00", To: "02:00"}, "2026-10-20 01:30", "2026-10-20 22:00"},
+		{"窗口打开后的第一个cron时间", "45 * * * *", &ScheduleWindow{From: "09:10", To: "18:00"}, "2026-10-19 07:00", "2026-10-19 09:45"},
+		{"cron不在窗口内", "30 9 * * *", &ScheduleWindow{From: "10:00", To: "18:00"}, "2026-10-19 07:00", ""},
+		{"窗口已经失效", "0 * * * *", &ScheduleWindow{End: "2026-10-19 12:00:00"}, "2026-10-19 12:30", ""},
+	}
+	for _, c := range cases {
+		schedule := &TaskSchedule{Task: "test", Cron: c.cron, Window: c.window}
+		if err := schedule.parse(); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		next, ok := schedule.nextTime(cronTime(c.after))
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s: nextTime = %v, want none", c.name, next)
+			}
+			continue
+		}
+		if !ok || !next.Equal(cronTime(c.want)) {
+			t.Errorf("%s: nextTime = %v %v, want %s", c.name, next, ok, c.want)
+		}
+	}
+}
+
+func TestSchedulerSavePastAt(t *testing.T) {
+	at := time.Now().Add(-time.Minute).Format(SCHEDULER_TIME_FORMAT)
+	if _, err := (&Scheduler{}).Save(&TaskSchedule{Task: "test", At: at}); err == nil {
+		t.Errorf("at %s: want error", at)
+	}
+}
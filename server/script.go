@@ -6,12 +6,20 @@ import (
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/tidwall/gjson"
 	"insane/constant"
+	"strings"
 	"sync"
 )
 
 type ScriptRequest struct {
-	Data           []gjson.Result    `json:data`
-	ScriptResponse []*ScriptResponse `json:"response"`
+	Data []gjson.Result `json:"data"`
+
+	extractOnce sync.Once
+	extract     map[string]bool // 被后续步骤提取响应字段的步骤
+}
+
+// 脚本步骤，与ScriptRequest.Data中的元素结构一致
+type ScriptStep struct {
+	Data *HttpRequest `json:"data"`
 }
 
 type ScriptResponse struct {
@@ -42,38 +50,46 @@ func (scriptRequest *ScriptRequest) Run(serial uint64, scriptReportCh chan<- *Sc
 func (scriptRequest *ScriptRequest) ScriptSend(httpRequest *HttpRequest, sentCh chan bool, responseCh chan *Response, scriptReportCh chan<- *ScriptReport) {
 
 	var wasteTime uint64
+	scriptResponse := make([]*ScriptResponse, 0)
 	resp := &Response{
 		IsSuccess: false,
 		ErrCode:   constant.ERROR_REQUEST_DEFAULT,
 		ErrMsg:    "空数据",
+		ErrType:   ERR_TYPE_SCRIPT,
 	}
 
 	defer func() {
 		scriptReportCh <- &ScriptReport{
 			ErrCode:        resp.ErrCode,
 			ErrMsg:         resp.ErrMsg,
-			ScriptResponse: scriptRequest.ScriptResponse,
+			ErrType:        resp.ErrType,
+			ScriptResponse: scriptResponse,
 			WasteTime:      wasteTime,
 		}
-		scriptRequest.ScriptResponse = make([]*ScriptResponse, 0)
 	}()
 
 	for _, v := range scriptRequest.Data {
 		httpRequest.Parse(v.Get("data"))
+		// 只有被提取字段的步骤才缓存响应内容
+		httpRequest.ReadResponse = scriptRequest.isExtracted(httpRequest)
 
 		go httpRequest.HttpSend(responseCh, sentCh)
 		<-sentCh
 		resp = <-responseCh
 
 		wasteTime += resp.WasteTime
-		if resp.IsSuccess == false {
-			return
+		name := httpRequest.Name
+		if name == "" {
+			name = httpRequest.Url
 		}
-
-		scriptRequest.ScriptResponse = append(scriptRequest.ScriptResponse, &ScriptResponse{
-			Name:     v.Get("data.name").String(),
+		// 失败的步骤也记录下来，用于按步骤统计
+		scriptResponse = append(scriptResponse, &ScriptResponse{
+			Name:     name,
 			Response: resp,
 		})
+		if resp.IsSuccess == false {
+			return
+		}
 	}
 }
 
@@ -82,6 +98,7 @@ func (scriptRequest *ScriptRequest) Validate() (vc []byte, err error) {
 	responseCh := make(chan *Response, 1)
 	scriptReportCh := make(chan *ScriptReport)
 	httpRequest := GenerateHttpRequest(true)
+	httpRequest.keepBody = true
 
 	go scriptRequest.ScriptSend(httpRequest, sentCh, responseCh, scriptReportCh)
 	resp := <-scriptReportCh
@@ -89,3 +106,28 @@ func (scriptRequest *ScriptRequest) Validate() (vc []byte, err error) {
 	vc, err = json.Marshal(resp)
 	return
 }
+
+func (scriptRequest *ScriptRequest) isExtracted(httpRequest *HttpRequest) bool {
+	scriptRequest.extractOnce.Do(func() {
+		scriptRequest.extract = make(map[string]bool)
+		for _, v := range scriptRequest.Data {
+			scriptRequest.findExtract(v.Get("data.body"))
+		}
+	})
+	key := httpRequest.Name
+	if key == "" {
+		key = httpRequest.Url
+	}
+	return scriptRequest.extract[key]
+}
+
+// response类型字段的dynamic为“步骤名---字段路径”
+func (scriptRequest *ScriptRequest) findExtract(fields gjson.Result) {
+	for _, field := range fields.Array() {
+		if field.Get("type").String() == "response" {
+			step := strings.Split(field.Get("dynamic").String(), HTTP_RESPONSE_FIELD_SEP)[0]
+			scriptRequest.extract[step] = true
+		}
+		scriptRequest.findExtract(field.Get("children"))
+	}
+}
@@ -10,6 +10,7 @@ import (
 )
 
 type ScriptReportList struct {
+	Name           string                     `json:"name"` // 任务名称
 	ScriptReport   map[uint64][]*ScriptReport `json:"scriptReport"`
 	TotalSuccess   uint64                     `json:"totalSuccess"`
 	TotalError     uint64                     `json:"totalError"`
@@ -17,7 +18,22 @@ type ScriptReportList struct {
 	AverageError   map[uint64]uint64          `json:"averageError"`
 	ErrCode        map[int]uint64             `json:"errCode"`
 	ErrCodeMsg     map[int]string             `json:"errCodeMsg"`
+	Steps          map[string]*StepReport     `json:"steps"`     // 按步骤统计
+	WasteTime      *Histogram                 `json:"wasteTime"` // 成功事务的耗时分布（毫秒）
+	RequestTime    uint64                     `json:"requestTime"`
+	Errors         ErrorReports               `json:"errors"`      // 按错误分类统计
+	Generator      *GeneratorReport           `json:"generator"`   // 施压机自身每秒的状态与饱和提示
+	Targets        TargetReports              `json:"targets"`     // 被测机器每秒的负载，由agent上报
+	Config         json.RawMessage            `json:"config"`      // 提交的任务配置
+	Thresholds     []*ThresholdResult         `json:"thresholds"`  // 阈值检查结果
+	Baseline       *Comparison                `json:"baseline"`    // 与同名任务基准的对比
+	Annotations    []*Annotation              `json:"annotations"` // 执行中的暂停、恢复与调整
 	Status         bool                       `json:"status"`
+	m              sync.Mutex
+	metrics        *TaskMetrics
+	samples        *sampleWriter
+	thresholds     []*Threshold
+	startTime      int64 // 开始接收结果的时间（毫秒）
 }
 
 type ScriptReport struct {
@@ -25,6 +41,15 @@ type ScriptReport struct {
 	WasteTime      uint64            `json:"wasteTime"` // 事务消耗时间
 	ErrCode        int               `json:"errCode"`   // 错误码
 	ErrMsg         string            `json:"errMsg"`    // 错误提示
+	ErrType        string            `json:"errType"`   // 错误分类
+}
+
+// 脚本中单个步骤的统计
+type StepReport struct {
+	SuccessNum uint64          `json:"successNum"`
+	FailureNum uint64          `json:"failureNum"`
+	WasteTime  *Histogram      `json:"wasteTime"` // 耗时分布（毫秒）
+	Phases     PhaseHistograms `json:"phases"`    // 各阶段耗时分布（微秒）
 }
 
 const SCRIPT_REPORT_SEP = 60
@@ -37,12 +62,19 @@ func (scriptReportList *ScriptReportList) ReceivingResults(id string, conCurrenc
 		averageSuccess = make(map[uint64]uint64)
 		averageError   = make(map[uint64]uint64)
 		errCodeMsg     = make(map[int]string)
+		steps          = make(map[string]*StepReport)
+		errorReports   = make(ErrorReports)
+		wasteTime      = NewHistogram()
 		totalSuccess   = 0
 		totalError     = 0
 	)
 
 	startTime := utils.Now()
+	scriptReportList.m.Lock()
+	scriptReportList.startTime = startTime
+	scriptReportList.m.Unlock()
 	for data := range slCh {
+		scriptReportList.m.Lock()
 		curSecond := utils.CurSecond(uint64(startTime))
 		// 统计维度分钟
 		sep := curSecond / SCRIPT_REPORT_SEP
@@ -52,12 +84,24 @@ func (scriptReportList *ScriptReportList) ReceivingResults(id string, conCurrenc
 
 		if data.ErrCode == http.StatusOK {
 			totalSuccess++
+			wasteTime.Add(data.WasteTime)
 			averageSuccess[sep]++
 		} else {
 			totalError++
 			averageError[sep]++
 			errCode[data.ErrCode]++
 			errCodeMsg[data.ErrCode] = data.ErrMsg
+			errorReports.add(data.ErrType, data.ErrMsg)
+		}
+		for _, v := range data.ScriptResponse {
+			step, ok := steps[v.Name]
+			if !ok {
+				step = &StepReport{WasteTime: NewHistogram(), Phases: NewPhaseHistograms()}
+				steps[v.Name] = step
+			}
+			step.add(v.Response)
+			scriptReportList.metrics.add(v.Name, v.Response)
+			scriptReportList.samples.write(v.Name, v.Response)
 		}
 
 		scriptReportList.TotalSuccess = uint64(totalSuccess)
@@ -66,9 +110,31 @@ func (scriptReportList *ScriptReportList) ReceivingResults(id string, conCurrenc
 		scriptReportList.AverageError = averageError
 		scriptReportList.ErrCode = errCode
 		scriptReportList.ErrCodeMsg = errCodeMsg
+		scriptReportList.Steps = steps
+		scriptReportList.WasteTime = wasteTime
+		scriptReportList.Errors = errorReports
 		scriptReportList.ScriptReport[sep] = append(scriptReportList.ScriptReport[sep], data)
+		scriptReportList.m.Unlock()
+	}
+	scriptReportList.m.Lock()
+	scriptReportList.RequestTime = uint64((utils.Now() - startTime) / 1000)
+	if len(scriptReportList.thresholds) > 0 {
+		scriptReportList.Thresholds = checkThresholds(scriptReportList.thresholds, scriptReportList.thresholdSource)
 	}
+	var run *CompareRun
+	if scriptReportList.Name != "" {
+		run = scriptReportList.compareRun(id)
+	}
+	scriptReportList.m.Unlock()
+	var baseline *Comparison
+	if run != nil {
+		baseline = InsaneBaselines.check(run)
+	}
+	scriptReportList.m.Lock()
+	scriptReportList.Baseline = baseline
 	scriptReportList.Status = true
+	scriptReportList.m.Unlock()
+	scriptReportList.samples.close()
 
 	content, err := json.Marshal(scriptReportList)
 	if err == nil {
@@ -76,3 +142,34 @@ func (scriptReportList *ScriptReportList) ReceivingResults(id string, conCurrenc
 		utils.FileWrite(filename, string(content))
 	}
 }
+
+func (scriptReportList *ScriptReportList) thresholdSource(step string) *thresholdSource {
+	if step == "" {
+		return &thresholdSource{success: scriptReportList.TotalSuccess, failure: scriptReportList.TotalError, seconds: scriptReportList.RequestTime, latency: scriptReportList.WasteTime}
+	}
+	cur, ok := scriptReportList.Steps[step]
+	if !ok {
+		return nil
+	}
+	return &thresholdSource{success: cur.SuccessNum, failure: cur.FailureNum, seconds: scriptReportList.RequestTime, latency: cur.WasteTime}
+}
+
+func (scriptReportList *ScriptReportList) Get() (content string) {
+	scriptReportList.m.Lock()
+	defer scriptReportList.m.Unlock()
+	con, err := json.Marshal(scriptReportList)
+	if err != nil {
+		return ""
+	}
+	return string(con)
+}
+
+func (step *StepReport) add(data *Response) {
+	if !data.IsSuccess {
+		step.FailureNum++
+		return
+	}
+	step.SuccessNum++
+	step.WasteTime.Add(data.WasteTime)
+	step.Phases.add(data)
+}
@@ -4,24 +4,32 @@ import (
 	"encoding/json"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
+	"insane/general/base/appconfig"
 	"insane/utils"
 	"math"
 	"sync"
 	"time"
 )
 
-const LISTEN_DURATION = 120
+const (
+	LOAD_DEFAULT_INTERVAL  = 3   // 默认采集间隔（秒）
+	LOAD_DEFAULT_RETENTION = 360 // 默认保留时长（秒）
+)
 
+// 本机负载，按固定间隔采集，保存在环形缓冲区中
 type ServerLoad struct {
-	InitIoCounters net.IOCountersStat `json:"-"`
-	Cpu            map[int64]uint32   `json:"cpu"`
-	Mem            map[int64]uint32   `json:"mem"`
-	Conn           map[int64]uint32   `json:"conn"`
-	Io             map[int64]*IoInfo  `json:"io"`
-	ServerInfo     ServerInfo         `json:"serverInfo"`
-	M              sync.Mutex         `json:"-"`
+	ServerInfo ServerInfo `json:"serverInfo"`
+	M          sync.RWMutex
+
+	samples  []*LoadSample // 环形缓冲区
+	next     int           // 下一个写入的位置
+	full     bool
+	lastCpu  []cpu.TimesStat // 上次采集的cpu时间，第一个为全部核心的合计
+	lastNet  map[string]net.IOCountersStat
+	lastTime time.Time
 }
 
 type ServerInfo struct {
@@ -34,70 +42,226 @@ type IoInfo struct {
 	Recv uint64 `json:"recv"`
 }
 
+type LoadSample struct {
+	Time       int64               `json:"time"`       // 采集时间（毫秒）
+	Cpu        float64             `json:"cpu"`        // cpu使用率（%）
+	Cores      []float64           `json:"cores"`      // 每个核心的使用率（%）
+	Mem        float64             `json:"mem"`        // 内存使用率（%）
+	Swap       float64             `json:"swap"`       // swap使用率（%）
+	Load       *load.AvgStat       `json:"load"`       // 1、5、15分钟平均负载
+	Conn       uint32              `json:"conn"`       // 连接数
+	Net        *NetRate            `json:"net"`        // 所有网卡合计
+	Interfaces map[string]*NetRate `json:"interfaces"` // 每个网卡
+}
+
+// 采集间隔内的平均速率
+type NetRate struct {
+	BytesSent   uint64 `json:"bytesSent"`   // 每秒发送字节数
+	BytesRecv   uint64 `json:"bytesRecv"`   // 每秒接收字节数
+	PacketsSent uint64 `json:"packetsSent"` // 每秒发送包数
+	PacketsRecv uint64 `json:"packetsRecv"` // 每秒接收包数
+}
+
+// 兼容旧接口的格式，以采集时间为key
+type serverLoadJson struct {
+	Cpu        map[int64]uint32  `json:"cpu"`
+	Mem        map[int64]uint32  `json:"mem"`
+	Conn       map[int64]uint32  `json:"conn"`
+	Io         map[int64]*IoInfo `json:"io"` // 每秒网络收发字节数
+	ServerInfo ServerInfo        `json:"serverInfo"`
+}
+
 var InsaneLoad ServerLoad
 
 func (serverLoad *ServerLoad) Init() error {
 	serverLoad.GetServerInfo()
-	serverLoad.Cpu = make(map[int64]uint32)
-	serverLoad.Mem = make(map[int64]uint32)
-	serverLoad.Conn = make(map[int64]uint32)
-	serverLoad.Io = make(map[int64]*IoInfo)
+	serverLoad.samples = make([]*LoadSample, loadRetention()/loadInterval())
+	serverLoad.next = 0
+	serverLoad.full = false
 	return nil
 }
 
-func (serverLoad *ServerLoad) Start(interval uint64) error {
+// 按配置的间隔采集，保留配置时长内的数据
+func (serverLoad *ServerLoad) Start() error {
+	serverLoad.M.Lock()
 	serverLoad.Init()
-	var key int64 = 0
-	step := 0
-	keyAddr := make([]int64, LISTEN_DURATION)
-	t := time.NewTicker(time.Duration(interval) * time.Second)
+	serverLoad.M.Unlock()
+	serverLoad.sample() // 第一次采集作为计算速率的起点
+
+	t := time.NewTicker(time.Duration(loadInterval()) * time.Second)
+	defer t.Stop()
 	for {
 		<-t.C
-		serverLoad.saveIoCounters()
-
+		// 采集不持有锁，写入时才加锁
+		sample := serverLoad.sample()
 		serverLoad.M.Lock()
-		if step == LISTEN_DURATION {
-			step = 0
+		serverLoad.samples[serverLoad.next] = sample
+		serverLoad.next = (serverLoad.next + 1) % len(serverLoad.samples)
+		if serverLoad.next == 0 {
+			serverLoad.full = true
 		}
+		serverLoad.M.Unlock()
+	}
+}
+
+func loadInterval() uint64 {
+	if interval := appconfig.GetConfig().Load.Interval; interval > 0 {
+		return interval
+	}
+	return LOAD_DEFAULT_INTERVAL
+}
 
-		key = utils.Now()
-		if keyAddr[step] != 0 {
-			delete(serverLoad.Cpu, keyAddr[step])
-			delete(serverLoad.Mem, keyAddr[step])
-			delete(serverLoad.Io, keyAddr[step])
-			delete(serverLoad.Conn, keyAddr[step])
+func loadRetention() uint64 {
+	retention := appconfig.GetConfig().Load.Retention
+	if retention == 0 {
+		retention = LOAD_DEFAULT_RETENTION
+	}
+	if retention < loadInterval() {
+		retention = loadInterval()
+	}
+	return retention
+}
+
+func (serverLoad *ServerLoad) sample() *LoadSample {
+	now := time.Now()
+	seconds := now.Sub(serverLoad.lastTime).Seconds()
+	serverLoad.lastTime = now
+	sample := &LoadSample{Time: utils.Now()}
+
+	// cpu使用率按两次采集之间的cpu时间计算，不阻塞
+	total, err := cpu.Times(false)
+	if err != nil {
+		logger.Debug(err)
+	}
+	cores, err := cpu.Times(true)
+	if err != nil {
+		logger.Debug(err)
+	}
+	cur := append(total, cores...)
+	if len(cur) == len(serverLoad.lastCpu) && len(total) == 1 {
+		sample.Cpu = cpuPercent(serverLoad.lastCpu[0], cur[0])
+		sample.Cores = make([]float64, len(cores))
+		for i := range cores {
+			sample.Cores[i] = cpuPercent(serverLoad.lastCpu[i+1], cur[i+1])
 		}
-		keyAddr[step] = key
+	}
+	serverLoad.lastCpu = cur
 
-		serverLoad.saveCpuLoad(keyAddr[step], serverLoad.getCpuLoad())
-		serverLoad.saveMemLoad(keyAddr[step], serverLoad.getMemLoad())
-		serverLoad.saveIoLoad(keyAddr[step], serverLoad.getIoLoad())
-		serverLoad.saveConnNumber(keyAddr[step], serverLoad.getConn())
+	if virtualMem, err := mem.VirtualMemory(); err == nil {
+		sample.Mem = virtualMem.UsedPercent
+	} else {
+		logger.Debug(err)
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		sample.Swap = swap.UsedPercent
+	} else {
+		logger.Debug(err)
+	}
+	if avg, err := load.Avg(); err == nil {
+		sample.Load = avg
+	} else {
+		logger.Debug(err)
+	}
+	sample.Conn = serverLoad.getConn()
 
-		serverLoad.M.Unlock()
-		step++
+	// 网络速率按两次采集之间的差值计算
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		logger.Debug(err)
+	}
+	lastNet := make(map[string]net.IOCountersStat)
+	for _, counter := range counters {
+		lastNet[counter.Name] = counter
+		last, ok := serverLoad.lastNet[counter.Name]
+		if !ok || seconds <= 0 {
+			continue
+		}
+		rate := &NetRate{
+			BytesSent:   counterRate(last.BytesSent, counter.BytesSent, seconds),
+			BytesRecv:   counterRate(last.BytesRecv, counter.BytesRecv, seconds),
+			PacketsSent: counterRate(last.PacketsSent, counter.PacketsSent, seconds),
+			PacketsRecv: counterRate(last.PacketsRecv, counter.PacketsRecv, seconds),
+		}
+		if sample.Interfaces == nil {
+			sample.Interfaces = make(map[string]*NetRate)
+			sample.Net = new(NetRate)
+		}
+		sample.Interfaces[counter.Name] = rate
+		sample.Net.BytesSent += rate.BytesSent
+		sample.Net.BytesRecv += rate.BytesRecv
+		sample.Net.PacketsSent += rate.PacketsSent
+		sample.Net.PacketsRecv += rate.PacketsRecv
+	}
+	serverLoad.lastNet = lastNet
+	return sample
+}
 
+func cpuPercent(last cpu.TimesStat, cur cpu.TimesStat) float64 {
+	total := cur.Total() - last.Total()
+	if total <= 0 {
+		return 0
 	}
-	return nil
+	idle := (cur.Idle + cur.Iowait) - (last.Idle + last.Iowait)
+	return math.Max(0, math.Min(100, (total-idle)/total*100))
 }
 
-func (serverLoad *ServerLoad) Get() (string, error) {
-	serverLoad.M.Lock()
-	data, err := json.Marshal(serverLoad)
-	serverLoad.M.Unlock()
-	if err != nil {
-		return "", err
+// 计数器重置（如网卡重启）时返回0
+func counterRate(last uint64, cur uint64, seconds float64) uint64 {
+	if cur < last {
+		return 0
+	}
+	return uint64(float64(cur-last) / seconds)
+}
+
+// 时间范围内的采样，start、end为毫秒时间戳，为0时不限制
+func (serverLoad *ServerLoad) Query(start int64, end int64) []*LoadSample {
+	serverLoad.M.RLock()
+	defer serverLoad.M.RUnlock()
+	list := make([]*LoadSample, 0, len(serverLoad.samples))
+	serverLoad.each(func(sample *LoadSample) {
+		if (start == 0 || sample.Time >= start) && (end == 0 || sample.Time <= end) {
+			list = append(list, sample)
+		}
+	})
+	return list
+}
+
+// 按时间顺序遍历
+func (serverLoad *ServerLoad) each(f func(sample *LoadSample)) {
+	if serverLoad.full {
+		for _, sample := range serverLoad.samples[serverLoad.next:] {
+			f(sample)
+		}
+	}
+	for _, sample := range serverLoad.samples[:serverLoad.next] {
+		f(sample)
 	}
-	return string(data), nil
 }
 
-// 最近num个cpu的百分比
-func (serverLoad *ServerLoad) GetLatelyCpuLoad(num int) (cpuLoad []uint32) {
+func (serverLoad *ServerLoad) Get() (string, error) {
+	serverLoad.M.RLock()
+	v := serverLoadJson{
+		Cpu:        make(map[int64]uint32),
+		Mem:        make(map[int64]uint32),
+		Conn:       make(map[int64]uint32),
+		Io:         make(map[int64]*IoInfo),
+		ServerInfo: serverLoad.ServerInfo,
+	}
+	serverLoad.each(func(sample *LoadSample) {
+		v.Cpu[sample.Time] = uint32(sample.Cpu)
+		v.Mem[sample.Time] = uint32(sample.Mem)
+		v.Conn[sample.Time] = sample.Conn
+		if sample.Net != nil {
+			v.Io[sample.Time] = &IoInfo{Sent: sample.Net.BytesSent, Recv: sample.Net.BytesRecv}
+		}
+	})
+	serverLoad.M.RUnlock()
 
-	for i := 0; i < num; i++ {
-		cpuLoad = append(cpuLoad, serverLoad.getCpuLoad())
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
 	}
-	return
+	return string(data), nil
 }
 
 func (serverLoad *ServerLoad) GetServerInfo() {
@@ -108,15 +272,6 @@ func (serverLoad *ServerLoad) GetServerInfo() {
 	serverLoad.ServerInfo.Mem = memSize
 }
 
-func (serverLoad *ServerLoad) getCpuLoad() uint32 {
-	c, err := cpu.Percent(time.Second*1, false)
-	if err != nil {
-		logger.Debug(err)
-		return 0
-	}
-	return uint32(c[0])
-}
-
 func (serverLoad *ServerLoad) getMemLoad() uint32 {
 	mem, err := mem.VirtualMemory()
 	if err != nil {
@@ -126,24 +281,6 @@ func (serverLoad *ServerLoad) getMemLoad() uint32 {
 	return uint32(mem.UsedPercent)
 }
 
-func (serverLoad *ServerLoad) getIoLoad() *IoInfo {
-	io, err := net.IOCounters(false)
-	if err != nil {
-		logger.Debug(err)
-		return nil
-	}
-	initBytesSent := serverLoad.InitIoCounters.BytesSent
-	initBytesRecv := serverLoad.InitIoCounters.BytesRecv
-	curBytesSent := io[0].BytesSent
-	curBytesRecv := io[0].BytesRecv
-	sent := curBytesSent - initBytesSent
-	recv := curBytesRecv - initBytesRecv
-	return &IoInfo{
-		Sent: sent,
-		Recv: recv,
-	}
-}
-
 func (serverLoad *ServerLoad) getConn() uint32 {
 	conn, err := net.Connections("all")
 	if err != nil {
@@ -152,29 +289,3 @@ func (serverLoad *ServerLoad) getConn() uint32 {
 	}
 	return uint32(len(conn))
 }
-
-func (serverLoad *ServerLoad) saveIoCounters() error {
-	ioc, err := net.IOCounters(false)
-	if err != nil {
-		logger.Debug(err)
-		return err
-	}
-	serverLoad.InitIoCounters = ioc[0]
-	return nil
-}
-
-func (serverLoad *ServerLoad) saveCpuLoad(key int64, value uint32) {
-	serverLoad.Cpu[key] = value
-}
-
-func (serverLoad *ServerLoad) saveMemLoad(key int64, value uint32) {
-	serverLoad.Mem[key] = value
-}
-
-func (serverLoad *ServerLoad) saveIoLoad(key int64, value *IoInfo) {
-	serverLoad.Io[key] = value
-}
-
-func (serverLoad *ServerLoad) saveConnNumber(key int64, value uint32) {
-	serverLoad.Conn[key] = value
-}
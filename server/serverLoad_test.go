@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestCounterRate(t *testing.T) {
+	cases := []struct {
+		last    uint64
+		cur     uint64
+		seconds float64
+		want    uint64
+	}{
+		{last: 100, cur: 400, seconds: 3, want: 100},
+		{last: 100, cur: 100, seconds: 1, want: 0},
+		{last: 500, cur: 100, seconds: 1, want: 0}, // 计数器重置
+		{last: 0, cur: 1000, seconds: 0.5, want: 2000},
+	}
+	for _, c := range cases {
+		if got := counterRate(c.last, c.cur, c.seconds); got != c.want {
+			t.Errorf("%d -> %d in %vs: %d, want %d", c.last, c.cur, c.seconds, got, c.want)
+		}
+	}
+}
+
+func TestCpuPercent(t *testing.T) {
+	cases := []struct {
+		last cpu.TimesStat
+		cur  cpu.TimesStat
+		want float64
+	}{
+		{last: cpu.TimesStat{User: 10, Idle: 10}, cur: cpu.TimesStat{User: 15, Idle: 15}, want: 50},
+		{last: cpu.TimesStat{User: 10, Idle: 10}, cur: cpu.TimesStat{User: 10, Idle: 14, Iowait: 1}, want: 0},
+		{last: cpu.TimesStat{System: 1}, cur: cpu.TimesStat{System: 4, Idle: 1}, want: 75},
+		{last: cpu.TimesStat{User: 10}, cur: cpu.TimesStat{User: 10}, want: 0},
+	}
+	for _, c := range cases {
+		if got := cpuPercent(c.last, c.cur); got != c.want {
+			t.Errorf("%+v -> %+v: %v, want %v", c.last, c.cur, got, c.want)
+		}
+	}
+}
+
+func TestServerLoadQuery(t *testing.T) {
+	serverLoad := &ServerLoad{samples: make([]*LoadSample, 3)}
+	add := func(time int64) {
+		serverLoad.samples[serverLoad.next] = &LoadSample{Time: time, Cpu: float64(time), Net: &NetRate{BytesSent: uint64(time)}}
+		serverLoad.next = (serverLoad.next + 1) % len(serverLoad.samples)
+		if serverLoad.next == 0 {
+			serverLoad.full = true
+		}
+	}
+	times := func(list []*LoadSample) (times []int64) {
+		for _, sample := range list {
+			times = append(times, sample.Time)
+		}
+		return
+	}
+
+	add(1000)
+	add(2000)
+	if got := times(serverLoad.Query(0, 0)); len(got) != 2 || got[0] != 1000 || got[1] != 2000 {
+		t.Errorf("not full: %v", got)
+	}
+	// 超出容量后覆盖最早的采样，仍按时间顺序返回
+	add(3000)
+	add(4000)
+	cases := []struct {
+		start int64
+		end   int64
+		want  []int64
+	}{
+		{want: []int64{2000, 3000, 4000}},
+		{start: 3000, want: []int64{3000, 4000}},
+		{end: 2500, want: []int64{2000}},
+		{start: 2500, end: 3500, want: []int64{3000}},
+		{start: 5000},
+	}
+	for _, c := range cases {
+		got := times(serverLoad.Query(c.start, c.end))
+		if len(got) != len(c.want) {
+			t.Errorf("%d-%d: %v, want %v", c.start, c.end, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%d-%d: %v, want %v", c.start, c.end, got, c.want)
+				break
+			}
+		}
+	}
+
+	// 旧接口的格式以采集时间为key
+	data, err := serverLoad.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := gjson.Parse(data)
+	if result.Get("cpu.3000").Uint() != 3000 || result.Get("io.4000.sent").Uint() != 4000 || result.Get("cpu.1000").Exists() {
+		t.Errorf("get = %s", data)
+	}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SOCKET_CONN_PERSISTENT  = "persistent" // 每个协程保持一条连接，默认
+	SOCKET_CONN_PER_MESSAGE = "perMessage" // 每条消息新建连接
+
+	PAYLOAD_TEXT   = "text" // 文本，可以使用{{字段名}}模板
+	PAYLOAD_HEX    = "hex"
+	PAYLOAD_BASE64 = "base64"
+
+	MATCH_NONE      = "none"      // 只发送不读取
+	MATCH_LENGTH    = "length"    // 读取固定字节数
+	MATCH_DELIMITER = "delimiter" // 读取到分隔符为止
+	MATCH_TIMEOUT   = "timeout"   // 读取到超时为止，收到数据即成功
+	MATCH_FRAME     = "frame"     // 按长度前缀读取一帧
+
+	SOCKET_UDP_BUFFER = 65535
+	SOCKET_MAX_FRAME  = 1 << 20 // 单个响应默认最多读取1MB
+)
+
+type SocketRequest struct {
+	Address      string       `json:"address"`      // 目标地址，如 127.0.0.1:9000
+	Connection   string       `json:"connection"`   // persistent | perMessage
+	PayloadType  string       `json:"payloadType"`  // text | hex | base64
+	Payload      string       `json:"payload"`      // 发送内容
+	Body         []*BodyField `json:"body"`         // text模板中使用的字段
+	LengthPrefix int          `json:"lengthPrefix"` // 长度前缀字节数：0（不加）| 1 | 2 | 4
+	LittleEndian bool         `json:"littleEndian"` // 长度前缀默认大端
+	Match        string       `json:"match"`        // none | length | delimiter | timeout | frame
+	MatchLength  int          `json:"matchLength"`  // length模式读取的字节数
+	Delimiter    string       `json:"delimiter"`    // delimiter模式的分隔符，格式与payloadType一致
+	Timeout      uint64       `json:"timeout"`      // 读写超时（毫秒），默认5秒
+	MaxFrame     int          `json:"maxFrame"`     // length、delimiter、frame模式单个响应的最大字节数，默认1MB
+
+	network   string
+	payload   []byte
+	delimiter []byte
+	request   *HttpRequest // 复用http请求体的字段生成
+	pacer     *pacer       // 按计划时间发送，见schedule.go
+}
+
+// 每个协程的连接
+type socketConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	lastRead int64 // timeout模式最后一次收到数据的时间
+}
+
+func (socketRequest *SocketRequest) Load(network string) (err error) {
+	socketRequest.network = network
+	if socketRequest.Address == "" {
+		return errors.New("address不能为空")
+	}
+	switch socketRequest.Connection {
+	case "":
+		socketRequest.Connection = SOCKET_CONN_PERSISTENT
+	case SOCKET_CONN_PERSISTENT, SOCKET_CONN_PER_MESSAGE:
+	default:
+		return fmt.Errorf("connection必须是%s | %s", SOCKET_CONN_PERSISTENT, SOCKET_CONN_PER_MESSAGE)
+	}
+	switch socketRequest.LengthPrefix {
+	case 0, 1, 2, 4:
+	default:
+		return errors.New("lengthPrefix必须是0 | 1 | 2 | 4")
+	}
+
+	if socketRequest.PayloadType == "" {
+		socketRequest.PayloadType = PAYLOAD_TEXT
+	}
+	if socketRequest.payload, err = socketRequest.decode(socketRequest.Payload); err != nil {
+		return fmt.Errorf("payload解析失败：%s", err)
+	}
+	if !socketRequest.template() {
+		if _, err = socketRequest.frame(socketRequest.payload); err != nil {
+			return fmt.Errorf("payload%s", err)
+		}
+	}
+	if socketRequest.delimiter, err = socketRequest.decode(socketRequest.Delimiter); err != nil {
+		return fmt.Errorf("delimiter解析失败：%s", err)
+	}
+
+	if socketRequest.Match == "" {
+		socketRequest.Match = MATCH_NONE
+	}
+	if socketRequest.MaxFrame <= 0 {
+		socketRequest.MaxFrame = SOCKET_MAX_FRAME
+	}
+	switch socketRequest.Match {
+	case MATCH_NONE, MATCH_TIMEOUT:
+	case MATCH_LENGTH:
+		if socketRequest.MatchLength <= 0 || socketRequest.MatchLength > socketRequest.MaxFrame {
+			return fmt.Errorf("matchLength范围1-%d", socketRequest.MaxFrame)
+		}
+	case MATCH_DELIMITER:
+		if len(socketRequest.delimiter) == 0 {
+			return errors.New("delimiter不能为空")
+		}
+	case MATCH_FRAME:
+		if socketRequest.LengthPrefix == 0 {
+			return errors.New("frame模式需要设置lengthPrefix")
+		}
+	default:
+		return fmt.Errorf("match必须是%s | %s | %s | %s | %s", MATCH_NONE, MATCH_LENGTH, MATCH_DELIMITER, MATCH_TIMEOUT, MATCH_FRAME)
+	}
+
+	socketRequest.request = GenerateHttpRequest(false)
+	socketRequest.request.HttpBody.Body = socketRequest.Body
+	socketRequest.request.RawBody = socketRequest.Payload
+	return socketRequest.request.HttpBody.verifyFields(socketRequest.Body, false)
+}
+
+func (socketRequest *SocketRequest) decode(s string) ([]byte, error) {
+	switch socketRequest.PayloadType {
+	case PAYLOAD_TEXT:
+		return []byte(s), nil
+	case PAYLOAD_HEX:
+		return hex.DecodeString(strings.Replace(s, " ", "", -1))
+	case PAYLOAD_BASE64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("payloadType必须是%s | %s | %s", PAYLOAD_TEXT, PAYLOAD_HEX, PAYLOAD_BASE64)
+}
+
+func (socketRequest *SocketRequest) timeout() time.Duration {
+	if socketRequest.Timeout == 0 {
+		return HTTP_RESPONSE_TIMEOUT
+	}
+	return time.Duration(socketRequest.Timeout) * time.Millisecond
+}
+
+func (socketRequest *SocketRequest) Run(serial uint64, ch chan<- *Response, wg *sync.WaitGroup, stopCh <-chan int) {
+	sc := new(socketConn)
+	slot := socketRequest.pacer.slot(serial)
+	for {
+		lag, ok := slot.wait(stopCh)
+		if !ok {
+			logger.Debug(fmt.Sprintf("%d号%s协程关闭", serial, socketRequest.network))
+			sc.close()
+			wg.Done()
+			return
+		}
+		resp := socketRequest.SocketSend(sc)
+		lag.set(resp)
+		ch <- resp
+	}
+}
+
+func (socketRequest *SocketRequest) SocketSend(sc *socketConn) *Response {
+	resp := &Response{Proto: strings.ToUpper(socketRequest.network)}
+	start := utils.Now()
+	sc.lastRead = 0
+	defer func() {
+		if err := recover(); err != nil {
+			resp.setPanic(err)
+		}
+		end := utils.Now()
+		if resp.IsSuccess && sc.lastRead > 0 {
+			end = sc.lastRead
+		}
+		resp.WasteTime = uint64(end - start)
+		// 出错后连接状态不确定，下一条消息重新连接，消息没有生成时连接不受影响
+		if (!resp.IsSuccess && resp.ErrType != ERR_TYPE_REQUEST) || socketRequest.Connection == SOCKET_CONN_PER_MESSAGE {
+			sc.close()
+		}
+	}()
+
+	message, err := socketRequest.frame(socketRequest.message())
+	if err != nil {
+		resp.setErrorType(ERR_TYPE_REQUEST, err.Error())
+		return resp
+	}
+
+	resp.ConnReused = sc.conn != nil
+	if sc.conn == nil {
+		conn, err := net.DialTimeout(socketRequest.network, socketRequest.Address, socketRequest.timeout())
+		if err != nil {
+			resp.setError(err, PHASE_CONNECT)
+			return resp
+		}
+		sc.conn = conn
+		sc.reader = bufio.NewReader(conn)
+	}
+
+	sc.conn.SetDeadline(time.Now().Add(socketRequest.timeout()))
+	resp.BytesSent = uint64(len(message))
+	if _, err := sc.conn.Write(message); err != nil {
+		resp.setError(err, PHASE_WRITE)
+		return resp
+	}
+
+	n, err := socketRequest.receive(sc)
+	resp.BytesRecv = uint64(n)
+	if err != nil {
+		resp.setError(err, PHASE_WAIT)
+		return resp
+	}
+	resp.IsSuccess = true
+	return resp
+}
+
+// text模板每条消息重新生成
+func (socketRequest *SocketRequest) template() bool {
+	return socketRequest.PayloadType == PAYLOAD_TEXT && len(socketRequest.Body) > 0
+}
+
+func (socketRequest *SocketRequest) message() []byte {
+	if socketRequest.template() {
+		return []byte(socketRequest.request.createRawBody())
+	}
+	return socketRequest.payload
+}
+
+// 按lengthPrefix在消息前加上长度，超出前缀能表示的长度时返回错误
+func (socketRequest *SocketRequest) frame(data []byte) ([]byte, error) {
+	if socketRequest.LengthPrefix == 0 {
+		return data, nil
+	}
+	if socketRequest.LengthPrefix < 4 && len(data) >= 1<<(8*uint(socketRequest.LengthPrefix)) {
+		return nil, fmt.Errorf("长度%d超过%d字节长度前缀的范围", len(data), socketRequest.LengthPrefix)
+	}
+	prefix := make([]byte, 4)
+	socketRequest.byteOrder().PutUint32(prefix, uint32(len(data)))
+	if socketRequest.LittleEndian {
+		prefix = prefix[:socketRequest.LengthPrefix]
+	} else {
+		prefix = prefix[4-socketRequest.LengthPrefix:]
+	}
+	return append(prefix, data...), nil
+}
+
+func (socketRequest *SocketRequest) byteOrder() binary.ByteOrder {
+	if socketRequest.LittleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// 按匹配规则读取响应，返回读取的字节数
+func (socketRequest *SocketRequest) receive(sc *socketConn) (int, error) {
+	if socketRequest.Match == MATCH_NONE {
+		return 0, nil
+	}
+	if socketRequest.network == TYPE_UDP {
+		return socketRequest.receivePacket(sc)
+	}
+
+	switch socketRequest.Match {
+	case MATCH_LENGTH:
+		return io.ReadFull(sc.reader, make([]byte, socketRequest.MatchLength))
+	case MATCH_DELIMITER:
+		return socketRequest.readDelimiter(sc.reader)
+	case MATCH_FRAME:
+		length, err := socketRequest.readPrefix(sc.reader)
+		if err != nil {
+			return 0, err
+		}
+		if length > socketRequest.MaxFrame {
+			return socketRequest.LengthPrefix, &assertionError{fmt.Errorf("响应帧长度%d超过%d", length, socketRequest.MaxFrame)}
+		}
+		n, err := io.CopyN(ioutil.Discard, sc.reader, int64(length))
+		return int(n) + socketRequest.LengthPrefix, err
+	}
+
+	return socketRequest.readTimeout(sc)
+}
+
+// timeout：读取到超时为止，有数据即视为成功，耗时计到最后一次收到数据
+func (socketRequest *SocketRequest) readTimeout(sc *socketConn) (int, error) {
+	buf := make([]byte, 4096)
+	total := 0
+	for {
+		n, err := sc.reader.Read(buf)
+		if n > 0 {
+			total += n
+			sc.lastRead = utils.Now()
+		}
+		if err == nil {
+			continue
+		}
+		if total > 0 {
+			return total, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+}
+
+// 读取到分隔符为止，超过maxFrame还没有读到分隔符时断言失败
+func (socketRequest *SocketRequest) readDelimiter(reader *bufio.Reader) (n int, err error) {
+	var buf []byte
+	last := socketRequest.delimiter[len(socketRequest.delimiter)-1]
+	for {
+		line, err := reader.ReadSlice(last)
+		buf = append(buf, line...)
+		if len(buf) > socketRequest.MaxFrame {
+			return len(buf), &assertionError{fmt.Errorf("响应超过%d字节没有分隔符", socketRequest.MaxFrame)}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return len(buf), err
+		}
+		if bytes.HasSuffix(buf, socketRequest.delimiter) {
+			return len(buf), nil
+		}
+	}
+}
+
+func (socketRequest *SocketRequest) readPrefix(reader io.Reader) (int, error) {
+	prefix := make([]byte, 4)
+	p := prefix[4-socketRequest.LengthPrefix:]
+	if socketRequest.LittleEndian {
+		p = prefix[:socketRequest.LengthPrefix]
+	}
+	if _, err := io.ReadFull(reader, p); err != nil {
+		return 0, err
+	}
+	return int(socketRequest.byteOrder().Uint32(prefix)), nil
+}
+
+// udp每次读取一个数据包，匹配规则作用于数据包内容
+func (socketRequest *SocketRequest) receivePacket(sc *socketConn) (int, error) {
+	buf := make([]byte, SOCKET_UDP_BUFFER)
+	n, err := sc.conn.Read(buf)
+	if err != nil {
+		return n, err
+	}
+	packet := buf[:n]
+	switch socketRequest.Match {
+	case MATCH_LENGTH:
+		if n < socketRequest.MatchLength {
+			return n, &assertionError{fmt.Errorf("响应长度%d小于%d", n, socketRequest.MatchLength)}
+		}
+	case MATCH_DELIMITER:
+		if !bytes.Contains(packet, socketRequest.delimiter) {
+			return n, &assertionError{errors.New("响应中没有分隔符")}
+		}
+	case MATCH_FRAME:
+		length, err := socketRequest.readPrefix(bytes.NewReader(packet))
+		if err != nil || n-socketRequest.LengthPrefix < length {
+			return n, &assertionError{errors.New("响应帧不完整")}
+		}
+	}
+	return n, nil
+}
+
+func (sc *socketConn) close() {
+	if sc.conn != nil {
+		sc.conn.Close()
+		sc.conn = nil
+		sc.reader = nil
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSocketLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		request SocketRequest
+		payload []byte
+		err     bool
+	}{
+		{name: "默认值", request: SocketRequest{Address: "h:1", Payload: "ping"}, payload: []byte("ping")},
+		{name: "hex", request: SocketRequest{Address: "h:1", PayloadType: PAYLOAD_HEX, Payload: "01 ff"}, payload: []byte{1, 255}},
+		{name: "base64", request: SocketRequest{Address: "h:1", PayloadType: PAYLOAD_BASE64, Payload: "aGk="}, payload: []byte("hi")},
+		{name: "缺少address", request: SocketRequest{Payload: "ping"}, err: true},
+		{name: "connection错误", request: SocketRequest{Address: "h:1", Connection: "pool"}, err: true},
+		{name: "lengthPrefix错误", request: SocketRequest{Address: "h:1", LengthPrefix: 3}, err: true},
+		{name: "payloadType错误", request: SocketRequest{Address: "h:1", PayloadType: "bin"}, err: true},
+		{name: "hex错误", request: SocketRequest{Address: "h:1", PayloadType: PAYLOAD_HEX, Payload: "zz"}, err: true},
+		{name: "length缺少长度", request: SocketRequest{Address: "h:1", Match: MATCH_LENGTH}, err: true},
+		{name: "delimiter为空", request: SocketRequest{Address: "h:1", Match: MATCH_DELIMITER}, err: true},
+		{name: "frame缺少前缀", request: SocketRequest{Address: "h:1", Match: MATCH_FRAME}, err: true},
+		{name: "match错误", request: SocketRequest{Address: "h:1", Match: "regex"}, err: true},
+		{name: "payload超出前缀范围", request: SocketRequest{Address: "h:1", Payload: strings.Repeat("a", 256), LengthPrefix: 1}, err: true},
+	}
+	for _, c := range cases {
+		socketRequest := c.request
+		err := socketRequest.Load(TYPE_TCP)
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = %v, want err %v", c.name, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if socketRequest.Connection != SOCKET_CONN_PERSISTENT || socketRequest.Match != MATCH_NONE || !bytes.Equal(socketRequest.payload, c.payload) {
+			t.Errorf("%s: %s %s %v", c.name, socketRequest.Connection, socketRequest.Match, socketRequest.payload)
+		}
+	}
+}
+
+func TestSocketFrame(t *testing.T) {
+	cases := []struct {
+		prefix int
+		little bool
+		want   []byte
+	}{
+		{prefix: 0, want: []byte("ab")},
+		{prefix: 1, want: []byte{2, 'a', 'b'}},
+		{prefix: 2, want: []byte{0, 2, 'a', 'b'}},
+		{prefix: 2, little: true, want: []byte{2, 0, 'a', 'b'}},
+		{prefix: 4, want: []byte{0, 0, 0, 2, 'a', 'b'}},
+		{prefix: 4, little: true, want: []byte{2, 0, 0, 0, 'a', 'b'}},
+	}
+	for _, c := range cases {
+		socketRequest := &SocketRequest{LengthPrefix: c.prefix, LittleEndian: c.little}
+		got, err := socketRequest.frame([]byte("ab"))
+		if err != nil || !bytes.Equal(got, c.want) {
+			t.Errorf("prefix %d little %t: %v, want %v", c.prefix, c.little, got, c.want)
+		}
+		if c.prefix == 0 {
+			continue
+		}
+		length, err := socketRequest.readPrefix(bytes.NewReader(got))
+		if err != nil || length != 2 {
+			t.Errorf("prefix %d little %t: read %d %v", c.prefix, c.little, length, err)
+		}
+	}
+
+	limits := map[int]int{1: 255, 2: 65535}
+	for prefix, max := range limits {
+		socketRequest := &SocketRequest{LengthPrefix: prefix}
+		if _, err := socketRequest.frame(make([]byte, max)); err != nil {
+			t.Errorf("prefix %d: %d bytes: %v", prefix, max, err)
+		}
+		if _, err := socketRequest.frame(make([]byte, max+1)); err == nil {
+			t.Errorf("prefix %d: %d bytes: want error", prefix, max+1)
+		}
+	}
+}
+
+// 原样返回收到的数据
+func startSocketEcho(t *testing.T) (tcp string, udp string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, SOCKET_UDP_BUFFER)
+		for {
+			n, addr, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packetConn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return listener.Addr().String(), packetConn.LocalAddr().String(), func() {
+		listener.Close()
+		packetConn.Close()
+	}
+}
+
+func TestSocketSend(t *testing.T) {
+	tcp, udp, stop := startSocketEcho(t)
+	defer stop()
+
+	cases := []struct {
+		name    string
+		network string
+		request SocketRequest
+		n       int
+		success bool
+	}{
+		{name: "tcp只发送", network: TYPE_TCP, request: SocketRequest{Payload: "ping"}, success: true},
+		{name: "tcp定长", network: TYPE_TCP, request: SocketRequest{Payload: "ping", Match: MATCH_LENGTH, MatchLength: 4}, n: 4, success: true},
+		{name: "tcp分隔符", network: TYPE_TCP, request: SocketRequest{Payload: "ab\r\n", Match: MATCH_DELIMITER, Delimiter: "\r\n"}, n: 4, success: true},
+		{name: "tcp长度前缀", network: TYPE_TCP, request: SocketRequest{Payload: "ping", LengthPrefix: 2, Match: MATCH_FRAME}, n: 6, success: true},
+		{
+			name:    "tcp模板",
+			network: TYPE_TCP,
+			request: SocketRequest{Payload: "id={{id}};", Body: []*BodyField{{Name: "id", Type: "string", Default: "7"}}, Match: MATCH_DELIMITER, Delimiter: ";"},
+			n:       5,
+			success: true,
+		},
+		{name: "tcp读取超时", network: TYPE_TCP, request: SocketRequest{Payload: "ping", Match: MATCH_LENGTH, MatchLength: 8, Timeout: 100}, n: 0},
+		{name: "tcp超时模式", network: TYPE_TCP, request: SocketRequest{Payload: "ping", Match: MATCH_TIMEOUT, Timeout: 100}, n: 4, success: true},
+		{name: "udp定长", network: TYPE_UDP, request: SocketRequest{Payload: "ping", Match: MATCH_LENGTH, MatchLength: 4}, n: 4, success: true},
+		{name: "udp长度不足", network: TYPE_UDP, request: SocketRequest{Payload: "ping", Match: MATCH_LENGTH, MatchLength: 8}, n: 0},
+		{name: "udp分隔符", network: TYPE_UDP, request: SocketRequest{Payload: "a;b", Match: MATCH_DELIMITER, Delimiter: ";"}, n: 3, success: true},
+		{name: "udp长度前缀", network: TYPE_UDP, request: SocketRequest{Payload: "ping", LengthPrefix: 1, Match: MATCH_FRAME}, n: 5, success: true},
+	}
+	for _, c := range cases {
+		socketRequest := c.request
+		socketRequest.Address = tcp
+		if c.network == TYPE_UDP {
+			socketRequest.Address = udp
+		}
+		if err := socketRequest.Load(c.network); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		sc := new(socketConn)
+		for i := 0; i < 2; i++ {
+			resp := socketRequest.SocketSend(sc)
+			if resp.IsSuccess != c.success || (c.success && resp.BytesRecv != uint64(c.n)) || resp.BytesSent == 0 {
+				t.Errorf("%s #%d: success %t sent %d recv %d err %s, want %t %d", c.name, i, resp.IsSuccess, resp.BytesSent, resp.BytesRecv, resp.ErrMsg, c.success, c.n)
+			}
+			// 成功时复用连接，失败后重新连接
+			if resp.ConnReused != (i > 0 && c.success) {
+				t.Errorf("%s #%d: reused %t", c.name, i, resp.ConnReused)
+			}
+		}
+		sc.close()
+	}
+
+	// 模板生成的消息超出前缀范围时记为请求错误
+	socketRequest := SocketRequest{Address: tcp, Payload: "{{id}}", Body: []*BodyField{{Name: "id", Type: "string", Default: strings.Repeat("a", 256)}}, LengthPrefix: 1}
+	if err := socketRequest.Load(TYPE_TCP); err != nil {
+		t.Fatal(err)
+	}
+	if resp := socketRequest.SocketSend(new(socketConn)); resp.ErrType != ERR_TYPE_REQUEST || resp.BytesSent != 0 {
+		t.Errorf("oversize template: %s %s", resp.ErrType, resp.ErrMsg)
+	}
+
+	// timeout模式耗时到最后一次收到数据，不包括等待超时的时间
+	socketRequest = SocketRequest{Address: tcp, Payload: "ping", Match: MATCH_TIMEOUT, Timeout: 300}
+	if err := socketRequest.Load(TYPE_TCP); err != nil {
+		t.Fatal(err)
+	}
+	sc := new(socketConn)
+	defer sc.close()
+	if resp := socketRequest.SocketSend(sc); !resp.IsSuccess || resp.WasteTime >= 300 {
+		t.Errorf("timeout match: success %t, waste %d", resp.IsSuccess, resp.WasteTime)
+	}
+}
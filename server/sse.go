@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"insane/utils"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	STREAM_CONNECT = "connect" // 建立连接
+	STREAM_EVENT   = "event"   // 收到事件
+	STREAM_CLOSE   = "close"   // 连接断开
+	STREAM_BODY    = "body"    // 流式读取的http响应
+
+	SSE_DEFAULT_RETRY = 3000 // 默认重连间隔（毫秒）
+	STREAM_BUFFER     = 32 * 1024
+)
+
+// 流式响应的附加信息，connect与close只用于统计连接，不计入请求数
+type StreamResponse struct {
+	Kind      string `json:"kind"`      // connect | event | close | body
+	Reconnect bool   `json:"reconnect"` // connect：是否为重连
+	First     bool   `json:"first"`     // event：是否为连接上的第一个事件
+	Events    uint64 `json:"events"`    // close：连接上收到的事件数
+	FirstTime uint64 `json:"firstTime"` // body：首个数据块到达时间（毫秒）
+}
+
+// 一个sse连接上的状态，重连时保留lastEventId和retry
+type sseSession struct {
+	lastEventId string
+	retry       time.Duration
+	reconnect   bool
+	closed      bool // 服务端返回204或非200，按规范不再重连
+}
+
+type sseEvent struct {
+	id    string
+	data  []string
+	hasId bool
+}
+
+// 流式读取响应体，记录首个数据块到达时间；已收到数据后到达超时时间视为正常结束
+func (httpRequest *HttpRequest) verifyStream(ctx context.Context, trace *httpTrace, rp *http.Response, resp *Response, start int64) {
+	defer rp.Body.Close()
+	stream := &StreamResponse{Kind: STREAM_BODY}
+	resp.Stream = stream
+	resp.ErrCode = rp.StatusCode
+	resp.ErrMsg = rp.Status
+
+	var received uint64
+	buf := make([]byte, STREAM_BUFFER)
+	for {
+		n, err := rp.Body.Read(buf)
+		if n > 0 && received == 0 {
+			stream.FirstTime = uint64(utils.Now() - start)
+		}
+		received += uint64(n)
+		resp.BytesRecv += uint64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded && received > 0 {
+				break
+			}
+			httpRequest.setError(ctx, trace, resp, err)
+			return
+		}
+	}
+	if rp.StatusCode != http.StatusOK {
+		resp.setStatusError(rp.StatusCode, rp.Status)
+		return
+	}
+	resp.IsSuccess = true
+}
+
+// sse：每个协程保持一条连接接收事件，断开后按retry间隔重连
+func (httpRequest *HttpRequest) SseRun(serial uint64, ch chan<- *Response, wg *sync.WaitGroup, stopCh <-chan int) {
+	defer wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		logger.Debug(fmt.Sprintf("%d号sse协程关闭", serial))
+		cancel()
+	}()
+
+	session := &sseSession{retry: SSE_DEFAULT_RETRY * time.Millisecond}
+	for ctx.Err() == nil {
+		httpRequest.sseConnect(ctx, session, ch)
+		if session.closed {
+			<-ctx.Done()
+			return
+		}
+		session.reconnect = true
+		select {
+		case <-ctx.Done():
+		case <-time.After(session.retry):
+		}
+	}
+}
+
+func (httpRequest *HttpRequest) sseConnect(ctx context.Context, session *sseSession, ch chan<- *Response) {
+	start := utils.Now()
+	// 任务结束时取消的请求不计入错误
+	fail := func(setError func(resp *Response)) {
+		if ctx.Err() != nil {
+			return
+		}
+		resp := &Response{WasteTime: uint64(utils.Now() - start), Group: httpRequest.Group}
+		setError(resp)
+		httpSendRespCh(ch, resp)
+	}
+	// 生成请求体等出错时记为一次失败，间隔后重新连接
+	defer func() {
+		if err := recover(); err != nil {
+			fail(func(resp *Response) { resp.setPanic(err) })
+		}
+	}()
+
+	req, err := httpRequest.getRequest()
+	if err != nil {
+		fail(func(resp *Response) { resp.setErrorType(ERR_TYPE_REQUEST, err.Error()) })
+		session.closed = true
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if session.lastEventId != "" {
+		req.Header.Set("Last-Event-ID", session.lastEventId)
+	}
+
+	// 超时时间只限制等待响应头，连接建立后一直读取到断开
+	connCtx, connCancel := context.WithCancel(ctx)
+	defer connCancel()
+	timer := time.AfterFunc(httpRequest.timeout(), connCancel)
+	rp, err := httpRequest.getClient().Do(req.WithContext(connCtx))
+	if !timer.Stop() {
+		if err == nil {
+			rp.Body.Close()
+		}
+		fail(func(resp *Response) {
+			resp.setErrorType(ERR_TYPE_TIMEOUT+"."+PHASE_WAIT, fmt.Sprintf("超时时间%dms, 请重试", httpRequest.timeout()/time.Millisecond))
+		})
+		return
+	}
+	if err != nil {
+		fail(func(resp *Response) { resp.setError(err, "") })
+		return
+	}
+	defer rp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(rp.Header.Get("Content-Type"))
+	if rp.StatusCode != http.StatusOK {
+		fail(func(resp *Response) { resp.setStatusError(rp.StatusCode, rp.Status) })
+		session.closed = true
+		return
+	}
+	if mediaType != "text/event-stream" {
+		fail(func(resp *Response) {
+			resp.setErrorType(ERR_TYPE_ASSERTION, "Content-Type不是text/event-stream："+mediaType)
+		})
+		session.closed = true
+		return
+	}
+
+	httpSendRespCh(ch, &Response{
+		Proto:  rp.Proto,
+		Group:  httpRequest.Group,
+		Stream: &StreamResponse{Kind: STREAM_CONNECT, Reconnect: session.reconnect},
+	})
+
+	var (
+		events uint64
+		last   = start
+		event  = new(sseEvent)
+		reader = bufio.NewReaderSize(rp.Body, STREAM_BUFFER)
+	)
+	defer func() {
+		httpSendRespCh(ch, &Response{
+			Group:  httpRequest.Group,
+			Stream: &StreamResponse{Kind: STREAM_CLOSE, Events: events},
+		})
+	}()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				fail(func(resp *Response) { resp.setError(err, "") })
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line != "" {
+			session.field(event, line)
+			continue
+		}
+
+		// 空行分发事件，没有data的事件忽略
+		if event.hasId {
+			session.lastEventId = event.id
+		}
+		if len(event.data) > 0 {
+			now := utils.Now()
+			httpSendRespCh(ch, &Response{
+				WasteTime: uint64(now - last), // 首个事件为连接开始到收到事件的时间，之后为事件间隔
+				IsSuccess: true,
+				ErrCode:   http.StatusOK,
+				Group:     httpRequest.Group,
+				Stream:    &StreamResponse{Kind: STREAM_EVENT, First: events == 0},
+			})
+			events++
+			last = now
+		}
+		event = new(sseEvent)
+	}
+}
+
+// connect与close只统计连接，不计入请求数
+func (stream *StreamResponse) isRequest() bool {
+	return stream == nil || stream.Kind == STREAM_EVENT || stream.Kind == STREAM_BODY
+}
+
+func (session *sseSession) field(event *sseEvent, line string) {
+	if strings.HasPrefix(line, ":") {
+		return // 注释，通常是心跳
+	}
+	name, value := line, ""
+	if pos := strings.Index(line, ":"); pos >= 0 {
+		name, value = line[:pos], strings.TrimPrefix(line[pos+1:], " ")
+	}
+	switch name {
+	case "data":
+		event.data = append(event.data, value)
+	case "id":
+		if !strings.Contains(value, "\x00") {
+			event.id, event.hasId = value, true
+		}
+	case "retry":
+		if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+			session.retry = time.Duration(ms) * time.Millisecond
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSseSessionField(t *testing.T) {
+	cases := []struct {
+		lines []string
+		data  []string
+		id    string
+		hasId bool
+		retry time.Duration
+	}{
+		{lines: []string{"data: a", "data:b", "data"}, data: []string{"a", "b", ""}},
+		{lines: []string{": ping", "event: x"}},
+		{lines: []string{"id: 7"}, id: "7", hasId: true},
+		{lines: []string{"id"}, hasId: true},
+		{lines: []string{"id: a\x00b"}},
+		{lines: []string{"retry: 500"}, retry: 500 * time.Millisecond},
+		{lines: []string{"retry: 5s"}},
+	}
+	for _, c := range cases {
+		session := &sseSession{}
+		event := new(sseEvent)
+		for _, line := range c.lines {
+			session.field(event, line)
+		}
+		if !reflect.DeepEqual(event.data, c.data) || event.id != c.id || event.hasId != c.hasId || session.retry != c.retry {
+			t.Errorf("%q: data %q id %q %t retry %v", c.lines, event.data, event.id, event.hasId, session.retry)
+		}
+	}
+}
+
+func TestStreamReportAdd(t *testing.T) {
+	stream := &StreamReport{FirstTime: new(GroupReport), EventGap: new(GroupReport)}
+	for _, data := range []*Response{
+		{Stream: &StreamResponse{Kind: STREAM_CONNECT}},
+		{WasteTime: 30, IsSuccess: true, Stream: &StreamResponse{Kind: STREAM_EVENT, First: true}},
+		{WasteTime: 10, IsSuccess: true, Stream: &StreamResponse{Kind: STREAM_EVENT}},
+		{WasteTime: 20, IsSuccess: true, Stream: &StreamResponse{Kind: STREAM_EVENT}},
+		{Stream: &StreamResponse{Kind: STREAM_CLOSE, Events: 3}},
+		{Stream: &StreamResponse{Kind: STREAM_CONNECT, Reconnect: true}},
+		{Stream: &StreamResponse{Kind: STREAM_CLOSE}},
+		{IsSuccess: true, Stream: &StreamResponse{Kind: STREAM_BODY, FirstTime: 50}},
+		{Stream: &StreamResponse{Kind: STREAM_BODY, FirstTime: 1}},
+	} {
+		stream.add(data)
+	}
+	if stream.Connections != 2 || stream.Reconnects != 1 || stream.Events != 3 || stream.EventsPerConn != 1 || stream.MaxEvents != 3 {
+		t.Errorf("stream = %+v", stream)
+	}
+	if stream.FirstTime.SuccessNum != 2 || stream.FirstTime.MinTime != 30 || stream.FirstTime.MaxTime != 50 {
+		t.Errorf("firstTime = %+v", stream.FirstTime)
+	}
+	if stream.EventGap.SuccessNum != 2 || stream.EventGap.AvgTime != 15 {
+		t.Errorf("eventGap = %+v", stream.EventGap)
+	}
+	for _, stream := range []*StreamResponse{nil, {Kind: STREAM_EVENT}, {Kind: STREAM_BODY}} {
+		if !stream.isRequest() {
+			t.Errorf("%+v: want request", stream)
+		}
+	}
+	for _, stream := range []*StreamResponse{{Kind: STREAM_CONNECT}, {Kind: STREAM_CLOSE}} {
+		if stream.isRequest() {
+			t.Errorf("%+v: want not request", stream)
+		}
+	}
+}
+
+func TestSseConnect(t *testing.T) {
+	var lastEventId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events":
+			lastEventId = r.Header.Get("Last-Event-ID")
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			io.WriteString(w, ": ping\n\nretry: 100\nid: 1\ndata: a\n\nid: 2\n\ndata: b\r\ndata: c\r\n\r\n")
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, "{}")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		path   string
+		kinds  []string
+		closed bool
+		lastId string
+		retry  time.Duration
+	}{
+		{path: "/events", kinds: []string{STREAM_CONNECT, STREAM_EVENT, STREAM_EVENT, STREAM_CLOSE}, lastId: "2", retry: 100 * time.Millisecond},
+		{path: "/json", kinds: []string{""}, closed: true, retry: time.Second},
+		{path: "/none", kinds: []string{""}, closed: true, retry: time.Second},
+	}
+	for _, c := range cases {
+		httpRequest := GenerateHttpRequest(false)
+		httpRequest.Method = "GET"
+		httpRequest.Url = server.URL + c.path
+		session := &sseSession{retry: time.Second, lastEventId: "0", reconnect: true}
+		ch := make(chan *Response, 10)
+		httpRequest.sseConnect(context.Background(), session, ch)
+		close(ch)
+
+		var kinds []string
+		for resp := range ch {
+			if resp.Stream == nil {
+				kinds = append(kinds, "")
+				continue
+			}
+			kinds = append(kinds, resp.Stream.Kind)
+			if resp.Stream.Kind == STREAM_CONNECT && !resp.Stream.Reconnect {
+				t.Errorf("%s: connect should be reconnect", c.path)
+			}
+			if resp.Stream.Kind == STREAM_CLOSE && resp.Stream.Events != 2 {
+				t.Errorf("%s: events = %d", c.path, resp.Stream.Events)
+			}
+		}
+		if !reflect.DeepEqual(kinds, c.kinds) || session.closed != c.closed || session.retry != c.retry {
+			t.Errorf("%s: kinds %q closed %t retry %v", c.path, kinds, session.closed, session.retry)
+		}
+		if !c.closed && (session.lastEventId != c.lastId || lastEventId != "0") {
+			t.Errorf("%s: lastEventId %s, sent %s", c.path, session.lastEventId, lastEventId)
+		}
+	}
+}
+
+func TestSseConnectPanic(t *testing.T) {
+	httpRequest := GenerateHttpRequest(false)
+	httpRequest.Method = "POST"
+	httpRequest.Url = "http://127.0.0.1:1/events"
+	httpRequest.HttpBody = nil // 生成请求体时出错
+	session := &sseSession{retry: time.Second}
+	ch := make(chan *Response, 1)
+	httpRequest.sseConnect(context.Background(), session, ch)
+	close(ch)
+
+	resp := <-ch
+	if resp == nil || resp.IsSuccess || resp.ErrType != ERR_TYPE_SCRIPT || session.closed {
+		t.Errorf("resp = %+v, closed %t", resp, session.closed)
+	}
+}
+
+func TestVerifyParamSseFields(t *testing.T) {
+	insaneRequest := &InsaneRequest{Form: TYPE_SSE, HttpRequest: GenerateHttpRequest(false)}
+	insaneRequest.HttpRequest.Url = "http://127.0.0.1/events"
+	insaneRequest.HttpRequest.HttpBody.Body = []*BodyField{{Name: "token", Type: "response", Dynamic: "login---token"}}
+	if err := insaneRequest.VerifyParam(); err == nil {
+		t.Error("response field: want error")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"insane/general/base/appconfig"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"insane/utils"
@@ -29,6 +30,9 @@ var TK = &TaskList{
 	CurTask: make(chan *Task),
 }
 
+// 上一个任务的id，定时计划同时添加多个任务时避免同一毫秒的id重复
+var lastTaskId int64
+
 const (
 	COMPLETED_TASK  = 1
 	UNFINISHED_TASK = 2
@@ -62,6 +66,7 @@ func (taskList *TaskList) TaskListRemove(id string) (err error) {
 	}
 	if _, ok := taskList.getTasks(id, COMPLETED_TASK); ok {
 		taskList.deleteTasks(id, COMPLETED_TASK)
+		InsaneMetrics.remove(id)
 		return
 	}
 
@@ -150,6 +155,16 @@ func (taskList *TaskList) getTasks(id string, tp uint32) (task *Task, ok bool) {
 	return
 }
 
+// 按任务id查找任务与所在的列表
+func (taskList *TaskList) findTask(id string) (*Task, uint32) {
+	for _, tp := range []uint32{RUN_TASK, UNFINISHED_TASK, COMPLETED_TASK} {
+		if task, ok := taskList.getTasks(id, tp); ok {
+			return task, tp
+		}
+	}
+	return nil, 0
+}
+
 func (taskList *TaskList) getTasksAll(tp uint32) (tasks map[string]*Task) {
 	var data sync.Map
 	tasks = make(map[string]*Task)
@@ -208,10 +223,13 @@ func (taskList *TaskList) TaskListRun() {
 }
 
 func (task *Task) Init() {
-	id := strconv.FormatInt(utils.Now(), 10)
+	id := nextTaskId()
 	task.InsaneRequest.Id = id
 	task.InsaneRequest.Report = new(Report)
-	task.InsaneRequest.initStopCh()
+	task.InsaneRequest.ScriptReportList = &ScriptReportList{
+		ScriptReport: make(map[uint64][]*ScriptReport),
+	}
+	task.InsaneRequest.initControl()
 }
 
 func (task *Task) Run() {
@@ -230,5 +248,21 @@ func (task *Task) Stop() error {
 }
 
 func (task *Task) Info() string {
+	if task.InsaneRequest.Form == TYPE_SCRIPT {
+		return task.InsaneRequest.ScriptReportList.Get()
+	}
 	return task.InsaneRequest.Report.Get()
 }
+
+func nextTaskId() string {
+	for {
+		last := atomic.LoadInt64(&lastTaskId)
+		id := utils.Now()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastTaskId, last, id) {
+			return strconv.FormatInt(id, 10)
+		}
+	}
+}
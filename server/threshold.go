@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	THRESHOLD_AVG         = "avg" // 平均耗时（毫秒）
+	THRESHOLD_P50         = "p50" // 耗时百分位（毫秒）
+	THRESHOLD_P90         = "p90"
+	THRESHOLD_P95         = "p95"
+	THRESHOLD_P99         = "p99"
+	THRESHOLD_MAX         = "max"        // 最大耗时（毫秒）
+	THRESHOLD_ERROR_RATE  = "errorRate"  // 错误率（%）
+	THRESHOLD_FAILURE_NUM = "failureNum" // 失败请求数
+	THRESHOLD_RPS         = "rps"        // 每秒成功请求数
+)
+
+var thresholdOps = map[string]func(actual float64, value float64) bool{
+	"<":  func(actual float64, value float64) bool { return actual < value },
+	"<=": func(actual float64, value float64) bool { return actual <= value },
+	">":  func(actual float64, value float64) bool { return actual > value },
+	">=": func(actual float64, value float64) bool { return actual >= value },
+}
+
+// 任务结束时检查的阈值，导出JUnit时每个阈值为一个测试用例
+type Threshold struct {
+	Metric string  `json:"metric"` // avg | p50 | p90 | p95 | p99 | max | errorRate | failureNum | rps
+	Op     string  `json:"op"`     // < | <= | > | >=，默认<=
+	Value  float64 `json:"value"`
+	Step   string  `json:"step"` // 分组或脚本步骤名，为空时检查整个任务
+}
+
+type ThresholdResult struct {
+	Threshold
+	Name    string  `json:"name"`
+	Actual  float64 `json:"actual"`
+	Passed  bool    `json:"passed"`
+	Message string  `json:"message"`
+}
+
+// 计算阈值需要的统计
+type thresholdSource struct {
+	success uint64
+	failure uint64
+	seconds uint64
+	latency *Histogram   // 耗时分布，没有时使用group
+	group   *GroupReport // 分组只有平均与最大耗时
+}
+
+func verifyThresholds(thresholds []*Threshold) error {
+	for _, threshold := range thresholds {
+		switch threshold.Metric {
+		case THRESHOLD_AVG, THRESHOLD_P50, THRESHOLD_P90, THRESHOLD_P95, THRESHOLD_P99, THRESHOLD_MAX,
+			THRESHOLD_ERROR_RATE, THRESHOLD_FAILURE_NUM, THRESHOLD_RPS:
+		default:
+			return fmt.Errorf("不支持的阈值指标：%s", threshold.Metric)
+		}
+		if threshold.Op == "" {
+			threshold.Op = "<="
+		}
+		if _, ok := thresholdOps[threshold.Op]; !ok {
+			return fmt.Errorf("阈值比较方式必须是 < | <= | > | >=：%s", threshold.Op)
+		}
+	}
+	return nil
+}
+
+func checkThresholds(thresholds []*Threshold, source func(step string) *thresholdSource) []*ThresholdResult {
+	results := make([]*ThresholdResult, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		result := &ThresholdResult{Threshold: *threshold, Name: threshold.name()}
+		actual, err := source(threshold.Step).value(threshold.Metric)
+		if err != nil {
+			result.Message = err.Error()
+		} else {
+			result.Actual = actual
+			result.Passed = thresholdOps[threshold.Op](actual, threshold.Value)
+			if !result.Passed {
+				result.Message = fmt.Sprintf("实际值%s，要求%s %s", thresholdNumber(actual), threshold.Op, thresholdNumber(threshold.Value))
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (threshold *Threshold) name() string {
+	name := fmt.Sprintf("%s %s %s", threshold.Metric, threshold.Op, thresholdNumber(threshold.Value))
+	if threshold.Step != "" {
+		name = threshold.Step + ": " + name
+	}
+	return name
+}
+
+func (source *thresholdSource) value(metric string) (float64, error) {
+	if source == nil {
+		return 0, errors.New("分组或步骤不存在")
+	}
+	total := source.success + source.failure
+	switch metric {
+	case THRESHOLD_ERROR_RATE:
+		if total == 0 {
+			return 0, errors.New("没有请求")
+		}
+		return float64(source.failure) * 100 / float64(total), nil
+	case THRESHOLD_FAILURE_NUM:
+		return float64(source.failure), nil
+	case THRESHOLD_RPS:
+		if source.seconds == 0 {
+			return 0, errors.New("没有持续时间")
+		}
+		return float64(source.success) / float64(source.seconds), nil
+	}
+	if source.success == 0 {
+		return 0, errors.New("没有成功的请求")
+	}
+	if source.latency != nil {
+		summary := source.latency.Summary()
+		return float64(map[string]uint64{
+			THRESHOLD_AVG: summary.Avg,
+			THRESHOLD_P50: summary.P50,
+			THRESHOLD_P90: summary.P90,
+			THRESHOLD_P95: summary.P95,
+			THRESHOLD_P99: summary.P99,
+			THRESHOLD_MAX: summary.Max,
+		}[metric]), nil
+	}
+	if source.group != nil {
+		switch metric {
+		case THRESHOLD_AVG:
+			return float64(source.group.AvgTime), nil
+		case THRESHOLD_MAX:
+			return float64(source.group.MaxTime), nil
+		}
+	}
+	return 0, fmt.Errorf("分组不支持%s", metric)
+}
+
+func thresholdNumber(v float64) string {
+	return fmt.Sprintf("%g", float64(int64(v*100+0.5))/100)
+}
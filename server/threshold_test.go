@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestVerifyThresholds(t *testing.T) {
+	cases := []struct {
+		threshold *Threshold
+		op        string
+		err       bool
+	}{
+		{threshold: &Threshold{Metric: THRESHOLD_P95, Value: 100}, op: "<="},
+		{threshold: &Threshold{Metric: THRESHOLD_RPS, Op: ">", Value: 100}, op: ">"},
+		{threshold: &Threshold{Metric: "p999"}, err: true},
+		{threshold: &Threshold{Metric: THRESHOLD_AVG, Op: "=="}, err: true},
+	}
+	for _, c := range cases {
+		err := verifyThresholds([]*Threshold{c.threshold})
+		if (err != nil) != c.err || (err == nil && c.threshold.Op != c.op) {
+			t.Errorf("%+v: err = %v", c.threshold, err)
+		}
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	latency := NewHistogram()
+	for i := uint64(1); i <= 100; i++ {
+		latency.Add(i)
+	}
+	sources := map[string]*thresholdSource{
+		"":      {success: 90, failure: 10, seconds: 10, latency: latency},
+		"login": {success: 5, failure: 0, seconds: 10, group: &GroupReport{AvgTime: 20, MaxTime: 80}},
+		"empty": {failure: 3},
+	}
+	source := func(step string) *thresholdSource {
+		return sources[step]
+	}
+	cases := []struct {
+		threshold Threshold
+		name      string
+		actual    float64
+		passed    bool
+		message   bool
+	}{
+		{threshold: Threshold{Metric: THRESHOLD_P95, Op: "<=", Value: 95}, name: "p95 <= 95", actual: 95, passed: true},
+		{threshold: Threshold{Metric: THRESHOLD_MAX, Op: "<", Value: 100}, name: "max < 100", actual: 100, message: true},
+		{threshold: Threshold{Metric: THRESHOLD_ERROR_RATE, Op: "<", Value: 5}, name: "errorRate < 5", actual: 10, message: true},
+		{threshold: Threshold{Metric: THRESHOLD_RPS, Op: ">=", Value: 9}, name: "rps >= 9", actual: 9, passed: true},
+		{threshold: Threshold{Metric: THRESHOLD_FAILURE_NUM, Op: "<=", Value: 10}, name: "failureNum <= 10", actual: 10, passed: true},
+		{threshold: Threshold{Metric: THRESHOLD_AVG, Op: "<=", Value: 20.5, Step: "login"}, name: "login: avg <= 20.5", actual: 20, passed: true},
+		{threshold: Threshold{Metric: THRESHOLD_P99, Op: "<=", Value: 1, Step: "login"}, name: "login: p99 <= 1", message: true},
+		{threshold: Threshold{Metric: THRESHOLD_AVG, Op: "<=", Value: 1, Step: "empty"}, name: "empty: avg <= 1", message: true},
+		{threshold: Threshold{Metric: THRESHOLD_AVG, Op: "<=", Value: 1, Step: "none"}, name: "none: avg <= 1", message: true},
+	}
+	for _, c := range cases {
+		threshold := c.threshold
+		result := checkThresholds([]*Threshold{&threshold}, source)[0]
+		if result.Name != c.name || result.Actual != c.actual || result.Passed != c.passed || (result.Message != "") != c.message {
+			t.Errorf("%s: %+v", c.name, result)
+		}
+	}
+}
+
+func TestThresholdNumber(t *testing.T) {
+	cases := map[float64]string{1: "1", 0.125: "0.13", 33.333333: "33.33", 1e7: "1e+07"}
+	for v, want := range cases {
+		if got := thresholdNumber(v); got != want {
+			t.Errorf("%v: %s, want %s", v, got, want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"insane/constant"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/donnie4w/go-logger/logger"
@@ -15,7 +16,7 @@ var defaultDialer = websocket.Dialer{
 	HandshakeTimeout: 20 * time.Second,
 }
 
-func Websocket(ch chan<- *Response, wg *sync.WaitGroup, insaneRequest *InsaneRequest) {
+func Websocket(ch chan<- *Response, wg *sync.WaitGroup, insaneRequest *InsaneRequest, stopCh <-chan int) {
 
 	conn, _, err := defaultDialer.Dial(insaneRequest.HttpRequest.Url, nil)
 
@@ -28,26 +29,25 @@ func Websocket(ch chan<- *Response, wg *sync.WaitGroup, insaneRequest *InsaneReq
 		}
 	}()
 	if err != nil {
-		ch <- &Response{
-			WasteTime: 0,
-			IsSuccess: false,
-			ErrCode:   constant.ERROR_REQUEST_CONNECTION,
-			ErrMsg:    err.Error(),
-		}
+		resp := new(Response)
+		resp.setError(err, PHASE_CONNECT)
+		ch <- resp
 		return
 	}
 
-	// 读取响应消息
+	// 读取响应消息，耗时为距最近一次发送的时间
 	rstop := make(chan int, 1)
-	go wsReceive(conn, ch, rstop)
+	var sentAt int64
+	go wsReceive(conn, ch, rstop, &sentAt)
 
 	for {
 		select {
-		case <-insaneRequest.Stop:
+		case <-stopCh:
 			rstop <- 1
 			return
 		default:
 			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt64(&sentAt, time.Now().UnixNano())
 			wsSend(conn, insaneRequest)
 		}
 	}
@@ -68,7 +68,7 @@ func wsSend(conn *websocket.Conn, insaneRequest *InsaneRequest) (err error) {
 
 }
 
-func wsReceive(conn *websocket.Conn, ch chan<- *Response, rstop chan int) {
+func wsReceive(conn *websocket.Conn, ch chan<- *Response, rstop chan int, sentAt *int64) {
 	for {
 		select {
 		case <-rstop:
@@ -77,17 +77,24 @@ func wsReceive(conn *websocket.Conn, ch chan<- *Response, rstop chan int) {
 			// 接收数据
 			_, _, err := conn.ReadMessage()
 			if err != nil {
-				ch <- &Response{
-					WasteTime: 0,
-					IsSuccess: false,
-					ErrCode:   constant.ERROR_REQUEST_RECEIVE,
-					ErrMsg:    "接收数据失败",
-				}
-			} else {
-				ch <- &Response{
-					WasteTime: 0,
-					IsSuccess: true,
+				// 任务结束关闭连接导致的错误不计入统计，连接出错后不能再读取
+				select {
+				case <-rstop:
+					return
+				default:
 				}
+				resp := new(Response)
+				resp.setError(err, PHASE_WAIT)
+				ch <- resp
+				return
+			}
+			var wasteTime uint64
+			if sent := atomic.LoadInt64(sentAt); sent > 0 {
+				wasteTime = uint64(time.Since(time.Unix(0, sent)) / time.Millisecond)
+			}
+			ch <- &Response{
+				WasteTime: wasteTime,
+				IsSuccess: true,
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"strconv"
 )
 
@@ -16,6 +17,11 @@ func ConvString(i interface{}) (s string) {
 		s = strconv.FormatFloat(i.(float64), 'f', -1, 64)
 	case string:
 		s = i.(string)
+	case bool:
+		s = strconv.FormatBool(i.(bool))
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(i)
+		s = string(b)
 	default:
 		s = ""
 	}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
 )
 
 func ParseJson(json string) (result gjson.Result, err error) {
@@ -13,3 +16,34 @@ func ParseJson(json string) (result gjson.Result, err error) {
 	result = gjson.Parse(json)
 	return
 }
+
+// yaml转换为json
+func YamlToJson(content string) (string, error) {
+	var data interface{}
+	if err := yaml.Unmarshal([]byte(content), &data); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(convertYamlValue(data))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// yaml解析出的map键是interface{}，json无法直接序列化
+func convertYamlValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprint(k)] = convertYamlValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range val {
+			val[i] = convertYamlValue(item)
+		}
+		return val
+	}
+	return v
+}
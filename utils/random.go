@@ -34,3 +34,14 @@ func GetRandomintegers(len int64) int64 {
 	n, _ := strconv.ParseInt(in, 10, 64)
 	return n
 }
+
+func GetRandomItem(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return items[rand.Intn(len(items))]
+}
+
+func GetRandomBool() bool {
+	return rand.Intn(2) == 1
+}